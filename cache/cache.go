@@ -0,0 +1,93 @@
+// Package cache provides an on-disk cache for analysis results.
+//
+// Entries are JSON-encoded values stored in a directory. Keys should include
+// content hashes of the analysed source (see ssabuilder.SSAInfo.PkgHash), so
+// entries of edited packages are never read again; the directory can be
+// removed at any time to clear the cache.
+package cache // import "github.com/nickng/dingo-hunter/cache"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Version is the version of cached formats and analysis, part of every key.
+const Version = "2"
+
+// Dir is a cache directory. It is safe for concurrent use.
+type Dir struct {
+	Path string
+
+	mu     sync.Mutex
+	hits   int
+	misses int
+}
+
+// Open opens (and creates if needed) the cache directory at path.
+func Open(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return nil, err
+	}
+	return &Dir{Path: path}, nil
+}
+
+// file returns the path of the entry with key.
+func (d *Dir) file(key string) string {
+	sum := sha256.Sum256([]byte(Version + "\x00" + key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(d.Path, name[:2], name[2:]+".json")
+}
+
+// Get reads the entry with key into v, and returns false if there is no
+// (readable) entry.
+func (d *Dir) Get(key string, v interface{}) bool {
+	b, err := ioutil.ReadFile(d.file(key))
+	ok := err == nil && json.Unmarshal(b, v) == nil
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ok {
+		d.hits++
+	} else {
+		d.misses++
+	}
+	return ok
+}
+
+// Put writes v as the entry with key.
+func (d *Dir) Put(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	file := d.file(key)
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see partial entries.
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Stats returns the number of hits and misses of Get.
+func (d *Dir) Stats() (hits, misses int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hits, d.misses
+}
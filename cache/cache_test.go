@@ -0,0 +1,31 @@
+package cache
+
+import "testing"
+
+// Tests entries written can be read back, and missing entries are misses.
+func TestPutGet(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	type entry struct {
+		Name string
+		Pos  []int
+	}
+	if err := d.Put("key", entry{Name: "main.main", Pos: []int{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	var e entry
+	if !d.Get("key", &e) {
+		t.Fatalf("Expecting entry for key\n")
+	}
+	if e.Name != "main.main" || len(e.Pos) != 2 {
+		t.Errorf("Expecting entry {main.main [1 2]} but got %v\n", e)
+	}
+	if d.Get("other", &e) {
+		t.Errorf("Expecting no entry for other key\n")
+	}
+	if hits, misses := d.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("Expecting 1 hit and 1 miss but got %d and %d\n", hits, misses)
+	}
+}
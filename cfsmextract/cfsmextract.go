@@ -5,7 +5,10 @@ package cfsmextract
 //  - Set up session variables
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go/token"
 	"go/types"
 	"log"
 	"os"
@@ -14,29 +17,44 @@ import (
 	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
 	"github.com/nickng/dingo-hunter/cfsmextract/utils"
 	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/dingo-hunter/stubs/stdlib"
 	"golang.org/x/tools/go/ssa"
 )
 
+var (
+	ErrNoMainPkg  = errors.New("no main package found")
+	ErrNoMainFunc = errors.New("no main() function found in main package")
+)
+
 type CFSMExtract struct {
-	SSA   *ssabuilder.SSAInfo
-	Time  time.Duration
-	Done  chan struct{}
-	Error chan error
+	SSA     *ssabuilder.SSAInfo
+	Time    time.Duration
+	Done    chan struct{}
+	Error   chan error
+	Jobs    int                     // Goroutines analysed concurrently.
+	Stubs   map[string]Stub         // Stubbed functions (see Stub).
+	StubSet *stubs.Set              // Models of functions without SSA body.
+	Models  map[string]stdlib.Model // Models of functions by stub name.
+	Limits  Limits                  // Extraction limits.
+
+	Diagnostics []*Diagnostic // Parts of the session left out (see Limits).
 
 	session *sesstype.Session
-	goQueue []*frame
 	prefix  string
 	outdir  string
+	ctx     context.Context // Cancellation of the analysis.
 }
 
 func New(ssainfo *ssabuilder.SSAInfo, prefix, outdir string) *CFSMExtract {
 	return &CFSMExtract{
-		SSA:   ssainfo,
-		Done:  make(chan struct{}),
-		Error: make(chan error),
+		SSA:     ssainfo,
+		Done:    make(chan struct{}, 1),
+		Error:   make(chan error, 1),
+		StubSet: stdlib.Stubs(),
+		Models:  stdlib.Models(),
 
 		session: sesstype.CreateSession(),
-		goQueue: []*frame{},
 		prefix:  prefix,
 		outdir:  outdir,
 	}
@@ -45,11 +63,26 @@ func New(ssainfo *ssabuilder.SSAInfo, prefix, outdir string) *CFSMExtract {
 // Run function analyses main.main() then all the goroutines collected, and
 // finally output the analysis results.
 func (extract *CFSMExtract) Run() {
+	extract.RunContext(context.Background())
+}
+
+// RunContext is Run until ctx is cancelled, the analysis then stops at the
+// next function or block and ctx.Err() is sent to extract.Error.
+//
+// The analysis also stops when extract.Limits.Timeout is exceeded, but is
+// then completed with a Diagnostic of the partial session.
+func (extract *CFSMExtract) RunContext(ctx context.Context) {
+	extract.ctx = ctx
+	if extract.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		extract.ctx, cancel = context.WithTimeout(ctx, extract.Limits.Timeout)
+		defer cancel()
+	}
 	startTime := time.Now()
 	mainPkg := ssabuilder.MainPkg(extract.SSA.Prog)
 	if mainPkg == nil {
-		fmt.Fprintf(os.Stderr, "Error: 'main' package not found\n")
-		os.Exit(1)
+		extract.Error <- ErrNoMainPkg
+		return
 	}
 	init := mainPkg.Func("init")
 	main := mainPkg.Func("main")
@@ -60,50 +93,57 @@ func (extract *CFSMExtract) Run() {
 			case *ssa.Global:
 				switch derefAll(val.Type()).(type) {
 				case *types.Array:
-					vd := utils.NewDef(val)
+					vd := fr.env.vers.NewDef(val)
 					fr.env.globals[val] = vd
 					fr.env.arrays[vd] = make(Elems)
 
 				case *types.Struct:
-					vd := utils.NewDef(val)
+					vd := fr.env.vers.NewDef(val)
 					fr.env.globals[val] = vd
 					fr.env.structs[vd] = make(Fields)
 
 				case *types.Chan:
 					var c *types.Chan
-					vd := utils.NewDef(utils.EmptyValue{T: c})
+					vd := fr.env.vers.NewDef(utils.EmptyValue{T: c})
 					fr.env.globals[val] = vd
 
 				default:
-					fr.env.globals[val] = utils.NewDef(val)
+					fr.env.globals[val] = fr.env.vers.NewDef(val)
 				}
 			}
 		}
 	}
 
-	fmt.Fprintf(os.Stderr, "++ call.toplevel %s()\n", orange("init"))
+	fmt.Fprintf(fr.env.log, "++ call.toplevel %s()\n", orange("init"))
 	visitFunc(init, fr)
 	if main == nil {
-		fmt.Fprintf(os.Stderr, "Error: 'main()' function not found in 'main' package\n")
-		os.Exit(1)
+		extract.Error <- ErrNoMainFunc
+		return
 	}
-	fmt.Fprintf(os.Stderr, "++ call.toplevel %s()\n", orange("main"))
+	fmt.Fprintf(fr.env.log, "++ call.toplevel %s()\n", orange("main"))
 	visitFunc(main, fr)
 
 	fr.env.session.Types[fr.gortn.role] = fr.gortn.root
 
-	var goFrm *frame
-	for len(extract.goQueue) > 0 {
-		goFrm, extract.goQueue = extract.goQueue[0], extract.goQueue[1:]
-		fmt.Fprintf(os.Stderr, "\n%s\nLOCATION: %s%s\n", goFrm.fn.Name(), goFrm.gortn.role.Name(), loc(goFrm, goFrm.fn.Pos()))
-		visitFunc(goFrm.fn, goFrm)
-		goFrm.env.session.Types[goFrm.gortn.role] = goFrm.gortn.root
-	}
+	extract.runQueue(fr.env)
 
 	extract.Time = time.Since(startTime)
+	if err := ctx.Err(); err != nil {
+		extract.Error <- err
+		return
+	}
+	if extract.cancelled() {
+		fr.env.diagnose(token.NoPos, "analysis stopped (time limit %s)", extract.Limits.Timeout)
+	}
+	extract.Diagnostics = fr.env.diags
 	extract.Done <- struct{}{}
 }
 
+// cancelled returns true if the analysis is cancelled.
+func (extract *CFSMExtract) cancelled() bool {
+	return extract.ctx != nil && extract.ctx.Err() != nil
+}
+
 // Session returns the session after extraction.
 func (extract *CFSMExtract) Session() *sesstype.Session {
 	return extract.session
@@ -0,0 +1,291 @@
+package cfsmextract
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/dingo-hunter/stubs/stdlib"
+)
+
+// extract runs session type extraction on program s and returns the session,
+// CFSMs and dot graph outputs.
+func extract(t *testing.T, s string) (session, cfsms, dot string) {
+	return extractWith(t, s, func(*CFSMExtract) {})
+}
+
+// extractStubs runs session type extraction on program s with stubs set.
+func extractStubs(t *testing.T, s string, set *stubs.Set) (session, cfsms, dot string) {
+	return extractWith(t, s, func(extract *CFSMExtract) { extract.StubSet = set })
+}
+
+// extractWith runs session type extraction on program s, configured by
+// configure before running.
+func extractWith(t *testing.T, s string, configure func(*CFSMExtract)) (session, cfsms, dot string) {
+	conf, err := ssabuilder.NewConfigFromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	extract := New(info, "test", t.TempDir())
+	configure(extract)
+	go extract.Run()
+	select {
+	case err := <-extract.Error:
+		t.Fatal(err)
+	case <-extract.Done:
+	}
+	var cfsmBuf, dotBuf bytes.Buffer
+	if _, err := sesstype.NewCFSMs(extract.Session()).WriteTo(&cfsmBuf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sesstype.NewGraphvizDot(extract.Session()).WriteTo(&dotBuf); err != nil {
+		t.Fatal(err)
+	}
+	return extract.Session().String(), cfsmBuf.String(), dotBuf.String()
+}
+
+// Tests extraction of the same program gives byte-identical outputs.
+func TestDeterministicOutput(t *testing.T) {
+	s := `package main
+func send(ch chan int, x int) { ch <- x }
+func recv(ch, done chan int) { <-ch; <-ch; done <- 1 }
+func main() {
+	a, b, done := make(chan int), make(chan int), make(chan int)
+	go send(a, 1)
+	go send(a, 2)
+	go send(b, 3)
+	go recv(a, done)
+	<-b
+	<-done
+}`
+	session, cfsms, dot := extract(t, s)
+	for i := 0; i < 5; i++ {
+		session2, cfsms2, dot2 := extract(t, s)
+		if session != session2 {
+			t.Errorf("Expecting identical session but got\n%s\nand\n%s\n", session, session2)
+		}
+		if cfsms != cfsms2 {
+			t.Errorf("Expecting identical CFSMs but got\n%s\nand\n%s\n", cfsms, cfsms2)
+		}
+		if dot != dot2 {
+			t.Errorf("Expecting identical dot graph but got\n%s\nand\n%s\n", dot, dot2)
+		}
+	}
+}
+
+// Tests analyses running at the same time number definitions on their own,
+// i.e. there are no versions of definitions shared by analyses.
+func TestConcurrentExtract(t *testing.T) {
+	s := `package main
+func send(ch chan int) { ch <- 1 }
+func main() {
+	ch := make(chan int)
+	go send(ch)
+	<-ch
+}`
+	session, _, _ := extract(t, s)
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			t.Run(fmt.Sprint(i), func(t *testing.T) {
+				t.Parallel()
+				if session2, _, _ := extract(t, s); session2 != session {
+					t.Errorf("Expecting session\n%s\nbut got\n%s\n", session, session2)
+				}
+			})
+		}
+	})
+}
+
+// Tests goroutines spawned by stubs are roles of their own.
+func TestStubs(t *testing.T) {
+	set, err := stubs.Parse(strings.NewReader(`
+def main.subscribe(ch):
+    spawn main.subscribe#publish(ch);
+def main.subscribe#publish(ch):
+    send ch;
+    call main.subscribe#publish(ch);
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, cfsms, _ := extractStubs(t, `package main
+func subscribe() chan int
+func main() {
+	ch := subscribe()
+	<-ch
+}`, set)
+	if !strings.Contains(session, "main.subscribe#publish") {
+		t.Errorf("Expecting role of main.subscribe#publish but got\n%s\n", session)
+	}
+	if strings.Count(cfsms, "-- Machines") != 3 {
+		t.Errorf("Expecting CFSMs of main, publisher and channel but got\n%s\n", cfsms)
+	}
+}
+
+// Tests calls to noop stubs are skipped and calls to blocking stubs block
+// forever.
+func TestNamedStubs(t *testing.T) {
+	s := `package main
+func send(ch chan int) { ch <- 1 }
+func serve() { for {} }
+func main() {
+	ch := make(chan int, 1)
+	send(ch)
+	serve()
+}`
+	session, _, _ := extractWith(t, s, func(extract *CFSMExtract) {
+		extract.Stubs = map[string]Stub{"main.send": NoopStub, "main.serve": BlockingStub}
+	})
+	if strings.Contains(session, "Send ") {
+		t.Errorf("Expecting no send in noop stub but got\n%s\n", session)
+	}
+	if !strings.Contains(session, "main.serve#block") || !strings.Contains(session, "Recv ") {
+		t.Errorf("Expecting receive of blocking stub but got\n%s\n", session)
+	}
+	session, _, _ = extractWith(t, s, func(extract *CFSMExtract) {
+		extract.Stubs = map[string]Stub{"main.*": NoopStub}
+	})
+	if strings.Contains(session, "Send ") || strings.Contains(session, "Recv ") {
+		t.Errorf("Expecting package of noop stubs but got\n%s\n", session)
+	}
+}
+
+// Tests calls modelled by stdlib models, with look-alikes of sync.Once,
+// sync.Cond and errgroup.Group.
+func TestModels(t *testing.T) {
+	session, _, _ := extractWith(t, `package main
+type Once struct{}
+func (o *Once) Do(f func())
+type Cond struct{}
+func NewCond() *Cond
+func (c *Cond) Wait()
+func (c *Cond) Signal()
+type Group struct{}
+func (g *Group) Go(f func() error)
+func (g *Group) Wait() error
+func main() {
+	ch := make(chan int)
+	var once Once
+	once.Do(func() { go func() { ch <- 1 }() })
+	<-ch
+	c := NewCond()
+	go func() { c.Signal() }()
+	c.Wait()
+	var g Group
+	g.Go(func() error { ch <- 2; return nil })
+	g.Go(func() error { return nil })
+	<-ch
+	g.Wait()
+}`, func(extract *CFSMExtract) {
+		extract.Models = map[string]stdlib.Model{
+			"main.Once.Do":     {Kind: stdlib.Call, Arg: 1},
+			"main.Cond.Wait":   {Kind: stdlib.Wait},
+			"main.Cond.Signal": {Kind: stdlib.Notify},
+			"main.Group.Go":    {Kind: stdlib.Spawn, Arg: 1, Join: true},
+			"main.Group.Wait":  {Kind: stdlib.Join},
+		}
+	})
+	if n := strings.Count(session, "Recv main←ᶜʰmain.main.sync_t12@0"); n != 2 {
+		t.Errorf("Expecting Wait to join 2 goroutines but got %d\n%s\n", n, session)
+	}
+	if !strings.Contains(session, "Recv main←ᶜʰmain.main.sync_t7@0") {
+		t.Errorf("Expecting Cond.Wait to receive but got\n%s\n", session)
+	}
+	if n := strings.Count(session, "→ᶜʰmain.main.t1@0"); n != 2 {
+		t.Errorf("Expecting 2 sends from goroutines of Once.Do and Group.Go but got %d\n%s\n", n, session)
+	}
+}
+
+// Tests handlers of Handler values are spawned by their ServeHTTP method, with
+// look-alikes of http.ServeMux and http.HandlerFunc.
+func TestHandlerModels(t *testing.T) {
+	session, _, _ := extractWith(t, `package main
+type Handler interface{ ServeHTTP(r int) }
+type HandlerFunc func(r int)
+func (f HandlerFunc) ServeHTTP(r int) { f(r) }
+type Mux struct{}
+func (m *Mux) Handle(pattern string, h Handler)
+type counter struct{ ch chan int }
+func (c *counter) ServeHTTP(r int) { c.ch <- r }
+func main() {
+	ch := make(chan int)
+	var mux Mux
+	mux.Handle("/count", &counter{ch})
+	mux.Handle("/send", HandlerFunc(func(r int) { ch <- r }))
+	<-ch
+}`, func(extract *CFSMExtract) {
+		extract.Models = map[string]stdlib.Model{
+			"main.Mux.Handle": {Kind: stdlib.Spawn, Arg: 2, Method: "ServeHTTP", Repeat: true},
+		}
+	})
+	if n := strings.Count(session, "→ᶜʰmain.main.t1@0"); n != 2 {
+		t.Errorf("Expecting 2 sends from goroutines of handlers but got %d\n%s\n", n, session)
+	}
+}
+
+// Tests deferred calls which recover run once on each of the normal and panic
+// paths, even if they may panic.
+func TestRecover(t *testing.T) {
+	session, _, _ := extract(t, `package main
+func mayPanic(x int) { if x > 0 { panic("x") } }
+func done(ch chan int, x int) {
+	recover()
+	mayPanic(x)
+	ch <- 1
+}
+func f(ch chan int, x int) {
+	defer done(ch, x)
+	mayPanic(x)
+}
+func main() {
+	ch := make(chan int)
+	go f(ch, 1)
+	<-ch
+}`)
+	if n := strings.Count(session, "→ᶜʰmain.main.t0@0"); n != 2 {
+		t.Errorf("Expecting a send on each of normal and panic paths but got %d\n%s\n", n, session)
+	}
+}
+
+// Tests calls and spawns over the limits are left out of a partial session
+// with diagnostics.
+func TestLimits(t *testing.T) {
+	s := `package main
+func send(ch chan int) { ch <- 1 }
+func main() {
+	ch := make(chan int)
+	go send(ch)
+	go send(ch)
+	send(ch)
+	<-ch
+	<-ch
+}`
+	var limited *CFSMExtract
+	session, _, _ := extractWith(t, s, func(extract *CFSMExtract) {
+		extract.Limits = Limits{MaxInstances: 2}
+		limited = extract
+	})
+	if n := strings.Count(session, "Send "); n != 2 {
+		t.Errorf("Expecting 2 sends within instance limit but got %d\n%s\n", n, session)
+	}
+	if len(limited.Diagnostics) != 1 || !strings.Contains(limited.Diagnostics[0].Msg, "instance limit 2") {
+		t.Errorf("Expecting instance limit diagnostic but got %v\n", limited.Diagnostics)
+	}
+	var stopped *CFSMExtract
+	extractWith(t, s, func(extract *CFSMExtract) {
+		extract.Limits = Limits{Timeout: time.Nanosecond}
+		stopped = extract
+	})
+	if len(stopped.Diagnostics) != 1 || !strings.Contains(stopped.Diagnostics[0].Msg, "time limit") {
+		t.Errorf("Expecting time limit diagnostic but got %v\n", stopped.Diagnostics)
+	}
+}
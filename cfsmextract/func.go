@@ -2,11 +2,14 @@ package cfsmextract
 
 import (
 	"fmt"
+	"go/token"
 	"go/types"
+	"io"
 	"os"
 
 	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
 	"github.com/nickng/dingo-hunter/cfsmextract/utils"
+	"github.com/nickng/dingo-hunter/stubs"
 	"golang.org/x/tools/go/ssa"
 )
 
@@ -38,18 +41,20 @@ type Fields map[int]*utils.Definition
 
 // Frame holds variables in current function scope
 type frame struct {
-	fn      *ssa.Function                   // Function ptr of callee
-	locals  map[ssa.Value]*utils.Definition // Holds definitions of local registers
-	arrays  map[*utils.Definition]Elems     // Array elements (Alloc local)
-	structs map[*utils.Definition]Fields    // Struct fields (Alloc local)
-	tuples  map[ssa.Value]Tuples            // Multiple return values as tuple
-	phi     map[ssa.Value][]ssa.Value       // Phis
-	recvok  map[ssa.Value]*sesstype.Chan    // Channel used in recvok
-	retvals Tuples                          // Return values to pass back to parent
-	defers  []*ssa.Defer                    // Deferred calls
-	caller  *frame                          // Ptr to caller's frame, nil if main/ext
-	env     *environ                        // Environment
-	gortn   *goroutine                      // Current goroutine
+	fn       *ssa.Function                   // Function ptr of callee
+	locals   map[ssa.Value]*utils.Definition // Holds definitions of local registers
+	arrays   map[*utils.Definition]Elems     // Array elements (Alloc local)
+	structs  map[*utils.Definition]Fields    // Struct fields (Alloc local)
+	tuples   map[ssa.Value]Tuples            // Multiple return values as tuple
+	phi      map[ssa.Value][]ssa.Value       // Phis
+	recvok   map[ssa.Value]*sesstype.Chan    // Channel used in recvok
+	retvals  Tuples                          // Return values to pass back to parent
+	defers   []*ssa.Defer                    // Deferred calls
+	panics   bool                            // Function may panic (not recovered)
+	panicked bool                            // Last call may panic
+	caller   *frame                          // Ptr to caller's frame, nil if main/ext
+	env      *environ                        // Environment
+	gortn    *goroutine                      // Current goroutine
 }
 
 // Environment: Variables/info available globally for all goroutines
@@ -71,8 +76,14 @@ type environ struct {
 		idx int       // The index of the branch
 		tpl ssa.Value // The SelectState tuple which the branch originates from
 	}
-	recvTest map[ssa.Value]*sesstype.Chan // Receive test
-	ifparent *sesstype.NodeStack
+	recvTest  map[ssa.Value]*sesstype.Chan  // Receive test
+	syncs     map[*utils.Definition]syncObj // Channels modelling receivers
+	ifparent  *sesstype.NodeStack
+	vers      *utils.Versions       // Versions of definitions
+	queue     []*frame              // Goroutines to be analysed
+	instances map[*ssa.Function]int // Number of calls or spawns visited
+	diags     []*Diagnostic         // Parts of the session left out
+	log       io.Writer             // Log of the analysis
 }
 
 func (env *environ) GetSessionChan(vd *utils.Definition) *sesstype.Chan {
@@ -112,8 +123,13 @@ func makeToplevelFrame(extract *CFSMExtract) *frame {
 				idx int
 				tpl ssa.Value
 			}),
-			recvTest: make(map[ssa.Value]*sesstype.Chan),
-			ifparent: sesstype.NewNodeStack(),
+			recvTest:  make(map[ssa.Value]*sesstype.Chan),
+			syncs:     make(map[*utils.Definition]syncObj),
+			ifparent:  sesstype.NewNodeStack(),
+			vers:      utils.NewVersions(),
+			queue:     []*frame{},
+			instances: make(map[*ssa.Function]int),
+			log:       os.Stderr,
 		},
 		gortn: &goroutine{
 			role:    extract.session.GetRole("main"),
@@ -132,8 +148,8 @@ func (caller *frame) callBuiltin(common *ssa.CallCommon) {
 	if builtin.Name() == "close" {
 		if len(common.Args) == 1 {
 			if ch, ok := caller.env.chans[caller.locals[common.Args[0]]]; ok {
-				fmt.Fprintf(os.Stderr, "++ call builtin %s(%s channel %s)\n", orange(builtin.Name()), green(common.Args[0].Name()), ch.Name())
-				visitClose(*ch, caller)
+				fmt.Fprintf(caller.env.log, "++ call builtin %s(%s channel %s)\n", orange(builtin.Name()), green(common.Args[0].Name()), ch.Name())
+				visitClose(*ch, common.Pos(), caller)
 			} else {
 				panic("Builtin close() called with non-channel\n")
 			}
@@ -141,15 +157,15 @@ func (caller *frame) callBuiltin(common *ssa.CallCommon) {
 	} else if builtin.Name() == "copy" {
 		dst := common.Args[0]
 		src := common.Args[1]
-		fmt.Fprintf(os.Stderr, "++ call builtin %s(%s <- %s)\n", orange("copy"), dst.Name(), src.Name())
+		fmt.Fprintf(caller.env.log, "++ call builtin %s(%s <- %s)\n", orange("copy"), dst.Name(), src.Name())
 		caller.locals[dst] = caller.locals[src]
 		return
 	} else {
-		fmt.Fprintf(os.Stderr, "++ call builtin %s(", builtin.Name())
+		fmt.Fprintf(caller.env.log, "++ call builtin %s(", builtin.Name())
 		for _, arg := range common.Args {
-			fmt.Fprintf(os.Stderr, "%s", arg.Name())
+			fmt.Fprintf(caller.env.log, "%s", arg.Name())
 		}
-		fmt.Fprintf(os.Stderr, ") # TODO (handle builtin)\n")
+		fmt.Fprintf(caller.env.log, ") # TODO (handle builtin)\n")
 	}
 }
 
@@ -164,12 +180,18 @@ func (caller *frame) callCommon(call *ssa.Call, common *ssa.CallCommon) {
 
 	case *ssa.MakeClosure:
 		// TODO(nickng) Handle calling closure
-		fmt.Fprintf(os.Stderr, "   # TODO (handle closure) %s\n", fn.String())
+		fmt.Fprintf(caller.env.log, "   # TODO (handle closure) %s\n", fn.String())
 
 	case *ssa.Function:
 		if common.StaticCallee() == nil {
 			panic("Call with nil CallCommon!")
 		}
+		if caller.callStub(call, common, stubs.Name(fn.String()), false, common.Pos()) {
+			return
+		}
+		if caller.callModel(common, fn, common.Pos()) {
+			return
+		}
 
 		callee := &frame{
 			fn:      common.StaticCallee(),
@@ -186,39 +208,49 @@ func (caller *frame) callCommon(call *ssa.Call, common *ssa.CallCommon) {
 			gortn:   caller.gortn, // Use the same role as caller
 		}
 
-		fmt.Fprintf(os.Stderr, "++ call %s(", orange(common.StaticCallee().String()))
+		fmt.Fprintf(caller.env.log, "++ call %s(", orange(common.StaticCallee().String()))
 		callee.translate(common)
-		fmt.Fprintf(os.Stderr, ")\n")
+		fmt.Fprintf(caller.env.log, ")\n")
 
-		if callee.isRecursive() {
-			fmt.Fprintf(os.Stderr, "-- Recursive %s()\n", orange(common.StaticCallee().String()))
+		if s, ok := caller.env.extract.stub(fn); ok {
+			fmt.Fprintf(caller.env.log, "-- Skip %s() (%s stub)\n", orange(fn.String()), s)
+			if s == BlockingStub {
+				caller.block(fn.String(), common.Pos())
+			}
+			caller.handleExtRetvals(call.Value(), callee)
+		} else if callee.isRecursive() {
+			fmt.Fprintf(caller.env.log, "-- Recursive %s()\n", orange(common.StaticCallee().String()))
 			callee.printCallStack()
+		} else if !callee.visitable(common.Pos()) {
+			fmt.Fprintf(caller.env.log, "-- Skip %s() (limit)\n", orange(common.StaticCallee().String()))
+			caller.handleExtRetvals(call.Value(), callee)
 		} else {
 			if hasCode := visitFunc(callee.fn, callee); hasCode {
 				caller.handleRetvals(call.Value(), callee)
 			} else {
 				caller.handleExtRetvals(call.Value(), callee)
 			}
-			fmt.Fprintf(os.Stderr, "-- return from %s (%d retvals)\n", orange(common.StaticCallee().String()), len(callee.retvals))
+			caller.panicked = callee.panics
+			fmt.Fprintf(caller.env.log, "-- return from %s (%d retvals)\n", orange(common.StaticCallee().String()), len(callee.retvals))
 		}
 
 	default:
 		if !common.IsInvoke() {
-			fmt.Fprintf(os.Stderr, "Unknown call type %v\n", common)
+			fmt.Fprintf(caller.env.log, "Unknown call type %v\n", common)
 			return
 		}
 
 		switch vd, kind := caller.get(common.Value); kind {
 		case Struct, LocalStruct:
-			fmt.Fprintf(os.Stderr, "++ invoke %s.%s, type=%s\n", reg(common.Value), common.Method.String(), vd.Var.Type().String())
+			fmt.Fprintf(caller.env.log, "++ invoke %s.%s, type=%s\n", reg(common.Value), common.Method.String(), vd.Var.Type().String())
 			// If dealing with interfaces, check that the method is invokable
 			if iface, ok := common.Value.Type().Underlying().(*types.Interface); ok {
 				if meth, _ := types.MissingMethod(vd.Var.Type(), iface, true); meth != nil {
-					fmt.Fprintf(os.Stderr, "     ^ interface not fully implemented\n")
+					fmt.Fprintf(caller.env.log, "     ^ interface not fully implemented\n")
 				} else {
-					fn := findMethod(common.Value.Parent().Prog, common.Method, vd.Var.Type())
+					fn := findMethod(caller.env.log, common.Value.Parent().Prog, common.Method, vd.Var.Type())
 					if fn != nil {
-						fmt.Fprintf(os.Stderr, "     ^ found function %s\n", fn.String())
+						fmt.Fprintf(caller.env.log, "     ^ found function %s\n", fn.String())
 
 						callee := &frame{
 							fn:      fn,
@@ -236,20 +268,23 @@ func (caller *frame) callCommon(call *ssa.Call, common *ssa.CallCommon) {
 						}
 
 						common.Args = append([]ssa.Value{common.Value}, common.Args...)
-						fmt.Fprintf(os.Stderr, "++ call %s(", orange(fn.String()))
+						fmt.Fprintf(caller.env.log, "++ call %s(", orange(fn.String()))
 						callee.translate(common)
-						fmt.Fprintf(os.Stderr, ")\n")
+						fmt.Fprintf(caller.env.log, ")\n")
 
 						if callee.isRecursive() {
-							fmt.Fprintf(os.Stderr, "-- Recursive %s()\n", orange(fn.String()))
+							fmt.Fprintf(caller.env.log, "-- Recursive %s()\n", orange(fn.String()))
 							callee.printCallStack()
+						} else if !callee.visitable(common.Pos()) {
+							fmt.Fprintf(caller.env.log, "-- Skip %s() (limit)\n", orange(fn.String()))
+							caller.handleExtRetvals(call.Value(), callee)
 						} else {
 							if hasCode := visitFunc(callee.fn, callee); hasCode {
 								caller.handleRetvals(call.Value(), callee)
 							} else {
 								caller.handleExtRetvals(call.Value(), callee)
 							}
-							fmt.Fprintf(os.Stderr, "-- return from %s (%d retvals)\n", orange(fn.String()), len(callee.retvals))
+							fmt.Fprintf(caller.env.log, "-- return from %s (%d retvals)\n", orange(fn.String()), len(callee.retvals))
 						}
 
 					} else {
@@ -257,26 +292,38 @@ func (caller *frame) callCommon(call *ssa.Call, common *ssa.CallCommon) {
 					}
 				}
 			} else {
-				fmt.Fprintf(os.Stderr, "     ^ method %s.%s does not exist\n", reg(common.Value), common.Method.String())
+				fmt.Fprintf(caller.env.log, "     ^ method %s.%s does not exist\n", reg(common.Value), common.Method.String())
 			}
 
 		default:
-			fmt.Fprintf(os.Stderr, "++ invoke %s.%s\n", reg(common.Value), common.Method.String())
+			if caller.callStub(call, common, stubs.Name(common.Method.FullName()), false, common.Pos()) {
+				return
+			}
+			fmt.Fprintf(caller.env.log, "++ invoke %s.%s\n", reg(common.Value), common.Method.String())
 		}
 	}
 }
 
-func findMethod(prog *ssa.Program, meth *types.Func, typ types.Type) *ssa.Function {
+func findMethod(w io.Writer, prog *ssa.Program, meth *types.Func, typ types.Type) *ssa.Function {
 	if meth != nil {
-		fmt.Fprintf(os.Stderr, "     ^ finding method for type: %s pkg: %s name: %s\n", typ.String(), meth.Pkg().Name(), meth.Name())
+		fmt.Fprintf(w, "     ^ finding method for type: %s pkg: %s name: %s\n", typ.String(), meth.Pkg().Name(), meth.Name())
 	}
 	return prog.LookupMethod(typ, meth.Pkg(), meth.Name())
 }
 
 func (caller *frame) callGo(g *ssa.Go) {
 	common := g.Common()
-	goname := fmt.Sprintf("%s_%d", common.Value.Name(), int(g.Pos()))
-	gorole := caller.env.session.GetRole(goname)
+	if fn := common.StaticCallee(); fn != nil && caller.callStub(nil, common, stubs.Name(fn.String()), true, g.Pos()) {
+		return
+	}
+	caller.goCommon(common, g.Pos())
+}
+
+// goCommon queues a new goroutine running common spawned at pos, and returns
+// its frame.
+func (caller *frame) goCommon(common *ssa.CallCommon, pos token.Pos) *frame {
+	goname := fmt.Sprintf("%s_%d", common.Value.Name(), int(pos))
+	gorole := caller.env.session.GetRoleAt(goname, pos)
 
 	callee := &frame{
 		fn:      common.StaticCallee(),
@@ -299,12 +346,15 @@ func (caller *frame) callGo(g *ssa.Go) {
 	}
 	callee.gortn.leaf = &callee.gortn.root
 
-	fmt.Fprintf(os.Stderr, "@@ queue go %s(", common.StaticCallee().String())
+	fmt.Fprintf(caller.env.log, "@@ queue go %s(", common.StaticCallee().String())
 	callee.translate(common)
-	fmt.Fprintf(os.Stderr, ")\n")
+	fmt.Fprintf(caller.env.log, ")\n")
 
 	// TODO(nickng) Does not stop at recursive call.
-	caller.env.extract.goQueue = append(caller.env.extract.goQueue, callee)
+	if caller.env.instantiable(callee.fn, pos) {
+		caller.env.queue = append(caller.env.queue, callee)
+	}
+	return callee
 }
 
 func (callee *frame) translate(common *ssa.CallCommon) {
@@ -317,26 +367,26 @@ func (callee *frame) translate(common *ssa.CallCommon) {
 		}
 
 		if i > 0 {
-			fmt.Fprintf(os.Stderr, ", ")
+			fmt.Fprintf(callee.env.log, ", ")
 		}
 
-		fmt.Fprintf(os.Stderr, "%s:caller[%s] = %s", orange(param.Name()), reg(common.Args[i]), callee.locals[param].String())
+		fmt.Fprintf(callee.env.log, "%s:caller[%s] = %s", orange(param.Name()), reg(common.Args[i]), callee.locals[param].String())
 		myVD := callee.locals[param] // VD of parameter (which are in callee.locals)
 
 		// if argument is a channel
 		if ch, ok := callee.env.chans[myVD]; ok {
-			fmt.Fprintf(os.Stderr, " channel %s", (*ch).Name())
+			fmt.Fprintf(callee.env.log, " channel %s", (*ch).Name())
 		} else if _, ok := callee.env.structs[myVD]; ok {
-			fmt.Fprintf(os.Stderr, " struct")
+			fmt.Fprintf(callee.env.log, " struct")
 		} else if _, ok := callee.env.arrays[myVD]; ok {
-			fmt.Fprintf(os.Stderr, " array")
+			fmt.Fprintf(callee.env.log, " array")
 		} else if fields, ok := callee.caller.structs[myVD]; ok {
 			// If param is local struct in caller, make local copy
-			fmt.Fprintf(os.Stderr, " lstruct")
+			fmt.Fprintf(callee.env.log, " lstruct")
 			callee.structs[myVD] = fields
 		} else if elems, ok := callee.caller.arrays[myVD]; ok {
 			// If param is local array in caller, make local copy
-			fmt.Fprintf(os.Stderr, " larray")
+			fmt.Fprintf(callee.env.log, " larray")
 			callee.arrays[myVD] = elems
 		}
 	}
@@ -345,7 +395,7 @@ func (callee *frame) translate(common *ssa.CallCommon) {
 	if captures, isClosure := callee.env.closures[common.Value]; isClosure {
 		for idx, fv := range callee.fn.FreeVars {
 			callee.locals[fv] = captures[idx]
-			fmt.Fprintf(os.Stderr, ", capture %s = %s", fv.Name(), captures[idx].String())
+			fmt.Fprintf(callee.env.log, ", capture %s = %s", fv.Name(), captures[idx].String())
 		}
 	}
 }
@@ -403,15 +453,15 @@ func (caller *frame) handleExtRetvals(returned ssa.Value, callee *frame) {
 	if resultsLen > 0 {
 		caller.env.extern[returned] = callee.fn.Signature.Results()
 		if resultsLen == 1 {
-			fmt.Fprintf(os.Stderr, "-- Return from %s (builtin/ext) with a single value\n", callee.fn.String())
+			fmt.Fprintf(caller.env.log, "-- Return from %s (builtin/ext) with a single value\n", callee.fn.String())
 			if _, ok := callee.fn.Signature.Results().At(0).Type().(*types.Chan); ok {
-				vardef := utils.NewDef(returned)
+				vardef := caller.env.vers.NewDef(returned)
 				ch := caller.env.session.MakeExtChan(vardef, caller.gortn.role)
 				caller.env.chans[vardef] = &ch
-				fmt.Fprintf(os.Stderr, "-- Return value from %s (builtin/ext) is a channel %s (ext)\n", callee.fn.String(), (*caller.env.chans[vardef]).Name())
+				fmt.Fprintf(caller.env.log, "-- Return value from %s (builtin/ext) is a channel %s (ext)\n", callee.fn.String(), (*caller.env.chans[vardef]).Name())
 			}
 		} else {
-			fmt.Fprintf(os.Stderr, "-- Return from %s (builtin/ext) with %d-tuple\n", callee.fn.String(), resultsLen)
+			fmt.Fprintf(caller.env.log, "-- Return from %s (builtin/ext) with %d-tuple\n", callee.fn.String(), resultsLen)
 		}
 	}
 }
@@ -444,7 +494,7 @@ func (callee *frame) isRecursive() bool {
 func (callee *frame) printCallStack() {
 	curFr := callee
 	for curFr != nil && curFr.fn != nil {
-		fmt.Fprintf(os.Stderr, "Called by: %s()\n", curFr.fn.String())
+		fmt.Fprintf(callee.env.log, "Called by: %s()\n", curFr.fn.String())
 		curFr = curFr.caller
 	}
 }
@@ -10,6 +10,7 @@ type goroutine struct {
 	root    sesstype.Node
 	leaf    *sesstype.Node
 	visited map[*ssa.BasicBlock]sesstype.Node
+	join    *sesstype.Chan // Channel to signal completion, if joined.
 }
 
 // Append a session type node to current goroutine.
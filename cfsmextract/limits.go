@@ -0,0 +1,68 @@
+package cfsmextract
+
+// Limits of the extraction.
+//
+// An extraction hitting a limit is not an error: the part of the program over
+// the limit is left out (calls are skipped, goroutines are not analysed, the
+// analysis stops) and a Diagnostic is added to the partial session. Loops are
+// not unrolled in CFSMs, so there is no limit of loop iterations.
+
+import (
+	"fmt"
+	"go/token"
+	"time"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Limits are limits of the extraction, zero means no limit.
+type Limits struct {
+	MaxDepth     int           // Maximum depth of calls to visit in a goroutine.
+	MaxInstances int           // Maximum instances (calls or spawns) of a function to visit.
+	Timeout      time.Duration // Time budget of the extraction.
+}
+
+// Diagnostic is a note of where the extracted session is incomplete.
+type Diagnostic struct {
+	Pos token.Pos // Position of the cause, NoPos if not in the source code.
+	Msg string
+}
+
+func (d *Diagnostic) String() string { return d.Msg }
+
+// diagnose adds a diagnostic at pos to env, once per position and message.
+func (env *environ) diagnose(pos token.Pos, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, d := range env.diags {
+		if d.Pos == pos && d.Msg == msg {
+			return
+		}
+	}
+	env.diags = append(env.diags, &Diagnostic{Pos: pos, Msg: msg})
+}
+
+// instantiable returns true if fn can be visited again within the instance
+// limit, and counts the instance if so.
+func (env *environ) instantiable(fn *ssa.Function, pos token.Pos) bool {
+	if limit := env.extract.Limits.MaxInstances; limit > 0 && env.instances[fn] >= limit {
+		env.diagnose(pos, "%s not visited again (instance limit %d)", fn.String(), limit)
+		return false
+	}
+	env.instances[fn]++
+	return true
+}
+
+// visitable returns true if the call to callee at pos is within the limits.
+func (callee *frame) visitable(pos token.Pos) bool {
+	if limit := callee.env.extract.Limits.MaxDepth; limit > 0 {
+		depth := 0
+		for fr := callee.caller; fr != nil && fr.gortn == callee.gortn; fr = fr.caller {
+			depth++
+		}
+		if depth > limit {
+			callee.env.diagnose(pos, "call to %s not visited (depth limit %d)", callee.fn.String(), limit)
+			return false
+		}
+	}
+	return callee.env.instantiable(callee.fn, pos)
+}
@@ -0,0 +1,159 @@
+package cfsmextract
+
+// Calls modelled by extract.Models (see package stdlib).
+//
+// A receiver synchronised by models, e.g. a sync.Cond, is modelled by a
+// channel created by the goroutine first using the receiver with a model.
+// Spawns repeated forever, e.g. of HTTP handlers, are a single goroutine.
+
+import (
+	"fmt"
+	"go/token"
+
+	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
+	"github.com/nickng/dingo-hunter/cfsmextract/utils"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/dingo-hunter/stubs/stdlib"
+	"golang.org/x/tools/go/ssa"
+)
+
+// syncObj is the channel modelling a receiver.
+type syncObj struct {
+	ch    *sesstype.Chan
+	joins int // Goroutines joined by the receiver.
+}
+
+// syncKey returns the definition of the receiver v, looking through loads of
+// variables holding the receiver.
+func (caller *frame) syncKey(v ssa.Value) *utils.Definition {
+	for {
+		u, ok := v.(*ssa.UnOp)
+		if !ok || u.Op != token.MUL {
+			break
+		}
+		v = u.X
+	}
+	if vd, ok := caller.locals[v]; ok && vd != nil {
+		return vd
+	}
+	if vd, ok := caller.env.globals[v]; ok {
+		return vd
+	}
+	vd := caller.env.vers.NewDef(v)
+	caller.locals[v] = vd
+	return vd
+}
+
+// syncObj returns the channel modelling the receiver v, which is created if v
+// is not used by models before.
+func (caller *frame) syncObj(v ssa.Value, pos token.Pos) (*utils.Definition, syncObj) {
+	key := caller.syncKey(v)
+	if obj, ok := caller.env.syncs[key]; ok {
+		return key, obj
+	}
+	vd := caller.env.vers.NewDef(&stubValue{name: fmt.Sprintf("sync_%s", key.Var.Name()), parent: key.Var.Parent(), pos: pos})
+	ch := caller.env.session.MakeChan(vd, caller.gortn.role)
+	caller.env.chans[vd] = &ch
+	caller.gortn.AddNode(sesstype.NewNewChanNode(ch))
+	caller.env.syncs[key] = syncObj{ch: &ch}
+	return key, caller.env.syncs[key]
+}
+
+// callModel models the call of common to fn with extract.Models. Returns false
+// if fn is not modelled.
+func (caller *frame) callModel(common *ssa.CallCommon, fn *ssa.Function, pos token.Pos) bool {
+	name := stubs.Name(fn.String())
+	m, ok := caller.env.extract.Models[name]
+	if !ok {
+		return false
+	}
+	fmt.Fprintf(caller.env.log, "++ call %s (%s model)\n", orange(name), m.Kind)
+	var key *utils.Definition
+	var obj syncObj
+	if m.Sync() {
+		if len(common.Args) == 0 {
+			return true
+		}
+		key, obj = caller.syncObj(common.Args[0], pos)
+	}
+	switch m.Kind {
+	case stdlib.Call, stdlib.Spawn:
+		if m.Arg >= len(common.Args) {
+			return true
+		}
+		fcommon := &ssa.CallCommon{Value: common.Args[m.Arg]}
+		if m.Method != "" {
+			if f, recv, ok := ssabuilder.DynamicMethod(caller.env.extract.SSA.Prog, common.Args[m.Arg], m.Method); ok {
+				fcommon.Value = f
+				if recv != nil {
+					fcommon.Args = append(fcommon.Args, recv)
+				}
+			}
+		}
+		fn := fcommon.StaticCallee()
+		if fn == nil {
+			fmt.Fprintf(caller.env.log, "   # model %s: unknown function %s, ignored\n", name, reg(common.Args[m.Arg]))
+			return true
+		}
+		for _, param := range fn.Params[len(fcommon.Args):] {
+			fcommon.Args = append(fcommon.Args, ssa.NewConst(nil, param.Type()))
+		}
+		if m.Kind == stdlib.Call {
+			caller.callFunc(fcommon)
+			return true
+		}
+		callee := caller.goCommon(fcommon, pos)
+		if m.Join {
+			callee.gortn.join = obj.ch
+			obj.joins++
+			caller.env.syncs[key] = obj
+		}
+	case stdlib.Add, stdlib.Done:
+		// Only goroutines spawned by models are joined in CFSMs.
+	case stdlib.Join:
+		for i := 0; i < obj.joins; i++ {
+			caller.gortn.AddNode(sesstype.SetPos(sesstype.NewRecvNode(*obj.ch, caller.gortn.role, stubChanType), pos))
+		}
+	case stdlib.Wait:
+		caller.gortn.AddNode(sesstype.SetPos(sesstype.NewRecvNode(*obj.ch, caller.gortn.role, stubChanType), pos))
+	case stdlib.Notify:
+		// Continues after the default (no waiter) case.
+		parent := caller.gortn.leaf
+		caller.gortn.AddNode(sesstype.SetPos(sesstype.NewSelectSendNode(caller.gortn.role, *obj.ch, stubChanType), pos))
+		caller.gortn.leaf = parent
+		caller.gortn.AddNode(&sesstype.EmptyBodyNode{})
+	}
+	return true
+}
+
+// callFunc calls the function of common in the current goroutine.
+func (caller *frame) callFunc(common *ssa.CallCommon) {
+	callee := &frame{
+		fn:      common.StaticCallee(),
+		locals:  make(map[ssa.Value]*utils.Definition),
+		arrays:  make(map[*utils.Definition]Elems),
+		structs: make(map[*utils.Definition]Fields),
+		tuples:  make(map[ssa.Value]Tuples),
+		phi:     make(map[ssa.Value][]ssa.Value),
+		recvok:  make(map[ssa.Value]*sesstype.Chan),
+		retvals: make(Tuples, common.Signature().Results().Len()),
+		defers:  make([]*ssa.Defer, 0),
+		caller:  caller,
+		env:     caller.env,   // Use the same env as caller
+		gortn:   caller.gortn, // Use the same role as caller
+	}
+	fmt.Fprintf(caller.env.log, "++ call %s(", orange(callee.fn.String()))
+	callee.translate(common)
+	fmt.Fprintf(caller.env.log, ")\n")
+	if callee.isRecursive() {
+		fmt.Fprintf(caller.env.log, "-- Recursive %s()\n", orange(callee.fn.String()))
+		return
+	}
+	if !callee.visitable(common.Pos()) {
+		return
+	}
+	visitFunc(callee.fn, callee)
+	caller.panicked = callee.panics
+	fmt.Fprintf(caller.env.log, "-- return from %s\n", orange(callee.fn.String()))
+}
@@ -0,0 +1,272 @@
+package cfsmextract
+
+// Concurrent analysis of queued goroutines.
+//
+// Goroutines are analysed in waves: goroutines queued when a wave starts are
+// analysed concurrently, each with a fork of the environment, and the forks
+// are merged back in queue order. Goroutines spawned during a wave are
+// analysed in the next wave, so the result does not depend on number of jobs.
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"sync"
+
+	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
+	"github.com/nickng/dingo-hunter/cfsmextract/utils"
+	"golang.org/x/tools/go/ssa"
+)
+
+// runQueue analyses the goroutines queued in env.
+func (extract *CFSMExtract) runQueue(env *environ) {
+	for len(env.queue) > 0 && !extract.cancelled() {
+		wave := env.queue
+		env.queue = []*frame{}
+		extract.runWave(env, wave)
+	}
+}
+
+// runWave analyses the goroutines in wave with up to extract.Jobs workers.
+func (extract *CFSMExtract) runWave(env *environ, wave []*frame) {
+	base := env.fork()
+	forks := make([]*environ, len(wave))
+	for i, goFrm := range wave {
+		forks[i] = env.fork()
+		goFrm.env = forks[i]
+		goFrm.arrays, goFrm.structs = copyElems(goFrm.arrays), copyFields(goFrm.structs)
+	}
+
+	jobs := extract.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	logs := make([]bytes.Buffer, len(wave))
+	if jobs > 1 { // Keep logs of goroutines in order.
+		for i, fork := range forks {
+			fork.log = &logs[i]
+		}
+	}
+	queue := make(chan *frame)
+	var wg sync.WaitGroup
+	var panicOnce sync.Once
+	var panicked interface{} // First panic of the workers.
+	for n := 0; n < jobs && n < len(wave); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for goFrm := range queue {
+				func() {
+					// Panics are raised again by the analysis goroutine,
+					// to be recovered by the caller of RunContext.
+					defer func() {
+						if r := recover(); r != nil {
+							panicOnce.Do(func() { panicked = r })
+						}
+					}()
+					fmt.Fprintf(goFrm.env.log, "\n%s\nLOCATION: %s%s\n", goFrm.fn.Name(), goFrm.gortn.role.Name(), loc(goFrm, goFrm.fn.Pos()))
+					visitFunc(goFrm.fn, goFrm)
+					if ch := goFrm.gortn.join; ch != nil {
+						goFrm.gortn.AddNode(sesstype.NewSendNode(goFrm.gortn.role, *ch, stubChanType))
+					}
+					goFrm.env.session.Types[goFrm.gortn.role] = goFrm.gortn.root
+				}()
+			}
+		}()
+	}
+	for _, goFrm := range wave {
+		queue <- goFrm
+	}
+	close(queue)
+	wg.Wait()
+	for i := range logs {
+		if logs[i].Len() > 0 {
+			env.log.Write(logs[i].Bytes())
+		}
+	}
+	if panicked != nil {
+		panic(panicked)
+	}
+
+	for _, fork := range forks {
+		env.merge(fork, base)
+	}
+}
+
+// fork returns a copy of env which can be used concurrently with env, the
+// changes are merged back to env with merge.
+func (env *environ) fork() *environ {
+	f := &environ{
+		session: &sesstype.Session{
+			Types: make(map[sesstype.Role]sesstype.Node, len(env.session.Types)),
+			Chans: make(map[*utils.Definition]sesstype.Chan, len(env.session.Chans)),
+			Roles: make(map[string]sesstype.Role, len(env.session.Roles)),
+		},
+		extract:  env.extract,
+		globals:  make(map[ssa.Value]*utils.Definition, len(env.globals)),
+		arrays:   copyElems(env.arrays),
+		structs:  copyFields(env.structs),
+		chans:    make(map[*utils.Definition]*sesstype.Chan, len(env.chans)),
+		extern:   make(map[ssa.Value]types.Type, len(env.extern)),
+		closures: make(map[ssa.Value]Captures, len(env.closures)),
+		selNode: make(map[ssa.Value]struct {
+			parent   *sesstype.Node
+			blocking bool
+		}, len(env.selNode)),
+		selIdx: make(map[ssa.Value]ssa.Value, len(env.selIdx)),
+		selTest: make(map[ssa.Value]struct {
+			idx int
+			tpl ssa.Value
+		}, len(env.selTest)),
+		recvTest:  make(map[ssa.Value]*sesstype.Chan, len(env.recvTest)),
+		syncs:     make(map[*utils.Definition]syncObj, len(env.syncs)),
+		ifparent:  sesstype.NewNodeStack(),
+		vers:      env.vers.Fork(),
+		queue:     []*frame{},
+		instances: make(map[*ssa.Function]int, len(env.instances)),
+		log:       env.log,
+	}
+	for role, node := range env.session.Types {
+		f.session.Types[role] = node
+	}
+	for vd, ch := range env.session.Chans {
+		f.session.Chans[vd] = ch
+	}
+	for name, role := range env.session.Roles {
+		f.session.Roles[name] = role
+	}
+	for v, vd := range env.globals {
+		f.globals[v] = vd
+	}
+	for vd, ch := range env.chans {
+		f.chans[vd] = ch
+	}
+	for v, t := range env.extern {
+		f.extern[v] = t
+	}
+	for v, cap := range env.closures {
+		f.closures[v] = cap
+	}
+	for v, sel := range env.selNode {
+		f.selNode[v] = sel
+	}
+	for v, idx := range env.selIdx {
+		f.selIdx[v] = idx
+	}
+	for v, test := range env.selTest {
+		f.selTest[v] = test
+	}
+	for v, ch := range env.recvTest {
+		f.recvTest[v] = ch
+	}
+	for vd, obj := range env.syncs {
+		f.syncs[vd] = obj
+	}
+	for fn, n := range env.instances {
+		f.instances[fn] = n
+	}
+	return f
+}
+
+// merge merges a fork of env back to env. base is a fork of env taken at the
+// same time as fork, so only values changed in fork are merged.
+func (env *environ) merge(fork, base *environ) {
+	env.vers.Merge(fork.vers)
+	for role, node := range fork.session.Types {
+		if base.session.Types[role] != node {
+			env.session.Types[role] = node
+		}
+	}
+	for vd, ch := range fork.session.Chans {
+		env.session.Chans[vd] = ch
+	}
+	for name, role := range fork.session.Roles {
+		if _, ok := env.session.Roles[name]; !ok {
+			env.session.Roles[name] = role
+		}
+	}
+	for v, vd := range fork.globals {
+		if base.globals[v] != vd {
+			env.globals[v] = vd
+		}
+	}
+	for vd, elems := range fork.arrays {
+		if _, ok := env.arrays[vd]; !ok {
+			env.arrays[vd] = elems
+			continue
+		}
+		for k, elem := range elems {
+			if base.arrays[vd][k] != elem {
+				env.arrays[vd][k] = elem
+			}
+		}
+	}
+	for vd, fields := range fork.structs {
+		if _, ok := env.structs[vd]; !ok {
+			env.structs[vd] = fields
+			continue
+		}
+		for i, field := range fields {
+			if base.structs[vd][i] != field {
+				env.structs[vd][i] = field
+			}
+		}
+	}
+	for vd, ch := range fork.chans {
+		env.chans[vd] = ch
+	}
+	for v, t := range fork.extern {
+		env.extern[v] = t
+	}
+	for v, cap := range fork.closures {
+		env.closures[v] = cap
+	}
+	for v, sel := range fork.selNode {
+		env.selNode[v] = sel
+	}
+	for v, idx := range fork.selIdx {
+		env.selIdx[v] = idx
+	}
+	for v, test := range fork.selTest {
+		env.selTest[v] = test
+	}
+	for v, ch := range fork.recvTest {
+		env.recvTest[v] = ch
+	}
+	for vd, obj := range fork.syncs {
+		if base.syncs[vd] != obj {
+			env.syncs[vd] = obj
+		}
+	}
+	env.queue = append(env.queue, fork.queue...)
+	for fn, n := range fork.instances {
+		env.instances[fn] += n - base.instances[fn]
+	}
+	for _, d := range fork.diags {
+		env.diagnose(d.Pos, "%s", d.Msg)
+	}
+}
+
+// copyElems returns a deep copy of arrays.
+func copyElems(arrays map[*utils.Definition]Elems) map[*utils.Definition]Elems {
+	c := make(map[*utils.Definition]Elems, len(arrays))
+	for vd, elems := range arrays {
+		c[vd] = make(Elems, len(elems))
+		for k, elem := range elems {
+			c[vd][k] = elem
+		}
+	}
+	return c
+}
+
+// copyFields returns a deep copy of structs.
+func copyFields(structs map[*utils.Definition]Fields) map[*utils.Definition]Fields {
+	c := make(map[*utils.Definition]Fields, len(structs))
+	for vd, fields := range structs {
+		c[vd] = make(Fields, len(fields))
+		for i, field := range fields {
+			c[vd][i] = field
+		}
+	}
+	return c
+}
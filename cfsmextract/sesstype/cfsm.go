@@ -1,11 +1,17 @@
 package sesstype
 
 import (
+	"bytes"
 	"fmt"
+	"go/token"
 	"io"
 	"log"
+	"sort"
+	"strings"
+	"text/template"
 
 	"github.com/nickng/cfsm"
+	"github.com/nickng/cfsm/petrify"
 )
 
 // STOP is the 'close' message.
@@ -17,6 +23,10 @@ type CFSMs struct {
 	Chans  map[Role]*cfsm.CFSM
 	Roles  map[Role]*cfsm.CFSM
 	States map[*cfsm.CFSM]map[string]*cfsm.State
+
+	chans []Role                        // Channels in canonical order.
+	roles []Role                        // Roles in canonical order.
+	pos   map[cfsm.Transition]token.Pos // Source positions of transitions.
 }
 
 func NewCFSMs(s *Session) *CFSMs {
@@ -25,32 +35,40 @@ func NewCFSMs(s *Session) *CFSMs {
 		Chans:  make(map[Role]*cfsm.CFSM),
 		Roles:  make(map[Role]*cfsm.CFSM),
 		States: make(map[*cfsm.CFSM]map[string]*cfsm.State),
+		pos:    make(map[cfsm.Transition]token.Pos),
 	}
-	for _, c := range s.Chans {
+	for _, c := range s.SortedChans() {
 		m := sys.Sys.NewMachine()
 		m.Comment = c.Name()
 		sys.Chans[c] = m
+		sys.chans = append(sys.chans, c)
 		defer sys.chanToMachine(c, c.Type().String(), m)
 	}
-	for role, root := range s.Types {
+	for _, role := range s.SortedRoles() {
 		m := sys.Sys.NewMachine()
 		m.Comment = role.Name()
 		sys.Roles[role] = m
 		sys.States[m] = make(map[string]*cfsm.State)
-		sys.rootToMachine(role, root, m)
+		sys.rootToMachine(role, s.Types[role], m)
 		if m.IsEmpty() {
 			log.Println("Machine", m.ID, "is empty")
 			sys.Sys.RemoveMachine(m.ID)
 			delete(sys.Roles, role)
 			delete(sys.States, m)
+			continue
 		}
+		sys.roles = append(sys.roles, role)
 	}
 	return sys
 }
 
 // WriteTo implementers io.WriterTo interface.
 func (sys *CFSMs) WriteTo(w io.Writer) (int64, error) {
-	n, err := w.Write([]byte(sys.Sys.String()))
+	var buf bytes.Buffer
+	for _, m := range sys.Sys.CFSMs {
+		buf.WriteString(machineString(m))
+	}
+	n, err := w.Write(buf.Bytes())
 	return int64(n), err
 }
 
@@ -58,12 +76,45 @@ func (sys *CFSMs) WriteTo(w io.Writer) (int64, error) {
 func (sys *CFSMs) PrintSummary() {
 	fmt.Printf("Total of %d CFSMs (%d are channels)\n",
 		len(sys.Roles)+len(sys.Chans), len(sys.Chans))
-	for r, m := range sys.Chans {
-		fmt.Printf("\t%d\t= %s (channel)\n", m.ID, r.Name())
+	for _, r := range sys.chans {
+		fmt.Printf("\t%d\t= %s (channel)\n", sys.Chans[r].ID, r.Name())
+	}
+	for _, r := range sys.roles {
+		fmt.Printf("\t%d\t= %s\n", sys.Roles[r].ID, r.Name())
+	}
+}
+
+var machineTmpl = template.Must(template.New("petrify").Funcs(template.FuncMap{
+	"multiline": func(s string) string { return strings.Replace(s, "\n", "\n--", -1) },
+}).Parse(petrify.Tmpl))
+
+// machineString returns m in petrify format as cfsm.CFSM String, but with the
+// transitions of each state sorted so the output is stable.
+func machineString(m *cfsm.CFSM) string {
+	mach := struct {
+		ID      int
+		Start   *cfsm.State
+		Comment string
+		Edges   []string
+	}{
+		ID:      m.ID,
+		Start:   m.Start,
+		Comment: m.Comment,
+	}
+	for _, st := range m.States() {
+		var edges []string
+		for _, tr := range st.Transitions() {
+			edges = append(edges, fmt.Sprintf("q%d%d %s q%d%d\n",
+				m.ID, st.ID, petrify.Encode(tr.Label()), m.ID, tr.State().ID))
+		}
+		sort.Strings(edges)
+		mach.Edges = append(mach.Edges, edges...)
 	}
-	for r, m := range sys.Roles {
-		fmt.Printf("\t%d\t= %s\n", m.ID, r.Name())
+	var buf bytes.Buffer
+	if err := machineTmpl.Execute(&buf, mach); err != nil {
+		log.Println("Failed to execute template:", err)
 	}
+	return buf.String()
 }
 
 func (sys *CFSMs) rootToMachine(role Role, root Node, m *cfsm.CFSM) {
@@ -91,12 +142,32 @@ func (sys *CFSMs) nodeToMachine(role Role, node Node, q0 *cfsm.State, m *cfsm.CF
 		}
 		tr.SetNext(qSent)
 		q0.AddTransition(tr)
+		sys.pos[tr] = node.Pos()
 
 	case *RecvNode:
 		from, ok := sys.Chans[node.From()]
 		if !ok {
 			log.Fatal("Cannot Recv from unknown channel", node.From().Name())
 		}
+		if node.CommaOk() {
+			// Choice of value (ok) or STOP (closed, !ok).
+			for i, msg := range []string{node.From().Type().String(), STOP} {
+				tr := cfsm.NewRecv(from, msg)
+				if i < len(node.Children()) {
+					qNext, ok := sys.loopback(m, node.Child(i))
+					if !ok {
+						qNext = m.NewState()
+						sys.nodeToMachine(role, node.Child(i), qNext, m)
+					}
+					tr.SetNext(qNext)
+				} else {
+					tr.SetNext(m.NewState())
+				}
+				q0.AddTransition(tr)
+				sys.pos[tr] = node.Pos()
+			}
+			return
+		}
 		msg := node.From().Type().String()
 		if node.Stop() {
 			msg = STOP
@@ -113,6 +184,7 @@ func (sys *CFSMs) nodeToMachine(role Role, node Node, q0 *cfsm.State, m *cfsm.CF
 		}
 		tr.SetNext(qRcvd)
 		q0.AddTransition(tr)
+		sys.pos[tr] = node.Pos()
 
 	case *EndNode:
 		ch, ok := sys.Chans[node.Chan()]
@@ -126,6 +198,7 @@ func (sys *CFSMs) nodeToMachine(role Role, node Node, q0 *cfsm.State, m *cfsm.CF
 		}
 		tr.SetNext(qEnd)
 		q0.AddTransition(tr)
+		sys.pos[tr] = node.Pos()
 
 	case *NewChanNode, *EmptyBodyNode: // Skip
 		for _, c := range node.Children() {
@@ -152,14 +225,16 @@ func (sys *CFSMs) nodeToMachine(role Role, node Node, q0 *cfsm.State, m *cfsm.CF
 func (sys *CFSMs) chanToMachine(ch Role, T string, m *cfsm.CFSM) {
 	q0 := m.NewState()
 	qEnd := m.NewState()
-	for _, machine := range sys.Roles {
+	for _, r := range sys.roles {
+		machine := sys.Roles[r]
 		q1 := m.NewState()
 		// q0 -- Recv --> q1
 		tr0 := cfsm.NewRecv(machine, T)
 		tr0.SetNext(q1)
 		q0.AddTransition(tr0)
 		// q1 -- Send --> q0
-		for _, machine2 := range sys.Roles {
+		for _, r2 := range sys.roles {
+			machine2 := sys.Roles[r2]
 			if machine.ID != machine2.ID {
 				tr1 := cfsm.NewSend(machine2, T)
 				tr1.SetNext(q0)
@@ -169,9 +244,10 @@ func (sys *CFSMs) chanToMachine(ch Role, T string, m *cfsm.CFSM) {
 		// q0 -- STOP --> qEnd (same qEnd)
 		tr2 := cfsm.NewRecv(machine, STOP)
 		tr2.SetNext(qEnd)
-		qEnd.AddTransition(tr2)
+		q0.AddTransition(tr2)
 		// qEnd -- STOP --> qEnd
-		for _, machine2 := range sys.Roles {
+		for _, r2 := range sys.roles {
+			machine2 := sys.Roles[r2]
 			if machine.ID != machine2.ID {
 				tr3 := cfsm.NewSend(machine2, STOP)
 				tr3.SetNext(qEnd)
@@ -186,11 +262,24 @@ func (sys *CFSMs) chanToMachine(ch Role, T string, m *cfsm.CFSM) {
 // i.e. the state before and after the transition is the same.
 func (sys *CFSMs) isSelfLoop(m *cfsm.CFSM, q0 *cfsm.State, node Node) bool {
 	if len(node.Children()) == 1 {
-		if gotoNode, ok := node.Child(0).(*GotoNode); ok {
-			if loopback, ok := sys.States[m][gotoNode.Name()]; ok {
-				return loopback == q0
-			}
+		if loopback, ok := sys.loopback(m, node.Child(0)); ok {
+			return loopback == q0
 		}
 	}
 	return false
 }
+
+// loopback returns the state node jumps to if node is a jump to an existing
+// state without any action, i.e. a goto possibly after empty bodies.
+func (sys *CFSMs) loopback(m *cfsm.CFSM, node Node) (*cfsm.State, bool) {
+	switch node := node.(type) {
+	case *GotoNode:
+		q, ok := sys.States[m][node.Name()]
+		return q, ok
+	case *EmptyBodyNode:
+		if len(node.Children()) == 1 {
+			return sys.loopback(m, node.Child(0))
+		}
+	}
+	return nil, false
+}
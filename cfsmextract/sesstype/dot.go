@@ -25,7 +25,8 @@ func NewGraphvizDot(s *Session) *GraphvizDot {
 	dot.Graph.SetDir(true)
 	dot.Graph.SetName("G")
 
-	for role, root := range s.Types {
+	for _, role := range s.SortedRoles() {
+		root := s.Types[role]
 		sg := gographviz.NewSubGraph("\"cluster_" + role.Name() + "\"")
 		if root != nil {
 			dot.visitNode(root, sg, nil)
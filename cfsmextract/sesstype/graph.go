@@ -0,0 +1,185 @@
+package sesstype
+
+// Graphs of sessions and CFSMs for rendering by clients, e.g. encoded as JSON
+// for the web interface, as an alternative to Graphviz dot.
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+
+	"github.com/nickng/cfsm"
+)
+
+// Graph is a directed graph of nodes in groups, e.g. the session types of
+// roles or the states of CFSMs.
+type Graph struct {
+	Groups []*GraphGroup `json:"groups"`
+	Nodes  []*GraphNode  `json:"nodes"`
+	Edges  []*GraphEdge  `json:"edges"`
+}
+
+// GraphGroup is a group of nodes, a role of a session or a CFSM.
+type GraphGroup struct {
+	ID    string    `json:"id"`
+	Label string    `json:"label"`
+	Kind  string    `json:"kind"` // "role" or "chan" (machine of a channel).
+	Pos   token.Pos `json:"-"`    // Source position, if known.
+}
+
+// GraphNode is a node of a Graph.
+type GraphNode struct {
+	ID    string    `json:"id"`
+	Group string    `json:"group"` // ID of the group of the node.
+	Kind  string    `json:"kind"`  // e.g. "send", "recv" (sessions) or "start", "state" (CFSMs).
+	Label string    `json:"label"`
+	Pos   token.Pos `json:"-"` // Source position, if known.
+}
+
+// GraphEdge is an edge between nodes of a Graph.
+type GraphEdge struct {
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Label string    `json:"label,omitempty"`
+	Pos   token.Pos `json:"-"` // Source position, if known.
+}
+
+// NewSessionGraph returns the graph of session s, with a group of each role
+// and a node of each session type node.
+func NewSessionGraph(s *Session) *Graph {
+	g := &Graph{Groups: []*GraphGroup{}, Nodes: []*GraphNode{}, Edges: []*GraphEdge{}}
+	labels := make(map[string]string) // Nodes of labels by group and name.
+	var gotos []*GraphEdge            // Edges to labels, resolved after visiting.
+	var visit func(node Node, group, parent string)
+	visit = func(node Node, group, parent string) {
+		if gt, ok := node.(*GotoNode); ok {
+			gotos = append(gotos, &GraphEdge{From: parent, To: group + "\x00" + gt.Name()})
+			for _, child := range node.Children() {
+				visit(child, group, parent)
+			}
+			return
+		}
+		n := &GraphNode{ID: "n" + strconv.Itoa(len(g.Nodes)), Group: group, Kind: nodeKind(node), Label: nodeLabel(node)}
+		if node, ok := node.(Positioned); ok {
+			n.Pos = node.Pos()
+		}
+		if label, ok := node.(*LabelNode); ok {
+			labels[group+"\x00"+label.Name()] = n.ID
+		}
+		g.Nodes = append(g.Nodes, n)
+		if parent != "" {
+			g.Edges = append(g.Edges, &GraphEdge{From: parent, To: n.ID})
+		}
+		for _, child := range node.Children() {
+			visit(child, group, n.ID)
+		}
+	}
+	for i, role := range s.SortedRoles() {
+		group := "r" + strconv.Itoa(i)
+		g.Groups = append(g.Groups, &GraphGroup{ID: group, Label: role.Name(), Kind: "role", Pos: role.Pos()})
+		if root := s.Types[role]; root != nil {
+			first := len(g.Nodes)
+			visit(root, group, "")
+			if first < len(g.Nodes) && !g.Nodes[first].Pos.IsValid() {
+				g.Nodes[first].Pos = role.Pos()
+			}
+		}
+	}
+	for _, e := range gotos {
+		if to, ok := labels[e.To]; ok && e.From != "" {
+			e.To = to
+			g.Edges = append(g.Edges, e)
+		}
+	}
+	return g
+}
+
+// nodeKind returns the kind of node in a session graph.
+func nodeKind(node Node) string {
+	switch node.(type) {
+	case *NewChanNode:
+		return "newchan"
+	case *SendNode:
+		return "send"
+	case *RecvNode:
+		return "recv"
+	case *EndNode:
+		return "end"
+	case *LabelNode:
+		return "label"
+	case *EmptyBodyNode:
+		return "empty"
+	}
+	return "other"
+}
+
+// nodeLabel returns the label of node in a session graph, as in Graphviz dot.
+func nodeLabel(node Node) string {
+	switch node := node.(type) {
+	case *NewChanNode:
+		return fmt.Sprintf("Channel %s Type:%s", node.Chan().Name(), node.Chan().Type())
+	case *SendNode:
+		if node.IsNondet() {
+			return fmt.Sprintf("Send %s nondet", node.To().Name())
+		}
+		return fmt.Sprintf("Send %s", node.To().Name())
+	case *RecvNode:
+		if node.IsNondet() {
+			return fmt.Sprintf("Recv %s nondet", node.From().Name())
+		}
+		return fmt.Sprintf("Recv %s", node.From().Name())
+	}
+	return node.String()
+}
+
+// Graph returns the graph of the CFSMs, with a group of each machine and a
+// node of each state. The nodes are named as states in the CFSMs (see
+// WriteTo), e.g. q12 for state 2 of machine 1.
+func (sys *CFSMs) Graph() *Graph {
+	g := &Graph{Groups: []*GraphGroup{}, Nodes: []*GraphNode{}, Edges: []*GraphEdge{}}
+	for _, m := range sys.Sys.CFSMs {
+		group := &GraphGroup{ID: "m" + strconv.Itoa(m.ID), Label: m.Comment, Kind: "role"}
+		if role := sys.role(m); role != nil {
+			group.Pos = role.Pos()
+		} else if ch := sys.channel(m); ch != nil {
+			group.Kind, group.Pos = "chan", ch.Pos()
+		}
+		g.Groups = append(g.Groups, group)
+		for _, st := range m.States() {
+			n := &GraphNode{ID: stateID(m, st), Group: group.ID, Kind: "state", Label: fmt.Sprintf("q%d%d", m.ID, st.ID)}
+			if st == m.Start {
+				n.Kind, n.Pos = "start", group.Pos
+			}
+			g.Nodes = append(g.Nodes, n)
+			for _, tr := range st.Transitions() {
+				g.Edges = append(g.Edges, &GraphEdge{From: n.ID, To: stateID(m, tr.State()), Label: tr.Label(), Pos: sys.pos[tr]})
+			}
+		}
+	}
+	return g
+}
+
+// stateID returns the ID of the node of state st of machine m in graphs.
+func stateID(m *cfsm.CFSM, st *cfsm.State) string {
+	return fmt.Sprintf("q%d_%d", m.ID, st.ID)
+}
+
+// role returns the role of machine m, or nil if m is a channel.
+func (sys *CFSMs) role(m *cfsm.CFSM) Role {
+	for _, r := range sys.roles {
+		if sys.Roles[r] == m {
+			return r
+		}
+	}
+	return nil
+}
+
+// channel returns the channel of machine m, or nil if m is a role.
+func (sys *CFSMs) channel(m *cfsm.CFSM) Role {
+	for _, c := range sys.chans {
+		if sys.Chans[c] == m {
+			return c
+		}
+	}
+	return nil
+}
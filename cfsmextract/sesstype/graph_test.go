@@ -0,0 +1,71 @@
+package sesstype
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/nickng/dingo-hunter/cfsmextract/utils"
+)
+
+// Tests the graph of a session has a node of each session type node, and
+// edges of jumps to labels.
+func TestSessionGraph(t *testing.T) {
+	s := CreateSession()
+	r := s.GetRole("main")
+	c := s.MakeChan(utils.NewVersions().NewDef(mockChan{}), r)
+	root := NewLabelNode("loop")
+	root.Append(SetPos(NewSendNode(r, c, types.NewStruct(nil, nil)), 42)).Append(NewGotoNode("loop"))
+	s.Types[r] = root
+
+	g := NewSessionGraph(s)
+	if want, got := 1, len(g.Groups); want != got {
+		t.Fatalf("Expecting %d group but got %d\n", want, got)
+	}
+	if want, got := 2, len(g.Nodes); want != got {
+		t.Fatalf("Expecting %d nodes but got %d\n", want, got)
+	}
+	if send := g.Nodes[1]; send.Kind != "send" || send.Pos != 42 {
+		t.Errorf("Expecting send at position 42 but got %+v\n", send)
+	}
+	if want, got := 2, len(g.Edges); want != got {
+		t.Fatalf("Expecting %d edges but got %d\n", want, got)
+	}
+	if loop := g.Edges[1]; loop.From != g.Nodes[1].ID || loop.To != g.Nodes[0].ID {
+		t.Errorf("Expecting edge from send to label but got %+v\n", loop)
+	}
+}
+
+// Tests the trace of CFSMs to a receive without sender.
+func TestStuckTrace(t *testing.T) {
+	s := CreateSession()
+	main := s.GetRole("main")
+	worker := s.GetRole("worker")
+	c := s.MakeChan(utils.NewVersions().NewDef(mockChan{}), main)
+	d := s.MakeChan(utils.NewVersions().NewDef(mockChan{}), main)
+	typ := types.NewStruct(nil, nil)
+	// main: send c; recv d. worker: recv c.
+	s.Types[main] = NewLabelNode("main")
+	s.Types[main].Append(NewSendNode(main, c, typ)).Append(NewRecvNode(d, main, typ))
+	s.Types[worker] = NewLabelNode("worker")
+	s.Types[worker].Append(NewRecvNode(c, worker, typ))
+
+	sys := NewCFSMs(s)
+	trace := sys.StuckTrace(1000)
+	if trace == nil {
+		t.Fatalf("Expecting trace to stuck configuration\n")
+	}
+	// Start, main sends to c, c sends to worker.
+	if want, got := 3, len(trace.Steps); want != got {
+		t.Errorf("Expecting %d steps but got %d\n", want, got)
+	}
+	m := sys.Roles[main]
+	if want := stateID(m, m.States()[1]); len(trace.Stuck) != 1 || trace.Stuck[0] != want {
+		t.Errorf("Expecting main stuck at %s but got %v\n", want, trace.Stuck)
+	}
+
+	// worker: recv c; send d.
+	s.Types[worker].Child(0).Append(NewSendNode(worker, d, typ))
+	if trace := NewCFSMs(s).StuckTrace(1000); trace != nil {
+		t.Errorf("Expecting no stuck configuration but got %+v\n", trace.Steps[len(trace.Steps)-1])
+	}
+}
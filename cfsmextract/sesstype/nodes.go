@@ -23,7 +23,10 @@ func SessionCountNodes(session *Session) map[string]int {
 func PrintNodeSummary(session *Session) {
 	counts := SessionCountNodes(session)
 	fmt.Printf("Total of nodes per role (%d roles)\n", len(counts))
-	for role, n := range counts {
-		fmt.Printf("\t%d\t: %s\n", n, role)
+	for _, role := range session.SortedRoles() {
+		if n, ok := counts[role.Name()]; ok {
+			fmt.Printf("\t%d\t: %s\n", n, role.Name())
+			delete(counts, role.Name())
+		}
 	}
 }
@@ -0,0 +1,42 @@
+package sesstype
+
+// Canonical ordering of roles and channels: by source position, then name.
+
+import (
+	"sort"
+)
+
+// roleLess returns true if role a is ordered before role b.
+func roleLess(a, b Role) bool {
+	if a.Pos() != b.Pos() {
+		return a.Pos() < b.Pos()
+	}
+	return a.Name() < b.Name()
+}
+
+// SortedRoles returns the roles with a session type in canonical order.
+func (s *Session) SortedRoles() []Role {
+	roles := make([]Role, 0, len(s.Types))
+	for r := range s.Types {
+		roles = append(roles, r)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		if roleLess(roles[i], roles[j]) || roleLess(roles[j], roles[i]) {
+			return roleLess(roles[i], roles[j])
+		}
+		// Same name and position, e.g. same go statement in different
+		// goroutines.
+		return StringRecursive(s.Types[roles[i]]) < StringRecursive(s.Types[roles[j]])
+	})
+	return roles
+}
+
+// SortedChans returns the channels in canonical order.
+func (s *Session) SortedChans() []Chan {
+	chans := make([]Chan, 0, len(s.Chans))
+	for _, ch := range s.Chans {
+		chans = append(chans, ch)
+	}
+	sort.Slice(chans, func(i, j int) bool { return roleLess(chans[i], chans[j]) })
+	return chans
+}
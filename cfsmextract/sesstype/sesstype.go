@@ -6,7 +6,9 @@ package sesstype // import "github.com/nickng/dingo-hunter/cfsmextract/sesstype"
 
 import (
 	"fmt"
+	"go/token"
 	"go/types"
+	"sort"
 
 	"github.com/nickng/dingo-hunter/cfsmextract/utils"
 	"golang.org/x/tools/go/ssa"
@@ -40,17 +42,21 @@ func (ch Chan) Type() types.Type {
 }
 func (ch Chan) Role() Role       { return ch.role }
 func (ch Chan) Value() ssa.Value { return ch.def.Var }
+func (ch Chan) Pos() token.Pos   { return ch.def.Var.Pos() }
 
 // Role in a session (main or goroutine).
 type Role interface {
 	Name() string
+	Pos() token.Pos // Source position of the role.
 }
 
 type role struct {
 	name string
+	pos  token.Pos
 }
 
-func (r *role) Name() string { return r.name }
+func (r *role) Name() string   { return r.name }
+func (r *role) Pos() token.Pos { return r.pos }
 
 // Different operations/actions available in session.
 const (
@@ -89,8 +95,14 @@ func CreateSession() *Session {
 
 // GetRole returns or create (if empty) a new session role using given name.
 func (s *Session) GetRole(name string) Role { // Get or create role
+	return s.GetRoleAt(name, token.NoPos)
+}
+
+// GetRoleAt returns or create (if empty) a new session role using given name
+// and source position, e.g. of the go statement.
+func (s *Session) GetRoleAt(name string, pos token.Pos) Role {
 	if _, found := s.Roles[name]; !found {
-		s.Roles[name] = &role{name: name}
+		s.Roles[name] = &role{name: name, pos: pos}
 	}
 	return s.Roles[name]
 }
@@ -115,14 +127,38 @@ func (s *Session) MakeExtChan(v *utils.Definition, r Role) Chan {
 	return s.Chans[v]
 }
 
+// Positioned is a Node of an operation in the source code, e.g. a send.
+type Positioned interface {
+	Node
+	Pos() token.Pos // Source position of the operation, if known.
+}
+
+// SetPos sets the source position of node if it is Positioned, and returns
+// node.
+func SetPos(node Node, pos token.Pos) Node {
+	switch node := node.(type) {
+	case *NewChanNode:
+		node.pos = pos
+	case *SendNode:
+		node.pos = pos
+	case *RecvNode:
+		node.pos = pos
+	case *EndNode:
+		node.pos = pos
+	}
+	return node
+}
+
 // NewChanNode represents creation of new channel
 type NewChanNode struct {
 	ch       Chan
+	pos      token.Pos
 	children []Node
 }
 
-func (nc *NewChanNode) Kind() op   { return NewChanOp }
-func (nc *NewChanNode) Chan() Chan { return nc.ch }
+func (nc *NewChanNode) Kind() op       { return NewChanOp }
+func (nc *NewChanNode) Chan() Chan     { return nc.ch }
+func (nc *NewChanNode) Pos() token.Pos { return nc.pos }
 func (nc *NewChanNode) Append(n Node) Node {
 	nc.children = append(nc.children, n)
 	return n
@@ -139,10 +175,12 @@ type SendNode struct {
 	dest     Chan       // Destination
 	nondet   bool       // Is this non-deterministic?
 	t        types.Type // Datatype
+	pos      token.Pos  // Source position
 	children []Node
 }
 
 func (s *SendNode) Kind() op       { return SendOp }
+func (s *SendNode) Pos() token.Pos { return s.pos }
 func (s *SendNode) Sender() Role   { return s.sndr }
 func (s *SendNode) To() Chan       { return s.dest }
 func (s *SendNode) IsNondet() bool { return s.nondet }
@@ -167,14 +205,18 @@ type RecvNode struct {
 	nondet   bool       // Is this non-deterministic?
 	t        types.Type // Datatype
 	stop     bool       // Stop message only?
+	commaok  bool       // Value or Stop message (v, ok := <-ch)?
+	pos      token.Pos  // Source position
 	children []Node
 }
 
 func (r *RecvNode) Kind() op       { return RecvOp }
+func (r *RecvNode) Pos() token.Pos { return r.pos }
 func (r *RecvNode) Receiver() Role { return r.rcvr }
 func (r *RecvNode) From() Chan     { return r.orig }
 func (r *RecvNode) IsNondet() bool { return r.nondet }
 func (r *RecvNode) Stop() bool     { return r.stop }
+func (r *RecvNode) CommaOk() bool  { return r.commaok }
 func (r *RecvNode) Append(node Node) Node {
 	r.children = append(r.children, node)
 	return node
@@ -189,6 +231,9 @@ func (r *RecvNode) String() string {
 	if r.t == nil {
 		return fmt.Sprintf("Recv END %s←ᶜʰ%s%s", r.rcvr.Name(), r.orig.Name(), nd)
 	}
+	if r.commaok {
+		return fmt.Sprintf("Recv,ok %s←ᶜʰ%s%s", r.rcvr.Name(), r.orig.Name(), nd)
+	}
 	return fmt.Sprintf("Recv %s←ᶜʰ%s%s", r.rcvr.Name(), r.orig.Name(), nd)
 }
 
@@ -226,11 +271,13 @@ func (g *GotoNode) String() string   { return fmt.Sprintf("Goto %s", g.name) }
 
 type EndNode struct {
 	ch       Chan
+	pos      token.Pos
 	children []Node
 }
 
-func (e *EndNode) Kind() op   { return EndOp }
-func (e *EndNode) Chan() Chan { return e.ch }
+func (e *EndNode) Kind() op       { return EndOp }
+func (e *EndNode) Chan() Chan     { return e.ch }
+func (e *EndNode) Pos() token.Pos { return e.pos }
 func (e *EndNode) Append(n Node) Node {
 	e.children = append(e.children, n)
 	return n
@@ -254,7 +301,7 @@ func (e *EmptyBodyNode) String() string   { return "(Empty)" }
 
 // NewNewChanNode makes a NewChanNode.
 func NewNewChanNode(ch Chan) Node {
-	return &NewChanNode{ch: ch, children: []Node{}}
+	return &NewChanNode{ch: ch, pos: ch.Pos(), children: []Node{}}
 }
 
 // NewSendNode makes a SendNode.
@@ -303,6 +350,20 @@ func NewRecvStopNode(orig Chan, rcvr Role, typ types.Type) Node {
 	}
 }
 
+// NewRecvOkNode makes a RecvNode for comma-ok receive (v, ok := <-ch).
+// The first child is the continuation when a value is received (ok), and the
+// second child is the continuation when STOP is received (!ok).
+func NewRecvOkNode(orig Chan, rcvr Role, typ types.Type) Node {
+	return &RecvNode{
+		orig:     orig,
+		rcvr:     rcvr,
+		nondet:   false,
+		t:        typ,
+		commaok:  true,
+		children: []Node{},
+	}
+}
+
 // NewSelectRecvNode makes a RecvNode in a select (non-deterministic).
 func NewSelectRecvNode(orig Chan, rcvr Role, typ types.Type) Node {
 	return &RecvNode{
@@ -340,16 +401,21 @@ func NewEndNode(ch Chan) Node {
 // String displays session details.
 func (s *Session) String() string {
 	str := "# Channels\n"
-	for _, ch := range s.Chans {
+	for _, ch := range s.SortedChans() {
 		str += fmt.Sprintf("%s ", ch.Name())
 	}
 	str += "\n# Role\n"
+	roles := make([]Role, 0, len(s.Roles))
 	for _, r := range s.Roles {
+		roles = append(roles, r)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roleLess(roles[i], roles[j]) })
+	for _, r := range roles {
 		str += fmt.Sprintf("%s ", r.Name())
 	}
 	str += "\n# Session\n"
-	for role, session := range s.Types {
-		str += fmt.Sprintf("  %s: %s", role.Name(), StringRecursive(session))
+	for _, role := range s.SortedRoles() {
+		str += fmt.Sprintf("  %s: %s", role.Name(), StringRecursive(s.Types[role]))
 		str += "\n"
 	}
 	return str
@@ -3,6 +3,7 @@ package sesstype
 import (
 	"go/token"
 	"go/types"
+	"strings"
 	"testing"
 
 	"github.com/nickng/cfsm"
@@ -14,7 +15,7 @@ import (
 func TestSendNode(t *testing.T) {
 	s := CreateSession()
 	r := s.GetRole("main")
-	c := s.MakeChan(utils.NewDef(utils.EmptyValue{T: nil}), r)
+	c := s.MakeChan(utils.NewVersions().NewDef(utils.EmptyValue{T: nil}), r)
 	n := NewSendNode(r, c, nil)
 	if n.Kind() != SendOp {
 		t.Errorf("Expecting node kind to be %s but got %s\n", SendOp, n.Kind())
@@ -50,7 +51,7 @@ func TestSendNode(t *testing.T) {
 func TestRecvNode(t *testing.T) {
 	s := CreateSession()
 	r := s.GetRole("main")
-	c := s.MakeChan(utils.NewDef(utils.EmptyValue{T: nil}), r)
+	c := s.MakeChan(utils.NewVersions().NewDef(utils.EmptyValue{T: nil}), r)
 	n := NewRecvNode(c, r, nil)
 	if n.Kind() != RecvOp {
 		t.Errorf("Expecting node kind to be %s but got %s\n", RecvOp, n.Kind())
@@ -112,7 +113,7 @@ func TestLabelGotoNode(t *testing.T) {
 func TestNewChanNode(t *testing.T) {
 	s := CreateSession()
 	r := s.GetRole("main")
-	c := s.MakeChan(utils.NewDef(utils.EmptyValue{T: nil}), r)
+	c := s.MakeChan(utils.NewVersions().NewDef(utils.EmptyValue{T: nil}), r)
 	n := NewNewChanNode(c)
 	if n.Kind() != NewChanOp {
 		t.Errorf("Expecting node kind to be %s but got %s\n", NewChanOp, n.Kind())
@@ -133,7 +134,7 @@ func TestNewChanNode(t *testing.T) {
 func TestEndNode(t *testing.T) {
 	s := CreateSession()
 	r := s.GetRole("main")
-	c := s.MakeChan(utils.NewDef(utils.EmptyValue{T: nil}), r)
+	c := s.MakeChan(utils.NewVersions().NewDef(utils.EmptyValue{T: nil}), r)
 	n := NewEndNode(c)
 	if n.Kind() != EndOp {
 		t.Errorf("Expecting node kind to be %s but got %s\n", EndOp, n.Kind())
@@ -162,7 +163,7 @@ func (mc mockChan) Pos() token.Pos                { return token.NoPos }
 func TestSelfLoop(t *testing.T) {
 	s := CreateSession()
 	r := s.GetRole("main")
-	c := s.MakeChan(utils.NewDef(mockChan{}), r)
+	c := s.MakeChan(utils.NewVersions().NewDef(mockChan{}), r)
 
 	n0 := NewLabelNode("BeforeReceive")
 	n1 := NewRecvNode(c, r, types.NewStruct(nil, nil))
@@ -184,3 +185,42 @@ func TestSelfLoop(t *testing.T) {
 		t.Errorf("expecting self-loop but got %s", m.String())
 	}
 }
+
+// Tests comma-ok receive in a loop (range over channel) is a choice between
+// value (loop) and STOP (exit).
+func TestRecvOkLoop(t *testing.T) {
+	s := CreateSession()
+	r := s.GetRole("main")
+	c := s.MakeChan(utils.NewVersions().NewDef(mockChan{}), r)
+
+	n0 := NewLabelNode("BeforeReceive")
+	n1 := NewRecvOkNode(c, r, types.NewStruct(nil, nil))
+	n0.Append(n1)
+	n1.Append(&EmptyBodyNode{}).Append(NewGotoNode("BeforeReceive"))
+	n1.Append(&EmptyBodyNode{})
+
+	ms := NewCFSMs(s)
+	m := ms.Sys.NewMachine()
+	ms.Chans[c] = ms.Sys.NewMachine()
+	ms.States[m] = make(map[string]*cfsm.State)
+	ms.rootToMachine(r, n0, m)
+	if want, got := 2, len(m.States()); want != got {
+		t.Errorf("expecting %d states for comma-ok loop but got %d", want, got)
+	}
+	q0 := m.States()[0]
+	if want, got := 2, len(q0.Transitions()); want != got {
+		t.Fatalf("expecting %d transitions for comma-ok receive but got %d", want, got)
+	}
+	for _, tr := range q0.Transitions() {
+		switch {
+		case strings.HasSuffix(tr.Label(), "? "+STOP):
+			if tr.State() == q0 {
+				t.Errorf("expecting STOP to exit loop but got %s", m.String())
+			}
+		default:
+			if tr.State() != q0 {
+				t.Errorf("expecting value to loop but got %s", m.String())
+			}
+		}
+	}
+}
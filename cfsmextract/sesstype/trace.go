@@ -0,0 +1,139 @@
+package sesstype
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nickng/cfsm"
+)
+
+// Trace is a run of CFSMs from the start to a stuck configuration, e.g. the
+// steps to a deadlock, with the states as nodes of the graph of the CFSMs (see
+// CFSMs.Graph).
+type Trace struct {
+	Steps []*TraceStep `json:"steps"` // Configurations from the start.
+	Stuck []string     `json:"stuck"` // States of stuck machines at the end.
+}
+
+// TraceStep is a configuration of a Trace.
+type TraceStep struct {
+	Label  string   `json:"label"`  // Communication to this configuration, empty for the start.
+	States []string `json:"states"` // Current state of each machine.
+}
+
+// config is a configuration of CFSMs, the current state of each machine.
+type config struct {
+	states []*cfsm.State
+	parent int    // Index of the configuration before, -1 for the start.
+	label  string // Communication from the configuration before.
+}
+
+func (c *config) key() string {
+	ids := make([]string, len(c.states))
+	for i, st := range c.states {
+		ids[i] = strconv.Itoa(st.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// StuckTrace returns the shortest trace to a stuck configuration, where no
+// machine can communicate, but a goroutine has not finished or a channel holds
+// a message never received. Machines communicate synchronously, i.e. a send
+// of a machine with a matching receive of another. It returns nil if there is
+// no stuck configuration within limit configurations.
+func (sys *CFSMs) StuckTrace(limit int) *Trace {
+	machines := sys.Sys.CFSMs
+	index := make(map[int]int, len(machines)) // Index of machines by ID.
+	start := &config{parent: -1}
+	for i, m := range machines {
+		if m.Start == nil {
+			return nil
+		}
+		index[m.ID] = i
+		start.states = append(start.states, m.Start)
+	}
+	seen := map[string]bool{start.key(): true}
+	configs := []*config{start}
+	for i := 0; i < len(configs) && len(configs) <= limit; i++ {
+		c := configs[i]
+		stuck := true
+		for from, st := range c.states {
+			for _, tr := range st.Transitions() {
+				to, dir, msg := parseLabel(tr.Label())
+				j, ok := index[to]
+				if dir != "!" || !ok {
+					continue
+				}
+				for _, recv := range c.states[j].Transitions() {
+					if rfrom, rdir, rmsg := parseLabel(recv.Label()); rfrom != machines[from].ID || rdir != "?" || rmsg != msg {
+						continue
+					}
+					stuck = false
+					next := &config{states: append([]*cfsm.State{}, c.states...), parent: i, label: tr.Label()}
+					next.states[from], next.states[j] = tr.State(), recv.State()
+					if k := next.key(); !seen[k] {
+						seen[k] = true
+						configs = append(configs, next)
+					}
+				}
+			}
+		}
+		if !stuck {
+			continue
+		}
+		if blocked := sys.blocked(c); len(blocked) > 0 {
+			return sys.trace(configs, i, blocked)
+		}
+	}
+	return nil
+}
+
+// blocked returns the states of machines in c which are blocked, i.e. roles
+// not finished and channels holding a message.
+func (sys *CFSMs) blocked(c *config) []string {
+	var states []string
+	for i, m := range sys.Sys.CFSMs {
+		st := c.states[i]
+		if sys.role(m) != nil {
+			if len(st.Transitions()) > 0 {
+				states = append(states, stateID(m, st))
+			}
+			continue
+		}
+		// A channel holds a message after receiving it from the start.
+		for _, tr := range m.Start.Transitions() {
+			if _, dir, msg := parseLabel(tr.Label()); dir == "?" && msg != STOP && tr.State() == st {
+				states = append(states, stateID(m, st))
+				break
+			}
+		}
+	}
+	return states
+}
+
+// trace returns the trace to configs[i].
+func (sys *CFSMs) trace(configs []*config, i int, stuck []string) *Trace {
+	var steps []*TraceStep
+	for ; i >= 0; i = configs[i].parent {
+		step := &TraceStep{Label: configs[i].label}
+		for j, st := range configs[i].states {
+			step.States = append(step.States, stateID(sys.Sys.CFSMs[j], st))
+		}
+		steps = append([]*TraceStep{step}, steps...)
+	}
+	return &Trace{Steps: steps, Stuck: stuck}
+}
+
+// parseLabel returns the machine, direction ("!" or "?") and message of a
+// transition label, e.g. "1 ! int".
+func parseLabel(label string) (int, string, string) {
+	parts := strings.SplitN(label, " ", 3)
+	if len(parts) != 3 {
+		return -1, "", ""
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return -1, "", ""
+	}
+	return id, parts[1], parts[2]
+}
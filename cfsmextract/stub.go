@@ -0,0 +1,308 @@
+package cfsmextract
+
+// Calls modelled by stubs (see package stubs).
+//
+// The MiGo stub of a call is interpreted as the session type of the calling
+// goroutine, goroutines spawned by the stub are roles of their own. Recursive
+// calls in stubs are loops.
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
+	"github.com/nickng/migo/v3"
+	"golang.org/x/tools/go/ssa"
+)
+
+var (
+	_ ssa.Value = (*stubValue)(nil)
+
+	// stubChanType is the type of channels created by stubs.
+	stubChanType = types.NewChan(types.SendRecv, types.NewStruct(nil, nil))
+)
+
+// stubValue is a ssa.Value placeholder for channels created by stubs.
+type stubValue struct {
+	name   string
+	parent *ssa.Function
+	pos    token.Pos
+}
+
+func (v *stubValue) Name() string                  { return v.name }
+func (v *stubValue) String() string                { return v.name }
+func (v *stubValue) Type() types.Type              { return stubChanType }
+func (v *stubValue) Parent() *ssa.Function         { return v.parent }
+func (v *stubValue) Referrers() *[]ssa.Instruction { return nil }
+func (v *stubValue) Pos() token.Pos                { return v.pos }
+
+// Stub is a model of calls to a function by name, as in migoextract.
+type Stub int
+
+const (
+	NoopStub     Stub = iota + 1 // Call returns without communication.
+	BlockingStub                 // Call blocks forever.
+)
+
+func (s Stub) String() string {
+	switch s {
+	case NoopStub:
+		return "noop"
+	case BlockingStub:
+		return "blocking"
+	}
+	return fmt.Sprintf("Stub(%d)", s)
+}
+
+// stub returns the stub of fn, which is looked up by name, e.g.
+// example.com/logging.Printf, or by package path, e.g. example.com/logging.*
+func (extract *CFSMExtract) stub(fn *ssa.Function) (Stub, bool) {
+	if s, ok := extract.Stubs[fn.String()]; ok {
+		return s, true
+	}
+	if fn.Pkg != nil {
+		if s, ok := extract.Stubs[fn.Pkg.Pkg.Path()+".*"]; ok {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// block models a call at pos blocking forever, as a receive from a new
+// channel no other goroutine uses.
+func (caller *frame) block(name string, pos token.Pos) {
+	vd := caller.env.vers.NewDef(&stubValue{name: fmt.Sprintf("%s#block", name), parent: caller.fn, pos: pos})
+	ch := caller.env.session.MakeChan(vd, caller.gortn.role)
+	caller.env.chans[vd] = &ch
+	caller.gortn.AddNode(sesstype.NewNewChanNode(ch))
+	caller.gortn.AddNode(sesstype.SetPos(sesstype.NewRecvNode(ch, caller.gortn.role, ch.Value().Type()), pos))
+}
+
+// stubFrame is the state of interpreting the stub of a call.
+type stubFrame struct {
+	fr    *frame                    // Frame of the goroutine.
+	funcs map[string]*migo.Function // Stubs used by the call.
+	pos   token.Pos                 // Position of the call.
+	stack []string                  // Stubs being interpreted.
+}
+
+// callStub interprets the stub name for the call (or go statement if spawn is
+// true) common, where call stores the results (nil if none). Returns false if
+// there are no matching stubs.
+func (caller *frame) callStub(call *ssa.Call, common *ssa.CallCommon, name string, spawn bool, pos token.Pos) bool {
+	params, ok := caller.env.extract.StubSet.Params(name)
+	if !ok {
+		return false
+	}
+	var args []ssa.Value
+	for _, arg := range common.Args {
+		if _, ok := arg.Type().Underlying().(*types.Chan); ok {
+			args = append(args, arg)
+		}
+	}
+	results := common.Signature().Results()
+	nchans := len(args)
+	if !spawn {
+		for i := 0; i < results.Len(); i++ {
+			if _, ok := results.At(i).Type().Underlying().(*types.Chan); ok {
+				nchans++
+			}
+		}
+	}
+	if nchans != len(params) {
+		fmt.Fprintf(caller.env.log, "   # stub %s: expecting %d channels but got %d, ignored\n", name, len(params), nchans)
+		return false
+	}
+	fmt.Fprintf(caller.env.log, "++ call %s (stub)\n", orange(name))
+
+	sf := &stubFrame{fr: caller, funcs: make(map[string]*migo.Function), pos: pos}
+	for _, def := range caller.env.extract.StubSet.Funcs(name) {
+		sf.funcs[def.Name] = def
+	}
+	chans := make(map[string]*sesstype.Chan)
+	for i, arg := range args {
+		vd, kind := caller.get(arg)
+		if kind != Chan {
+			vd = caller.env.vers.NewDef(arg)
+			caller.locals[arg] = vd
+			ch := caller.env.session.MakeExtChan(vd, caller.gortn.role)
+			caller.env.chans[vd] = &ch
+		}
+		chans[params[i]] = caller.env.chans[vd]
+	}
+	if spawn {
+		sf.spawn(name, chans)
+		return true
+	}
+	if call != nil {
+		caller.env.extern[call] = results
+	}
+	var tuple Tuples
+	if call != nil && results.Len() > 1 {
+		tuple = make(Tuples, results.Len())
+		caller.tuples[call] = tuple
+	}
+	for i, n := 0, len(args); i < results.Len(); i++ {
+		if _, ok := results.At(i).Type().Underlying().(*types.Chan); !ok {
+			continue
+		}
+		// The value holding the result in the caller, if any.
+		var v ssa.Value = &stubValue{name: fmt.Sprintf("%s#%d", name, i), parent: caller.fn, pos: pos}
+		if call != nil && results.Len() == 1 {
+			v = call
+		} else if call != nil {
+			for _, instr := range *call.Referrers() {
+				if e, ok := instr.(*ssa.Extract); ok && e.Index == i {
+					v = e
+					break
+				}
+			}
+		}
+		vd := caller.env.vers.NewDef(v)
+		ch := caller.env.session.MakeChan(vd, caller.gortn.role)
+		caller.env.chans[vd] = &ch
+		caller.gortn.AddNode(sesstype.NewNewChanNode(ch))
+		if v == call {
+			caller.locals[call] = vd
+		} else if tuple != nil {
+			tuple[i] = vd
+		}
+		chans[params[n]] = &ch
+		n++
+	}
+	sf.call(name, chans)
+	return true
+}
+
+// call interprets the stub name with channels chans in the current goroutine.
+// Returns false if the call is recursive, i.e. a jump back to the stub.
+func (sf *stubFrame) call(name string, chans map[string]*sesstype.Chan) bool {
+	label := fmt.Sprintf("%s_%d", name, int(sf.pos))
+	for _, fn := range sf.stack {
+		if fn == name {
+			sf.fr.gortn.AddNode(sesstype.NewGotoNode(label))
+			return false
+		}
+	}
+	sf.fr.gortn.AddNode(sesstype.NewLabelNode(label))
+	sf.stack = append(sf.stack, name)
+	sf.stmts(sf.funcs[name].Stmts, chans)
+	sf.stack = sf.stack[:len(sf.stack)-1]
+	return true
+}
+
+// spawn interprets the stub name with channels chans in a new goroutine.
+func (sf *stubFrame) spawn(name string, chans map[string]*sesstype.Chan) {
+	for _, fn := range sf.stack {
+		if fn == name {
+			return // Already running.
+		}
+	}
+	goname := fmt.Sprintf("%s_%d", name, int(sf.pos))
+	gofr := *sf.fr
+	gofr.gortn = &goroutine{
+		role:    sf.fr.env.session.GetRoleAt(goname, sf.pos),
+		root:    sesstype.NewLabelNode(goname),
+		leaf:    nil,
+		visited: make(map[*ssa.BasicBlock]sesstype.Node),
+	}
+	gofr.gortn.leaf = &gofr.gortn.root
+	fmt.Fprintf(sf.fr.env.log, "@@ go %s (stub)\n", orange(name))
+	// The root label of the goroutine is the label of the stub (see call).
+	gosf := &stubFrame{fr: &gofr, funcs: sf.funcs, pos: sf.pos, stack: append(append([]string{}, sf.stack...), name)}
+	gosf.stmts(sf.funcs[name].Stmts, chans)
+	sf.fr.env.session.Types[gofr.gortn.role] = gofr.gortn.root
+}
+
+// bind returns the channels of the parameters of stub name in a call.
+func (sf *stubFrame) bind(name string, params []*migo.Parameter, chans map[string]*sesstype.Chan) map[string]*sesstype.Chan {
+	bound := make(map[string]*sesstype.Chan)
+	for i, p := range sf.funcs[name].Params {
+		if ch, ok := chans[params[i].Caller.Name()]; ok {
+			bound[p.Callee.Name()] = ch
+		}
+	}
+	return bound
+}
+
+// stmts interprets stmts with channels chans.
+func (sf *stubFrame) stmts(stmts []migo.Statement, chans map[string]*sesstype.Chan) {
+	fr := sf.fr
+	lookup := func(name string) (*sesstype.Chan, bool) {
+		ch, ok := chans[name]
+		if !ok {
+			fmt.Fprintf(sf.fr.env.log, "   # stub channel %s undefined\n", red(name))
+		}
+		return ch, ok
+	}
+	for i, stmt := range stmts {
+		rest := stmts[i+1:]
+		switch stmt := stmt.(type) {
+		case *migo.SendStatement:
+			if ch, ok := lookup(stmt.Chan); ok {
+				fr.gortn.AddNode(sesstype.SetPos(sesstype.NewSendNode(fr.gortn.role, *ch, ch.Value().Type()), sf.pos))
+			}
+		case *migo.RecvStatement:
+			if ch, ok := lookup(stmt.Chan); ok {
+				fr.gortn.AddNode(sesstype.SetPos(sesstype.NewRecvNode(*ch, fr.gortn.role, ch.Value().Type()), sf.pos))
+			}
+		case *migo.CloseStatement:
+			if ch, ok := lookup(stmt.Chan); ok {
+				visitClose(*ch, sf.pos, fr)
+			}
+		case *migo.NewChanStatement:
+			vd := fr.env.vers.NewDef(&stubValue{name: stmt.Name.Name(), parent: fr.fn, pos: sf.pos})
+			ch := fr.env.session.MakeChan(vd, fr.gortn.role)
+			fr.env.chans[vd] = &ch
+			fr.gortn.AddNode(sesstype.NewNewChanNode(ch))
+			chans[stmt.Name.Name()] = &ch
+		case *migo.CallStatement:
+			if !sf.call(stmt.Name, sf.bind(stmt.Name, stmt.Params, chans)) {
+				return
+			}
+		case *migo.SpawnStatement:
+			sf.spawn(stmt.Name, sf.bind(stmt.Name, stmt.Params, chans))
+		case *migo.IfStatement:
+			sf.branch([][]migo.Statement{stmt.Then, stmt.Else}, rest, chans)
+			return
+		case *migo.IfForStatement:
+			sf.branch([][]migo.Statement{stmt.Then, stmt.Else}, rest, chans)
+			return
+		case *migo.SelectStatement:
+			sf.branch(stmt.Cases, rest, chans)
+			return
+		}
+	}
+}
+
+// branch interprets each of branches followed by rest, the first statement of
+// a branch is its guard if the branch is a select case.
+func (sf *stubFrame) branch(branches [][]migo.Statement, rest []migo.Statement, chans map[string]*sesstype.Chan) {
+	fr := sf.fr
+	parent := *fr.gortn.leaf
+	for _, b := range branches {
+		leaf := parent
+		fr.gortn.leaf = &leaf
+		body := b
+		var guard sesstype.Node = &sesstype.EmptyBodyNode{}
+		if len(b) > 0 {
+			switch stmt := b[0].(type) {
+			case *migo.SendStatement:
+				if ch, ok := chans[stmt.Chan]; ok {
+					guard, body = sesstype.SetPos(sesstype.NewSelectSendNode(fr.gortn.role, *ch, ch.Value().Type()), sf.pos), b[1:]
+				}
+			case *migo.RecvStatement:
+				if ch, ok := chans[stmt.Chan]; ok {
+					guard, body = sesstype.SetPos(sesstype.NewSelectRecvNode(*ch, fr.gortn.role, ch.Value().Type()), sf.pos), b[1:]
+				}
+			}
+		}
+		fr.gortn.AddNode(guard)
+		bound := make(map[string]*sesstype.Chan, len(chans))
+		for name, ch := range chans {
+			bound[name] = ch
+		}
+		sf.stmts(append(append([]migo.Statement{}, body...), rest...), bound)
+	}
+}
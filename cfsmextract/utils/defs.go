@@ -6,33 +6,64 @@ import (
 	"golang.org/x/tools/go/ssa"
 )
 
-var (
-	VarVers = make(map[ssa.Value]int)
-)
+// Versions keeps track of the versions of variable definitions.
+type Versions struct {
+	vers map[ssa.Value]int
+	defs []*Definition // Definitions created in a fork.
+}
 
-// Variable definitions
-type Definition struct {
-	Var ssa.Value
-	Ver int
+// NewVersions creates a new version tracker.
+func NewVersions() *Versions {
+	return &Versions{vers: make(map[ssa.Value]int)}
 }
 
-// NewVarDef creates a new variable definition from an ssa.Value
-func NewDef(v ssa.Value) *Definition {
-	if v == nil {
-		panic("NewVarDef: Cannot create new VarDef with nil")
+// Fork returns a copy of vs for creating definitions concurrently with vs.
+// The definitions created are renumbered after those of vs by Merge.
+func (vs *Versions) Fork() *Versions {
+	fork := &Versions{vers: make(map[ssa.Value]int, len(vs.vers)), defs: []*Definition{}}
+	for v, ver := range vs.vers {
+		fork.vers[v] = ver
 	}
-	if ver, ok := VarVers[v]; ok {
-		VarVers[v]++
-		return &Definition{
-			Var: v,
-			Ver: ver + 1,
+	return fork
+}
+
+// Merge renumbers the definitions created in fork as if they are created
+// in vs.
+func (vs *Versions) Merge(fork *Versions) {
+	for _, vd := range fork.defs {
+		vd.Ver = vs.next(vd.Var)
+		if vs.defs != nil {
+			vs.defs = append(vs.defs, vd)
 		}
 	}
-	VarVers[v] = 0
-	return &Definition{
-		Var: v,
-		Ver: 0,
+}
+
+// next returns and records the next version of v.
+func (vs *Versions) next(v ssa.Value) int {
+	ver := 0
+	if last, ok := vs.vers[v]; ok {
+		ver = last + 1
+	}
+	vs.vers[v] = ver
+	return ver
+}
+
+// NewDef creates a new variable definition from an ssa.Value
+func (vs *Versions) NewDef(v ssa.Value) *Definition {
+	if v == nil {
+		panic("NewVarDef: Cannot create new VarDef with nil")
+	}
+	vd := &Definition{Var: v, Ver: vs.next(v)}
+	if vs.defs != nil {
+		vs.defs = append(vs.defs, vd)
 	}
+	return vd
+}
+
+// Variable definitions
+type Definition struct {
+	Var ssa.Value
+	Ver int
 }
 
 func (vd *Definition) String() string {
@@ -72,6 +72,7 @@ func Build(files []string) {
 		log.Fatal(err)
 	}
 	conf.BuildLog = l.Writer
+	configureBuild(conf)
 	ssainfo, err := conf.Build()
 	if err != nil {
 		log.Fatal(err)
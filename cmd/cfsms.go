@@ -69,6 +69,7 @@ func extractCFSMs(files []string) {
 
 	conf, err := ssabuilder.NewConfig(files)
 	conf.BuildLog = l.Writer
+	configureBuild(conf)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -77,13 +78,18 @@ func extractCFSMs(files []string) {
 		log.Fatal(err)
 	}
 	extract := cfsmextract.New(ssainfo, prefix, outdir)
+	extract.Jobs = jobs
+	configureCFSM(extract)
 	go extract.Run()
 
 	select {
-	case <-extract.Error:
+	case err := <-extract.Error:
 		log.Fatal(err)
 	case <-extract.Done:
 		log.Println("Analysis finished in", extract.Time)
+		for _, d := range extract.Diagnostics {
+			warnPartial(ssainfo.FSet, d.Pos, d.Msg)
+		}
 		extract.WriteOutput()
 	}
 }
@@ -64,6 +64,7 @@ func check(files []string) {
 		log.Fatal(err)
 	}
 	conf.BuildLog = l.Writer
+	configureBuild(conf)
 	ssainfo, err := conf.Build()
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,165 @@
+// Copyright © 2016 Nicholas Ng <nickng@projectfate.org>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nickng/dingo-hunter/cache"
+	"github.com/nickng/dingo-hunter/logwriter"
+	"github.com/nickng/dingo-hunter/migodiff"
+	"github.com/nickng/dingo-hunter/migoextract"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/migo/v3"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff old/ new/",
+	Short: "Compare concurrency behaviour of two revisions",
+	Long: `Compare concurrency behaviour of two revisions
+
+Extracts MiGo types from the .go files (of package main) in the two directories
+and reports the changes in channels created, goroutines spawned, channels
+closed and select branches of each function. A regression is reported if the
+new revision has a goroutine leak or deadlock which the old revision does not
+have, findings of both revisions are matched by the blocking operation and
+its function.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if status := diffMigo(args[0], args[1]); status != 0 {
+			os.Exit(status)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+}
+
+// diffMigo compares the revisions in oldDir and newDir and returns the exit
+// status, which is 1 if the new revision has a regression.
+func diffMigo(oldDir, newDir string) int {
+	l := logwriter.NewFile(logFile, !noLogging, !noColour)
+	if err := l.Create(); err != nil {
+		log.Fatal(err)
+	}
+	defer l.Cleanup()
+	if noColour {
+		color.NoColor = true
+	}
+
+	result := migodiff.Compare(dirMigo(oldDir, l), dirMigo(newDir, l))
+	for _, c := range result.Changes {
+		switch c.Kind {
+		case migodiff.AddedFunc, migodiff.AddedChan, migodiff.AddedSpawn, migodiff.AddedClose:
+			fmt.Println(color.GreenString("+ %s", c))
+		case migodiff.RemovedFunc, migodiff.RemovedChan, migodiff.RemovedSpawn, migodiff.RemovedClose:
+			fmt.Println(color.RedString("- %s", c))
+		default:
+			fmt.Println(color.YellowString("~ %s", c))
+		}
+	}
+	if len(result.Changes) == 0 {
+		fmt.Println("no changes in concurrency behaviour")
+	}
+	for _, f := range result.FixedFindings {
+		fmt.Println(color.GreenString("✓ fixed %s", f))
+	}
+	if result.Regression() {
+		fmt.Println(color.RedString("❌ regression: %s has %d leaks or deadlocks not in %s", newDir, len(result.AddedFindings), oldDir))
+		for _, f := range result.AddedFindings {
+			fmt.Printf("   %s\n", f)
+		}
+		return 1
+	}
+	return 0
+}
+
+// dirMigo extracts the MiGo types of the .go files in dir.
+func dirMigo(dir string, l *logwriter.Writer) *migo.Program {
+	files, err := dirFiles(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	extract, _, err := extractFiles(files, l, openCache())
+	if err != nil {
+		log.Fatal(err)
+	}
+	extract.Logger.Println("Analysis of", dir, "finished in", extract.Time)
+	migofile.Simplify(extract.Env.MigoProg)
+	return extract.Env.MigoProg
+}
+
+// dirFiles returns the .go files (except tests) in dir.
+func dirFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var srcs []string
+	for _, file := range files {
+		if !strings.HasSuffix(file, "_test.go") {
+			srcs = append(srcs, file)
+		}
+	}
+	if len(srcs) == 0 {
+		return nil, fmt.Errorf("no .go files in %s", dir)
+	}
+	return srcs, nil
+}
+
+// extractFiles extracts the MiGo types of files.
+func extractFiles(files []string, l *logwriter.Writer, c *cache.Dir) (*migoextract.TypeInfer, *ssabuilder.SSAInfo, error) {
+	conf, err := ssabuilder.NewConfig(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf.BuildLog = l.Writer
+	configureBuild(conf)
+	ssainfo, err := conf.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	ssainfo.Cache = c
+	extract, err := migoextract.New(ssainfo, l.Writer)
+	if err != nil {
+		return nil, nil, err
+	}
+	extract.Jobs = jobs
+	configureExtract(extract)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				extract.Error <- fmt.Errorf("analysis failed: %v", r)
+			}
+		}()
+		extract.Run()
+	}()
+
+	select {
+	case err := <-extract.Error:
+		return nil, nil, err
+	case <-extract.Done:
+	}
+	return extract, ssainfo, nil
+}
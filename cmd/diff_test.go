@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Tests the diff of the fixed to the broken local-deadlock example is a
+// regression with exit status 1, and the other way round is not.
+func TestDiffRegression(t *testing.T) {
+	noLogging, noColour = true, true
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skipf("Cannot build SSA: %v", r)
+		}
+	}()
+	fixed := filepath.Join("..", "examples", "local-deadlock-fixed")
+	broken := filepath.Join("..", "examples", "local-deadlock")
+	if status := diffMigo(fixed, broken); status != 1 {
+		t.Errorf("Expecting exit status 1 but got %d\n", status)
+	}
+	if status := diffMigo(broken, fixed); status != 0 {
+		t.Errorf("Expecting exit status 0 but got %d\n", status)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2016 Nicholas Ng <nickng@projectfate.org>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"go/token"
+	"log"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/nickng/dingo-hunter/leakcheck"
+	"github.com/nickng/dingo-hunter/logwriter"
+	"github.com/nickng/dingo-hunter/migoextract"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/migo/v3"
+	"github.com/spf13/cobra"
+)
+
+// leaksCmd represents the leaks command
+var leaksCmd = &cobra.Command{
+	Use:   "leaks",
+	Short: "Find goroutine leaks in source code",
+	Long: `Find goroutine leaks in source code
+
+Extracts MiGo types from the source code and reports goroutines that can block
+forever on a channel that no other goroutine uses, or that has more senders
+than receivers (or the other way round). Blocking in the main goroutine is
+reported separately as a (global) deadlock.
+
+Panics run the deferred calls of the panicking goroutine if recovered, a panic
+which is not recovered ends the program and is not modelled. Panics are calls
+to panic and sends or closes on channels which may be closed before. Other
+runtime panics (e.g. nil dereference, index out of range) are not
+modelled.
+
+The inputs should be a list of .go files in the same directory (of package main)
+One of the .go file should contain the main function.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if status := findLeaks(args); status != 0 {
+			os.Exit(status)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(leaksCmd)
+}
+
+// findLeaks reports leaks and deadlocks of the program of files, and returns
+// the exit status: 1 if any are found, 0 otherwise.
+func findLeaks(files []string) int {
+	logFile, err := RootCmd.PersistentFlags().GetString("log")
+	if err != nil {
+		log.Fatal(err)
+	}
+	noLogging, err := RootCmd.PersistentFlags().GetBool("no-logging")
+	if err != nil {
+		log.Fatal(err)
+	}
+	noColour, err := RootCmd.PersistentFlags().GetBool("no-colour")
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := logwriter.NewFile(logFile, !noLogging, !noColour)
+	if err := l.Create(); err != nil {
+		log.Fatal(err)
+	}
+	defer l.Cleanup()
+
+	conf, err := ssabuilder.NewConfig(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf.BuildLog = l.Writer
+	configureBuild(conf)
+	ssainfo, err := conf.Build()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ssainfo.Cache = openCache()
+	extract, err := migoextract.New(ssainfo, l.Writer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	extract.Jobs = jobs
+	configureExtract(extract)
+	go extract.Run()
+
+	select {
+	case err := <-extract.Error:
+		log.Fatal(err)
+	case <-extract.Done:
+		extract.Logger.Println("Analysis finished in", extract.Time)
+	}
+	for _, d := range extract.Env.Diagnostics {
+		warnPartial(ssainfo.FSet, d.Pos, d.Msg)
+	}
+
+	migofile.Simplify(extract.Env.MigoProg)
+	if noColour {
+		color.NoColor = true
+	}
+	findings := leakcheck.Check(extract.Env.MigoProg)
+	pos := func(stmt migo.Statement) string {
+		if p, ok := extract.Env.StmtPos[stmt]; ok && p != token.NoPos {
+			return ssainfo.FSet.Position(p).String()
+		}
+		return "?"
+	}
+	leaks := 0
+	for _, f := range findings {
+		switch f.Kind {
+		case leakcheck.Leak:
+			leaks++
+			fmt.Println(color.RedString("❌ goroutine leak: %s (spawned at %s)", f.Proc, pos(f.Spawn)))
+		case leakcheck.Deadlock:
+			fmt.Println(color.RedString("❌ deadlock: %s", f.Proc))
+		}
+		fmt.Printf("   blocks forever on %s in %s at %s\n", f.Op, f.Func, pos(f.Op))
+	}
+	if len(findings) == 0 {
+		fmt.Println(color.GreenString("✓ no goroutine leaks found"))
+		return 0
+	}
+	fmt.Printf("%d leak(s), %d deadlock(s)\n", leaks, len(findings)-leaks)
+	return 1
+}
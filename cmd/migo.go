@@ -20,8 +20,8 @@ import (
 
 	"github.com/nickng/dingo-hunter/logwriter"
 	"github.com/nickng/dingo-hunter/migoextract"
+	"github.com/nickng/dingo-hunter/migofile"
 	"github.com/nickng/dingo-hunter/ssabuilder"
-	"github.com/nickng/migo/v3/migoutil"
 	"github.com/spf13/cobra"
 )
 
@@ -35,6 +35,12 @@ var migoCmd = &cobra.Command{
 	Short: "Extract MiGo types from source code",
 	Long: `Extract MiGo types from source code
 
+Panics run the deferred calls of the panicking goroutine if recovered, a panic
+which is not recovered ends the program and is not modelled. Panics are calls
+to panic and sends or closes on channels which may be closed before. Other
+runtime panics (e.g. nil dereference, index out of range) are not
+modelled.
+
 The inputs should be a list of .go files in the same directory (of package main)
 One of the .go file should contain the main function.`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -72,24 +78,34 @@ func extractMigo(files []string) {
 		log.Fatal(err)
 	}
 	conf.BuildLog = l.Writer
+	configureBuild(conf)
 	ssainfo, err := conf.Build()
 	if err != nil {
 		log.Fatal(err)
 	}
+	ssainfo.Cache = openCache()
 	extract, err := migoextract.New(ssainfo, l.Writer)
 	if err != nil {
 		log.Fatal(err)
 	}
+	extract.Jobs = jobs
+	configureExtract(extract)
 	go extract.Run()
 
 	select {
-	case <-extract.Error:
+	case err := <-extract.Error:
 		log.Fatal(err)
 	case <-extract.Done:
 		extract.Logger.Println("Analysis finished in", extract.Time)
 	}
 
-	migoutil.SimplifyProgram(extract.Env.MigoProg)
+	for _, op := range extract.Env.NilChanOps {
+		log.Printf("warning: %s at %s", op, ssainfo.FSet.Position(op.Pos))
+	}
+	for _, d := range extract.Env.Diagnostics {
+		warnPartial(ssainfo.FSet, d.Pos, d.Msg)
+	}
+	migofile.Simplify(extract.Env.MigoProg)
 	if outfile != "" {
 		f, err := os.Create(outfile)
 		if err != nil {
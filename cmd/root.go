@@ -16,17 +16,28 @@ package cmd
 
 import (
 	"fmt"
+	"go/token"
+	"log"
 	"os"
 
+	"github.com/nickng/dingo-hunter/cache"
+	"github.com/nickng/dingo-hunter/cfsmextract"
+	"github.com/nickng/dingo-hunter/migoextract"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/dingo-hunter/stubs/stdlib"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	cfgFile   string // Path to config file
-	logFile   string // Path to log file
-	noLogging bool   // Turn off logging
-	noColour  bool   // Turn of colour output
+	cfgFile   string   // Path to config file
+	logFile   string   // Path to log file
+	noLogging bool     // Turn off logging
+	noColour  bool     // Turn of colour output
+	jobs      int      // Number of goroutines analysed concurrently
+	cacheDir  string   // Path to analysis cache directory
+	stubPaths []string // Paths to stub files or directories
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -51,24 +62,133 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dingo-hunter.yaml)")
+	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./.dingo-hunter.yaml or $HOME/.dingo-hunter.yaml)")
 	RootCmd.PersistentFlags().StringVar(&logFile, "log", "", "path to log file (default is stdout)")
 	RootCmd.PersistentFlags().BoolVar(&noLogging, "no-logging", false, "disable logging")
 	RootCmd.PersistentFlags().BoolVar(&noColour, "no-colour", false, "disable colour output")
+	RootCmd.PersistentFlags().IntVar(&jobs, "jobs", 1, "number of goroutines to analyse concurrently")
+	RootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "", "directory to cache analysis results (default is no caching)")
+	RootCmd.PersistentFlags().StringSliceVar(&stubPaths, "stubs", nil, "stub files (or directories of "+stubs.Ext+" files) modelling functions without body")
+}
+
+// openCache opens the analysis cache directory, or returns nil if caching is
+// disabled.
+func openCache() *cache.Dir {
+	if cacheDir == "" {
+		return nil
+	}
+	dir, err := cache.Open(cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dir
+}
+
+// loadStubs returns the built-in stubs with the stubs in the config file and
+// --stubs flag, which replace built-in stubs of the same name.
+func loadStubs() *stubs.Set {
+	paths := append(viper.GetStringSlice("stubs.files"), stubPaths...)
+	if len(paths) == 0 {
+		return stdlib.Stubs()
+	}
+	set, err := stubs.Load(paths...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return stdlib.Stubs().Merge(set)
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" { // enable ability to specify config file via flag
 		viper.SetConfigFile(cfgFile)
+	} else { // SetConfigName overrides SetConfigFile
+		viper.SetConfigName(".dingo-hunter") // name of config file (without extension)
+		viper.AddConfigPath(".")             // adding current directory as first search path
+		viper.AddConfigPath("$HOME")         // then home directory
 	}
-
-	viper.SetConfigName(".dingo-hunter") // name of config file (without extension)
-	viper.AddConfigPath("$HOME")         // adding home directory as first search path
-	viper.AutomaticEnv()                 // read in environment variables that match
+	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+}
+
+// configureBuild applies the config file to SSA build configuration conf:
+//
+//	skip-packages:   # packages (by name) not to load, with reasons
+//	  logging: communication-free
+//	load-packages:   # packages skipped by default to load
+//	  - strings
+func configureBuild(conf *ssabuilder.Config) {
+	badPkgs := make(map[string]string)
+	for name, reason := range conf.BadPkgs {
+		badPkgs[name] = reason
+	}
+	for name, reason := range viper.GetStringMapString("skip-packages") {
+		badPkgs[name] = reason
+	}
+	for _, name := range viper.GetStringSlice("load-packages") {
+		delete(badPkgs, name)
+	}
+	conf.BadPkgs = badPkgs
+}
+
+// configureExtract applies the config file to MiGo extraction:
+//
+//	stubs:
+//	  noop:          # functions (or package.*) treated as no-op
+//	    - example.com/logging.*
+//	  blocking:      # functions treated as blocking forever
+//	    - example.com/server.ListenAndServe
+//	  files:         # stub files or directories (see package stubs)
+//	    - stubs/
+//	limits:
+//	  max-unroll: 100    # static loops with more iterations are not unrolled
+//	  max-depth: 20      # calls deeper are treated as no-op
+//	  max-instances: 50  # calls of a function after the 50th are treated as no-op
+//	  timeout: 30s       # stop the analysis after 30s
+func configureExtract(extract *migoextract.TypeInfer) {
+	extract.Stubs = make(map[string]migoextract.Stub)
+	for _, name := range viper.GetStringSlice("stubs.noop") {
+		extract.Stubs[name] = migoextract.NoopStub
+	}
+	for _, name := range viper.GetStringSlice("stubs.blocking") {
+		extract.Stubs[name] = migoextract.BlockingStub
+	}
+	extract.StubSet = loadStubs()
+	extract.Limits = migoextract.Limits{
+		MaxUnroll:    viper.GetInt64("limits.max-unroll"),
+		MaxDepth:     viper.GetInt("limits.max-depth"),
+		MaxInstances: viper.GetInt("limits.max-instances"),
+		Timeout:      viper.GetDuration("limits.timeout"),
+	}
+}
+
+// configureCFSM applies the stubs and limits of the config file to CFSM
+// extraction, as configureExtract (loops are not unrolled in CFSMs).
+func configureCFSM(extract *cfsmextract.CFSMExtract) {
+	extract.Stubs = make(map[string]cfsmextract.Stub)
+	for _, name := range viper.GetStringSlice("stubs.noop") {
+		extract.Stubs[name] = cfsmextract.NoopStub
+	}
+	for _, name := range viper.GetStringSlice("stubs.blocking") {
+		extract.Stubs[name] = cfsmextract.BlockingStub
+	}
+	extract.StubSet = loadStubs()
+	extract.Limits = cfsmextract.Limits{
+		MaxDepth:     viper.GetInt("limits.max-depth"),
+		MaxInstances: viper.GetInt("limits.max-instances"),
+		Timeout:      viper.GetDuration("limits.timeout"),
+	}
+}
+
+// warnPartial logs a part of the model left out by the limits, at pos.
+func warnPartial(fset *token.FileSet, pos token.Pos, msg string) {
+	if pos.IsValid() {
+		log.Printf("warning: partial model: %s at %s", msg, fset.Position(pos))
+		return
 	}
+	log.Printf("warning: partial model: %s", msg)
 }
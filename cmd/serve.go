@@ -15,9 +15,11 @@
 package cmd
 
 import (
-	"go/build"
+	"io/fs"
 	"log"
-	"path"
+	"net"
+	"os"
+	"time"
 
 	"github.com/nickng/dingo-hunter/webservice"
 	"github.com/spf13/cobra"
@@ -37,32 +39,96 @@ Each example is a Go command (i.e. package main) in a directory, under the examp
 	},
 }
 
-const basePkg = "github.com/nickng/dingo-hunter"
-
 var (
+	// Assets are the files of the web interface embedded in the binary, in
+	// directories examples/popl17, templates and static. Set by main.
+	Assets fs.FS
+
 	addr string // Listen interface.
 	port string // Listen port.
+
+	examplesDir string // Examples directory, instead of embedded.
+	templateDir string // Templates directory, instead of embedded.
+	staticDir   string // Static files directory, instead of embedded.
+	shareDir    string // Shared snippets directory, sharing is disabled if empty.
+
+	enableExec  bool          // Enable running programs.
+	execPublic  bool          // Enable running programs on a non-loopback address.
+	execTimeout time.Duration // Time limit of running programs.
+	execOutput  int64         // Output limit of running programs.
+	execMemory  uint64        // Memory limit of running programs.
+	execCPU     time.Duration // CPU time limit of running programs.
 )
 
 func init() {
 	RootCmd.AddCommand(serveCmd)
 
-	p, err := build.Default.Import(basePkg, "", build.FindOnly)
-	if err != nil {
-		log.Fatal("Could not find base path")
-	}
-	basePath := p.Dir
-
 	serveCmd.Flags().StringVar(&addr, "bind", "127.0.0.1", "Bind address. Defaults to 127.0.0.1.")
 	serveCmd.Flags().StringVar(&port, "port", "6060", "Listen port. Defaults to 6060.")
-	serveCmd.Flags().StringVar(&webservice.ExamplesDir, "examples", path.Join(basePath, "examples", "popl17"), "Path to examples directory")
-	serveCmd.Flags().StringVar(&webservice.TemplateDir, "templates", path.Join(basePath, "templates"), "Path to templates directory")
-	serveCmd.Flags().StringVar(&webservice.StaticDir, "static", path.Join(basePath, "static"), "Path to static files directory")
+	serveCmd.Flags().StringVar(&examplesDir, "examples", "", "Path to examples directory (default is embedded examples)")
+	serveCmd.Flags().StringVar(&templateDir, "templates", "", "Path to templates directory (default is embedded templates)")
+	serveCmd.Flags().StringVar(&staticDir, "static", "", "Path to static files directory (default is embedded files)")
+	serveCmd.Flags().StringVar(&shareDir, "share-dir", "", "Path to store shared snippets (default is sharing disabled)")
+	serveCmd.Flags().DurationVar(&webservice.AnalysisLimits.Timeout, "timeout", webservice.AnalysisLimits.Timeout, "Time limit of each analysis (0 for no limit)")
+	serveCmd.Flags().Uint64Var(&webservice.AnalysisLimits.Memory, "memory", webservice.AnalysisLimits.Memory, "Heap growth limit of each analysis in bytes, shared with analyses running at the same time (0 for no limit)")
+	serveCmd.Flags().Uint64Var(&webservice.ServerMemory, "server-memory", webservice.ServerMemory, "Heap limit of the server in bytes, running analyses are stopped when exceeded (0 for no limit)")
+	serveCmd.Flags().IntVar(&webservice.JobWorkers, "workers", webservice.JobWorkers, "Number of background jobs run at the same time")
+	serveCmd.Flags().IntVar(&webservice.JobQueue, "queue", webservice.JobQueue, "Number of background jobs waiting to run")
+	serveCmd.Flags().BoolVar(&enableExec, "exec", false, "Enable running programs in the playground on the server (default is analysis only)")
+	serveCmd.Flags().DurationVar(&execTimeout, "exec-timeout", 10*time.Second, "Time limit to build and run a program (0 for no limit)")
+	serveCmd.Flags().Int64Var(&execOutput, "exec-output", 1<<20, "Output limit of a program in bytes (0 for no limit)")
+	serveCmd.Flags().Uint64Var(&execMemory, "exec-memory", 1<<30, "Address space limit of a program in bytes, Linux only (0 for no limit)")
+	serveCmd.Flags().DurationVar(&execCPU, "exec-cpu", 5*time.Second, "CPU time limit of a program, Linux only (0 for no limit)")
+	serveCmd.Flags().BoolVar(&execPublic, "exec-public", false, "Allow --exec on a non-loopback address, e.g. in a container without network")
 }
 
 // Serve starts the HTTP server.
 func Serve() {
+	webservice.Examples = assetDir(examplesDir, "examples/popl17")
+	webservice.Templates = assetDir(templateDir, "templates")
+	webservice.Static = assetDir(staticDir, "static")
+	if enableExec {
+		if !isLoopback(addr) {
+			if !execPublic {
+				log.Fatalf("Cannot enable --exec on %s: programs run on the host, bind to a loopback address or isolate the server and add --exec-public", addr)
+			}
+			log.Printf("Warning: --exec on %s runs programs of any client on the host, isolate the server (e.g. in a container without network)", addr)
+		}
+		sandbox := webservice.NewSandbox(execTimeout, execOutput, webservice.JobWorkers) // As many as jobs.
+		sandbox.Memory, sandbox.CPU = execMemory, execCPU
+		webservice.Exec = sandbox
+	}
+	if shareDir != "" {
+		shares, err := webservice.OpenShareStore(shareDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		webservice.Shares = shares
+	}
 	server := webservice.NewServer(addr, port)
 	server.Start()
 	server.Close()
 }
+
+// isLoopback returns true if addr is a loopback address, e.g. 127.0.0.1 or
+// localhost.
+func isLoopback(addr string) bool {
+	if addr == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// assetDir returns the files of dir, e.g. for development, or the directory
+// name of Assets if dir is not given.
+func assetDir(dir, name string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(Assets, name)
+	if err != nil {
+		log.Fatalf("Cannot find embedded %s: %v", name, err)
+	}
+	return sub
+}
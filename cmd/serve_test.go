@@ -0,0 +1,19 @@
+package cmd
+
+import "testing"
+
+// Tests running programs can only be enabled on loopback addresses.
+func TestIsLoopback(t *testing.T) {
+	for addr, loopback := range map[string]bool{
+		"127.0.0.1": true,
+		"::1":       true,
+		"localhost": true,
+		"0.0.0.0":   false,
+		"":          false,
+		"10.0.0.1":  false,
+	} {
+		if isLoopback(addr) != loopback {
+			t.Errorf("Expecting isLoopback(%q) to be %t\n", addr, loopback)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2016 Nicholas Ng <nickng@projectfate.org>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/nickng/dingo-hunter/leakcheck"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify model.migo...",
+	Short: "Check MiGo types from .migo files",
+	Long: `Check MiGo types from .migo files
+
+Reads MiGo types, e.g. written by the migo command and edited by hand, or
+written by other tools, and checks that they are well-formed (no function is
+defined twice, all called functions are defined). Well-formed MiGo types with
+a main.main function are then checked for goroutine leaks and deadlocks, as in
+the leaks command.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		verifyMigo(args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+}
+
+func verifyMigo(files []string) {
+	if noColour {
+		color.NoColor = true
+	}
+	failed := false
+	for _, file := range files {
+		prog, err := migofile.ParseFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if errs := migofile.Check(prog); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Println(color.RedString("❌ %s: %s", file, err))
+			}
+			failed = true
+			continue
+		}
+		if _, ok := prog.Function("main.main"); !ok {
+			fmt.Printf("%s: no main.main, not checked for goroutine leaks\n", file)
+			continue
+		}
+		findings := leakcheck.Check(prog)
+		for _, f := range findings {
+			fmt.Println(color.RedString("❌ %s: %s", file, f))
+		}
+		if len(findings) == 0 {
+			fmt.Println(color.GreenString("✓ %s: no goroutine leaks found", file))
+		}
+		failed = failed || len(findings) > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright © 2016 Nicholas Ng <nickng@projectfate.org>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+	"github.com/nickng/dingo-hunter/cache"
+	"github.com/nickng/dingo-hunter/fairness"
+	"github.com/nickng/dingo-hunter/leakcheck"
+	"github.com/nickng/dingo-hunter/logwriter"
+	"github.com/nickng/dingo-hunter/migodiff"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/migo/v3"
+	"github.com/spf13/cobra"
+)
+
+// watchDelay is the time to wait for more changes before re-running checks.
+const watchDelay = 200 * time.Millisecond
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch [dirs or files]",
+	Short: "Re-run checks when source files change",
+	Long: `Re-run checks when source files change
+
+Watches the .go files of main packages and re-runs MiGo extraction with
+goroutine leak checks, and loop fairness checks, when they change. Only new
+and resolved findings are printed.
+
+The whole module of each package is watched (or the directory of the package
+outside modules), including subpackages and directories created later, and
+changes to .go files, go.mod or go.sum anywhere in it re-run the checks of
+the package.
+
+The inputs are directories of main packages (dir/... for all main packages
+below dir), or .go files in the same directory. The default is ./...`,
+	Run: func(cmd *cobra.Command, args []string) {
+		watch(args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(watchCmd)
+}
+
+// watchTarget is a program to check.
+type watchTarget struct {
+	dir      string
+	root     string            // Directory tree watched (see watchRoot).
+	files    []string          // Files to check (nil for all files in dir).
+	findings map[string]string // Last findings (key -> message with position).
+	err      string            // Last error.
+}
+
+func watch(args []string) {
+	l := logwriter.NewFile(logFile, !noLogging, !noColour)
+	if err := l.Create(); err != nil {
+		log.Fatal(err)
+	}
+	defer l.Cleanup()
+	if noColour {
+		color.NoColor = true
+	}
+
+	targets, err := watchTargets(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(targets) == 0 {
+		log.Fatal("no main packages to watch")
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer watcher.Close()
+	c := openCache()
+	watched := make(map[string]bool) // Directories watched.
+	for _, t := range targets {
+		if t.root, err = watchRoot(t.dir); err != nil {
+			log.Fatal(err)
+		}
+		if err := watchTree(watcher, t.root, watched); err != nil {
+			log.Fatal(err)
+		}
+		t.check(l, c)
+	}
+
+	changed := make(map[string]bool) // Directories changed.
+	timer := time.NewTimer(watchDelay)
+	timer.Stop()
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() && !skipDir(info.Name()) {
+					if err := watchTree(watcher, ev.Name, watched); err != nil {
+						log.Println("watch:", err)
+					}
+					changed[ev.Name] = true // May contain files already.
+					timer.Reset(watchDelay)
+				}
+			}
+			if isWatchedFile(ev.Name) {
+				changed[filepath.Dir(ev.Name)] = true
+				timer.Reset(watchDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("watch:", err)
+		case <-timer.C:
+			for _, t := range targets {
+				for dir := range changed {
+					if inDir(dir, t.root) {
+						t.check(l, c)
+						break
+					}
+				}
+			}
+			changed = make(map[string]bool)
+		}
+	}
+}
+
+// isWatchedFile returns true if changes to file may change the findings: .go
+// files (except tests), go.mod and go.sum.
+func isWatchedFile(file string) bool {
+	switch name := filepath.Base(file); {
+	case name == "go.mod" || name == "go.sum":
+		return true
+	case strings.HasSuffix(name, ".go"):
+		return !strings.HasSuffix(name, "_test.go")
+	}
+	return false
+}
+
+// watchRoot returns the absolute directory of the module of dir, i.e. with a
+// go.mod file, or dir itself outside modules.
+func watchRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d, nil
+		}
+		if filepath.Dir(d) == d {
+			return dir, nil
+		}
+	}
+}
+
+// watchTree adds root and its subdirectories not yet watched to watcher,
+// except directories the go command ignores.
+func watchTree(watcher *fsnotify.Watcher, root string, watched map[string]bool) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && skipDir(info.Name()) {
+			return filepath.SkipDir
+		}
+		if !watched[path] {
+			if err := watcher.Add(path); err != nil {
+				return err
+			}
+			watched[path] = true
+		}
+		return nil
+	})
+}
+
+// skipDir returns true if the go command ignores directories named name.
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor"
+}
+
+// inDir returns true if path is dir or is below dir.
+func inDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// watchTargets returns the programs to check for args.
+func watchTargets(args []string) ([]*watchTarget, error) {
+	if len(args) == 0 {
+		args = []string{"./..."}
+	}
+	var targets []*watchTarget
+	files := make(map[string][]string) // Files given, by directory.
+	var dirs []string
+	for _, arg := range args {
+		switch {
+		case strings.HasSuffix(arg, ".go"):
+			dir := filepath.Dir(arg)
+			if _, ok := files[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			files[dir] = append(files[dir], arg)
+		case strings.HasSuffix(arg, "/..."):
+			err := filepath.Walk(strings.TrimSuffix(arg, "/..."), func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					if path != "." && skipDir(info.Name()) {
+						return filepath.SkipDir
+					}
+					if isMainDir(path) {
+						targets = append(targets, &watchTarget{dir: path})
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		default:
+			if !isMainDir(arg) {
+				return nil, fmt.Errorf("%s is not a main package", arg)
+			}
+			targets = append(targets, &watchTarget{dir: arg})
+		}
+	}
+	for _, dir := range dirs {
+		targets = append(targets, &watchTarget{dir: dir, files: files[dir]})
+	}
+	return targets, nil
+}
+
+// isMainDir returns true if dir contains .go files of package main.
+func isMainDir(dir string) bool {
+	files, err := dirFiles(dir)
+	if err != nil {
+		return false
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), files[0], nil, parser.PackageClauseOnly)
+	return err == nil && f.Name.Name == "main"
+}
+
+// check runs the checks on t and prints new and resolved findings.
+func (t *watchTarget) check(l *logwriter.Writer, c *cache.Dir) {
+	findings, err := t.run(l, c)
+	if err != nil {
+		if err.Error() != t.err {
+			fmt.Println(color.YellowString("! %s: %v", t.dir, err))
+		}
+		t.err = err.Error()
+		return // Keep findings of last successful run.
+	}
+	t.err = ""
+	var keys []string
+	for key := range findings {
+		if _, ok := t.findings[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Println(color.RedString("+ %s: %s", t.dir, findings[key]))
+	}
+	keys = keys[:0]
+	for key := range t.findings {
+		if _, ok := findings[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Println(color.GreenString("- %s: resolved %s", t.dir, t.findings[key]))
+	}
+	t.findings = findings
+}
+
+// run runs the checks on t and returns the findings, keyed by Go function and
+// position relative to the function (see findingPos), so editing code outside
+// the function does not change the findings.
+func (t *watchTarget) run(l *logwriter.Writer, c *cache.Dir) (findings map[string]string, err error) {
+	files := t.files
+	if files == nil {
+		if files, err = dirFiles(t.dir); err != nil {
+			return nil, err
+		}
+	}
+	extract, ssainfo, err := extractFiles(files, l, c)
+	if err != nil {
+		return nil, err
+	}
+	extract.Logger.Println("Analysis of", t.dir, "finished in", extract.Time)
+	findings = make(map[string]string)
+	pos := func(stmt migo.Statement) string {
+		if p, ok := extract.Env.StmtPos[stmt]; ok && p != token.NoPos {
+			return ssainfo.FSet.Position(p).String()
+		}
+		return "?"
+	}
+	migofile.Simplify(extract.Env.MigoProg)
+	for _, f := range leakcheck.Check(extract.Env.MigoProg) {
+		msg := fmt.Sprintf("goroutine leak: %s blocks forever on %s in %s", f.Proc, f.Op, f.Func)
+		if f.Kind == leakcheck.Deadlock {
+			msg = fmt.Sprintf("deadlock: %s blocks forever on %s in %s", f.Proc, f.Op, f.Func)
+		}
+		fn := extract.Env.FuncPos[strings.SplitN(f.Func, "#", 2)[0]]
+		key := migodiff.FindingKey(f) + findingPos(ssainfo.FSet, fn, extract.Env.StmtPos[f.Op])
+		findings[key] = fmt.Sprintf("%s at %s", msg, pos(f.Op))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			findings, err = nil, fmt.Errorf("fairness check failed: %v", r)
+		}
+	}()
+	if fa := fairness.Analyse(ssainfo, log.New(l.Writer, "fairness: ", log.LstdFlags)); fa != nil {
+		for _, f := range fa.Findings {
+			msg := "likely unfair: " + f.Msg
+			findings[msg+findingPos(ssainfo.FSet, f.Func, f.Pos)] = fmt.Sprintf("%s at %s", msg, ssainfo.FSet.Position(f.Pos))
+		}
+	}
+	return findings, nil
+}
+
+// findingPos returns the position p relative to the position of its function
+// fn, as lines after and column, which edits outside the function do not
+// change.
+func findingPos(fset *token.FileSet, fn, p token.Pos) string {
+	if !fn.IsValid() || !p.IsValid() {
+		return ""
+	}
+	fp, pp := fset.Position(fn), fset.Position(p)
+	return fmt.Sprintf(" at +%d:%d", pp.Line-fp.Line, pp.Column)
+}
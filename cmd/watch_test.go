@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickng/dingo-hunter/logwriter"
+)
+
+const watchSrc = `package main
+
+func send(ch chan int) {
+	ch <- 1
+}
+
+func drain(ch chan int) {
+	for range ch {
+	}
+}
+
+func main() {
+	ch := make(chan int)
+	go send(ch)
+	go drain(make(chan int))
+}
+`
+
+// Tests editing a line outside the functions of the findings does not change
+// the findings.
+func TestWatchUnrelatedEdit(t *testing.T) {
+	noLogging, noColour = true, true
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skipf("Cannot build SSA: %v", r)
+		}
+	}()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	l := logwriter.NewFile("", false, false)
+	if err := l.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Cleanup()
+
+	w := &watchTarget{dir: dir}
+	if err := ioutil.WriteFile(file, []byte(watchSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := w.run(l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) == 0 {
+		t.Fatal("Expecting findings but got none")
+	}
+	edited := watchSrc[:len("package main\n")] + "\nvar unrelated = 1\n" + watchSrc[len("package main\n"):]
+	if err := ioutil.WriteFile(file, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := w.run(l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, msg := range before {
+		if _, ok := after[key]; !ok {
+			t.Errorf("Finding %s changed after unrelated edit\n", msg)
+		}
+	}
+	for key, msg := range after {
+		if _, ok := before[key]; !ok {
+			t.Errorf("Finding %s new after unrelated edit\n", msg)
+		}
+	}
+}
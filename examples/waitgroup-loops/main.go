@@ -0,0 +1,36 @@
+// Command waitgroup-loops waits for goroutines started in loops with a
+// sync.WaitGroup and an errgroup.Group.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func main() {
+	results := make(chan int, 10)
+	var g errgroup.Group
+	for i := range os.Args {
+		i := i
+		g.Go(func() error {
+			results <- i
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		fmt.Println(err)
+	}
+
+	var wg sync.WaitGroup
+	for range os.Args {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-results
+		}()
+	}
+	wg.Wait()
+}
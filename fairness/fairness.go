@@ -10,6 +10,8 @@
 package fairness
 
 import (
+	"fmt"
+	"go/token"
 	"log"
 	"os"
 
@@ -21,12 +23,36 @@ import (
 
 // FairnessAnalysis
 type FairnessAnalysis struct {
+	Findings []*Finding // Loops likely unsafe.
+
 	unsafe int
 	total  int
 	info   *ssabuilder.SSAInfo
 	logger *log.Logger
 }
 
+// Finding is a loop which is likely unfair.
+type Finding struct {
+	Pos  token.Pos // Position of the loop.
+	Func token.Pos // Position of the function of the loop.
+	Msg  string
+}
+
+func (f *Finding) String() string { return f.Msg }
+
+// unfair records the loop at blk as likely unfair.
+func (fa *FairnessAnalysis) unfair(blk *ssa.BasicBlock, msg string) {
+	fa.unsafe++
+	pos := blk.Parent().Pos()
+	for _, instr := range blk.Instrs {
+		if instr.Pos().IsValid() {
+			pos = instr.Pos()
+			break
+		}
+	}
+	fa.Findings = append(fa.Findings, &Finding{Pos: pos, Func: blk.Parent().Pos(), Msg: fmt.Sprintf("%s in %s", msg, blk.Parent().String())})
+}
+
 // NewFairnessAnalysis starts a new analysis.
 func NewFairnessAnalysis() *FairnessAnalysis {
 	return &FairnessAnalysis{unsafe: 0, total: 0}
@@ -54,13 +80,13 @@ func (fa *FairnessAnalysis) Visit(fn *ssa.Function) {
 				}
 				if !hasClose {
 					fa.logger.Println(color.RedString("❌ range over channel w/o close() likely unfair (%s)", fa.info.FSet.Position(blk.Instrs[0].Pos())))
-					fa.unsafe++
+					fa.unfair(blk, "range over channel w/o close()")
 				}
 			} else if blk.Comment == "for.loop" {
 				fa.total++
 				if fa.isLikelyUnsafe(blk) {
 					fa.logger.Println(color.RedString("❌ for.loop maybe bad"))
-					fa.unsafe++
+					fa.unfair(blk, "for.loop condition or index")
 				} else {
 					fa.logger.Println(color.GreenString("✓ for.loop is ok"))
 				}
@@ -73,7 +99,7 @@ func (fa *FairnessAnalysis) Visit(fn *ssa.Function) {
 							fa.total++
 							if !fa.isCondFair(ifInst.Cond) {
 								fa.logger.Println(color.YellowString("Warning: recurring block condition probably unfair"))
-								fa.unsafe++
+								fa.unfair(blk, "recurring block condition")
 							} else {
 								fa.logger.Println(color.GreenString("✓ recurring block is ok"))
 							}
@@ -81,7 +107,7 @@ func (fa *FairnessAnalysis) Visit(fn *ssa.Function) {
 					} else if jInst, ok := blk.Instrs[len(blk.Instrs)-1].(*ssa.Jump); ok {
 						if _, visited := visitedBlk[jInst.Block().Succs[0]]; visited {
 							fa.total++
-							fa.unsafe++
+							fa.unfair(blk, "infinite loop or recurring block")
 							fa.logger.Println(color.RedString("❌ infinite loop or recurring block, probably bad (%s)", fa.info.FSet.Position(blk.Instrs[0].Pos())))
 						}
 					}
@@ -163,15 +189,24 @@ func (fa *FairnessAnalysis) isIndexStatic(blk *ssa.BasicBlock) bool {
 
 // Check for fairness on a built SSA
 func Check(info *ssabuilder.SSAInfo) {
-	if cgRoot := info.CallGraph(); cgRoot != nil {
-		fa := NewFairnessAnalysis()
-		fa.info = info
-		fa.logger = log.New(logwriter.New(os.Stdout, true, true), "fairness: ", log.LstdFlags)
-		cgRoot.Traverse(fa)
-		if fa.unsafe <= 0 {
-			fa.logger.Printf(color.GreenString("Result: %d/%d is likely unsafe", fa.unsafe, fa.total))
-		} else {
-			fa.logger.Printf(color.RedString("Result: %d/%d is likely unsafe", fa.unsafe, fa.total))
-		}
+	Analyse(info, log.New(logwriter.New(os.Stdout, true, true), "fairness: ", log.LstdFlags))
+}
+
+// Analyse checks for fairness on a built SSA, logging to logger, and returns
+// the analysis (nil if there is no main function).
+func Analyse(info *ssabuilder.SSAInfo, logger *log.Logger) *FairnessAnalysis {
+	cgRoot := info.CallGraph()
+	if cgRoot == nil {
+		return nil
+	}
+	fa := NewFairnessAnalysis()
+	fa.info = info
+	fa.logger = logger
+	cgRoot.Traverse(fa)
+	if fa.unsafe <= 0 {
+		fa.logger.Printf(color.GreenString("Result: %d/%d is likely unsafe", fa.unsafe, fa.total))
+	} else {
+		fa.logger.Printf(color.RedString("Result: %d/%d is likely unsafe", fa.unsafe, fa.total))
 	}
+	return fa
 }
@@ -0,0 +1,548 @@
+// Package leakcheck finds goroutine leaks in MiGo types.
+//
+// A goroutine leaks if it can reach a channel operation which blocks forever
+// because no other process uses the channel, while the rest of the program is
+// free to terminate. This is different from a global deadlock, where the main
+// process itself is blocked forever (and the runtime panics).
+//
+// The analysis is an over-approximation of the processes in the program:
+//   - Processes are main.main and the targets of spawn statements, one per
+//     spawn site and channels, with the number of instances (a spawn site in a
+//     loop spawns many instances, which can use channels with each other)
+//   - Channels are identified by their creation site (newchan statement)
+//   - Operations on every path of a process (not in a branch, select or loop)
+//     are matched by count: per channel, the sends which instances of the
+//     processes reach are matched against the receives they reach, and the
+//     operations left over block forever. Instances blocked on an operation do
+//     not reach the operations after it, nor the processes they would spawn
+//   - Other operations block forever if no other process (or instance) which
+//     reaches the dual operation on the same channel (send/recv) exists, and
+//     no process reaches a close of a received channel
+//   - Sends on buffered channels and selects with a default case are assumed
+//     not to block
+package leakcheck // import "github.com/nickng/dingo-hunter/leakcheck"
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/nickng/migo/v3"
+)
+
+// Kind is the kind of blocking a Finding represents.
+type Kind int
+
+const (
+	Leak     Kind = iota // Spawned goroutine blocked forever.
+	Deadlock             // Main process blocked forever.
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Leak:
+		return "leak"
+	case Deadlock:
+		return "deadlock"
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// Finding is a channel operation which can block forever.
+type Finding struct {
+	Kind  Kind
+	Spawn *migo.SpawnStatement // Spawn site of the blocked process (nil for main).
+	Proc  string               // Function the blocked process starts from.
+	Func  string               // Function containing the operation.
+	Op    migo.Statement       // The blocking operation.
+	Chan  string               // Channel (creation site) the operation blocks on.
+}
+
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s: %s in %s blocks forever on %s", f.Kind, f.Op, f.Func, f.Chan)
+}
+
+// many is the number of unboundedly many instances or operations.
+const many = math.MaxInt32
+
+// opKind is the kind of a channel operation.
+type opKind int
+
+const (
+	opSend opKind = iota
+	opRecv
+	opClose
+)
+
+// chanOp is a channel operation performed by a process.
+type chanOp struct {
+	kind opKind
+	ch   string         // Channel creation site.
+	stmt migo.Statement // Operation (or enclosing select).
+	fn   string         // Function containing the operation.
+	sel  *migo.SelectStatement
+	must bool // On every path of the process.
+	many bool // In a loop.
+	pos  int  // Operations on every path of the process before this one.
+	pass int  // Instances which complete the operation (for must).
+}
+
+// process is a main or spawned process.
+type process struct {
+	fn     string
+	spawn  *migo.SpawnStatement
+	ops    []*chanOp
+	must   []*chanOp       // Operations on every path, in order.
+	starts []*start        // Spawns of instances of the process.
+	seen   map[string]bool // Visited function instances (name + env) not on every path.
+	count  int             // Instances of the process started.
+}
+
+// start is a spawn of instances of a process by a parent process.
+type start struct {
+	parent *process
+	pos    int  // Operations on every path of the parent before the spawn.
+	many   bool // Spawns many instances (in a loop).
+}
+
+// spawnKey identifies the processes spawned at a spawn site with the same
+// channels.
+type spawnKey struct {
+	spawn *migo.SpawnStatement
+	inst  string // Function instance (name + env).
+}
+
+// context is the context of a statement in its process.
+type context struct {
+	must bool // On every path.
+	many bool // In a loop.
+}
+
+// checker holds the state of a leak check.
+type checker struct {
+	funcs   map[string]*migo.Function
+	bufSize map[string]int64 // Channel buffer sizes.
+	procs   []*process
+	spawned map[spawnKey]*process
+	loops   map[string]bool // Functions in a cycle of calls, i.e. loops.
+}
+
+// Check returns the blocking operations in prog which can never proceed.
+//
+// Findings from the main process are reported as Deadlock, findings from
+// spawned processes are reported as Leak.
+func Check(prog *migo.Program) []*Finding {
+	c := &checker{
+		funcs:   make(map[string]*migo.Function),
+		bufSize: make(map[string]int64),
+		spawned: make(map[spawnKey]*process),
+		loops:   make(map[string]bool),
+	}
+	for _, f := range prog.Funcs {
+		c.funcs[f.Name] = f
+		c.funcs[f.SimpleName()] = f
+	}
+	for _, f := range prog.Funcs {
+		c.loops[f.Name] = c.reaches(f, f.Name, make(map[string]bool))
+	}
+	main, ok := c.funcs["main.main"]
+	if !ok {
+		return nil
+	}
+	c.run(&process{fn: main.Name, seen: make(map[string]bool)}, main, make(map[string]string))
+	c.markCycles()
+	c.solve()
+
+	var findings []*Finding
+	for _, p := range c.procs {
+		kind := Leak
+		if p.spawn == nil {
+			kind = Deadlock
+		}
+		reported := make(map[migo.Statement]bool)
+		for _, op := range p.ops {
+			if reported[op.stmt] || !c.stuck(p, op) {
+				continue
+			}
+			reported[op.stmt] = true
+			findings = append(findings, &Finding{
+				Kind:  kind,
+				Spawn: p.spawn,
+				Proc:  p.fn,
+				Func:  op.fn,
+				Op:    op.stmt,
+				Chan:  op.ch,
+			})
+		}
+	}
+	return findings
+}
+
+// run starts process p from fn.
+func (c *checker) run(p *process, fn *migo.Function, env map[string]string) {
+	c.procs = append(c.procs, p)
+	c.visitFunc(p, fn, env, context{must: true})
+}
+
+// reaches returns true if a call in fn (transitively) calls function name.
+func (c *checker) reaches(fn *migo.Function, name string, seen map[string]bool) bool {
+	found := false
+	walkCalls(fn.Stmts, func(callee string) {
+		if found || seen[callee] {
+			return
+		}
+		seen[callee] = true
+		if callee == name {
+			found = true
+		} else if f, ok := c.funcs[callee]; ok {
+			found = c.reaches(f, name, seen)
+		}
+	})
+	return found
+}
+
+// walkCalls calls f with the name of each function called in stmts.
+func walkCalls(stmts []migo.Statement, f func(string)) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *migo.CallStatement:
+			f(stmt.Name)
+		case *migo.IfStatement:
+			walkCalls(stmt.Then, f)
+			walkCalls(stmt.Else, f)
+		case *migo.IfForStatement:
+			walkCalls(stmt.Then, f)
+			walkCalls(stmt.Else, f)
+		case *migo.SelectStatement:
+			for _, cas := range stmt.Cases {
+				walkCalls(cas, f)
+			}
+		}
+	}
+}
+
+// visitFunc visits fn in process p. Calls on every path are visited each time,
+// as their operations are counted, but other calls are visited once per
+// function instance (a loop is a cycle of calls).
+func (c *checker) visitFunc(p *process, fn *migo.Function, env map[string]string, ctx context) {
+	if c.loops[fn.Name] {
+		ctx = context{many: true}
+	}
+	if !ctx.must {
+		key := instanceKey(fn, env)
+		if ctx.many {
+			key += ",many"
+		}
+		if p.seen[key] {
+			return
+		}
+		p.seen[key] = true
+	}
+	c.visitStmts(p, fn, fn.Stmts, env, ctx)
+}
+
+func (c *checker) visitStmts(p *process, fn *migo.Function, stmts []migo.Statement, env map[string]string, ctx context) {
+	branch := context{many: ctx.many}
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *migo.NewChanStatement:
+			env[stmt.Name.Name()] = stmt.Chan
+			c.bufSize[stmt.Chan] = stmt.Size
+		case *migo.SendStatement:
+			c.addOp(p, fn, opSend, stmt.Chan, stmt, nil, env, ctx)
+		case *migo.RecvStatement:
+			c.addOp(p, fn, opRecv, stmt.Chan, stmt, nil, env, ctx)
+		case *migo.CloseStatement:
+			c.addOp(p, fn, opClose, stmt.Chan, stmt, nil, env, ctx)
+		case *migo.IfStatement:
+			c.visitStmts(p, fn, stmt.Then, env, branch)
+			c.visitStmts(p, fn, stmt.Else, env, branch)
+		case *migo.IfForStatement:
+			c.visitStmts(p, fn, stmt.Then, env, branch)
+			c.visitStmts(p, fn, stmt.Else, env, branch)
+		case *migo.SelectStatement:
+			for _, cas := range stmt.Cases {
+				if len(cas) == 0 {
+					continue
+				}
+				switch guard := cas[0].(type) {
+				case *migo.SendStatement:
+					c.addOp(p, fn, opSend, guard.Chan, stmt, stmt, env, branch)
+				case *migo.RecvStatement:
+					c.addOp(p, fn, opRecv, guard.Chan, stmt, stmt, env, branch)
+				}
+				c.visitStmts(p, fn, cas[1:], env, branch)
+			}
+		case *migo.CallStatement:
+			if callee, ok := c.funcs[stmt.Name]; ok {
+				c.visitFunc(p, callee, bindParams(callee, stmt.Params, env), ctx)
+			}
+		case *migo.SpawnStatement:
+			if callee, ok := c.funcs[stmt.Name]; ok {
+				calleeEnv := bindParams(callee, stmt.Params, env)
+				key := spawnKey{spawn: stmt, inst: instanceKey(callee, calleeEnv)}
+				q, ok := c.spawned[key]
+				if !ok {
+					q = &process{fn: callee.Name, spawn: stmt, seen: make(map[string]bool)}
+					c.spawned[key] = q
+				}
+				q.starts = append(q.starts, &start{parent: p, pos: len(p.must), many: ctx.many})
+				if !ok {
+					c.run(q, callee, calleeEnv)
+				}
+			}
+		}
+	}
+}
+
+// addOp records a channel operation if the channel can be resolved.
+func (c *checker) addOp(p *process, fn *migo.Function, kind opKind, name string, stmt migo.Statement, sel *migo.SelectStatement, env map[string]string, ctx context) {
+	ch, ok := env[name]
+	if !ok { // Unknown (e.g. external) channel.
+		return
+	}
+	op := &chanOp{kind: kind, ch: ch, stmt: stmt, fn: fn.Name, sel: sel, must: ctx.must, many: ctx.many, pos: len(p.must)}
+	p.ops = append(p.ops, op)
+	if op.must {
+		p.must = append(p.must, op)
+	}
+}
+
+// markCycles marks the spawns of processes which (transitively) spawn their
+// own parent as spawning many instances, e.g. recursive goroutines.
+func (c *checker) markCycles() {
+	for _, p := range c.procs {
+		for _, s := range p.starts {
+			if !s.many && c.spawns(p, s.parent, make(map[*process]bool)) {
+				s.many = true
+			}
+		}
+	}
+}
+
+// spawns returns true if p is q or (transitively) spawns q.
+func (c *checker) spawns(p, q *process, seen map[*process]bool) bool {
+	if p == q {
+		return true
+	}
+	if seen[q] {
+		return false
+	}
+	seen[q] = true
+	for _, s := range q.starts {
+		if c.spawns(p, s.parent, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// solve computes the instances of each process and the instances completing
+// each operation on every path, starting from none and matching operations by
+// count until nothing changes. Instances which complete an operation keep
+// completing it in later rounds, so the counts only grow.
+func (c *checker) solve() {
+	for changed := true; changed; {
+		changed = false
+		for _, p := range c.procs {
+			if n := c.instances(p); n != p.count {
+				p.count, changed = n, true
+			}
+		}
+		offers := make(map[opKind]map[string]int)            // Instances reaching an operation, per channel.
+		self := make(map[*process]map[opKind]map[string]int) // Offers of each process.
+		for _, p := range c.procs {
+			self[p] = make(map[opKind]map[string]int)
+			for _, op := range p.ops {
+				n := c.offer(p, op)
+				if offers[op.kind] == nil {
+					offers[op.kind] = make(map[string]int)
+				}
+				if self[p][op.kind] == nil {
+					self[p][op.kind] = make(map[string]int)
+				}
+				offers[op.kind][op.ch] = add(offers[op.kind][op.ch], n)
+				self[p][op.kind][op.ch] = add(self[p][op.kind][op.ch], n)
+			}
+		}
+		// Partners of each operation: other processes, or other instances.
+		partners := func(p *process, kind opKind, ch string) int {
+			if p.count > 1 {
+				return offers[kind][ch]
+			}
+			return sub(offers[kind][ch], self[p][kind][ch])
+		}
+		used := make(map[opKind]map[string]int)
+		match := func(p *process, op *chanOp, want int) int {
+			if op.kind == opClose || (op.kind == opSend && c.bufSize[op.ch] > 0) ||
+				(op.kind == opRecv && offers[opClose][op.ch] > 0) {
+				return want
+			}
+			dual := opRecv
+			if op.kind == opRecv {
+				dual = opSend
+			}
+			if used[op.kind] == nil {
+				used[op.kind] = make(map[string]int)
+			}
+			n := min(want, partners(p, dual, op.ch), sub(offers[dual][op.ch], used[op.kind][op.ch]))
+			used[op.kind][op.ch] = add(used[op.kind][op.ch], n)
+			return n
+		}
+		// Keep completed operations first, then match the rest.
+		kept := make(map[*chanOp]int)
+		for _, p := range c.procs {
+			for _, op := range p.must {
+				kept[op] = match(p, op, min(op.pass, c.reach(p, op.pos)))
+			}
+		}
+		for _, p := range c.procs {
+			for _, op := range p.must {
+				n := add(kept[op], match(p, op, sub(c.reach(p, op.pos), kept[op])))
+				if n != op.pass {
+					op.pass, changed = n, true
+				}
+			}
+		}
+	}
+}
+
+// instances returns the number of instances of p started by its parents.
+func (c *checker) instances(p *process) int {
+	if p.spawn == nil {
+		return 1
+	}
+	n := 0
+	for _, s := range p.starts {
+		if r := c.reach(s.parent, s.pos); r > 0 && s.many {
+			n = many
+		} else {
+			n = add(n, r)
+		}
+	}
+	return n
+}
+
+// reach returns the number of instances of p which reach the operation after
+// pos operations on every path.
+func (c *checker) reach(p *process, pos int) int {
+	if pos == 0 {
+		return p.count
+	}
+	return p.must[pos-1].pass
+}
+
+// offer returns the number of times instances of p can perform op.
+func (c *checker) offer(p *process, op *chanOp) int {
+	n := c.reach(p, op.pos)
+	if n > 0 && op.many {
+		return many
+	}
+	return n
+}
+
+// stuck returns true if op of process p can never proceed.
+func (c *checker) stuck(p *process, op *chanOp) bool {
+	if op.kind == opClose {
+		return false
+	}
+	if op.must {
+		return op.pass < c.reach(p, op.pos)
+	}
+	if c.reach(p, op.pos) == 0 {
+		return false // Not reached, blocked before.
+	}
+	if op.sel != nil {
+		return c.selectStuck(p, op.sel)
+	}
+	return !c.hasPartner(p, op)
+}
+
+// selectStuck returns true if none of the cases of sel can proceed.
+func (c *checker) selectStuck(p *process, sel *migo.SelectStatement) bool {
+	guards := 0
+	for _, op := range p.ops {
+		if op.sel != sel {
+			continue
+		}
+		guards++
+		if c.hasPartner(p, op) {
+			return false
+		}
+	}
+	// All cases must be resolved channel operations (no default or unknown).
+	return guards == len(sel.Cases)
+}
+
+// hasPartner returns true if another process which is not blocked before it
+// can complete op.
+func (c *checker) hasPartner(p *process, op *chanOp) bool {
+	if op.kind == opSend && c.bufSize[op.ch] > 0 {
+		return true
+	}
+	for _, q := range c.procs {
+		for _, qop := range q.ops {
+			if qop.ch != op.ch || c.offer(q, qop) == 0 {
+				continue
+			}
+			switch op.kind {
+			case opSend:
+				if (q != p || p.count > 1) && qop.kind == opRecv {
+					return true
+				}
+			case opRecv:
+				if qop.kind == opClose || ((q != p || p.count > 1) && qop.kind == opSend) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// add returns a+b, where many is unbounded.
+func add(a, b int) int {
+	if a >= many-b {
+		return many
+	}
+	return a + b
+}
+
+// sub returns a-b (at least 0), where many is unbounded.
+func sub(a, b int) int {
+	switch {
+	case a >= many:
+		return many
+	case a <= b:
+		return 0
+	}
+	return a - b
+}
+
+// bindParams returns the callee environment from caller parameters.
+func bindParams(callee *migo.Function, params []*migo.Parameter, env map[string]string) map[string]string {
+	calleeEnv := make(map[string]string)
+	for i, param := range params {
+		ch, ok := env[param.Caller.Name()]
+		if !ok {
+			continue
+		}
+		if i < len(callee.Params) {
+			calleeEnv[callee.Params[i].Callee.Name()] = ch
+		} else {
+			calleeEnv[param.Callee.Name()] = ch
+		}
+	}
+	return calleeEnv
+}
+
+// instanceKey returns a key identifying fn with the given environment.
+func instanceKey(fn *migo.Function, env map[string]string) string {
+	var buf strings.Builder
+	buf.WriteString(fn.Name)
+	for _, param := range fn.Params {
+		buf.WriteString(",")
+		buf.WriteString(env[param.Callee.Name()])
+	}
+	return buf.String()
+}
@@ -0,0 +1,251 @@
+package leakcheck
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nickng/dingo-hunter/migoextract"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/migo/v3"
+	"github.com/nickng/migo/v3/parser"
+)
+
+// Tests a spawned process blocked on a channel nobody receives from.
+func TestLeak(t *testing.T) {
+	prog, err := parser.Parse(strings.NewReader(`
+def main.main():
+    let a = newchan a, 0;
+    let b = newchan b, 0;
+    spawn main.worker(a, b);
+    send a;
+def main.worker(x, y):
+    recv x;
+    send y;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := Check(prog)
+	if len(findings) != 1 {
+		t.Fatalf("Expecting 1 finding but got %d\n", len(findings))
+	}
+	if findings[0].Kind != Leak {
+		t.Errorf("Expecting finding to be %s but got %s\n", Leak, findings[0].Kind)
+	}
+	if findings[0].Spawn == nil || findings[0].Spawn.Name != "main.worker" {
+		t.Errorf("Expecting spawn site of main.worker but got %v\n", findings[0].Spawn)
+	}
+	if findings[0].Chan != "b" {
+		t.Errorf("Expecting blocking channel to be b but got %s\n", findings[0].Chan)
+	}
+}
+
+// Tests main blocked forever is reported as deadlock.
+func TestDeadlock(t *testing.T) {
+	prog, err := parser.Parse(strings.NewReader(`
+def main.main():
+    let a = newchan a, 0;
+    let b = newchan b, 0;
+    spawn main.worker(a);
+    recv b;
+def main.worker(x):
+    send x;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := Check(prog)
+	deadlocks, leaks := 0, 0
+	for _, f := range findings {
+		switch f.Kind {
+		case Deadlock:
+			deadlocks++
+		case Leak:
+			leaks++
+		}
+	}
+	if deadlocks != 1 || leaks != 1 {
+		t.Errorf("Expecting 1 deadlock and 1 leak but got %d and %d\n", deadlocks, leaks)
+	}
+}
+
+// Tests close unblocks receivers and default cases make select non-blocking.
+func TestNoLeak(t *testing.T) {
+	prog, err := parser.Parse(strings.NewReader(`
+def main.main():
+    let a = newchan a, 0;
+    let b = newchan b, 1;
+    spawn main.worker(a, b);
+    close a;
+def main.worker(x, y):
+    recv x;
+    send y;
+    select case recv y; case tau; endselect;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findings := Check(prog); len(findings) != 0 {
+		t.Errorf("Expecting 0 finding but got %d: %v\n", len(findings), findings)
+	}
+}
+
+// Tests processes are spawned per spawn site, and workers of a pool spawned in
+// a loop can pass jobs to each other.
+func TestWorkerPool(t *testing.T) {
+	prog, err := parser.Parse(strings.NewReader(`
+def main.main():
+    let jobs = newchan jobs, 0;
+    let done = newchan done, 0;
+    call main.main#1(jobs);
+    spawn main.wait(done);
+    spawn main.wait(done);
+def main.main#1(jobs):
+    if spawn main.worker(jobs); call main.main#1(jobs); else endif;
+def main.worker(jobs):
+    select case recv jobs; case send jobs; endselect;
+def main.wait(done):
+    recv done;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := Check(prog)
+	if len(findings) != 2 {
+		t.Fatalf("Expecting 2 findings but got %d: %v\n", len(findings), findings)
+	}
+	for _, f := range findings {
+		if f.Spawn == nil || f.Spawn.Name != "main.wait" || f.Chan != "done" {
+			t.Errorf("Expecting main.wait blocked on done but got %v\n", f)
+		}
+	}
+	if findings[0].Spawn == findings[1].Spawn {
+		t.Errorf("Expecting findings from each spawn site of main.wait\n")
+	}
+}
+
+// Tests sends and receives on every path are matched by count: one of the two
+// receivers of a single send blocks, so main blocks on its second receive.
+func TestCountMismatch(t *testing.T) {
+	prog, err := parser.Parse(strings.NewReader(`
+def main.main():
+    let ch = newchan ch, 0;
+    let done = newchan done, 0;
+    spawn main.send(ch);
+    spawn main.recv(ch, done);
+    spawn main.recv(ch, done);
+    recv done;
+    recv done;
+def main.send(ch):
+    send ch;
+def main.recv(ch, done):
+    recv ch;
+    send done;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := Check(prog)
+	if len(findings) != 2 {
+		t.Fatalf("Expecting 2 findings but got %d: %v\n", len(findings), findings)
+	}
+	if findings[0].Kind != Deadlock || findings[0].Op != prog.Funcs[0].Stmts[6] {
+		t.Errorf("Expecting deadlock on second recv done but got %v\n", findings[0])
+	}
+	if findings[1].Kind != Leak || findings[1].Chan != "ch" {
+		t.Errorf("Expecting a receiver blocked on ch but got %v\n", findings[1])
+	}
+}
+
+// Tests processes waiting for each other (recv before send) are all blocked.
+func TestCyclicWait(t *testing.T) {
+	prog, err := parser.Parse(strings.NewReader(`
+def main.main():
+    let a = newchan a, 0;
+    let b = newchan b, 0;
+    spawn main.worker(a, b);
+    recv b;
+    send a;
+def main.worker(x, y):
+    recv x;
+    send y;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := Check(prog)
+	if len(findings) != 2 {
+		t.Fatalf("Expecting 2 findings but got %d: %v\n", len(findings), findings)
+	}
+	if findings[0].Kind != Deadlock || findings[0].Chan != "b" {
+		t.Errorf("Expecting deadlock on b but got %v\n", findings[0])
+	}
+	if findings[1].Kind != Leak || findings[1].Chan != "a" {
+		t.Errorf("Expecting leak on a but got %v\n", findings[1])
+	}
+}
+
+// Tests the local-deadlock example is reported, and its fixed version is not.
+func TestLocalDeadlockExample(t *testing.T) {
+	findings := Check(extractExample(t, "local-deadlock"))
+	deadlocks, leaks := 0, 0
+	for _, f := range findings {
+		switch f.Kind {
+		case Deadlock:
+			deadlocks++
+		case Leak:
+			leaks++
+			if f.Proc != "main.Recv" {
+				t.Errorf("Expecting main.Recv to leak but got %v\n", f)
+			}
+		}
+	}
+	if deadlocks != 1 || leaks != 1 {
+		t.Errorf("Expecting 1 deadlock and 1 leak but got %d and %d: %v\n", deadlocks, leaks, findings)
+	}
+	if findings := Check(extractExample(t, "local-deadlock-fixed")); len(findings) != 0 {
+		t.Errorf("Expecting 0 finding but got %d: %v\n", len(findings), findings)
+	}
+}
+
+// extractExample returns the MiGo types of an example, the test is skipped if
+// SSA cannot be built for the example (e.g. unsupported Go version).
+func extractExample(t *testing.T, name string) *migo.Program {
+	files, err := filepath.Glob(filepath.Join("..", "examples", name, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := ssabuilder.NewConfig(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info *ssabuilder.SSAInfo
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Skipf("Cannot build SSA: %v", r)
+			}
+		}()
+		if info, err = conf.Build(); err != nil {
+			t.Skipf("Cannot build SSA: %v", err)
+		}
+	}()
+	infer, err := migoextract.New(info, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go infer.Run()
+	select {
+	case err := <-infer.Error:
+		if errors.Is(err, migoextract.ErrAnalysisFailed) {
+			t.Skipf("Cannot analyse: %v", err)
+		}
+		t.Fatal(err)
+	case <-infer.Done:
+	}
+	return migofile.Simplify(infer.Env.MigoProg)
+}
@@ -3,13 +3,20 @@
 package main
 
 import (
+	"embed"
 	"fmt"
 	"os"
 
 	"github.com/nickng/dingo-hunter/cmd"
 )
 
+// assets are the files of the web interface.
+//
+//go:embed examples/popl17 templates static
+var assets embed.FS
+
 func main() {
+	cmd.Assets = assets
 	if err := cmd.RootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(-1)
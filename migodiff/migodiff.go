@@ -0,0 +1,286 @@
+// Package migodiff compares the concurrency behaviour of two MiGo programs.
+//
+// Programs are compared per Go function: the MiGo functions extracted from the
+// blocks of a function (e.g. main.main#3) are folded into the function itself,
+// since block indices are not stable across revisions. For the same reason
+// SSA names (e.g. t0) are not compared: channels are identified by buffer size,
+// closes by the parameter name and select branches by their kinds.
+package migodiff // import "github.com/nickng/dingo-hunter/migodiff"
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nickng/dingo-hunter/leakcheck"
+	"github.com/nickng/migo/v3"
+)
+
+// Kind is the kind of behavioural change.
+type Kind int
+
+const (
+	AddedFunc     Kind = iota // Function only in new program.
+	RemovedFunc               // Function only in old program.
+	AddedChan                 // New channel created.
+	RemovedChan               // Channel no longer created.
+	AddedSpawn                // New goroutine spawned.
+	RemovedSpawn              // Goroutine no longer spawned.
+	AddedClose                // New channel closed.
+	RemovedClose              // Channel no longer closed.
+	ChangedSelect             // Select branches changed.
+)
+
+func (k Kind) String() string {
+	switch k {
+	case AddedFunc:
+		return "added func"
+	case RemovedFunc:
+		return "removed func"
+	case AddedChan:
+		return "added newchan"
+	case RemovedChan:
+		return "removed newchan"
+	case AddedSpawn:
+		return "added spawn"
+	case RemovedSpawn:
+		return "removed spawn"
+	case AddedClose:
+		return "added close"
+	case RemovedClose:
+		return "removed close"
+	case ChangedSelect:
+		return "changed select"
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// Change is a behavioural difference in a function.
+type Change struct {
+	Kind   Kind
+	Func   string // Go function the change is in.
+	Detail string // Description of the changed operation.
+}
+
+func (c *Change) String() string {
+	if c.Detail == "" {
+		return fmt.Sprintf("%s: %s", c.Func, c.Kind)
+	}
+	return fmt.Sprintf("%s: %s %s", c.Func, c.Kind, c.Detail)
+}
+
+// Result is the result of comparing two programs.
+type Result struct {
+	Changes       []*Change
+	OldFindings   []*leakcheck.Finding // Leaks and deadlocks in old program.
+	NewFindings   []*leakcheck.Finding // Leaks and deadlocks in new program.
+	AddedFindings []*leakcheck.Finding // Findings of new program not in old program.
+	FixedFindings []*leakcheck.Finding // Findings of old program not in new program.
+}
+
+// Regression returns true if the new program has a leak or deadlock which the
+// old program does not have.
+func (r *Result) Regression() bool {
+	return len(r.AddedFindings) > 0
+}
+
+// summary is the concurrency behaviour of a Go function.
+type summary struct {
+	chans   map[string]int // Channels created, by buffer size.
+	spawns  map[string]int // Goroutines spawned, by function.
+	closes  map[string]int // Channels closed, by parameter name.
+	selects []string       // Branches of each select.
+}
+
+func newSummary() *summary {
+	return &summary{
+		chans:  make(map[string]int),
+		spawns: make(map[string]int),
+		closes: make(map[string]int),
+	}
+}
+
+// Compare compares programs old and new and returns the behavioural changes,
+// with findings of leakcheck on both programs.
+func Compare(old, new *migo.Program) *Result {
+	oldSums, newSums := summarise(old), summarise(new)
+	var names []string
+	for name := range oldSums {
+		names = append(names, name)
+	}
+	for name := range newSums {
+		if _, ok := oldSums[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	r := &Result{
+		OldFindings: leakcheck.Check(old),
+		NewFindings: leakcheck.Check(new),
+	}
+	r.AddedFindings = diffFindings(r.NewFindings, r.OldFindings)
+	r.FixedFindings = diffFindings(r.OldFindings, r.NewFindings)
+	for _, name := range names {
+		o, n := oldSums[name], newSums[name]
+		switch {
+		case o == nil:
+			r.Changes = append(r.Changes, &Change{Kind: AddedFunc, Func: name})
+			o = newSummary()
+		case n == nil:
+			r.Changes = append(r.Changes, &Change{Kind: RemovedFunc, Func: name})
+			n = newSummary()
+		}
+		r.Changes = append(r.Changes, diffCounts(name, o.chans, n.chans, AddedChan, RemovedChan)...)
+		r.Changes = append(r.Changes, diffCounts(name, o.spawns, n.spawns, AddedSpawn, RemovedSpawn)...)
+		r.Changes = append(r.Changes, diffCounts(name, o.closes, n.closes, AddedClose, RemovedClose)...)
+		r.Changes = append(r.Changes, diffSelects(name, o.selects, n.selects)...)
+	}
+	return r
+}
+
+// diffCounts compares the number of each operation in old and new.
+func diffCounts(fn string, old, new map[string]int, added, removed Kind) []*Change {
+	var keys []string
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var changes []*Change
+	for _, k := range keys {
+		for i := new[k]; i < old[k]; i++ {
+			changes = append(changes, &Change{Kind: removed, Func: fn, Detail: k})
+		}
+		for i := old[k]; i < new[k]; i++ {
+			changes = append(changes, &Change{Kind: added, Func: fn, Detail: k})
+		}
+	}
+	return changes
+}
+
+// diffFindings returns the findings of a not in b, where findings are the same
+// if their keys are (see FindingKey).
+func diffFindings(a, b []*leakcheck.Finding) []*leakcheck.Finding {
+	keys := make(map[string]int)
+	for _, f := range b {
+		keys[FindingKey(f)]++
+	}
+	var diff []*leakcheck.Finding
+	for _, f := range a {
+		if k := FindingKey(f); keys[k] > 0 {
+			keys[k]--
+		} else {
+			diff = append(diff, f)
+		}
+	}
+	return diff
+}
+
+// FindingKey returns the identity of a finding across revisions: its kind, the
+// blocking operation and channel name, and the Go functions of the operation
+// and of the process.
+func FindingKey(f *leakcheck.Finding) string {
+	op := "select"
+	switch stmt := f.Op.(type) {
+	case *migo.SendStatement:
+		op = "send " + chanName(stmt.Chan)
+	case *migo.RecvStatement:
+		op = "recv " + chanName(stmt.Chan)
+	}
+	return fmt.Sprintf("%s: %s in %s of %s", f.Kind, op, goFunc(f.Func), goFunc(f.Proc))
+}
+
+// diffSelects compares the branches of selects in old and new in order.
+func diffSelects(fn string, old, new []string) []*Change {
+	var changes []*Change
+	for i := 0; i < len(old) || i < len(new); i++ {
+		o, n := "(none)", "(none)"
+		if i < len(old) {
+			o = old[i]
+		}
+		if i < len(new) {
+			n = new[i]
+		}
+		if o != n {
+			changes = append(changes, &Change{Kind: ChangedSelect, Func: fn,
+				Detail: fmt.Sprintf("#%d %s → %s", i+1, o, n)})
+		}
+	}
+	return changes
+}
+
+// summarise returns the summary of each Go function in prog.
+func summarise(prog *migo.Program) map[string]*summary {
+	sums := make(map[string]*summary)
+	for _, fn := range prog.Funcs {
+		name := goFunc(fn.Name)
+		if _, ok := sums[name]; !ok {
+			sums[name] = newSummary()
+		}
+		visitStmts(sums[name], fn.Stmts)
+	}
+	return sums
+}
+
+// goFunc returns the name of the Go function a MiGo function is extracted
+// from, i.e. without the block suffix.
+func goFunc(name string) string {
+	if i := strings.Index(name, "#"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func visitStmts(s *summary, stmts []migo.Statement) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *migo.NewChanStatement:
+			s.chans[fmt.Sprintf("chan (buffer %d)", stmt.Size)]++
+		case *migo.SpawnStatement:
+			s.spawns[goFunc(stmt.Name)]++
+		case *migo.CloseStatement:
+			s.closes[chanName(stmt.Chan)]++
+		case *migo.SelectStatement:
+			var cases []string
+			for _, c := range stmt.Cases {
+				if len(c) > 0 {
+					cases = append(cases, caseString(c[0]))
+				}
+				visitStmts(s, c)
+			}
+			s.selects = append(s.selects, "{"+strings.Join(cases, "; ")+"}")
+		case *migo.IfStatement:
+			visitStmts(s, stmt.Then)
+			visitStmts(s, stmt.Else)
+		case *migo.IfForStatement:
+			visitStmts(s, stmt.Then)
+			visitStmts(s, stmt.Else)
+		}
+	}
+}
+
+// caseString returns the kind of guard of a select case.
+func caseString(stmt migo.Statement) string {
+	switch stmt.(type) {
+	case *migo.SendStatement:
+		return "send"
+	case *migo.RecvStatement:
+		return "recv"
+	case *migo.TauStatement:
+		return "default"
+	}
+	return stmt.String()
+}
+
+// chanName returns name of a channel variable, or "chan" if it is an SSA name.
+func chanName(name string) string {
+	if len(name) > 1 && name[0] == 't' && strings.Trim(name[1:], "0123456789") == "" {
+		return "chan"
+	}
+	return name
+}
@@ -0,0 +1,189 @@
+package migodiff
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nickng/dingo-hunter/migoextract"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/migo/v3"
+	"github.com/nickng/migo/v3/parser"
+)
+
+func parse(t *testing.T, s string) *migo.Program {
+	prog, err := parser.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+// Tests changes in spawns, closes and selects are reported per function.
+func TestCompare(t *testing.T) {
+	old := parse(t, `
+def main.main():
+    let t0 = newchan main.main.t0_0_0, 0;
+    spawn main.worker(t0);
+    recv t0;
+def main.worker(ch):
+    send ch;
+    close ch;
+`)
+	new := parse(t, `
+def main.main():
+    let t0 = newchan main.main.t0_0_0, 0;
+    let t1 = newchan main.main.t1_0_0, 0;
+    spawn main.worker(t0);
+    spawn main.worker(t0);
+    call main.main#1(t0, t1);
+def main.main#1(t2, t3):
+    select
+      case recv t3;
+      case tau;
+    endselect;
+def main.worker(ch):
+    send ch;
+`)
+	r := Compare(old, new)
+	var got []string
+	for _, c := range r.Changes {
+		got = append(got, c.String())
+	}
+	expected := []string{
+		"main.main: added newchan chan (buffer 0)",
+		"main.main: added spawn main.worker",
+		"main.main: changed select #1 (none) → {recv; default}",
+		"main.worker: removed close ch",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expecting changes\n%s\nbut got\n%s\n", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	if !r.Regression() {
+		t.Errorf("Expecting regression as t0 is never received in new program\n")
+	}
+}
+
+// Tests renaming SSA variables is not a change.
+func TestCompareRenamed(t *testing.T) {
+	old := parse(t, `
+def main.main():
+    let t0 = newchan main.main.t0_0_0, 0;
+    spawn main.worker(t0);
+    recv t0;
+    close t0;
+def main.worker(ch):
+    send ch;
+`)
+	new := parse(t, `
+def main.main():
+    let t4 = newchan main.main.t4_0_0, 0;
+    spawn main.worker(t4);
+    recv t4;
+    close t4;
+def main.worker(ch):
+    send ch;
+`)
+	r := Compare(old, new)
+	if len(r.Changes) != 0 {
+		t.Errorf("Expecting no changes but got %v\n", r.Changes)
+	}
+	if r.Regression() {
+		t.Errorf("Expecting no regression\n")
+	}
+}
+
+// Tests a regression is a finding not in the old program, even if the old
+// program has other findings.
+func TestCompareFindings(t *testing.T) {
+	old := parse(t, `
+def main.main():
+    let t0 = newchan main.main.t0_0_0, 0;
+    let t1 = newchan main.main.t1_0_0, 0;
+    spawn main.worker(t0);
+    spawn main.waiter(t1);
+def main.worker(ch):
+    send ch;
+def main.waiter(done):
+    recv done;
+`)
+	new := parse(t, `
+def main.main():
+    let t2 = newchan main.main.t2_0_0, 0;
+    let t3 = newchan main.main.t3_0_0, 0;
+    spawn main.worker(t2);
+    spawn main.waiter(t3);
+    recv t2;
+def main.worker(ch):
+    send ch;
+    recv ch;
+def main.waiter(done):
+    recv done;
+`)
+	r := Compare(old, new)
+	if !r.Regression() || len(r.AddedFindings) != 1 || r.AddedFindings[0].Func != "main.worker" {
+		t.Errorf("Expecting regression of recv in main.worker but got %v\n", r.AddedFindings)
+	}
+	if len(r.FixedFindings) != 1 || r.FixedFindings[0].Func != "main.worker" {
+		t.Errorf("Expecting fixed send in main.worker but got %v\n", r.FixedFindings)
+	}
+	if r = Compare(new, new); r.Regression() {
+		t.Errorf("Expecting no regression of the same program but got %v\n", r.AddedFindings)
+	}
+}
+
+// Tests the local-deadlock example is a regression of its fixed version.
+func TestCompareExamples(t *testing.T) {
+	fixed, broken := extractExample(t, "local-deadlock-fixed"), extractExample(t, "local-deadlock")
+	r := Compare(fixed, broken)
+	if !r.Regression() {
+		t.Errorf("Expecting regression but got none in\n%s\n", broken)
+	}
+	if len(r.OldFindings) != 0 {
+		t.Errorf("Expecting no findings in fixed example but got %v\n", r.OldFindings)
+	}
+	if r := Compare(broken, fixed); r.Regression() || len(r.FixedFindings) != len(r.OldFindings) {
+		t.Errorf("Expecting all findings fixed but got %v added, %v fixed\n", r.AddedFindings, r.FixedFindings)
+	}
+}
+
+// extractExample returns the MiGo types of an example, the test is skipped if
+// SSA cannot be built for the example (e.g. unsupported Go version).
+func extractExample(t *testing.T, name string) *migo.Program {
+	files, err := filepath.Glob(filepath.Join("..", "examples", name, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := ssabuilder.NewConfig(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info *ssabuilder.SSAInfo
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Skipf("Cannot build SSA: %v", r)
+			}
+		}()
+		if info, err = conf.Build(); err != nil {
+			t.Skipf("Cannot build SSA: %v", err)
+		}
+	}()
+	infer, err := migoextract.New(info, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go infer.Run()
+	select {
+	case err := <-infer.Error:
+		if errors.Is(err, migoextract.ErrAnalysisFailed) {
+			t.Skipf("Cannot analyse: %v", err)
+		}
+		t.Fatal(err)
+	case <-infer.Done:
+	}
+	return migofile.Simplify(infer.Env.MigoProg)
+}
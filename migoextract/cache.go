@@ -0,0 +1,199 @@
+package migoextract
+
+// Function summaries persisted in the on-disk cache.
+//
+// A summarised call (see summary.go) taking only channels and basic values,
+// and returning nothing, depends only on the source of the callee package and
+// its dependencies. The MiGo functions defined by the call are stored in the
+// cache keyed by the package hash and the summary key, so unchanged packages
+// are not analysed again in later runs.
+
+import (
+	"bytes"
+	"go/types"
+	"strings"
+
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/migo/v3"
+	"golang.org/x/tools/go/ssa"
+)
+
+// cachedSummary is a function summary in the cache.
+type cachedSummary struct {
+	MiGo    string                         // Non-empty definitions, callee first.
+	Empty   []string                       // Empty definitions.
+	Names   map[string]string              // Names before filtering.
+	FuncPos map[string]ssabuilder.Position // Positions of definitions.
+	StmtPos []ssabuilder.Position          // Positions of statements (in order).
+	Panics  bool
+}
+
+// cacheKey returns the key of a call to fn in the cache, where key is the
+// summary key of the call. ok is false if the call cannot be cached.
+func (caller *Function) cacheKey(infer *TypeInfer, common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.Value, key string) (ckey string, ok bool) {
+	if infer.SSA.Cache == nil || rcvr != nil || fn.Pkg == nil || fn.Signature.Results().Len() > 0 {
+		return "", false
+	}
+	for _, arg := range common.Args {
+		switch arg.Type().Underlying().(type) {
+		case *types.Chan, *types.Basic:
+		default:
+			return "", false // Instances are specific to the caller.
+		}
+	}
+	return "migo|" + infer.configKey() + "|" + infer.SSA.PkgHash(fn.Pkg.Pkg) + "|" + key, true
+}
+
+// loadSummary returns the callee of a call to fn from the cache.
+func (caller *Function) loadSummary(infer *TypeInfer, common *ssa.CallCommon, fn *ssa.Function, ckey string) (*Function, bool) {
+	var s cachedSummary
+	if !infer.SSA.Cache.Get(ckey, &s) {
+		return nil, false
+	}
+	defs, ok := s.decode()
+	if !ok {
+		return nil, false
+	}
+	callee := caller.prepareCallFn(common, fn, nil)
+	callee.hasBody = true
+	callee.panics = s.Panics
+	i := 0
+	for _, def := range defs {
+		walkStmts(def.Stmts, func(stmt migo.Statement) {
+			if i < len(s.StmtPos) {
+				if pos := infer.SSA.Pos(s.StmtPos[i]); pos.IsValid() {
+					caller.Prog.StmtPos[stmt] = pos
+				}
+			}
+			i++
+		})
+		if _, ok := caller.Prog.MigoProg.Function(def.Name); !ok {
+			caller.Prog.addFunction(def, infer.SSA.Pos(s.FuncPos[def.Name]))
+		}
+	}
+	callee.FuncDef, _ = caller.Prog.MigoProg.Function(callee.defName())
+	return callee, true
+}
+
+// storeSummary stores callee of a call in the cache.
+func (caller *Function) storeSummary(infer *TypeInfer, ckey string, callee *Function) {
+	root, ok := caller.Prog.MigoProg.Function(callee.defName())
+	if !ok {
+		return
+	}
+	s := cachedSummary{
+		Names:   make(map[string]string),
+		FuncPos: make(map[string]ssabuilder.Position),
+		Panics:  callee.panics,
+	}
+	// Definitions of the callee and (transitively) functions it calls.
+	defs, seen := []*migo.Function{root}, map[string]bool{root.Name: true}
+	for i := 0; i < len(defs); i++ {
+		walkStmts(defs[i].Stmts, func(stmt migo.Statement) {
+			var name string
+			switch stmt := stmt.(type) {
+			case *migo.CallStatement:
+				name = stmt.Name
+			case *migo.SpawnStatement:
+				name = stmt.Name
+			case *migo.NewChanStatement:
+				s.Names[stubs.Name(stmt.Chan)] = stmt.Chan
+			}
+			if def, ok := caller.Prog.MigoProg.Function(name); ok && !seen[name] {
+				seen[name] = true
+				defs = append(defs, def)
+			}
+			if name != "" {
+				s.Names[stubs.Name(name)] = name
+			}
+		})
+	}
+	var buf bytes.Buffer
+	for _, def := range defs {
+		if prev, ok := s.Names[def.SimpleName()]; ok && prev != def.Name {
+			return // Names not distinguishable after filtering.
+		}
+		s.Names[def.SimpleName()] = def.Name
+		s.FuncPos[def.Name] = infer.SSA.Position(caller.Prog.FuncPos[def.Name])
+		if def.IsEmpty() {
+			s.Empty = append(s.Empty, def.Name)
+			continue
+		}
+		buf.WriteString(def.String())
+		walkStmts(def.Stmts, func(stmt migo.Statement) {
+			s.StmtPos = append(s.StmtPos, infer.SSA.Position(caller.Prog.StmtPos[stmt]))
+		})
+	}
+	s.MiGo = buf.String()
+	// Only store summaries which read back to the same definitions.
+	decoded, ok := s.decode()
+	if !ok || len(decoded) != len(defs)-len(s.Empty) {
+		return
+	}
+	var buf2 bytes.Buffer
+	for _, def := range decoded {
+		buf2.WriteString(def.String())
+	}
+	if buf.String() != buf2.String() {
+		return
+	}
+	if err := infer.SSA.Cache.Put(ckey, s); err != nil {
+		infer.Logger.Printf("Cannot cache summary of %s: %v", callee.Fn.String(), err)
+	}
+}
+
+// decode returns the non-empty definitions followed by empty definitions, with
+// the original names.
+func (s *cachedSummary) decode() ([]*migo.Function, bool) {
+	prog := &migo.Program{}
+	if s.MiGo != "" {
+		var err error
+		if prog, err = migofile.Parse(strings.NewReader(s.MiGo)); err != nil {
+			return nil, false
+		}
+	}
+	name := func(n string) string {
+		if orig, ok := s.Names[n]; ok {
+			return orig
+		}
+		return n
+	}
+	for _, def := range prog.Funcs {
+		def.Name = name(def.Name)
+		walkStmts(def.Stmts, func(stmt migo.Statement) {
+			switch stmt := stmt.(type) {
+			case *migo.CallStatement:
+				stmt.Name = name(stmt.Name)
+			case *migo.SpawnStatement:
+				stmt.Name = name(stmt.Name)
+			case *migo.NewChanStatement:
+				stmt.Chan = name(stmt.Chan)
+			}
+		})
+	}
+	for _, n := range s.Empty {
+		prog.Funcs = append(prog.Funcs, migo.NewFunction(n))
+	}
+	return prog.Funcs, true
+}
+
+// walkStmts calls f on stmts and their nested statements in order.
+func walkStmts(stmts []migo.Statement, f func(migo.Statement)) {
+	for _, stmt := range stmts {
+		f(stmt)
+		switch stmt := stmt.(type) {
+		case *migo.IfStatement:
+			walkStmts(stmt.Then, f)
+			walkStmts(stmt.Else, f)
+		case *migo.IfForStatement:
+			walkStmts(stmt.Then, f)
+			walkStmts(stmt.Else, f)
+		case *migo.SelectStatement:
+			for _, c := range stmt.Cases {
+				walkStmts(c, f)
+			}
+		}
+	}
+}
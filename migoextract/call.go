@@ -4,6 +4,7 @@ package migoextract
 // i.e. builtin, call, closure, defer, go.
 
 import (
+	"go/token"
 	"go/types"
 
 	"github.com/nickng/migo/v3"
@@ -13,29 +14,43 @@ import (
 // Call performs call on a given unprepared call context.
 func (caller *Function) Call(call *ssa.Call, infer *TypeInfer, b *Block, l *Loop) {
 	if call == nil {
-		infer.Logger.Fatal("Call is nil")
+		infer.Logger.Panic("Call is nil")
 		return
 	}
-	common := call.Common()
+	caller.callCommon(call.Common(), call, call.Pos(), infer, b, l)
+}
+
+// callCommon performs call of common at pos, the return values (if any) are
+// stored to retval. retval is nil for deferred calls.
+func (caller *Function) callCommon(common *ssa.CallCommon, retval ssa.Value, pos token.Pos, infer *TypeInfer, b *Block, l *Loop) {
 	switch fn := common.Value.(type) {
 	case *ssa.Builtin:
 		switch fn.Name() {
 		case "close":
-			ch, ok := caller.locals[common.Args[0]]
+			ch, ok := caller.lookupChan(common.Args[0])
 			if !ok {
-				infer.Logger.Fatalf("call close: %s: %s", common.Args[0].Name(), ErrUnknownValue)
+				infer.Logger.Panicf("call close: %s: %s", common.Args[0].Name(), ErrUnknownValue)
 				return
 			}
+			if isNilConst(ch) {
+				// close(nil) panics, block forever instead.
+				recvStmt := &migo.RecvStatement{Chan: caller.nilChanOp("close", pos, infer)}
+				caller.FuncDef.AddStmts(recvStmt)
+				caller.Prog.StmtPos[recvStmt] = pos
+				return
+			}
+			caller.possiblyNil("close", common.Args[0], ch, pos, infer)
+			closeStmt := &migo.CloseStatement{}
 			if paramName, ok := caller.revlookup[ch.String()]; ok {
-				caller.FuncDef.AddStmts(&migo.CloseStatement{Chan: paramName})
+				closeStmt.Chan = paramName
+			} else if _, ok := common.Args[0].(*ssa.Phi); ok {
+				closeStmt.Chan = common.Args[0].Name()
 			} else {
-				if _, ok := common.Args[0].(*ssa.Phi); ok {
-					caller.FuncDef.AddStmts(&migo.CloseStatement{Chan: common.Args[0].Name()})
-				} else {
-					caller.FuncDef.AddStmts(&migo.CloseStatement{Chan: ch.(*Value).Name()})
-				}
+				closeStmt.Chan = ch.(*Value).Name()
 			}
+			caller.Prog.StmtPos[closeStmt] = pos
 			infer.Logger.Print(caller.Sprintf("close %s", common.Args[0]))
+			caller.closedPanic("close", closeStmt, common.Pos(), infer)
 			return
 		case "len":
 			if l.State == Enter {
@@ -47,7 +62,7 @@ func (caller *Function) Call(call *ssa.Call, infer *TypeInfer, b *Block, l *Loop
 				l.Bound, l.End = Static, len
 				return
 			}
-			caller.locals[call] = &Value{call, caller.InstanceID(), l.Index}
+			caller.locals[retval] = &Value{retval, caller.InstanceID(), l.Index}
 			infer.Logger.Printf(caller.Sprintf("  builtin.%s", common.String()))
 		default:
 			infer.Logger.Printf(caller.Sprintf("  builtin.%s", common.String()))
@@ -57,11 +72,21 @@ func (caller *Function) Call(call *ssa.Call, infer *TypeInfer, b *Block, l *Loop
 		caller.callClosure(common, fn, infer, b, l)
 	case *ssa.Function:
 		if common.StaticCallee() == nil {
-			infer.Logger.Fatal("Call with nil CallCommon")
+			infer.Logger.Panic("Call with nil CallCommon")
+		}
+		if name, ok := infer.stubName(fn); ok && caller.callStubSet(common, retval, name, false, infer) {
+			infer.Logger.Printf(caller.Sprintf(SkipSymbol+"%s (stub %s)", fn.String(), name))
+			return
+		}
+		if caller.callModel(common, fn, infer, b, l) {
+			if retval != nil { // Results of models are external.
+				caller.storeRetvals(infer, retval, &Function{Fn: fn})
+			}
+			return
 		}
 		callee := caller.callFn(common, infer, b, l)
-		if callee != nil {
-			caller.storeRetvals(infer, call.Value(), callee)
+		if callee != nil && retval != nil {
+			caller.storeRetvals(infer, retval, callee)
 		}
 	default:
 		if !common.IsInvoke() {
@@ -69,20 +94,29 @@ func (caller *Function) Call(call *ssa.Call, infer *TypeInfer, b *Block, l *Loop
 			return
 		}
 		callee := caller.invoke(common, infer, b, l)
+		if callee == nil {
+			if name, ok := infer.stubName(common.Method); ok && caller.callStubSet(common, retval, name, false, infer) {
+				infer.Logger.Printf(caller.Sprintf(SkipSymbol+"%s (stub %s)", common.Method.FullName(), name))
+				return
+			}
+		}
+		if retval == nil {
+			return
+		}
 		if callee != nil {
-			caller.storeRetvals(infer, call.Value(), callee)
+			caller.storeRetvals(infer, retval, callee)
 		} else {
 			// Mock out the return values.
 			switch common.Signature().Results().Len() {
 			case 0:
 			case 1:
-				caller.locals[call.Value()] = &External{
+				caller.locals[retval] = &External{
 					parent: caller.Fn,
-					typ:    call.Value().Type().Underlying(),
+					typ:    retval.Type().Underlying(),
 				}
 			case 2:
-				caller.locals[call.Value()] = &External{typ: call.Value().Type().Underlying()}
-				caller.tuples[caller.locals[call.Value()]] = make(Tuples, common.Signature().Results().Len())
+				caller.locals[retval] = &External{typ: retval.Type().Underlying()}
+				caller.tuples[caller.locals[retval]] = make(Tuples, common.Signature().Results().Len())
 			}
 		}
 	}
@@ -91,28 +125,28 @@ func (caller *Function) Call(call *ssa.Call, infer *TypeInfer, b *Block, l *Loop
 // Go handles Go statements.
 func (caller *Function) Go(instr *ssa.Go, infer *TypeInfer) {
 	common := instr.Common()
-	callee := caller.prepareCallFn(common, common.StaticCallee(), nil)
-	spawnStmt := &migo.SpawnStatement{Name: callee.Fn.String(), Params: []*migo.Parameter{}}
-	for i, c := range common.Args {
-		if _, ok := c.Type().(*types.Chan); ok {
-			ch := getChan(c, infer)
-			spawnStmt.AddParams(&migo.Parameter{Caller: ch, Callee: callee.Fn.Params[i]})
-		}
-	}
-	if inst, ok := caller.locals[common.Value]; ok {
-		if bindings, ok := caller.Prog.closures[inst]; ok {
-			for _, b := range bindings {
-				if v, ok := b.(*Value); ok {
-					if _, ok := derefType(v.Type()).(*types.Chan); ok {
-						spawnStmt.AddParams(&migo.Parameter{Caller: v, Callee: v})
-					}
-				}
-			}
-		}
+	if name, ok := infer.stubName(common.StaticCallee()); ok && caller.callStubSet(common, nil, name, true, infer) {
+		infer.Logger.Printf(caller.Sprintf(SkipSymbol+"go %s (stub %s)", common.StaticCallee().String(), name))
+		return
 	}
+	spawnStmt, _ := caller.spawn(common, infer)
 	caller.FuncDef.AddStmts(spawnStmt)
+	caller.Prog.StmtPos[spawnStmt] = instr.Pos()
+}
+
+// spawn returns the statement spawning common and the spawned callee, which is
+// queued to be analysed.
+func (caller *Function) spawn(common *ssa.CallCommon, infer *TypeInfer) (*migo.SpawnStatement, *Function) {
+	queue := caller.Prog.instantiable(common.StaticCallee(), common.Pos())
+	callee := caller.prepareCallFn(common, common.StaticCallee(), nil)
+	callee.joins = make(Joins) // Goroutines added by caller are joined by caller.
+	callee.recovered = false // Panics of goroutines end the program.
+	spawnStmt := &migo.SpawnStatement{Name: callee.defName(), Params: caller.callParams(common, callee.Fn, nil)}
 	// Don't actually call/visit the function but enqueue it.
-	infer.GQueue = append(infer.GQueue, callee)
+	if queue {
+		infer.GQueue = append(infer.GQueue, callee)
+	}
+	return spawnStmt, callee
 }
 
 // callLen computes the length of a given data structure (if statically known).
@@ -184,7 +218,7 @@ func (caller *Function) storeRetvals(infer *TypeInfer, retval ssa.Value, callee
 			infer.Logger.Print(caller.Sprintf(ExitSymbol+"[1] constant %s", inst))
 			return
 		default:
-			infer.Logger.Fatalf("return[1]: %s: not an instance %+v", ErrUnknownValue, retval)
+			infer.Logger.Panicf("return[1]: %s: not an instance %+v", ErrUnknownValue, retval)
 		}
 	default:
 		caller.locals[retval] = &Value{retval, caller.InstanceID(), int64(0)}
@@ -250,12 +284,12 @@ func (caller *Function) IsRecursiveCall() bool {
 func (caller *Function) invoke(common *ssa.CallCommon, infer *TypeInfer, b *Block, l *Loop) *Function {
 	iface, ok := common.Value.Type().Underlying().(*types.Interface)
 	if !ok {
-		infer.Logger.Fatalf("invoke: %s is not an interface", common.String())
+		infer.Logger.Panicf("invoke: %s is not an interface", common.String())
 		return nil
 	}
 	ifaceInst, ok := caller.locals[common.Value] // SSA value initialised
 	if !ok {
-		infer.Logger.Fatalf("invoke: %s: %s", common.Value.Name(), ErrUnknownValue)
+		infer.Logger.Panicf("invoke: %s: %s", common.Value.Name(), ErrUnknownValue)
 		return nil
 	}
 	switch inst := ifaceInst.(type) {
@@ -264,7 +298,7 @@ func (caller *Function) invoke(common *ssa.CallCommon, infer *TypeInfer, b *Bloc
 		if inst.Const.IsNil() {
 			return nil
 		}
-		infer.Logger.Fatalf("invoke: %+v is not nil nor concrete", ifaceInst)
+		infer.Logger.Panicf("invoke: %+v is not nil nor concrete", ifaceInst)
 	case *External:
 		infer.Logger.Printf(caller.Sprintf("invoke: %+v external", ifaceInst))
 		return nil
@@ -297,30 +331,60 @@ func (caller *Function) callFn(common *ssa.CallCommon, infer *TypeInfer, b *Bloc
 }
 
 func (caller *Function) call(common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.Value, infer *TypeInfer, b *Block, l *Loop) *Function {
-	callee := caller.prepareCallFn(common, fn, rcvr)
-	if callee.IsRecursiveCall() {
-		return callee
+	if s, ok := infer.stub(fn); ok {
+		infer.Logger.Printf(caller.Sprintf(SkipSymbol+"%s (%s stub)", fn.String(), s))
+		return caller.stubCall(common, fn, rcvr, s, infer)
 	}
-	visitFunc(callee.Fn, infer, callee)
-	if callee.HasBody() {
-		callStmt := &migo.CallStatement{Name: callee.Fn.String(), Params: []*migo.Parameter{}}
-		for i, c := range common.Args {
-			if _, ok := c.Type().(*types.Chan); ok {
-				ch := getChan(c, infer)
-				callStmt.AddParams(&migo.Parameter{Caller: ch, Callee: callee.Fn.Params[i]})
+	if infer.Limits.MaxDepth > 0 && caller.Level >= infer.Limits.MaxDepth {
+		infer.Logger.Printf(caller.Sprintf(SkipSymbol+"%s (call depth limit %d)", fn.String(), infer.Limits.MaxDepth))
+		caller.Prog.diagnose(common.Pos(), "call to %s not visited (depth limit %d)", fn.String(), infer.Limits.MaxDepth)
+		return caller.stubCall(common, fn, rcvr, NoopStub, infer)
+	}
+	// Parameters are given by the arguments before the callee is visited.
+	params := caller.callParams(common, fn, rcvr)
+	key, memo := caller.summaryKey(common, fn, rcvr)
+	callee, ok := caller.Prog.summaries[key]
+	if memo && ok {
+		caller.Prog.SummaryHits++
+		infer.Logger.Printf(caller.Sprintf(SummarySymbol+"%s (%d hits)", key, caller.Prog.SummaryHits))
+	} else {
+		var ckey string
+		var cached, loaded bool
+		if memo {
+			if ckey, cached = caller.cacheKey(infer, common, fn, rcvr, key); cached {
+				callee, loaded = caller.loadSummary(infer, common, fn, ckey)
 			}
 		}
-		if inst, ok := caller.locals[common.Value]; ok {
-			if bindings, ok := caller.Prog.closures[inst]; ok {
-				for _, b := range bindings {
-					if v, ok := b.(*Value); ok {
-						if _, ok := derefType(v.Type()).(*types.Chan); ok {
-							callStmt.AddParams(&migo.Parameter{Caller: v, Callee: v})
-						}
-					}
-				}
+		if loaded {
+			infer.Logger.Printf(caller.Sprintf(SummarySymbol+"%s (cached)", key))
+		} else {
+			if !caller.Prog.instantiable(fn, common.Pos()) {
+				infer.Logger.Printf(caller.Sprintf(SkipSymbol+"%s (instance limit %d)", fn.String(), infer.Limits.MaxInstances))
+				return caller.stubCall(common, fn, rcvr, NoopStub, infer)
+			}
+			callee = caller.prepareCallFn(common, fn, rcvr)
+			if callee.IsRecursiveCall() {
+				return callee
+			}
+			spawns, nilOps, diags := len(infer.GQueue), len(caller.Prog.NilChanOps), len(caller.Prog.Diagnostics)
+			visitFunc(callee.Fn, infer, callee)
+			// Spawned goroutines, nil channel warnings and partial summaries
+			// (see Limits) are not cached.
+			if cached && len(infer.GQueue) == spawns && len(caller.Prog.NilChanOps) == nilOps &&
+				len(caller.Prog.Diagnostics) == diags && !infer.cancelled() {
+				caller.storeSummary(infer, ckey, callee)
 			}
 		}
+		if memo {
+			caller.Prog.summaries[key] = callee
+		}
+	}
+	if callee.panics {
+		caller.panicked = true
+	}
+	caller.returnJoins(callee)
+	if callee.HasBody() {
+		callStmt := &migo.CallStatement{Name: callee.defName(), Params: params}
 		caller.FuncDef.AddStmts(callStmt)
 	}
 	return callee
@@ -334,12 +398,14 @@ func (caller *Function) callClosure(common *ssa.CallCommon, closure *ssa.MakeClo
 		}
 	}
 	callee.call(common, common.StaticCallee(), nil, infer, b, l)
+	caller.panicked = callee.panicked
+	caller.returnJoins(callee)
 }
 
 func findMethod(prog *ssa.Program, meth *types.Func, typ types.Type, infer *TypeInfer) *ssa.Function {
 	if meth != nil {
 		return prog.LookupMethod(typ, meth.Pkg(), meth.Name())
 	}
-	infer.Logger.Fatal(ErrMethodNotFound)
+	infer.Logger.Panic(ErrMethodNotFound)
 	return nil
 }
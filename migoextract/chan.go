@@ -3,11 +3,74 @@ package migoextract
 // Utility functions to work with channels.
 
 import (
+	"fmt"
+	"go/token"
 	"go/types"
 
+	"github.com/nickng/migo/v3"
 	"golang.org/x/tools/go/ssa"
 )
 
+// NilChanOp is a channel operation on a channel which may be nil.
+//
+// Send and receive on a nil channel blocks forever, close of a nil channel
+// panics. Both are modelled as blocked forever on a stand-in channel. Channels
+// possibly nil are only reported, their operations are kept in MiGo.
+type NilChanOp struct {
+	Op       string    // Channel operation (send, recv, close).
+	Pos      token.Pos // Position of the operation.
+	Chan     string    // Stand-in channel in MiGo (empty if Possibly).
+	Possibly bool      // Nil by pointer analysis only (e.g. made in a package not loaded).
+}
+
+func (op *NilChanOp) String() string {
+	nilChan, outcome := "nil channel", "blocks forever"
+	if op.Possibly {
+		nilChan, outcome = "possibly nil channel", "blocks forever if nil"
+	}
+	if op.Op == "close" {
+		if op.Possibly {
+			return fmt.Sprintf("close of %s (panics if nil)", nilChan)
+		}
+		return fmt.Sprintf("close of %s (panics)", nilChan)
+	}
+	return fmt.Sprintf("%s on %s (%s)", op.Op, nilChan, outcome)
+}
+
+// nilChan is a stand-in for a nil channel, no other process uses the channel.
+type nilChan struct {
+	name string
+}
+
+func (c *nilChan) Name() string   { return c.name }
+func (c *nilChan) String() string { return c.name }
+
+// unknownChan is a stand-in for a channel not known to a call (see
+// alignCalls), which is external to the caller.
+type unknownChan struct {
+	name string
+}
+
+func (c *unknownChan) Name() string   { return c.name }
+func (c *unknownChan) String() string { return c.name }
+
+// paramChan is a channel named by a parameter (or free variable) of a function.
+type paramChan struct {
+	name string
+}
+
+func (c *paramChan) Name() string   { return c.name }
+func (c *paramChan) String() string { return c.name }
+
+// chanVar returns the variable naming channel inst in caller: the parameter
+// bound to inst, or the value inst is made from.
+func (caller *Function) chanVar(inst *Value) migo.NamedVar {
+	if paramName, ok := caller.revlookup[inst.String()]; ok {
+		return &paramChan{name: paramName}
+	}
+	return inst
+}
+
 func getChan(val ssa.Value, infer *TypeInfer) ssa.Value {
 	if _, ok := val.Type().(*types.Chan); ok {
 		switch instr := val.(type) {
@@ -24,3 +87,92 @@ func getChan(val ssa.Value, infer *TypeInfer) ssa.Value {
 	infer.Logger.Print("Don't know where this chan comes from:", val.String())
 	return val
 }
+
+// isNilConst returns true if inst is a nil constant.
+func isNilConst(inst Instance) bool {
+	c, ok := inst.(*Const)
+	return ok && c.Const.IsNil()
+}
+
+// lookupChan returns the instance of channel ch, nil channel constants are not
+// stored as locals so they are created on demand.
+func (caller *Function) lookupChan(ch ssa.Value) (Instance, bool) {
+	if inst, ok := caller.locals[ch]; ok {
+		return inst, true
+	}
+	if c, ok := ch.(*ssa.Const); ok {
+		return &Const{c}, true
+	}
+	return nil, false
+}
+
+// possiblyNil flags op at pos as an operation on channel ch with instance
+// inst if it is nil by pointer analysis only. Channels made in packages not
+// loaded are also found to be nil (see ssabuilder.FindNilChans), so the
+// operation is reported but kept as is.
+func (caller *Function) possiblyNil(op string, ch ssa.Value, inst Instance, pos token.Pos, infer *TypeInfer) {
+	v, ok := inst.(*Value)
+	if !ok || !caller.Prog.nilChans[ch] || infer.stubResult(v.Value) {
+		return
+	}
+	nilOp := &NilChanOp{Op: op, Pos: pos, Possibly: true}
+	caller.Prog.NilChanOps = append(caller.Prog.NilChanOps, nilOp)
+	infer.Logger.Print(caller.Sprintf(ErrorSymbol+"%s @ %s", nilOp, fmtPos(infer.SSA.DecodePos(pos))))
+}
+
+// chanName returns the name of the n-th channel of prog named by prefix, which
+// is unique across goroutines analysed concurrently.
+func (prog *Program) chanName(prefix string, n int) string {
+	if prog.gid > 0 {
+		return fmt.Sprintf("%s%d_%d", prefix, prog.gid, n)
+	}
+	return fmt.Sprintf("%s%d", prefix, n)
+}
+
+// nilChanOp flags op at pos as an operation on a nil channel (see isNilConst),
+// and declares a stand-in channel for the operation. Returns name of the
+// stand-in channel.
+func (caller *Function) nilChanOp(op string, pos token.Pos, infer *TypeInfer) string {
+	ch := &nilChan{name: caller.Prog.chanName("nilchan", len(caller.Prog.NilChanOps))}
+	caller.FuncDef.AddStmts(&migo.NewChanStatement{Name: ch, Chan: ch.name, Size: 0})
+	nilOp := &NilChanOp{Op: op, Pos: pos, Chan: ch.name}
+	caller.Prog.NilChanOps = append(caller.Prog.NilChanOps, nilOp)
+	infer.Logger.Print(caller.Sprintf(ErrorSymbol+"%s @ %s", nilOp, fmtPos(infer.SSA.DecodePos(pos))))
+	return ch.name
+}
+
+// chanNilCond returns the outcome of cond if cond compares a channel with nil
+// and the channel is known to be nil or made by make(chan), e.g. a channel set
+// to nil to disable a select case.
+func (caller *Function) chanNilCond(cond ssa.Value) (isTrue bool, ok bool) {
+	bin, ok := cond.(*ssa.BinOp)
+	if !ok || (bin.Op != token.EQL && bin.Op != token.NEQ) {
+		return false, false
+	}
+	ch, nilConst := bin.X, bin.Y
+	if _, ok := ch.(*ssa.Const); ok {
+		ch, nilConst = bin.Y, bin.X
+	}
+	if c, ok := nilConst.(*ssa.Const); !ok || !c.IsNil() {
+		return false, false
+	}
+	if _, ok := ch.Type().Underlying().(*types.Chan); !ok {
+		return false, false
+	}
+	inst, ok := caller.lookupChan(ch)
+	if !ok {
+		return false, false
+	}
+	var isNil bool
+	switch inst := inst.(type) {
+	case *Const:
+		isNil = inst.Const.IsNil()
+	case *Value:
+		if _, ok := inst.Value.(*ssa.MakeChan); !ok {
+			return false, false
+		}
+	default:
+		return false, false
+	}
+	return isNil == (bin.Op == token.EQL), true
+}
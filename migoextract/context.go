@@ -6,8 +6,11 @@ package migoextract
 import (
 	"bytes"
 	"fmt"
+	"go/token"
 	"go/types"
 	"log"
+	"sort"
+	"strings"
 
 	"github.com/nickng/migo/v3"
 	"golang.org/x/tools/go/ssa"
@@ -39,13 +42,24 @@ const (
 // A single inference has exactly one Program, and it contains all global
 // data (and metadata) in the program.
 type Program struct {
-	FuncInstance map[*ssa.Function]int  // Count number of function instances.
-	InitPkgs     map[*ssa.Package]bool  // Initialised packages.
-	Infer        *TypeInfer             // Reference to inference.
-	MigoProg     *migo.Program          // Core calculus of program.
-	closures     map[Instance]Captures  // Closures.
-	globals      map[ssa.Value]Instance // Global variables.
-	*Storage                            // Storage.
+	FuncInstance map[*ssa.Function]int        // Count number of function instances.
+	InitPkgs     map[*ssa.Package]bool        // Initialised packages.
+	Infer        *TypeInfer                   // Reference to inference.
+	MigoProg     *migo.Program                // Core calculus of program.
+	StmtPos      map[migo.Statement]token.Pos // Source positions of statements.
+	FuncPos      map[string]token.Pos         // Source positions of functions.
+	NilChanOps   []*NilChanOp                 // Operations on nil channels.
+	Diagnostics  []*Diagnostic                // Parts of the model left out (see Limits).
+	SummaryHits  int                          // Number of calls reusing summaries.
+	nilChans     map[ssa.Value]bool           // Channels nil by pointer analysis.
+	closedOps    map[token.Pos]bool           // Sends and closes which may panic (see ssabuilder.FindClosedChanOps).
+	summaries    map[string]*Function         // Memoised function summaries.
+	closures     map[Instance]Captures        // Closures.
+	globals      map[ssa.Value]Instance       // Global variables.
+	gid          int                          // Goroutine of a fork (0 if not forked).
+	stubChans    int                          // Channels created by blocking stubs.
+	syncChans    int                          // Channels modelling receivers.
+	*Storage                                  // Storage.
 }
 
 // NewProgram creates a program for a type inference.
@@ -54,12 +68,238 @@ func NewProgram(infer *TypeInfer) *Program {
 		FuncInstance: make(map[*ssa.Function]int),
 		InitPkgs:     make(map[*ssa.Package]bool),
 		Infer:        infer,
+		StmtPos:      make(map[migo.Statement]token.Pos),
+		FuncPos:      make(map[string]token.Pos),
+		nilChans:     make(map[ssa.Value]bool),
+		closedOps:    make(map[token.Pos]bool),
+		summaries:    make(map[string]*Function),
 		closures:     make(map[Instance]Captures),
 		globals:      make(map[ssa.Value]Instance),
 		Storage:      NewStorage(),
 	}
 }
 
+// addFunction adds a MiGo function defined at pos to the program.
+func (prog *Program) addFunction(fn *migo.Function, pos token.Pos) {
+	if f, ok := prog.MigoProg.Function(fn.Name); ok && f != fn {
+		// Another instance of the function, the first definition is kept.
+		prog.Infer.Logger.Printf("Definition of %s dropped (already defined)", fn.Name)
+		return
+	}
+	prog.MigoProg.AddFunction(fn)
+	if _, ok := prog.FuncPos[fn.Name]; !ok {
+		prog.FuncPos[fn.Name] = pos
+	}
+}
+
+// sortFunctions orders the MiGo functions by source position then by name, so
+// the output does not depend on the order of analysis. main.main is first and
+// functions without position, e.g. stubs, are last.
+func (prog *Program) sortFunctions() {
+	funcs := prog.MigoProg.Funcs
+	sort.SliceStable(funcs, func(i, j int) bool {
+		if mi, mj := funcs[i].Name == "main.main", funcs[j].Name == "main.main"; mi != mj {
+			return mi
+		}
+		pi, pj := prog.FuncPos[funcs[i].Name], prog.FuncPos[funcs[j].Name]
+		if pi.IsValid() != pj.IsValid() {
+			return pi.IsValid()
+		}
+		if pi != pj {
+			return pi < pj
+		}
+		return funcs[i].Name < funcs[j].Name
+	})
+}
+
+// alignCalls makes the calls and spawns match the parameters of the callee,
+// as instances of a function may know different channels of their arguments,
+// e.g. channels in a struct which is not analysed. Channels unknown to a call
+// are passed as channels external to the caller.
+func (prog *Program) alignCalls() {
+	defs := make(map[string]*migo.Function)
+	for _, f := range prog.MigoProg.Funcs {
+		defs[f.SimpleName()] = f
+	}
+	for _, f := range prog.MigoProg.Funcs {
+		alignStmts(f.Stmts, defs)
+	}
+}
+
+func alignStmts(stmts []migo.Statement, defs map[string]*migo.Function) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *migo.CallStatement:
+			if def, ok := defs[s.SimpleName()]; ok {
+				s.Params = alignParams(s.Params, def)
+			}
+		case *migo.SpawnStatement:
+			if def, ok := defs[s.SimpleName()]; ok {
+				s.Params = alignParams(s.Params, def)
+			}
+		case *migo.IfStatement:
+			alignStmts(s.Then, defs)
+			alignStmts(s.Else, defs)
+		case *migo.IfForStatement:
+			alignStmts(s.Then, defs)
+			alignStmts(s.Else, defs)
+		case *migo.SelectStatement:
+			for _, c := range s.Cases {
+				alignStmts(c, defs)
+			}
+		}
+	}
+}
+
+// alignParams returns params of a call in the order of the parameters of
+// def, matched by name.
+func alignParams(params []*migo.Parameter, def *migo.Function) []*migo.Parameter {
+	if len(params) == len(def.Params) {
+		return params
+	}
+	aligned := make([]*migo.Parameter, len(def.Params))
+	for i, dp := range def.Params {
+		aligned[i] = &migo.Parameter{Caller: &unknownChan{name: dp.Callee.Name() + "_unknown"}, Callee: dp.Callee}
+		for _, p := range params {
+			if p.Callee.Name() == dp.Callee.Name() {
+				aligned[i] = p
+				break
+			}
+		}
+	}
+	return aligned
+}
+
+// passFree passes the names used but not created in a function (free names)
+// as parameters from callers which create or are passed them, e.g. a global
+// channel made by main and used by the functions it calls. Free names are
+// otherwise different (external) channels to the analyses.
+func (prog *Program) passFree() {
+	defs := make(map[string]*migo.Function)
+	bound := make(map[string]map[string]bool) // Names created or passed in, by function.
+	for _, f := range prog.MigoProg.Funcs {
+		defs[f.SimpleName()] = f
+		bound[f.SimpleName()] = make(map[string]bool)
+		for _, p := range f.Params {
+			bound[f.SimpleName()][p.Callee.Name()] = true
+		}
+		walkStmts(f.Stmts, func(stmt migo.Statement) {
+			switch s := stmt.(type) {
+			case *migo.NewChanStatement:
+				bound[f.SimpleName()][s.Name.Name()] = true
+			case *migo.NewMem:
+				bound[f.SimpleName()][s.Name] = true
+			}
+		})
+	}
+	// Free names of functions, including free names of their callees.
+	need := make(map[string]map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, f := range prog.MigoProg.Funcs {
+			name := f.SimpleName()
+			if need[name] == nil {
+				need[name] = make(map[string]bool)
+			}
+			use := func(used string) {
+				if !bound[name][used] && !need[name][used] {
+					need[name][used], changed = true, true
+				}
+			}
+			walkStmts(f.Stmts, func(stmt migo.Statement) {
+				switch s := stmt.(type) {
+				case *migo.SendStatement:
+					use(s.Chan)
+				case *migo.RecvStatement:
+					use(s.Chan)
+				case *migo.CloseStatement:
+					use(s.Chan)
+				case *migo.MemRead:
+					use(s.Name)
+				case *migo.MemWrite:
+					use(s.Name)
+				case *migo.CallStatement:
+					for used := range need[s.SimpleName()] {
+						use(used)
+					}
+				case *migo.SpawnStatement:
+					for used := range need[s.SimpleName()] {
+						use(used)
+					}
+				}
+			})
+		}
+	}
+	// Free names created or passed in by a caller.
+	passed := make(map[string]map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, f := range prog.MigoProg.Funcs {
+			caller := f.SimpleName()
+			walkStmts(f.Stmts, func(stmt migo.Statement) {
+				var callee string
+				switch s := stmt.(type) {
+				case *migo.CallStatement:
+					callee = s.SimpleName()
+				case *migo.SpawnStatement:
+					callee = s.SimpleName()
+				}
+				if _, ok := defs[callee]; !ok {
+					return
+				}
+				for name := range need[callee] {
+					if (bound[caller][name] || passed[caller][name]) && !passed[callee][name] {
+						if passed[callee] == nil {
+							passed[callee] = make(map[string]bool)
+						}
+						passed[callee][name], changed = true, true
+					}
+				}
+			})
+		}
+	}
+	names := make(map[string][]string) // Sorted passed.
+	for callee, set := range passed {
+		for name := range set {
+			names[callee] = append(names[callee], name)
+		}
+		sort.Strings(names[callee])
+		for _, name := range names[callee] {
+			defs[callee].AddParams(&migo.Parameter{Caller: &paramChan{name: name}, Callee: &paramChan{name: name}})
+		}
+	}
+	done := make(map[migo.Statement]bool) // Statements shared by functions.
+	for _, f := range prog.MigoProg.Funcs {
+		walkStmts(f.Stmts, func(stmt migo.Statement) {
+			if done[stmt] {
+				return
+			}
+			done[stmt] = true
+			switch s := stmt.(type) {
+			case *migo.CallStatement:
+				for _, name := range names[s.SimpleName()] {
+					s.AddParams(&migo.Parameter{Caller: &paramChan{name: name}, Callee: &paramChan{name: name}})
+				}
+			case *migo.SpawnStatement:
+				for _, name := range names[s.SimpleName()] {
+					s.AddParams(&migo.Parameter{Caller: &paramChan{name: name}, Callee: &paramChan{name: name}})
+				}
+			}
+		})
+	}
+}
+
+// blockPos returns the source position of the first instruction in b with a
+// position, or the position of its enclosing function.
+func blockPos(b *ssa.BasicBlock) token.Pos {
+	for _, instr := range b.Instrs {
+		if pos := instr.Pos(); pos.IsValid() {
+			return pos
+		}
+	}
+	return b.Parent().Pos()
+}
+
 // Function captures the function environment.
 //
 // Function environment stores local variable instances (as reference), return
@@ -75,16 +315,88 @@ type Function struct {
 
 	id        int                    // Instance identifier.
 	hasBody   bool                   // True if function has body.
+	panics    bool                   // True if function may panic (not recovered).
+	panicked  bool                   // True if last call may panic.
+	panicOp   migo.Statement         // Send or close of the normal path if it may panic.
+	recovered bool                   // True if a caller in the goroutine may recover panics.
+	suffix    string                 // Suffix of the MiGo function name (see nilSuffix).
 	commaok   map[Instance]*CommaOk  // CommaOK statements.
 	defers    []*ssa.Defer           // Deferred calls.
 	locals    map[ssa.Value]Instance // Local variable instances.
 	revlookup map[string]string      // Reverse lookup names.
 	extraargs []ssa.Value
-	retvals   []Instance           // Return value instances.
-	selects   map[Instance]*Select // Select cases mapping.
-	tuples    map[Instance]Tuples  // Tuples.
-	loopstack *LoopStack           // Stack of Loop.
-	*Storage                       // Storage.
+	blockDefs map[string]bool           // MiGo functions defined for blocks.
+	retvals   []Instance                // Return value instances.
+	selects   map[Instance]*Select      // Select cases mapping.
+	syncs     map[Instance]*syncValue   // Channels modelling receivers in scope.
+	joins     Joins                     // Goroutines to be joined along the path.
+	retjoins  Joins                     // Goroutines to be joined at return.
+	entries   map[*ssa.BasicBlock]Joins // Joins at entry of block functions visited.
+	tuples    map[Instance]Tuples       // Tuples.
+	loopstack *LoopStack                // Stack of Loop.
+	*Storage                            // Storage.
+}
+
+// frame is a snapshot of the state of a function visit, which is restored to
+// visit blocks again, e.g. with different nil channels.
+type frame struct {
+	visited   map[*ssa.BasicBlock]int
+	locals    map[ssa.Value]Instance
+	extraargs []ssa.Value
+	storage   *Storage
+	joins     Joins
+	loop      Loop
+	loops     []*Loop
+}
+
+// save returns a snapshot of the visit of caller in loop l.
+func (caller *Function) save(l *Loop) *frame {
+	fr := &frame{
+		visited:   make(map[*ssa.BasicBlock]int, len(caller.Visited)),
+		locals:    make(map[ssa.Value]Instance, len(caller.locals)),
+		extraargs: append([]ssa.Value{}, caller.extraargs...),
+		storage:   caller.Storage.copy(),
+		joins:     caller.joins.copy(),
+		loop:      *l,
+	}
+	for blk, n := range caller.Visited {
+		fr.visited[blk] = n
+	}
+	for v, inst := range caller.locals {
+		fr.locals[v] = inst
+	}
+	caller.loopstack.Lock()
+	fr.loops = append([]*Loop{}, caller.loopstack.s...)
+	caller.loopstack.Unlock()
+	return fr
+}
+
+// restore restores the visit of caller in loop l to snapshot fr.
+func (caller *Function) restore(fr *frame, l *Loop) {
+	caller.Visited, caller.locals, caller.extraargs = fr.visited, fr.locals, fr.extraargs
+	caller.Storage, caller.joins = fr.storage, fr.joins
+	*l = fr.loop
+	caller.loopstack.Lock()
+	caller.loopstack.s = fr.loops
+	caller.loopstack.Unlock()
+}
+
+// vars returns the instances stored in the variables (pointers) of caller.
+func (caller *Function) vars() map[ssa.Value]Instance {
+	vars := make(map[ssa.Value]Instance)
+	for v, inst := range caller.locals {
+		if _, ok := v.Type().Underlying().(*types.Pointer); ok {
+			vars[v] = inst
+		}
+	}
+	return vars
+}
+
+// setVars stores instances of vars (see vars) in the variables of caller.
+func (caller *Function) setVars(vars map[ssa.Value]Instance) {
+	for v, inst := range vars {
+		caller.locals[v] = inst
+	}
 }
 
 // NewMainFunction returns a new main() call context.
@@ -101,8 +413,12 @@ func NewMainFunction(prog *Program, mainFn *ssa.Function) *Function {
 		locals:    make(map[ssa.Value]Instance),
 		retvals:   []Instance{},
 		extraargs: []ssa.Value{},
+		blockDefs: make(map[string]bool),
 		revlookup: make(map[string]string),
 		selects:   make(map[Instance]*Select),
+		syncs:     make(map[Instance]*syncValue),
+		joins:     make(Joins),
+		entries:   make(map[*ssa.BasicBlock]Joins),
 		tuples:    make(map[Instance]Tuples),
 		loopstack: NewLoopStack(),
 		Storage:   NewStorage(),
@@ -125,8 +441,12 @@ func NewFunction(caller *Function) *Function {
 		locals:    make(map[ssa.Value]Instance),
 		revlookup: make(map[string]string),
 		extraargs: []ssa.Value{},
+		blockDefs: make(map[string]bool),
 		retvals:   []Instance{},
 		selects:   make(map[Instance]*Select),
+		syncs:     make(map[Instance]*syncValue),
+		joins:     make(Joins),
+		entries:   make(map[*ssa.BasicBlock]Joins),
 		tuples:    make(map[Instance]Tuples),
 		loopstack: NewLoopStack(),
 		Storage:   NewStorage(),
@@ -142,31 +462,25 @@ func (caller *Function) HasBody() bool { return caller.hasBody }
 func (caller *Function) prepareCallFn(common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.Value) *Function {
 	callee := NewFunction(caller)
 	callee.Fn = fn
+	callee.recovered = caller.mayRecover()
 	// This function was called before
 	if _, ok := callee.Prog.FuncInstance[callee.Fn]; ok {
 		callee.Prog.FuncInstance[callee.Fn]++
 	} else {
 		callee.Prog.FuncInstance[callee.Fn] = 0
 	}
-	callee.FuncDef.Name = fn.String()
+	callee.suffix = caller.nilSuffix(common, fn, rcvr)
+	callee.FuncDef.Name = callee.defName()
 	callee.id = callee.Prog.FuncInstance[callee.Fn]
+	callee.FuncDef.Params = caller.callParams(common, fn, rcvr)
 	for i, param := range callee.Fn.Params {
-		var argCaller ssa.Value
-		if rcvr != nil {
-			if i == 0 {
-				argCaller = rcvr
-			} else {
-				argCaller = common.Args[i-1]
-			}
-		} else {
-			argCaller = common.Args[i]
-		}
-		if _, ok := argCaller.Type().(*types.Chan); ok {
-			callee.FuncDef.AddParams(&migo.Parameter{Caller: argCaller, Callee: param})
-		}
+		argCaller := callArg(common, rcvr, i)
 		if inst, ok := caller.locals[argCaller]; ok {
 			callee.locals[param] = inst
 			callee.revlookup[argCaller.Name()] = param.Name()
+			if ch, ok := caller.syncArg(argCaller, caller.Prog.Infer); ok {
+				callee.syncs[inst] = ch
+			}
 
 			// Copy array and struct from parent.
 			if elems, ok := caller.arrays[inst]; ok {
@@ -187,20 +501,120 @@ func (caller *Function) prepareCallFn(common *ssa.CallCommon, fn *ssa.Function,
 		if cap, ok := caller.Prog.closures[inst]; ok {
 			for i, fv := range callee.Fn.FreeVars {
 				callee.locals[fv] = cap[i]
-				if _, ok := derefType(fv.Type()).(*types.Chan); ok {
-					callee.FuncDef.AddParams(&migo.Parameter{Caller: fv, Callee: fv})
+				if ch, ok := caller.syncChan(cap[i], fv.Type(), caller.Prog.Infer); ok {
+					callee.syncs[cap[i]] = ch
 				}
 			}
 		}
 	}
+	caller.passJoins(callee)
 	return callee
 }
 
+// callArg returns the argument of the i-th parameter of a call of common,
+// where rcvr is the receiver of an invoke call (nil otherwise).
+func callArg(common *ssa.CallCommon, rcvr ssa.Value, i int) ssa.Value {
+	if rcvr == nil {
+		return common.Args[i]
+	}
+	if i == 0 {
+		return rcvr
+	}
+	return common.Args[i-1]
+}
+
+// callParams returns the parameters of a call of fn by common (see callArg),
+// from values of the caller to parameters of fn: channels (except nil),
+// channels in fields of structs, and channels modelling synchronised values,
+// of arguments and of bindings of closures.
+//
+// Both the definition of the callee and the statements calling or spawning
+// it take their parameters from callParams before the callee is visited, so
+// calls always match the definition.
+func (caller *Function) callParams(common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.Value) []*migo.Parameter {
+	infer := caller.Prog.Infer
+	params := []*migo.Parameter{}
+	add := func(from, to migo.NamedVar) {
+		for _, p := range params {
+			if p.Callee.Name() == to.Name() { // e.g. struct channels of same MakeChan
+				return
+			}
+		}
+		params = append(params, &migo.Parameter{Caller: from, Callee: to})
+	}
+	for i, param := range fn.Params {
+		arg := callArg(common, rcvr, i)
+		if _, ok := arg.Type().(*types.Chan); ok {
+			if inst, ok := caller.lookupChan(arg); !ok || !isNilConst(inst) {
+				add(getChan(arg, infer), param)
+			}
+		}
+		if inst, ok := caller.locals[arg]; ok {
+			for _, ch := range caller.structChans(inst) {
+				add(ch, ch)
+			}
+			if ch, ok := caller.syncArg(arg, infer); ok {
+				add(ch, ch)
+			}
+		}
+	}
+	if inst, ok := caller.locals[common.Value]; ok {
+		if bindings, ok := caller.Prog.closures[inst]; ok {
+			for i, fv := range fn.FreeVars {
+				if v, ok := bindings[i].(*Value); ok {
+					if _, ok := derefType(fv.Type()).(*types.Chan); ok {
+						add(caller.chanVar(v), fv)
+					}
+				}
+				if ch, ok := caller.syncChan(bindings[i], fv.Type(), infer); ok {
+					add(ch, ch)
+				}
+			}
+		}
+	}
+	return params
+}
+
+// nilSuffix returns the suffix of the MiGo function of a call of fn by common
+// (see callArg) naming the channels of parameters and bindings of closures
+// which are nil. Nil channels are not passed (see callParams), so the callee
+// is specialised for the nil channels, as block functions are (see
+// blockCall).
+func (caller *Function) nilSuffix(common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.Value) string {
+	var nils []string
+	for i, param := range fn.Params {
+		arg := callArg(common, rcvr, i)
+		if _, ok := arg.Type().(*types.Chan); ok {
+			if inst, ok := caller.lookupChan(arg); ok && isNilConst(inst) {
+				nils = append(nils, param.Name())
+			}
+		}
+	}
+	if inst, ok := caller.locals[common.Value]; ok {
+		if bindings, ok := caller.Prog.closures[inst]; ok {
+			for i, fv := range fn.FreeVars {
+				if _, ok := derefType(fv.Type()).(*types.Chan); ok && isNilConst(bindings[i]) {
+					nils = append(nils, fv.Name())
+				}
+			}
+		}
+	}
+	if len(nils) == 0 {
+		return ""
+	}
+	return "_nil_" + strings.Join(nils, "_")
+}
+
+// defName returns the name of the MiGo function of caller.
+func (caller *Function) defName() string {
+	return caller.Fn.String() + caller.suffix
+}
+
 // InstanceID returns the current function instance number (numbers of times
 // function called).
 func (caller *Function) InstanceID() int {
 	if caller.id < 0 {
-		log.Fatal(ErrUnitialisedFunc)
+		log.Panic(ErrUnitialisedFunc)
 	}
 	return caller.id
 }
@@ -209,7 +623,7 @@ func (caller *Function) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("--- Context ---\n")
 	if caller.Fn == nil {
-		log.Fatal(ErrUnitialisedFunc)
+		log.Panic(ErrUnitialisedFunc)
 	}
 	buf.WriteString(fmt.Sprintf("\t- Fn:\t%s_%d\n", caller.Fn, caller.id))
 	if caller.Caller != nil {
@@ -272,7 +686,7 @@ type Block struct {
 
 // NewBlock creates a new block enclosed by the given function.
 func NewBlock(parent *Function, block *ssa.BasicBlock, curr int) *Block {
-	blockFn := fmt.Sprintf("%s#%d", parent.Fn.String(), block.Index)
+	blockFn := fmt.Sprintf("%s#%d", parent.defName(), block.Index)
 	parent.ChildBlocks[block.Index] = &Block{
 		Function: parent,
 		MigoDef:  migo.NewFunction(blockFn),
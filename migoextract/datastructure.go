@@ -14,6 +14,37 @@ type Elems map[ssa.Value]Instance
 // Fields is a slice of variable instances.
 type Fields []Instance
 
+// zeroFields returns Fields of a zero-valued struct of type t, where channel
+// fields are nil.
+func zeroFields(t *types.Struct) Fields {
+	fields := make(Fields, t.NumFields())
+	for i := 0; i < t.NumFields(); i++ {
+		if _, ok := t.Field(i).Type().Underlying().(*types.Chan); ok {
+			fields[i] = &Const{ssa.NewConst(nil, t.Field(i).Type())}
+		}
+	}
+	return fields
+}
+
+// structChans returns the channels stored in fields of struct instance inst.
+func (caller *Function) structChans(inst Instance) []*Value {
+	fields, ok := caller.structs[inst]
+	if !ok {
+		if fields, ok = caller.Prog.structs[inst]; !ok {
+			return nil
+		}
+	}
+	var chans []*Value
+	for _, field := range fields {
+		if v, ok := field.(*Value); ok {
+			if _, ok := v.Type().Underlying().(*types.Chan); ok {
+				chans = append(chans, v)
+			}
+		}
+	}
+	return chans
+}
+
 func (caller *Function) getStructField(struc ssa.Value, idx int) (Instance, error) {
 	if instance, ok := caller.locals[struc]; ok {
 		if fields, ok := caller.structs[instance]; ok {
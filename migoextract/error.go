@@ -22,4 +22,5 @@ var (
 	ErrMethodNotFound  = errors.New("interface method not found")
 	ErrPhiUnknownEdge  = errors.New("phi node has edge from unknown block")
 	ErrIncompatType    = errors.New("cannot convert incompatible type")
+	ErrAnalysisFailed  = errors.New("analysis failed")
 )
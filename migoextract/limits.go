@@ -0,0 +1,77 @@
+package migoextract
+
+// Limits of the extraction.
+//
+// An extraction hitting a limit is not an error: the part of the program over
+// the limit is left out (loops are not unrolled, calls are treated as no-op,
+// the analysis stops) and a Diagnostic is added to the partial model.
+
+import (
+	"fmt"
+	"go/token"
+	"time"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Limits are limits of the extraction, zero means no limit.
+type Limits struct {
+	MaxUnroll    int64         // Maximum iterations of a static loop to unroll.
+	MaxDepth     int           // Maximum depth of calls to visit.
+	MaxInstances int           // Maximum instances (calls or spawns) of a function to visit.
+	Timeout      time.Duration // Time budget of the extraction.
+}
+
+// Diagnostic is a note of where the extracted model is incomplete.
+type Diagnostic struct {
+	Pos token.Pos // Position of the cause, NoPos if not in the source code.
+	Msg string
+}
+
+func (d *Diagnostic) String() string { return d.Msg }
+
+// diagnose adds a diagnostic at pos to the program, once per position and
+// message.
+func (prog *Program) diagnose(pos token.Pos, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, d := range prog.Diagnostics {
+		if d.Pos == pos && d.Msg == msg {
+			return
+		}
+	}
+	prog.Diagnostics = append(prog.Diagnostics, &Diagnostic{Pos: pos, Msg: msg})
+}
+
+// unrollable returns true if the static loop l is within the unroll limit.
+func (infer *TypeInfer) unrollable(l *Loop) bool {
+	if infer.Limits.MaxUnroll <= 0 {
+		return true
+	}
+	n, step := l.End-l.Start, l.Step // End is inclusive.
+	if n < 0 {
+		n = -n
+	}
+	if step < 0 {
+		step = -step
+	}
+	if step > 1 {
+		n /= step
+	}
+	n++
+	if n > infer.Limits.MaxUnroll {
+		l.Parent.Prog.diagnose(l.CondVar.Pos(), "loop of %d iterations not unrolled (unroll limit %d)", n, infer.Limits.MaxUnroll)
+		return false
+	}
+	return true
+}
+
+// instantiable returns true if fn can have another instance within the
+// instance limit.
+func (prog *Program) instantiable(fn *ssa.Function, pos token.Pos) bool {
+	limit := prog.Infer.Limits.MaxInstances
+	if n, ok := prog.FuncInstance[fn]; limit > 0 && ok && n+1 >= limit {
+		prog.diagnose(pos, "%s not visited again (instance limit %d)", fn.String(), limit)
+		return false
+	}
+	return true
+}
@@ -3,35 +3,49 @@
 package migoextract // import "github.com/nickng/dingo-hunter/migoextract"
 
 import (
+	"context"
+	"fmt"
+	"go/token"
 	"go/types"
 	"io"
 	"log"
 	"time"
 
 	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/dingo-hunter/stubs/stdlib"
 	"github.com/nickng/migo/v3"
 	"golang.org/x/tools/go/ssa"
 )
 
 // TypeInfer contains the metadata for a type inference.
 type TypeInfer struct {
-	SSA    *ssabuilder.SSAInfo // SSA IR of program.
-	Env    *Program            // Analysed program.
-	GQueue []*Function         // Goroutines to be analysed.
+	SSA     *ssabuilder.SSAInfo     // SSA IR of program.
+	Env     *Program                // Analysed program.
+	GQueue  []*Function             // Goroutines to be analysed.
+	Jobs    int                     // Goroutines analysed concurrently.
+	Stubs   map[string]Stub         // Stubbed functions (see stub).
+	StubSet *stubs.Set              // Models of functions without SSA body.
+	Models  map[string]stdlib.Model // Models of functions by stub name.
+	Limits  Limits                  // Extraction limits.
 
 	Time   time.Duration
 	Logger *log.Logger
 	Done   chan struct{}
 	Error  chan error
+
+	ctx context.Context // Cancellation of the analysis.
 }
 
 // New creates a new session type infer analysis.
 func New(ssainfo *ssabuilder.SSAInfo, inferlog io.Writer) (*TypeInfer, error) {
 	infer := &TypeInfer{
-		SSA:    ssainfo,
-		Logger: log.New(inferlog, "migoextract: ", ssainfo.BuildConf.LogFlags),
+		SSA:     ssainfo,
+		StubSet: stdlib.Stubs(),
+		Models:  stdlib.Models(),
+		Logger:  log.New(inferlog, "migoextract: ", ssainfo.BuildConf.LogFlags),
 
-		Done:  make(chan struct{}),
+		Done:  make(chan struct{}, 1),
 		Error: make(chan error, 1),
 	}
 
@@ -40,6 +54,31 @@ func New(ssainfo *ssabuilder.SSAInfo, inferlog io.Writer) (*TypeInfer, error) {
 
 // Run executes the analysis.
 func (infer *TypeInfer) Run() {
+	infer.RunContext(context.Background())
+}
+
+// RunContext executes the analysis until ctx is cancelled, the analysis then
+// stops at the next function or block and ctx.Err() is sent to infer.Error.
+// infer.Env holds the model extracted so far.
+//
+// The analysis also stops when infer.Limits.Timeout is exceeded, but is then
+// completed with a Diagnostic in the partial model.
+//
+// Programs the analysis cannot handle (e.g. unknown SSA values) stop the
+// analysis with an error wrapping ErrAnalysisFailed sent to infer.Error.
+func (infer *TypeInfer) RunContext(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			infer.Logger.Printf("Analysis failed: %v", r)
+			infer.Error <- fmt.Errorf("%w: %v", ErrAnalysisFailed, r)
+		}
+	}()
+	infer.ctx = ctx
+	if infer.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		infer.ctx, cancel = context.WithTimeout(ctx, infer.Limits.Timeout)
+		defer cancel()
+	}
 	infer.Logger.Println("---- Start Analysis ----")
 	// Initialise session.
 	infer.Env = NewProgram(infer)
@@ -49,42 +88,81 @@ func (infer *TypeInfer) Run() {
 	mainPkg := ssabuilder.MainPkg(infer.SSA.Prog)
 	if mainPkg == nil {
 		infer.Error <- ErrNoMainPkg
+		return
 	}
-	defer close(infer.Done)
 
 	initFn := mainPkg.Func("init")
 	mainFn := mainPkg.Func("main")
 
-	ctx := NewMainFunction(infer.Env, mainFn)
+	fn := NewMainFunction(infer.Env, mainFn)
 	// TODO(nickng): inline initialisation of var declarations
 	for _, pkg := range infer.SSA.Prog.AllPackages() {
 		for _, memb := range pkg.Members {
 			switch value := memb.(type) {
 			case *ssa.Global:
-				ctx.Prog.globals[value] = &Value{Value: value}
+				fn.Prog.globals[value] = &Value{Value: value}
 				switch t := derefAllType(value.Type()).Underlying().(type) {
 				case *types.Array:
-					ctx.Prog.arrays[ctx.Prog.globals[value]] = make(Elems, t.Len())
+					fn.Prog.arrays[fn.Prog.globals[value]] = make(Elems, t.Len())
 				case *types.Slice:
-					ctx.Prog.arrays[ctx.Prog.globals[value]] = make(Elems, 0)
+					fn.Prog.arrays[fn.Prog.globals[value]] = make(Elems, 0)
 				case *types.Struct:
-					ctx.Prog.structs[ctx.Prog.globals[value]] = make(Fields, t.NumFields())
+					fn.Prog.structs[fn.Prog.globals[value]] = zeroFields(t)
 				default:
 				}
 			}
 		}
 	}
-	visitFunc(initFn, infer, ctx)
-	visitFunc(mainFn, infer, ctx)
+	nilOps, err := infer.SSA.FindNilChans()
+	if err != nil {
+		infer.Logger.Print("Cannot find nil channels:", err)
+		infer.Env.diagnose(token.NoPos, "nil channels not detected: %v", err)
+	}
+	for _, op := range nilOps {
+		infer.Env.nilChans[op.Value] = true
+	}
+	closedOps, err := infer.SSA.FindClosedChanOps()
+	if err != nil {
+		infer.Logger.Print("Cannot find closed channels:", err)
+		infer.Env.diagnose(token.NoPos, "panics of closed channels not detected: %v", err)
+	}
+	for _, op := range closedOps {
+		infer.Env.closedOps[op.Pos] = true
+	}
+	visitFunc(initFn, infer, fn)
+	visitFunc(mainFn, infer, fn)
 
 	infer.RunQueue()
+	if infer.cancelled() {
+		// Goroutines not analysed are defined without behaviour.
+		for _, f := range infer.GQueue {
+			infer.Env.addFunction(f.FuncDef, f.Fn.Pos())
+		}
+	}
+	infer.Env.alignCalls()
+	infer.Env.passFree()
+	infer.Env.sortFunctions()
 	infer.Time = time.Now().Sub(startTime)
+	infer.Logger.Printf("Function summaries reused %d times", infer.Env.SummaryHits)
+	if infer.SSA.Cache != nil {
+		hits, misses := infer.SSA.Cache.Stats()
+		infer.Logger.Printf("Cache: %d hits, %d misses", hits, misses)
+	}
+	if err := ctx.Err(); err != nil {
+		infer.Logger.Printf("Analysis stopped: %v", err)
+		infer.Error <- err
+		return
+	}
+	if infer.cancelled() {
+		infer.Env.diagnose(token.NoPos, "analysis stopped (time limit %s)", infer.Limits.Timeout)
+	}
+	for _, d := range infer.Env.Diagnostics {
+		infer.Logger.Printf("Partial model: %s", d.Msg)
+	}
+	close(infer.Done)
 }
 
-// RunQueue executes the analysis on spawned (queued) goroutines.
-func (infer *TypeInfer) RunQueue() {
-	for _, ctx := range infer.GQueue {
-		infer.Logger.Printf("----- Goroutine %s -----", ctx.Fn.String())
-		visitFunc(ctx.Fn, infer, ctx)
-	}
+// cancelled returns true if the analysis is cancelled.
+func (infer *TypeInfer) cancelled() bool {
+	return infer.ctx != nil && infer.ctx.Err() != nil
 }
@@ -0,0 +1,1228 @@
+package migoextract
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nickng/dingo-hunter/cache"
+	"github.com/nickng/dingo-hunter/leakcheck"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/dingo-hunter/stubs/stdlib"
+	"github.com/nickng/migo/v3"
+)
+
+// extract runs MiGo type inference on source code s.
+func extract(t *testing.T, s string) *TypeInfer {
+	return extractJobs(t, s, 1)
+}
+
+// extractJobs runs MiGo type inference on source code s with jobs workers.
+func extractJobs(t *testing.T, s string, jobs int) *TypeInfer {
+	return extractCache(t, s, jobs, nil)
+}
+
+// extractCache runs MiGo type inference on source code s with jobs workers
+// and cache dir (nil to disable).
+func extractCache(t *testing.T, s string, jobs int, dir *cache.Dir) *TypeInfer {
+	return extractWith(t, s, func(infer *TypeInfer) {
+		infer.Jobs = jobs
+		infer.SSA.Cache = dir
+	})
+}
+
+// extractWith runs MiGo type inference on source code s, configure sets up
+// the inference before it runs.
+func extractWith(t *testing.T, s string, configure func(*TypeInfer)) *TypeInfer {
+	conf, err := ssabuilder.NewConfigFromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	infer, err := New(info, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configure(infer)
+	go infer.Run()
+	select {
+	case err := <-infer.Error:
+		t.Fatal(err)
+	case <-infer.Done:
+	}
+	return infer
+}
+
+// Tests operations on zero-valued (nil) struct channel fields are flagged.
+func TestNilChanField(t *testing.T) {
+	infer := extract(t, `package main
+type T struct {
+	done  chan struct{}
+	value int
+}
+func X(ctx T) { ctx.done <- struct{}{} }
+func main() {
+	ctx := T{value: 3}
+	go X(ctx)
+	<-ctx.done
+}`)
+	if len(infer.Env.NilChanOps) != 2 {
+		t.Errorf("Expecting 2 nil channel operations but got %d\n", len(infer.Env.NilChanOps))
+	}
+}
+
+// Tests initialised struct channel fields are not flagged.
+func TestChanField(t *testing.T) {
+	infer := extract(t, `package main
+type T struct {
+	done  chan struct{}
+	value int
+}
+func X(ctx T) { ctx.done <- struct{}{} }
+func main() {
+	ctx := T{done: make(chan struct{}), value: 3}
+	go X(ctx)
+	<-ctx.done
+}`)
+	if len(infer.Env.NilChanOps) != 0 {
+		t.Errorf("Expecting 0 nil channel operations but got %d\n", len(infer.Env.NilChanOps))
+	}
+}
+
+// Tests nil channel global found by pointer analysis is flagged.
+func TestNilChanGlobal(t *testing.T) {
+	infer := extract(t, `package main
+var global chan int
+func main() { global <- 1 }`)
+	if len(infer.Env.NilChanOps) != 1 {
+		t.Errorf("Expecting 1 nil channel operations but got %d\n", len(infer.Env.NilChanOps))
+	} else if !infer.Env.NilChanOps[0].Possibly {
+		t.Errorf("Expecting nil channel by pointer analysis to be possibly nil\n")
+	}
+	// Possibly nil channels, e.g. made in packages not loaded, are not
+	// replaced by a stand-in channel.
+	if s := infer.Env.MigoProg.String(); !strings.Contains(s, "send global;") || strings.Contains(s, "nilchan") {
+		t.Errorf("Expecting send on global channel kept but got\n%s\n", s)
+	}
+}
+
+// Tests close of nil channel is reported as panic.
+func TestNilChanClose(t *testing.T) {
+	infer := extract(t, `package main
+func main() {
+	var ch chan int
+	close(ch)
+}`)
+	if len(infer.Env.NilChanOps) != 1 {
+		t.Fatalf("Expecting 1 nil channel operations but got %d\n", len(infer.Env.NilChanOps))
+	}
+	if msg := infer.Env.NilChanOps[0].String(); msg != "close of nil channel (panics)" {
+		t.Errorf("Expecting close of nil channel (panics) but got %s\n", msg)
+	}
+}
+
+// Tests select cases disabled by setting the channel to nil in a loop.
+func TestSelectNilDisable(t *testing.T) {
+	infer := extract(t, `package main
+func producer(ch chan int) {
+	ch <- 1
+	close(ch)
+}
+func main() {
+	a, b := make(chan int), make(chan int)
+	go producer(a)
+	go producer(b)
+	for a != nil || b != nil {
+		select {
+		case _, ok := <-a:
+			if !ok {
+				a = nil
+			}
+		case _, ok := <-b:
+			if !ok {
+				b = nil
+			}
+		}
+	}
+}`)
+	if len(infer.Env.NilChanOps) != 0 {
+		t.Errorf("Expecting 0 nil channel operations but got %d\n", len(infer.Env.NilChanOps))
+	}
+	nilFuncs := 0
+	for _, f := range infer.Env.MigoProg.Funcs {
+		if !strings.Contains(f.Name, "_nil_") {
+			continue
+		}
+		nilFuncs++
+		for _, stmt := range f.Stmts {
+			if sel, ok := stmt.(*migo.SelectStatement); ok && len(sel.Cases) != 1 {
+				t.Errorf("Expecting 1 select case in %s but got %d\n", f.Name, len(sel.Cases))
+			}
+		}
+	}
+	if nilFuncs != 3 { // a nil, b nil, both nil
+		t.Errorf("Expecting 3 functions with nil channels but got %d\n", nilFuncs)
+	}
+	migofile.Simplify(infer.Env.MigoProg)
+	if findings := leakcheck.Check(infer.Env.MigoProg); len(findings) != 0 {
+		t.Errorf("Expecting 0 finding but got %d\n", len(findings))
+	}
+}
+
+// Tests channels stored in a branch to a variable captured by a closure are
+// passed to the block merging the branches, which is specialised for the nil
+// channel of the other branch.
+func TestNilCapturedBranch(t *testing.T) {
+	infer := extract(t, `package main
+var c bool
+func main() {
+	var ch chan int
+	if c {
+		ch = make(chan int)
+	}
+	go func() { ch <- 1 }()
+	<-ch
+}`)
+	if errs := migofile.Check(infer.Env.MigoProg); len(errs) != 0 {
+		t.Errorf("Expecting no problems but got %v\n", errs)
+	}
+	migofile.Simplify(infer.Env.MigoProg)
+	findings := leakcheck.Check(infer.Env.MigoProg)
+	kinds := make(map[leakcheck.Kind]int)
+	for _, f := range findings {
+		kinds[f.Kind]++
+	}
+	if len(findings) != 2 || kinds[leakcheck.Deadlock] != 1 || kinds[leakcheck.Leak] != 1 {
+		t.Errorf("Expecting 1 deadlock and 1 leak of the goroutine on nil channel but got %v\n", findings)
+	}
+}
+
+// Tests global channels made by the caller are passed to the functions using
+// them, directly or through their callees.
+func TestGlobalChanPassed(t *testing.T) {
+	infer := extract(t, `package main
+var ch chan int
+func f() { g() }
+func g() { <-ch }
+func main() {
+	ch = make(chan int)
+	f()
+}`)
+	if errs := migofile.Check(infer.Env.MigoProg); len(errs) != 0 {
+		t.Errorf("Expecting no problems but got %v\n", errs)
+	}
+	for _, name := range []string{"main.f", "main.g"} {
+		if f, ok := infer.Env.MigoProg.Function(name); !ok || len(f.Params) != 1 {
+			t.Errorf("Expecting %s with the channel as parameter but got %v\n", name, f)
+		}
+	}
+}
+
+// Tests select cases disabled by setting channel variables captured by
+// closures to nil in a loop, each case from the variables before the select.
+func TestSelectNilDisableCaptured(t *testing.T) {
+	infer := extract(t, `package main
+func main() {
+	a, b := make(chan int), make(chan int)
+	go func() { a <- 1; close(a) }()
+	go func() { b <- 1; close(b) }()
+	for a != nil || b != nil {
+		select {
+		case _, ok := <-a:
+			if !ok {
+				a = nil
+			}
+		case _, ok := <-b:
+			if !ok {
+				b = nil
+			}
+		}
+	}
+}`)
+	nils := make(map[string]bool) // Suffixes of functions with nil channels.
+	for _, f := range infer.Env.MigoProg.Funcs {
+		if i := strings.Index(f.Name, "_nil_"); i >= 0 {
+			nils[f.Name[i:]] = true
+		}
+	}
+	for _, suffix := range []string{"_nil_t0", "_nil_t1", "_nil_t0_t1"} {
+		if !nils[suffix] {
+			t.Errorf("Expecting function with nil channels %s but got %v\n", suffix, nils)
+		}
+	}
+	migofile.Simplify(infer.Env.MigoProg)
+	if findings := leakcheck.Check(infer.Env.MigoProg); len(findings) != 0 {
+		t.Errorf("Expecting 0 finding but got %v\n", findings)
+	}
+}
+
+// Tests blocks reached with different nil channels are specialised for each,
+// here channel variables captured by closures.
+func TestSelectNilBranches(t *testing.T) {
+	infer := extract(t, `package main
+func main() {
+	a, b := make(chan int), make(chan int)
+	go func() { a <- 1 }()
+	go func() { b <- 1 }()
+	x := 1
+	if x > 0 {
+		a = nil
+	} else {
+		b = nil
+	}
+	select {
+	case <-a:
+	case <-b:
+	}
+}`)
+	nilFuncs := make(map[string]bool)
+	for _, f := range infer.Env.MigoProg.Funcs {
+		if !strings.Contains(f.Name, "_nil_") {
+			continue
+		}
+		for _, stmt := range f.Stmts {
+			if sel, ok := stmt.(*migo.SelectStatement); ok {
+				nilFuncs[f.Name] = true
+				if len(sel.Cases) != 1 {
+					t.Errorf("Expecting 1 select case in %s but got %d\n", f.Name, len(sel.Cases))
+				}
+			}
+		}
+	}
+	if len(nilFuncs) != 2 { // a nil, b nil
+		t.Errorf("Expecting 2 selects with nil channels but got %d\n", len(nilFuncs))
+	}
+	if errs := migofile.Check(infer.Env.MigoProg); len(errs) != 0 {
+		t.Errorf("Expecting valid MiGo but got %v\n%s\n", errs, infer.Env.MigoProg)
+	}
+}
+
+// Tests a channel which is nil on one branch of an if.
+func TestPhiNilChan(t *testing.T) {
+	infer := extract(t, `package main
+func main() {
+	var ch chan int
+	x := 1
+	if x > 0 {
+		ch = make(chan int, 1)
+	}
+	ch <- 1
+}`)
+	if len(infer.Env.NilChanOps) != 1 {
+		t.Errorf("Expecting 1 nil channel operations but got %d\n", len(infer.Env.NilChanOps))
+	}
+}
+
+// Tests a channel which is nil on one edge of a Phi and passed to a goroutine
+// spawns a goroutine specialised for the nil channel.
+func TestPhiNilChanSpawn(t *testing.T) {
+	infer := extract(t, `package main
+var c bool
+func send(ch chan int) {
+	ch <- 1
+}
+func main() {
+	var ch chan int
+	if c {
+		ch = make(chan int)
+	}
+	go send(ch)
+	<-ch
+}`)
+	if len(infer.Env.NilChanOps) != 2 {
+		t.Errorf("Expecting 2 nil channel operations but got %d\n", len(infer.Env.NilChanOps))
+	}
+	fn, ok := infer.Env.MigoProg.Function("main.send_nil_ch")
+	if !ok {
+		t.Fatalf("Expecting main.send specialised for nil ch but got\n%s\n", infer.Env.MigoProg)
+	}
+	if len(fn.Params) != 0 {
+		t.Errorf("Expecting no parameters of %s but got %d\n", fn.Name, len(fn.Params))
+	}
+	spawns := 0
+	for _, f := range infer.Env.MigoProg.Funcs {
+		walkStmts(f.Stmts, func(stmt migo.Statement) {
+			if s, ok := stmt.(*migo.SpawnStatement); ok {
+				spawns++
+				if strings.Contains(f.Name, "_nil_") != (s.Name == fn.Name) {
+					t.Errorf("Expecting spawn of %s only on nil path but got %s in %s\n", fn.Name, s, f.Name)
+				}
+			}
+		})
+	}
+	if spawns != 2 {
+		t.Errorf("Expecting 2 spawns but got %d\n", spawns)
+	}
+	if errs := migofile.Check(infer.Env.MigoProg); len(errs) != 0 {
+		t.Errorf("Expecting valid MiGo but got %v\n%s\n", errs, infer.Env.MigoProg)
+	}
+}
+
+// Tests deferred close runs on the (recovered) panic path of a worker.
+func TestPanicDeferClose(t *testing.T) {
+	infer := extract(t, `package main
+func work(x int) {
+	if x > 3 {
+		panic("bad")
+	}
+}
+func worker(ch chan int, x int) {
+	defer close(ch)
+	defer func() {
+		recover()
+	}()
+	work(x)
+	ch <- 1
+}
+func main() {
+	ch := make(chan int)
+	go worker(ch, 5)
+	for range ch {
+	}
+}`)
+	if n := panicCloses(t, infer, "main.worker"); n != 1 {
+		t.Errorf("Expecting 1 close on panic path but got %d\n", n)
+	}
+}
+
+// Tests close after a panicking call is skipped on the (recovered) panic path.
+func TestPanicRecover(t *testing.T) {
+	infer := extract(t, `package main
+func work(x int) {
+	if x > 3 {
+		panic("bad")
+	}
+}
+func worker(ch chan int, x int) {
+	defer func() {
+		recover()
+	}()
+	work(x)
+	ch <- 1
+	close(ch)
+}
+func main() {
+	ch := make(chan int)
+	go worker(ch, 5)
+	for range ch {
+	}
+}`)
+	if n := panicCloses(t, infer, "main.worker"); n != 0 {
+		t.Errorf("Expecting 0 close on panic path but got %d\n", n)
+	}
+}
+
+// Tests sends and closes on channels which may be closed by another close are
+// split into normal and panic paths before the operation.
+func TestPanicClosedChan(t *testing.T) {
+	infer := extract(t, `package main
+func worker(ch chan int, done chan int) {
+	defer close(done)
+	defer func() {
+		recover()
+	}()
+	ch <- 1
+	close(ch)
+}
+func main() {
+	ch := make(chan int, 1)
+	done := make(chan int)
+	go worker(ch, done)
+	close(ch)
+	<-done
+}`)
+	if n := panicCloses(t, infer, "main.worker"); n != 1 {
+		t.Errorf("Expecting deferred close on panic path of send but got %d\n%s\n", n, infer.Env.MigoProg)
+	}
+	fn, _ := infer.Env.MigoProg.Function("main.worker")
+	for _, stmt := range fn.Stmts {
+		if ifStmt, ok := stmt.(*migo.IfStatement); ok {
+			if _, ok := ifStmt.Then[0].(*migo.SendStatement); !ok {
+				t.Errorf("Expecting send on normal path but got %s\n", ifStmt.Then[0])
+			}
+		}
+	}
+	if panicPaths(t, infer, "main.main") != 0 {
+		t.Errorf("Expecting no panic path of unrecovered close but got\n%s\n", infer.Env.MigoProg)
+	}
+}
+
+// Tests sends and closes before the close of a channel cannot panic.
+func TestPanicClosedChanOrder(t *testing.T) {
+	infer := extract(t, `package main
+func producer(ch chan int) {
+	defer func() {
+		recover()
+	}()
+	ch <- 1
+	ch <- 2
+	close(ch)
+}
+func main() {
+	ch := make(chan int, 2)
+	go producer(ch)
+	for range ch {
+	}
+}`)
+	if n := panicPaths(t, infer, "main.producer"); n != 0 {
+		t.Errorf("Expecting no panic path but got %d\n%s\n", n, infer.Env.MigoProg)
+	}
+}
+
+// panicCloses returns the number of close statements on the panic path of
+// function name.
+func panicCloses(t *testing.T, infer *TypeInfer, name string) int {
+	fn, ok := infer.Env.MigoProg.Function(name)
+	if !ok {
+		t.Fatalf("Function %s not found\n", name)
+	}
+	for _, stmt := range fn.Stmts {
+		if ifStmt, ok := stmt.(*migo.IfStatement); ok {
+			n := 0
+			for _, s := range ifStmt.Else {
+				if _, ok := s.(*migo.CloseStatement); ok {
+					n++
+				}
+			}
+			return n
+		}
+	}
+	t.Fatalf("Panic path of %s not found\n", name)
+	return 0
+}
+
+// panicPaths returns the number of if statements of function name, i.e. the
+// splits into normal and panic paths of a function without branches.
+func panicPaths(t *testing.T, infer *TypeInfer, name string) int {
+	fn, ok := infer.Env.MigoProg.Function(name)
+	if !ok {
+		t.Fatalf("Function %s not found\n", name)
+	}
+	n := 0
+	for _, stmt := range fn.Stmts {
+		if _, ok := stmt.(*migo.IfStatement); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Tests identical calls reuse the function summary.
+func TestSummaryReuse(t *testing.T) {
+	infer := extract(t, `package main
+func send(ch chan int, n int) {
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+}
+func pair(ch chan int) {
+	send(ch, 2)
+	send(ch, 2)
+	send(ch, 3)
+}
+func main() {
+	ch := make(chan int, 10)
+	pair(ch)
+	pair(ch)
+	<-ch
+}`)
+	if infer.Env.SummaryHits != 2 {
+		t.Errorf("Expecting 2 summary hits but got %d\n", infer.Env.SummaryHits)
+	}
+	if fn, ok := infer.Env.MigoProg.Function("main.pair"); !ok || len(fn.Stmts) != 3 {
+		t.Errorf("Expecting main.pair with 3 calls but got %v\n", fn)
+	}
+}
+
+// Tests goroutines analysed concurrently give the same result.
+func TestJobs(t *testing.T) {
+	s := `package main
+func leaf(ch chan int) { ch <- 1 }
+func mid(ch chan int) {
+	go leaf(ch)
+	go leaf(ch)
+	<-ch
+}
+func main() {
+	a, b := make(chan int), make(chan int)
+	go mid(a)
+	go mid(b)
+	<-a
+	<-b
+}`
+	seq := extractJobs(t, s, 1)
+	if _, ok := seq.Env.MigoProg.Function("main.leaf"); !ok {
+		t.Errorf("Expecting main.leaf spawned by goroutine to be analysed\n")
+	}
+	if len(seq.GQueue) != 6 {
+		t.Errorf("Expecting 6 goroutines but got %d\n", len(seq.GQueue))
+	}
+	for i := 0; i < 5; i++ {
+		par := extractJobs(t, s, 4)
+		if seq.Env.MigoProg.String() != par.Env.MigoProg.String() {
+			t.Errorf("Expecting same MiGo with 4 jobs but got\n%s\nand\n%s\n", seq.Env.MigoProg, par.Env.MigoProg)
+		}
+	}
+}
+
+// Tests MiGo functions are ordered by source position, then by name.
+func TestFunctionOrder(t *testing.T) {
+	s := `package main
+func main() {
+	ch := make(chan int)
+	go b(ch)
+	a(ch)
+}
+func b(ch chan int) { ch <- 1 }
+func a(ch chan int) { <-ch }`
+	infer := extract(t, s)
+	var names []string
+	for _, fn := range infer.Env.MigoProg.Funcs {
+		names = append(names, fn.Name)
+	}
+	iMain, iB, iA := -1, -1, -1
+	for i, name := range names {
+		switch name {
+		case "main.main":
+			iMain = i
+		case "main.b":
+			iB = i
+		case "main.a":
+			iA = i
+		}
+	}
+	if !(0 <= iMain && iMain < iB && iB < iA) {
+		t.Errorf("Expecting main.main, main.b, main.a in order but got %v\n", names)
+	}
+	for i := 0; i < 5; i++ {
+		if infer2 := extract(t, s); infer.Env.MigoProg.String() != infer2.Env.MigoProg.String() {
+			t.Errorf("Expecting identical MiGo but got\n%s\nand\n%s\n", infer.Env.MigoProg, infer2.Env.MigoProg)
+		}
+	}
+}
+
+// Tests summaries are read from the cache only if the source is unchanged.
+func TestCacheSummary(t *testing.T) {
+	s := `package main
+func send(ch chan int, n int) {
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+}
+func pair(ch chan int) {
+	send(ch, 2)
+	close(ch)
+}
+func main() {
+	ch := make(chan int, 10)
+	pair(ch)
+	pair(ch)
+	<-ch
+}`
+	dir, err := cache.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncached := extract(t, s)
+	extractCache(t, s, 1, dir)
+	if hits, _ := dir.Stats(); hits != 0 {
+		t.Errorf("Expecting no cache hits in first run but got %d\n", hits)
+	}
+	cached := extractCache(t, s, 1, dir)
+	if hits, _ := dir.Stats(); hits == 0 {
+		t.Errorf("Expecting cache hits in second run\n")
+	}
+	if uncached.Env.MigoProg.String() != cached.Env.MigoProg.String() {
+		t.Errorf("Expecting same MiGo from cache but got\n%s\nand\n%s\n", uncached.Env.MigoProg, cached.Env.MigoProg)
+	}
+	for _, stmt := range []string{"close", "send"} {
+		found := false
+		for st, pos := range cached.Env.StmtPos {
+			if strings.HasPrefix(st.String(), stmt) && pos.IsValid() {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expecting position of %s statement restored from cache\n", stmt)
+		}
+	}
+	edited := extractCache(t, strings.Replace(s, "close(ch)", "", 1), 1, dir)
+	if strings.Contains(edited.Env.MigoProg.String(), "close") {
+		t.Errorf("Expecting no close in edited program but got\n%s\n", edited.Env.MigoProg)
+	}
+}
+
+// Tests calls with channels of different origin or aliases do not reuse the
+// function summary.
+func TestSummaryOrigin(t *testing.T) {
+	infer := extract(t, `package main
+var cond bool
+func f(a, b chan int) {
+	a <- 1
+	<-b
+}
+func main() {
+	a, b := make(chan int, 1), make(chan int, 1)
+	f(a, b)
+	f(a, a)
+	c := a
+	if cond {
+		c = b
+	}
+	f(c, b)
+	f(b, a)
+}`)
+	if infer.Env.SummaryHits != 1 {
+		t.Errorf("Expecting 1 summary hit but got %d\n%s\n", infer.Env.SummaryHits, infer.Env.MigoProg)
+	}
+}
+
+// Tests summaries are cached per set of packages skipped by the build.
+func TestCacheKeySkipPkgs(t *testing.T) {
+	infer := extract(t, `package main
+func main() {}`)
+	key := infer.configKey()
+	infer.SSA.IgnoredPkgs = []string{"logging"}
+	if infer.configKey() == key {
+		t.Errorf("Expecting cache key to change with skipped packages but got %s\n", key)
+	}
+}
+
+// Tests calls to stubbed functions are not visited.
+func TestStubs(t *testing.T) {
+	infer := extractWith(t, `package main
+func logf(ch chan int) { ch <- 1 }
+func wait() {}
+func main() {
+	ch := make(chan int)
+	logf(ch)
+	wait()
+}`, func(infer *TypeInfer) {
+		infer.Stubs = map[string]Stub{"main.logf": NoopStub, "main.wait": BlockingStub}
+	})
+	if _, ok := infer.Env.MigoProg.Function("main.logf"); ok {
+		t.Errorf("Expecting no-op stub main.logf not to be visited but got\n%s\n", infer.Env.MigoProg)
+	}
+	findings := leakcheck.Check(infer.Env.MigoProg)
+	if len(findings) != 1 || findings[0].Kind != leakcheck.Deadlock {
+		t.Errorf("Expecting blocking stub main.wait to deadlock but got %v\n", findings)
+	}
+}
+
+// Tests static loops above the unroll limit are not unrolled.
+func TestMaxUnroll(t *testing.T) {
+	s := `package main
+func main() {
+	ch := make(chan int, 10)
+	for i := 0; i < 5; i++ {
+		ch <- i
+	}
+}`
+	unrolled := extract(t, s)
+	if strings.Count(unrolled.Env.MigoProg.String(), "send t0") != 5 {
+		t.Errorf("Expecting loop to be unrolled but got\n%s\n", unrolled.Env.MigoProg)
+	}
+	limited := extractWith(t, s, func(infer *TypeInfer) {
+		infer.Limits = Limits{MaxUnroll: 2}
+	})
+	if strings.Count(limited.Env.MigoProg.String(), "send t0") != 1 {
+		t.Errorf("Expecting loop not to be unrolled but got\n%s\n", limited.Env.MigoProg)
+	}
+	if len(unrolled.Env.Diagnostics) != 0 || len(limited.Env.Diagnostics) != 1 {
+		t.Errorf("Expecting 1 diagnostic of the limited loop but got %v and %v\n", unrolled.Env.Diagnostics, limited.Env.Diagnostics)
+	}
+	for limit, sends := range map[int64]int{4: 1, 5: 5} { // Loop of exactly the limit is unrolled.
+		boundary := extractWith(t, s, func(infer *TypeInfer) {
+			infer.Limits = Limits{MaxUnroll: limit}
+		})
+		if n := strings.Count(boundary.Env.MigoProg.String(), "send t0"); n != sends {
+			t.Errorf("Expecting %d sends with unroll limit %d but got %d\n%s\n", sends, limit, n, boundary.Env.MigoProg)
+		}
+	}
+}
+
+// Tests calls over the depth and instance limits are left out of a partial
+// model with diagnostics.
+func TestMaxInstances(t *testing.T) {
+	s := `package main
+func send(ch chan int, x int) { ch <- x }
+func pair(ch chan int) { send(ch, 2); send(ch, 3) }
+func main() {
+	ch := make(chan int, 3)
+	send(ch, 1)
+	pair(ch)
+}`
+	limited := extractWith(t, s, func(infer *TypeInfer) {
+		infer.Limits = Limits{MaxInstances: 2}
+	})
+	if n := strings.Count(limited.Env.MigoProg.String(), "call main.send("); n != 2 {
+		t.Errorf("Expecting 2 calls of main.send but got %d\n%s\n", n, limited.Env.MigoProg)
+	}
+	if len(limited.Env.Diagnostics) != 1 || !strings.Contains(limited.Env.Diagnostics[0].Msg, "instance limit 2") {
+		t.Errorf("Expecting instance limit diagnostic but got %v\n", limited.Env.Diagnostics)
+	}
+	shallow := extractWith(t, s, func(infer *TypeInfer) {
+		infer.Limits = Limits{MaxDepth: 1}
+	})
+	if n := strings.Count(shallow.Env.MigoProg.String(), "call main.send("); n != 1 {
+		t.Errorf("Expecting 1 call of main.send but got %d\n%s\n", n, shallow.Env.MigoProg)
+	}
+	if len(shallow.Env.Diagnostics) != 2 || !strings.Contains(shallow.Env.Diagnostics[0].Msg, "depth limit 1") {
+		t.Errorf("Expecting depth limit diagnostics but got %v\n", shallow.Env.Diagnostics)
+	}
+}
+
+// Tests goroutines analysed by concurrent jobs all count towards the instance
+// limit, which goroutines of the last wave analysed may exceed.
+func TestMaxInstancesJobs(t *testing.T) {
+	infer := extractWith(t, `package main
+func fib(n int, ch chan int) {
+	if n <= 1 {
+		ch <- n
+		return
+	}
+	ch1, ch2 := make(chan int), make(chan int)
+	go fib(n-1, ch1)
+	go fib(n-2, ch2)
+	ch <- <-ch1 + <-ch2
+}
+func main() {
+	ch := make(chan int)
+	go fib(10, ch)
+	<-ch
+}`, func(infer *TypeInfer) {
+		infer.Jobs = 2
+		infer.Limits = Limits{MaxInstances: 8}
+	})
+	if len(infer.GQueue) > 16 {
+		t.Errorf("Expecting at most 16 goroutines analysed but got %d\n", len(infer.GQueue))
+	}
+}
+
+// Tests an analysis over its time budget completes with a diagnostic.
+func TestTimeout(t *testing.T) {
+	infer := extractWith(t, `package main
+func worker(ch chan int) { ch <- 1 }
+func main() {
+	ch := make(chan int)
+	go worker(ch)
+	<-ch
+}`, func(infer *TypeInfer) {
+		infer.Limits = Limits{Timeout: time.Nanosecond}
+	})
+	if len(infer.Env.Diagnostics) != 1 || !strings.Contains(infer.Env.Diagnostics[0].Msg, "time limit") {
+		t.Errorf("Expecting time limit diagnostic but got %v\n", infer.Env.Diagnostics)
+	}
+	if errs := migofile.Check(infer.Env.MigoProg); len(errs) != 0 {
+		t.Errorf("Expecting well-formed partial model but got %v\n%s\n", errs, infer.Env.MigoProg)
+	}
+}
+
+// Tests channels returned by functions without body are modelled by stubs.
+func TestStubSet(t *testing.T) {
+	set, err := stubs.Parse(strings.NewReader(`
+def main.subscribe(ch):
+    spawn main.subscribe#publish(ch);
+def main.subscribe#publish(ch):
+    send ch;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infer := extractWith(t, `package main
+func subscribe() chan int
+func main() {
+	ch := subscribe()
+	<-ch
+}`, func(infer *TypeInfer) {
+		infer.StubSet = set
+	})
+	if _, ok := infer.Env.MigoProg.Function("main.subscribe#publish"); !ok {
+		t.Errorf("Expecting stub main.subscribe#publish in program but got\n%s\n", infer.Env.MigoProg)
+	}
+	if len(infer.Env.NilChanOps) != 0 {
+		t.Errorf("Expecting channel returned by stub not to be nil but got %v\n", infer.Env.NilChanOps)
+	}
+	findings := leakcheck.Check(infer.Env.MigoProg)
+	if len(findings) != 0 {
+		t.Errorf("Expecting receive to be matched by stub but got %v\n", findings)
+	}
+}
+
+// modelsProg uses look-alikes of sync.Once, sync.Cond and errgroup.Group.
+const modelsProg = `package main
+type Once struct{}
+func (o *Once) Do(f func())
+type Cond struct{}
+func NewCond() *Cond
+func (c *Cond) Wait()
+func (c *Cond) Signal()
+type Group struct{}
+func (g *Group) Go(f func() error)
+func (g *Group) Wait() error
+func main() {
+	ch := make(chan int)
+	var once Once
+	once.Do(func() { go func() { ch <- 1 }() })
+	<-ch
+	c := NewCond()
+	go func() { c.Signal() }()
+	c.Wait()
+	var g Group
+	g.Go(func() error { ch <- 2; return nil })
+	g.Go(func() error { return nil })
+	<-ch
+	g.Wait()
+}`
+
+// Tests calls modelled by stdlib models.
+func TestModels(t *testing.T) {
+	infer := extractWith(t, modelsProg, func(infer *TypeInfer) {
+		infer.Models = map[string]stdlib.Model{
+			"main.Once.Do":     {Kind: stdlib.Call, Arg: 1},
+			"main.Cond.Wait":   {Kind: stdlib.Wait},
+			"main.Cond.Signal": {Kind: stdlib.Notify},
+			"main.Group.Go":    {Kind: stdlib.Spawn, Arg: 1, Join: true},
+			"main.Group.Wait":  {Kind: stdlib.Join},
+		}
+	})
+	migo := infer.Env.MigoProg.String()
+	for _, s := range []string{
+		"call main.main$1(",
+		"spawn main.main$2(syncchan0);",
+		"recv syncchan0;",
+		"spawn main.Group.Go#main.main$3(t1, syncchan1);",
+		"spawn main.Group.Go#main.main$4(syncchan1);",
+	} {
+		if !strings.Contains(migo, s) {
+			t.Errorf("Expecting %q in MiGo but got\n%s\n", s, migo)
+		}
+	}
+	if n := strings.Count(migo, "recv syncchan1;"); n != 2 {
+		t.Errorf("Expecting Wait to join 2 goroutines but got %d\n%s\n", n, migo)
+	}
+	if findings := leakcheck.Check(infer.Env.MigoProg); len(findings) != 0 {
+		t.Errorf("Expecting no leaks but got %v\n", findings)
+	}
+}
+
+// Tests handlers of function and Handler values are spawned for each request
+// by a loop, with look-alikes of http.ServeMux and http.HandlerFunc.
+func TestRepeatModels(t *testing.T) {
+	infer := extractWith(t, `package main
+type Handler interface{ ServeHTTP(r int) }
+type HandlerFunc func(r int)
+func (f HandlerFunc) ServeHTTP(r int) { f(r) }
+type Mux struct{}
+func (m *Mux) HandleFunc(pattern string, f func(r int))
+func (m *Mux) Handle(pattern string, h Handler)
+type counter struct{ ch chan int }
+func (c *counter) ServeHTTP(r int) { c.ch <- r }
+func main() {
+	ch := make(chan int)
+	var mux Mux
+	mux.HandleFunc("/", func(r int) { ch <- r })
+	mux.Handle("/count", &counter{ch})
+	mux.Handle("/nop", HandlerFunc(func(r int) {}))
+	<-ch
+}`, func(infer *TypeInfer) {
+		infer.Models = map[string]stdlib.Model{
+			"main.Mux.HandleFunc": {Kind: stdlib.Spawn, Arg: 2, Repeat: true},
+			"main.Mux.Handle":     {Kind: stdlib.Spawn, Arg: 2, Method: "ServeHTTP", Repeat: true},
+		}
+	})
+	prog := infer.Env.MigoProg
+	if errs := migofile.Check(prog); len(errs) != 0 {
+		t.Errorf("Expecting well-formed MiGo but got %v\n%s\n", errs, prog)
+	}
+	for _, s := range []string{
+		"spawn main.Mux.HandleFunc#main.main$1(t1);",
+		"spawn main.main$1(p0);",
+		"call main.Mux.HandleFunc#main.main$1(p0);",
+		"spawn main.Mux.Handle#main.counter.ServeHTTP(t1);",
+		"spawn main.Mux.Handle#main.main$2();",
+	} {
+		if !strings.Contains(prog.String(), s) {
+			t.Errorf("Expecting %q in MiGo but got\n%s\n", s, prog)
+		}
+	}
+	// Only one of the handlers spawned for each request is received from.
+	if findings := leakcheck.Check(prog); len(findings) != 2 {
+		t.Errorf("Expecting leaks of both handlers sending to ch but got %v\n%s\n", findings, prog)
+	}
+}
+
+// Tests goroutines added to a sync.WaitGroup are joined by Wait once for each
+// goroutine along the same path, and in loops.
+func TestWaitGroup(t *testing.T) {
+	infer := extract(t, `package main
+import (
+	"os"
+	"sync"
+)
+func main() {
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); ch <- 1 }()
+	go func() { defer wg.Done(); <-ch }()
+	if len(os.Args) > 1 {
+		wg.Add(1)
+		go func() { defer wg.Done() }()
+	}
+	wg.Wait()
+	out := make(chan int)
+	for i := 0; i < len(os.Args); i++ {
+		wg.Add(1)
+		go func() { defer wg.Done(); out <- 2 }()
+	}
+	wg.Wait()
+}`)
+	prog := infer.Env.MigoProg
+	if errs := migofile.Check(prog); len(errs) != 0 {
+		t.Errorf("Expecting well-formed MiGo but got %v\n%s\n", errs, prog)
+	}
+	recvs := func(name string) int {
+		fn, ok := prog.Function(name)
+		if !ok {
+			t.Errorf("Expecting function %s in MiGo but got\n%s\n", name, prog)
+			return 0
+		}
+		return strings.Count(fn.String(), "recv syncchan0;")
+	}
+	if n := recvs("main.main#2_join_syncchan0_3"); n != 3 {
+		t.Errorf("Expecting Wait to join 3 goroutines added along the path but got %d\n%s\n", n, prog)
+	}
+	if n := recvs("main.main"); n != 2 {
+		t.Errorf("Expecting Wait to join 2 goroutines added along the path but got %d\n%s\n", n, prog)
+	}
+	if !strings.Contains(prog.String(), "call main.main#5(t1, syncchan0, t13); recv syncchan0;") {
+		t.Errorf("Expecting goroutines added by loop iterations to be joined after the loop but got\n%s\n", prog)
+	}
+	if findings := leakcheck.Check(prog); len(findings) != 1 || !strings.Contains(findings[0].String(), "main.main$4") {
+		t.Errorf("Expecting leak of goroutines sending to out but got %v\n%s\n", findings, prog)
+	}
+}
+
+// Tests goroutines of errgroup.Group and sync.WaitGroup in loops are joined,
+// with the example using golang.org/x/sync.
+func TestWaitGroupLoops(t *testing.T) {
+	prog := extractFiles(t, []string{filepath.Join("..", "examples", "waitgroup-loops", "main.go")})
+	if errs := migofile.Check(prog); len(errs) != 0 {
+		t.Errorf("Expecting well-formed MiGo but got %v\n%s\n", errs, prog)
+	}
+	for _, s := range []string{
+		"let syncchan0 = newchan syncchan0, 1;",
+		"spawn golang.org_x_sync_errgroup.Group.Go#main.main$1(t1, syncchan0); call main.main#1(t1, syncchan0); recv syncchan0;",
+		"send syncchan1;",
+	} {
+		if !strings.Contains(prog.String(), s) {
+			t.Errorf("Expecting %q in MiGo but got\n%s\n", s, prog)
+		}
+	}
+	if findings := leakcheck.Check(prog); len(findings) != 0 {
+		t.Errorf("Expecting no leaks but got %v\n%s\n", findings, prog)
+	}
+	// Done in loops of goroutines.
+	prog = extractFiles(t, []string{filepath.Join("..", "examples", "squaring-cancellation", "main.go")})
+	if s := "def main.merge$1#2(c, syncchan0, out, done):"; !strings.Contains(prog.String(), s) {
+		t.Errorf("Expecting %q in MiGo but got\n%s\n", s, prog)
+	}
+	if findings := leakcheck.Check(prog); len(findings) != 0 {
+		t.Errorf("Expecting no leaks but got %v\n%s\n", findings, prog)
+	}
+}
+
+// Tests MiGo output of the examples is parsed back to the same MiGo.
+func TestRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping examples in short mode")
+	}
+	dirs, err := filepath.Glob(filepath.Join("..", "examples", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Examples are skipped if their SSA cannot be built, e.g. by a Go
+	// toolchain not supported by golang.org/x/tools, or cannot be analysed,
+	// which must not pass as a successful test of (almost) no examples.
+	var mu sync.Mutex
+	var examples []*testing.T
+	t.Cleanup(func() {
+		skipped := 0
+		for _, t := range examples {
+			if t.Skipped() {
+				skipped++
+			}
+		}
+		if skipped > len(examples)/10 {
+			t.Errorf("Expecting most examples analysed but %d of %d skipped (unsupported Go toolchain? see README)\n", skipped, len(examples))
+		}
+	})
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			t.Parallel()
+			files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+			if err != nil || len(files) == 0 {
+				t.Skip("No .go files")
+			}
+			mu.Lock()
+			examples = append(examples, t)
+			mu.Unlock()
+			prog := extractFiles(t, files)
+			migofile.Simplify(prog)
+			s := prog.String()
+			parsed, err := migofile.Parse(strings.NewReader(s))
+			if err != nil {
+				t.Fatalf("Expecting MiGo output to parse but got %v\n%s\n", err, s)
+			}
+			if parsed.String() != s {
+				t.Errorf("Expecting parsed MiGo to be\n%s\nbut got\n%s\n", s, parsed.String())
+			}
+			if errs := migofile.Check(parsed); len(errs) != 0 {
+				t.Errorf("Expecting well-formed MiGo but got %v\n", errs)
+			}
+		})
+	}
+}
+
+// extractFiles runs MiGo type inference on the source files, the test is
+// skipped if SSA cannot be built for the files (e.g. unsupported Go version).
+func extractFiles(t *testing.T, files []string) *migo.Program {
+	conf, err := ssabuilder.NewConfig(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info *ssabuilder.SSAInfo
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Skipf("Cannot build SSA: %v", r)
+			}
+		}()
+		if info, err = conf.Build(); err != nil {
+			t.Skipf("Cannot build SSA: %v", err)
+		}
+	}()
+	infer, err := New(info, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Partial models are checked too.
+	infer.Limits = Limits{MaxInstances: 64, Timeout: 10 * time.Second}
+	go infer.Run()
+	select {
+	case err := <-infer.Error:
+		if errors.Is(err, ErrAnalysisFailed) {
+			t.Skipf("Cannot analyse: %v", err)
+		}
+		t.Fatal(err)
+	case <-infer.Done:
+	}
+	return infer.Env.MigoProg
+}
+
+// Tests a cancelled analysis stops and reports the cancellation.
+func TestRunContextCancelled(t *testing.T) {
+	conf, err := ssabuilder.NewConfigFromString(`package main
+func worker(ch chan int) { ch <- 1 }
+func main() {
+	ch := make(chan int)
+	go worker(ch)
+	<-ch
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	infer, err := New(info, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go infer.RunContext(ctx)
+	select {
+	case err := <-infer.Error:
+		if err != context.Canceled {
+			t.Errorf("Expecting %v but got %v\n", context.Canceled, err)
+		}
+	case <-infer.Done:
+		t.Errorf("Expecting cancelled analysis but it completed\n")
+	}
+	if len(infer.GQueue) != 0 {
+		t.Errorf("Expecting no goroutines analysed but got %d\n", len(infer.GQueue))
+	}
+}
+
+// Tests programs the analysis cannot handle, in goroutines analysed by
+// concurrent jobs, stop the analysis with an error.
+func TestRunFailed(t *testing.T) {
+	conf, err := ssabuilder.NewConfigFromString(`package main
+type T struct{ ch chan int }
+func worker() {
+	var ts []T
+	ts = append(ts, T{})
+	close(ts[0].ch)
+}
+func main() {
+	go worker()
+	go worker()
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	infer, err := New(info, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infer.Jobs = 2
+	go infer.Run()
+	select {
+	case err := <-infer.Error:
+		if !errors.Is(err, ErrAnalysisFailed) {
+			t.Errorf("Expecting %v but got %v\n", ErrAnalysisFailed, err)
+		}
+	case <-infer.Done:
+		t.Errorf("Expecting failed analysis but it completed\n")
+	}
+}
+
+// Tests calls knowing fewer channels than the callee are aligned to its
+// parameters, with the unknown channels external to the caller.
+func TestAlignCalls(t *testing.T) {
+	x, y := &unknownChan{name: "x"}, &unknownChan{name: "y"}
+	f := migo.NewFunction("main.f")
+	f.AddParams(&migo.Parameter{Caller: x, Callee: x}, &migo.Parameter{Caller: y, Callee: y})
+	call := &migo.CallStatement{Name: "main.f"}
+	call.AddParams(&migo.Parameter{Caller: &unknownChan{name: "t0"}, Callee: y})
+	main := migo.NewFunction("main.main")
+	main.AddStmts(&migo.IfStatement{Then: []migo.Statement{call}, Else: []migo.Statement{&migo.TauStatement{}}})
+	prog := &Program{MigoProg: migo.NewProgram()}
+	prog.MigoProg.AddFunction(main)
+	prog.MigoProg.AddFunction(f)
+	prog.alignCalls()
+	if s := call.String(); s != "call main.f(x_unknown, t0)" {
+		t.Errorf("Expecting call main.f(x_unknown, t0) but got %s\n", s)
+	}
+	if errs := migofile.Check(prog.MigoProg); len(errs) != 0 {
+		t.Errorf("Expecting valid MiGo but got %v\n", errs)
+	}
+}
@@ -0,0 +1,385 @@
+package migoextract
+
+// Calls modelled by infer.Models (see package stdlib).
+//
+// A receiver synchronised by models, e.g. a sync.Cond, is modelled by a
+// channel created where the receiver is allocated (or first used by a model
+// or passed to a function if not allocated in scope), and the channel is
+// passed along with the receiver.
+//
+// Goroutines joined by a receiver, e.g. a sync.WaitGroup, signal the channel
+// when done, and a join receives from the channel once for each goroutine
+// added along the path to the join (see joinSuffix).
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/dingo-hunter/stubs/stdlib"
+	"github.com/nickng/migo/v3"
+	"golang.org/x/tools/go/ssa"
+)
+
+var (
+	_ ssa.Value = (*syncValue)(nil)
+
+	// syncChanType is the type of channels modelling receivers.
+	syncChanType = types.NewChan(types.SendRecv, types.NewStruct(nil, nil))
+)
+
+// syncValue is a ssa.Value placeholder for the channel modelling a receiver.
+type syncValue struct {
+	name string
+	recv ssa.Value // Receiver, for the scope of the channel (see inScope).
+}
+
+func (v *syncValue) Name() string                  { return v.name }
+func (v *syncValue) String() string                { return v.name }
+func (v *syncValue) Type() types.Type              { return syncChanType }
+func (v *syncValue) Parent() *ssa.Function         { return nil }
+func (v *syncValue) Referrers() *[]ssa.Instruction { return nil }
+func (v *syncValue) Pos() token.Pos                { return token.NoPos }
+
+// Joins are the numbers of goroutines to be joined by the receivers modelled
+// by channels.
+type Joins map[*syncValue]int
+
+func (j Joins) copy() Joins {
+	c := make(Joins, len(j))
+	for ch, n := range j {
+		c[ch] = n
+	}
+	return c
+}
+
+// covers returns true if j has at least the goroutines of other to be joined.
+func (j Joins) covers(other Joins) bool {
+	for ch, n := range other {
+		if j[ch] < n {
+			return false
+		}
+	}
+	return true
+}
+
+// model returns the stub name and the model of calls to fn.
+func (infer *TypeInfer) model(fn *ssa.Function) (string, stdlib.Model, bool) {
+	if fn == nil {
+		return "", stdlib.Model{}, false
+	}
+	name := stubs.Name(fn.String())
+	m, ok := infer.Models[name]
+	return name, m, ok
+}
+
+// syncType returns the buffer size of the channel modelling a receiver of type
+// t. Returns false if t is not synchronised by models.
+func (infer *TypeInfer) syncType(t types.Type) (int64, bool) {
+	join, ok := stdlib.Receiver(infer.Models, t)
+	if join {
+		return 1, ok // Goroutines do not wait to be joined.
+	}
+	return 0, ok
+}
+
+// syncKey returns the instance of the receiver v, looking through loads of
+// variables holding the receiver.
+func (caller *Function) syncKey(v ssa.Value) (Instance, bool) {
+	for {
+		u, ok := v.(*ssa.UnOp)
+		if !ok || u.Op != token.MUL {
+			break
+		}
+		v = u.X
+	}
+	if inst, ok := caller.locals[v]; ok {
+		return inst, true
+	}
+	inst, ok := caller.Prog.globals[v]
+	return inst, ok
+}
+
+// syncChan returns the channel modelling the receiver inst of type t, which is
+// created if not in scope of caller. Returns false if t is not synchronised
+// by models.
+func (caller *Function) syncChan(inst Instance, t types.Type, infer *TypeInfer) (*syncValue, bool) {
+	return caller.newSyncChan(inst, nil, t, infer)
+}
+
+// allocSync creates the channel modelling the receiver allocated by alloc, if
+// its type is synchronised by models. Variables holding pointers to receivers
+// are not receivers.
+func (caller *Function) allocSync(alloc *ssa.Alloc, infer *TypeInfer) {
+	if _, ok := derefType(alloc.Type()).Underlying().(*types.Pointer); ok {
+		return
+	}
+	if inst, ok := caller.locals[alloc]; ok {
+		caller.newSyncChan(inst, alloc, alloc.Type(), infer)
+	}
+}
+
+// newSyncChan returns the channel modelling the receiver inst of type t, which
+// is created if not in scope of caller, in the scope of recv (nil if unknown).
+func (caller *Function) newSyncChan(inst Instance, recv ssa.Value, t types.Type, infer *TypeInfer) (*syncValue, bool) {
+	if ch, ok := caller.syncs[inst]; ok {
+		return ch, true
+	}
+	size, ok := infer.syncType(t)
+	if !ok || inst == nil {
+		return nil, false
+	}
+	ch := &syncValue{name: caller.Prog.chanName("syncchan", caller.Prog.syncChans), recv: recv}
+	caller.Prog.syncChans++
+	caller.FuncDef.AddStmts(&migo.NewChanStatement{Name: ch, Chan: ch.name, Size: size})
+	caller.extraargs = append(caller.extraargs, ch)
+	caller.syncs[inst] = ch
+	infer.Logger.Printf(caller.Sprintf(ChanSymbol+"%s = %s (%s)", ch, fmtChan("chan"), derefAllType(t)))
+	return ch, true
+}
+
+// syncArg returns the channel modelling the argument v passed to a function,
+// if v is a receiver synchronised by models.
+func (caller *Function) syncArg(v ssa.Value, infer *TypeInfer) (*syncValue, bool) {
+	if _, ok := infer.syncType(v.Type()); !ok {
+		return nil, false
+	}
+	inst, ok := caller.syncKey(v)
+	if !ok {
+		return nil, false
+	}
+	return caller.syncChan(inst, v.Type(), infer)
+}
+
+// callModel models the call of common to fn with infer.Models. Returns false
+// if fn is not modelled.
+func (caller *Function) callModel(common *ssa.CallCommon, fn *ssa.Function, infer *TypeInfer, b *Block, l *Loop) bool {
+	name, m, ok := infer.model(fn)
+	if !ok {
+		return false
+	}
+	infer.Logger.Printf(caller.Sprintf(SkipSymbol+"%s (%s model)", fn.String(), m.Kind))
+	var ch *syncValue
+	if m.Sync() {
+		if len(common.Args) == 0 {
+			return true
+		}
+		if ch, ok = caller.syncArg(common.Args[0], infer); !ok {
+			infer.Logger.Printf(caller.Sprintf("  model %s: unknown receiver %s, ignored", name, common.Args[0].Name()))
+			return true
+		}
+	}
+	var stmts []migo.Statement
+	switch m.Kind {
+	case stdlib.Call, stdlib.Spawn:
+		if m.Arg >= len(common.Args) {
+			return true
+		}
+		fcommon := modelCallCommon(common.Args[m.Arg], m.Method, infer.SSA.Prog)
+		if fcommon == nil {
+			infer.Logger.Printf(caller.Sprintf("  model %s: unknown function %s, ignored", name, common.Args[m.Arg].Name()))
+			return true
+		}
+		if m.Kind == stdlib.Call {
+			caller.call(fcommon, fcommon.StaticCallee(), nil, infer, b, l)
+			return true
+		}
+		spawnStmt, callee := caller.spawn(fcommon, infer)
+		if ch != nil {
+			caller.joins[ch]++
+			spawnStmt = caller.joinWrapper(name, spawnStmt, ch, callee.Fn.Pos())
+		}
+		if m.Repeat {
+			spawnStmt = caller.repeatWrapper(name, spawnStmt, callee.Fn.Pos())
+		}
+		stmts = append(stmts, spawnStmt)
+	case stdlib.Add:
+		n := caller.addCount(common, m, infer)
+		for ; n < 0; n++ { // Add(-1) is Done.
+			stmts = append(stmts, &migo.SendStatement{Chan: ch.Name()})
+		}
+		if n > 0 {
+			caller.joins[ch] += int(n)
+		}
+	case stdlib.Done:
+		stmts = append(stmts, &migo.SendStatement{Chan: ch.Name()})
+	case stdlib.Join:
+		for i := 0; i < caller.joins[ch]; i++ {
+			stmts = append(stmts, &migo.RecvStatement{Chan: ch.Name()})
+		}
+		delete(caller.joins, ch)
+	case stdlib.Wait:
+		stmts = append(stmts, &migo.RecvStatement{Chan: ch.Name()})
+	case stdlib.Notify:
+		stmts = append(stmts, &migo.SelectStatement{Cases: [][]migo.Statement{
+			{&migo.SendStatement{Chan: ch.Name()}},
+			{&migo.TauStatement{}},
+		}})
+	}
+	for _, stmt := range stmts {
+		caller.FuncDef.AddStmts(stmt)
+		caller.Prog.StmtPos[stmt] = common.Pos()
+	}
+	return true
+}
+
+// addCount returns the constant argument of a call of common modelled by the
+// Add model m, or 1 if the argument is not constant.
+func (caller *Function) addCount(common *ssa.CallCommon, m stdlib.Model, infer *TypeInfer) int64 {
+	if m.Arg < len(common.Args) {
+		arg := common.Args[m.Arg]
+		if c, ok := arg.(*ssa.Const); ok {
+			return c.Int64()
+		}
+		if c, ok := caller.locals[arg].(*Const); ok {
+			return c.Int64()
+		}
+		infer.Logger.Printf(caller.Sprintf("  model add: %s not constant, assuming 1", arg.Name()))
+	}
+	return 1
+}
+
+// joinWrapper returns a spawn of a function which runs spawnStmt then signals
+// completion to ch, the function is named after the model name.
+func (caller *Function) joinWrapper(name string, spawnStmt *migo.SpawnStatement, ch *syncValue, pos token.Pos) *migo.SpawnStatement {
+	wrapper := &migo.Function{Name: fmt.Sprintf("%s#%s", name, spawnStmt.Name)}
+	callStmt := &migo.CallStatement{Name: spawnStmt.Name, Params: []*migo.Parameter{}}
+	wrapSpawn := &migo.SpawnStatement{Name: wrapper.Name, Params: []*migo.Parameter{}}
+	for i, p := range spawnStmt.Params {
+		param := stubParam(fmt.Sprintf("p%d", i))
+		wrapper.AddParams(&migo.Parameter{Caller: p.Caller, Callee: param})
+		callStmt.AddParams(&migo.Parameter{Caller: param, Callee: p.Callee})
+		wrapSpawn.AddParams(&migo.Parameter{Caller: p.Caller, Callee: param})
+	}
+	wrapper.AddParams(&migo.Parameter{Caller: ch, Callee: stubParam("done")})
+	wrapSpawn.AddParams(&migo.Parameter{Caller: ch, Callee: stubParam("done")})
+	wrapper.AddStmts(callStmt, &migo.SendStatement{Chan: "done"})
+	if _, ok := caller.Prog.MigoProg.Function(wrapper.Name); !ok {
+		caller.Prog.addFunction(wrapper, pos)
+	}
+	return wrapSpawn
+}
+
+// repeatWrapper returns a spawn of a function which runs spawnStmt then calls
+// itself, i.e. spawns goroutines forever, the function is named after the
+// model name.
+func (caller *Function) repeatWrapper(name string, spawnStmt *migo.SpawnStatement, pos token.Pos) *migo.SpawnStatement {
+	wrapper := &migo.Function{Name: fmt.Sprintf("%s#%s", name, spawnStmt.Name)}
+	innerSpawn := &migo.SpawnStatement{Name: spawnStmt.Name, Params: []*migo.Parameter{}}
+	callStmt := &migo.CallStatement{Name: wrapper.Name, Params: []*migo.Parameter{}}
+	wrapSpawn := &migo.SpawnStatement{Name: wrapper.Name, Params: []*migo.Parameter{}}
+	for i, p := range spawnStmt.Params {
+		param := stubParam(fmt.Sprintf("p%d", i))
+		wrapper.AddParams(&migo.Parameter{Caller: p.Caller, Callee: param})
+		innerSpawn.AddParams(&migo.Parameter{Caller: param, Callee: p.Callee})
+		callStmt.AddParams(&migo.Parameter{Caller: param, Callee: param})
+		wrapSpawn.AddParams(&migo.Parameter{Caller: p.Caller, Callee: param})
+	}
+	wrapper.AddStmts(innerSpawn, callStmt)
+	if _, ok := caller.Prog.MigoProg.Function(wrapper.Name); !ok {
+		caller.Prog.addFunction(wrapper, pos)
+	}
+	return wrapSpawn
+}
+
+// modelCallCommon returns a call of the function value f with zero arguments,
+// or of method of f if method is given and f is an interface (see
+// ssabuilder.DynamicMethod). Returns nil if f is not a known function.
+func modelCallCommon(f ssa.Value, method string, prog *ssa.Program) *ssa.CallCommon {
+	common := &ssa.CallCommon{Value: f}
+	if method != "" {
+		fn, recv, ok := ssabuilder.DynamicMethod(prog, f, method)
+		if !ok {
+			return nil
+		}
+		common.Value = fn
+		if recv != nil {
+			common.Args = append(common.Args, recv)
+		}
+	}
+	if common.StaticCallee() == nil {
+		return nil
+	}
+	params := common.StaticCallee().Signature.Params()
+	for i := 0; i < params.Len(); i++ {
+		common.Args = append(common.Args, ssa.NewConst(nil, params.At(i).Type()))
+	}
+	return common
+}
+
+// modelsKey returns a string identifying the models in infer.Models.
+func (infer *TypeInfer) modelsKey() string {
+	var names []string
+	for name, m := range infer.Models {
+		names = append(names, fmt.Sprintf("%s=%s/%d/%s/%t/%t", name, m.Kind, m.Arg, m.Method, m.Join, m.Repeat))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// joinSuffix returns the suffix of the name of the MiGo function of block next
+// called from block curr, for the goroutines to be joined by receivers at the
+// call, so the function is specialised for the joins along the path.
+//
+// A loop (next dominates curr) calls the function of its block for the joins
+// at the entry of the block, unless the iteration joined goroutines added
+// before it. Goroutines added by the iteration are joined by the returned
+// receives after the call, i.e. when the rest of the loop returns.
+func (caller *Function) joinSuffix(curr, next *ssa.BasicBlock) (string, []migo.Statement) {
+	joins := caller.joins
+	var recvs []migo.Statement
+	if entry, ok := caller.entries[next]; ok && next.Dominates(curr) && joins.covers(entry) {
+		var names []string
+		for ch, n := range joins {
+			for i := entry[ch]; i < n; i++ {
+				names = append(names, ch.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			recvs = append(recvs, &migo.RecvStatement{Chan: name})
+		}
+		joins = entry
+	}
+	var names []string
+	for ch, n := range joins {
+		if n > 0 {
+			names = append(names, fmt.Sprintf("%s_%d", ch.Name(), n))
+		}
+	}
+	if len(names) == 0 {
+		return "", recvs
+	}
+	sort.Strings(names)
+	return "_join_" + strings.Join(names, "_"), recvs
+}
+
+// passJoins passes the goroutines to be joined by receivers of caller to the
+// receivers of callee.
+func (caller *Function) passJoins(callee *Function) {
+	for _, ch := range callee.syncs {
+		if n := caller.joins[ch]; n > 0 {
+			callee.joins[ch] = n
+		}
+	}
+}
+
+// returnJoins takes the goroutines to be joined by receivers of callee when it
+// returns to caller.
+func (caller *Function) returnJoins(callee *Function) {
+	joins := callee.retjoins
+	if joins == nil {
+		joins = callee.joins
+	}
+	for _, ch := range callee.syncs {
+		if n := joins[ch]; n > 0 {
+			caller.joins[ch] = n
+		} else {
+			delete(caller.joins, ch)
+		}
+	}
+}
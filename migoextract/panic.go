@@ -0,0 +1,122 @@
+package migoextract
+
+// Deal with panics and deferred calls.
+//
+// Panics are calls to panic, calls which may panic, and sends and closes on
+// channels which may be closed. Other runtime panics, e.g. nil dereferences,
+// are not modelled. A panic which is not recovered in its goroutine ends the
+// whole program, so panics are only modelled in functions which may recover
+// or are called by a function which may recover.
+
+import (
+	"go/token"
+
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/migo/v3"
+	"golang.org/x/tools/go/ssa"
+)
+
+// runDefers visits deferred calls in reverse order.
+func runDefers(infer *TypeInfer, ctx *Context) {
+	for i := len(ctx.F.defers) - 1; i >= 0; i-- {
+		d := ctx.F.defers[i]
+		infer.Logger.Printf(ctx.F.Sprintf(SkipSymbol+"deferred %s", d.Common().String()))
+		ctx.F.callCommon(d.Common(), nil, d.Pos(), infer, ctx.B, ctx.L)
+	}
+	ctx.F.panicked = false // Panics in deferred calls are not modelled.
+}
+
+// panicPath runs the deferred calls of a panicking function, the function
+// continues to panic unless a deferred call recovers.
+func panicPath(infer *TypeInfer, ctx *Context) {
+	runDefers(infer, ctx)
+	if ctx.F.recovers() {
+		infer.Logger.Printf(ctx.F.Sprintf(PanicSymbol + "recovered"))
+		return
+	}
+	if ctx.F.recovered {
+		ctx.F.panics = true
+	}
+}
+
+// mayRecover returns true if the function or a caller in the goroutine may
+// recover panics of the function.
+func (caller *Function) mayRecover() bool {
+	return caller.recovered || caller.recovers()
+}
+
+// recovers returns true if a deferred call of the function calls recover.
+func (caller *Function) recovers() bool {
+	for _, d := range caller.defers {
+		if fn := d.Common().StaticCallee(); fn != nil && ssabuilder.CallsRecover(fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// visitPanic visits a panic, the unreachable panic of a blocking select is
+// skipped.
+func visitPanic(instr *ssa.Panic, infer *TypeInfer, ctx *Context) {
+	if ssabuilder.IsSelectPanic(instr) {
+		visitSkip(instr, infer, ctx)
+		return
+	}
+	infer.Logger.Printf(ctx.F.Sprintf(PanicSymbol+"panic %s", instr.X.Name()))
+	panicPath(infer, ctx)
+}
+
+// closedPanic adds stmt, the send or close op at pos. If the channel may be
+// closed before the op, the op may panic, so the op and the rest of the
+// instructions are the normal path split from the panic path before the op
+// (see visitInstrs).
+func (caller *Function) closedPanic(op string, stmt migo.Statement, pos token.Pos, infer *TypeInfer) {
+	if !caller.Prog.closedOps[pos] {
+		caller.FuncDef.AddStmts(stmt)
+		return
+	}
+	infer.Logger.Printf(caller.Sprintf(PanicSymbol+"%s may panic (closed channel)", op))
+	caller.panicOp = stmt
+	caller.panicked = true
+}
+
+// visitPanicSplit visits instrs after a call which may panic. The rest of the
+// instructions are the normal path, and the deferred calls are the panic path.
+// A panic which cannot be recovered ends the program, so there is no panic
+// path to model.
+func visitPanicSplit(instrs []ssa.Instruction, infer *TypeInfer, ctx *Context) {
+	op := ctx.F.panicOp
+	ctx.F.panicOp = nil
+	if !ctx.F.mayRecover() {
+		infer.Logger.Printf(ctx.F.Sprintf(PanicSymbol + "panic path ends program"))
+		if op != nil {
+			ctx.F.FuncDef.AddStmts(op)
+		}
+		visitInstrs(instrs, infer, ctx)
+		return
+	}
+	parDef := ctx.F.FuncDef
+	parDef.PutAway() // Save parent.
+	if op != nil {
+		parDef.AddStmts(op)
+	}
+	visitInstrs(instrs, infer, ctx)
+	parDef.PutAway() // Save normal path.
+	infer.Logger.Printf(ctx.F.Sprintf(PanicSymbol + "panic path"))
+	panicPath(infer, ctx)
+	parDef.PutAway() // Save panic path.
+	panicStmts, err := parDef.Restore()
+	if err != nil {
+		infer.Logger.Panic("restore panic path:", err)
+	}
+	normalStmts, err := parDef.Restore()
+	if err != nil {
+		infer.Logger.Panic("restore normal path:", err)
+	}
+	parentStmts, err := parDef.Restore()
+	if err != nil {
+		infer.Logger.Panic("restore panic parent:", err)
+	}
+	parDef.AddStmts(parentStmts...)
+	parDef.AddStmts(&migo.IfStatement{Then: normalStmts, Else: panicStmts})
+}
@@ -3,6 +3,7 @@ package migoextract
 // Deal with Phi nodes.
 
 import (
+	"github.com/nickng/migo/v3"
 	"golang.org/x/tools/go/ssa"
 )
 
@@ -49,3 +50,43 @@ func phiSelectEdge(instr *ssa.Phi, infer *TypeInfer, ctx *Context) (edge int) {
 	infer.Logger.Fatalf("phi: %d->%d: %s", ctx.B.Pred, instr.Block().Index, ErrPhiUnknownEdge)
 	return
 }
+
+// phiEdge returns the edge of instr from predecessor block pred to block next,
+// or instr itself if instr is not a Phi of block next.
+func phiEdge(instr *ssa.Phi, pred, next *ssa.BasicBlock) ssa.Value {
+	if instr.Block() == next {
+		for i, p := range next.Preds {
+			if p == pred {
+				return instr.Edges[i]
+			}
+		}
+	}
+	return instr
+}
+
+// hasCallee returns true if v is the callee of one of params.
+func hasCallee(params []*migo.Parameter, v ssa.Value) bool {
+	for _, p := range params {
+		if p.Callee == v {
+			return true
+		}
+	}
+	return false
+}
+
+// inScope returns true if v can be used in block next, i.e. v is not defined
+// by an instruction of the function of next, or by an instruction of a block
+// strictly dominating next (or is a Phi of next). Values of other blocks reach next through Phis only.
+func inScope(v migo.NamedVar, next *ssa.BasicBlock) bool {
+	if ch, ok := v.(*syncValue); ok && ch.recv != nil {
+		v = ch.recv // Created with the receiver.
+	}
+	instr, ok := v.(ssa.Instruction)
+	if !ok || instr.Block() == nil || instr.Parent() != next.Parent() {
+		return true // Passed in, e.g. from the enclosing function.
+	}
+	if phi, ok := instr.(*ssa.Phi); ok && phi.Block() == next {
+		return true
+	}
+	return instr.Block() != next && instr.Block().Dominates(next)
+}
@@ -35,6 +35,8 @@ const (
 	SubSymbol       = "    ▸ "
 	ValSymbol       = "├ "
 	AssignSymbol    = "≔"
+	PanicSymbol     = "⚠ "
+	SummarySymbol   = " ≡ "
 )
 
 var (
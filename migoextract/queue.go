@@ -0,0 +1,260 @@
+package migoextract
+
+// Concurrent analysis of queued goroutines.
+//
+// Goroutines are analysed in waves: goroutines queued when a wave starts are
+// analysed concurrently, each with a fork of the program environment, and the
+// forks are merged back in queue order. Goroutines spawned during a wave are
+// analysed in the next wave, so the result does not depend on number of jobs.
+
+import (
+	"bytes"
+	"go/token"
+	"log"
+	"sync"
+
+	"github.com/nickng/migo/v3"
+	"golang.org/x/tools/go/ssa"
+)
+
+// RunQueue executes the analysis on spawned (queued) goroutines.
+func (infer *TypeInfer) RunQueue() {
+	for start := 0; start < len(infer.GQueue) && !infer.cancelled(); {
+		wave := infer.GQueue[start:]
+		infer.runWave(start, wave)
+		start += len(wave)
+	}
+}
+
+// runWave analyses the goroutines in wave with up to infer.Jobs workers.
+func (infer *TypeInfer) runWave(start int, wave []*Function) {
+	base := infer.Env.fork()
+	workers := make([]*TypeInfer, len(wave))
+	logs := make([]bytes.Buffer, len(wave))
+	// Programs are forked by the workers, infer.Env is not changed until
+	// all workers are done.
+	fork := func(i int) *TypeInfer {
+		w := new(TypeInfer)
+		*w = *infer
+		w.Env = infer.Env.fork()
+		w.Env.Infer = w
+		w.Env.gid = start + i + 1
+		w.GQueue = nil
+		if infer.jobs() > 1 { // Keep logs of goroutines in order.
+			w.Logger = log.New(&logs[i], infer.Logger.Prefix(), infer.Logger.Flags())
+		}
+		wave[i].Prog = w.Env
+		wave[i].Storage = wave[i].Storage.copy()
+		return w
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	var panicOnce sync.Once
+	var panicked interface{} // First panic of the workers.
+	for n := 0; n < infer.jobs() && n < len(wave); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if infer.cancelled() {
+					continue // Not analysed (see RunContext).
+				}
+				workers[i] = fork(i)
+				func() {
+					// Panics are raised again by the analysis goroutine,
+					// to be recovered by RunContext.
+					defer func() {
+						if r := recover(); r != nil {
+							panicOnce.Do(func() { panicked = r })
+						}
+					}()
+					workers[i].Logger.Printf("----- Goroutine %s -----", wave[i].Fn.String())
+					visitFunc(wave[i].Fn, workers[i], wave[i])
+				}()
+			}
+		}()
+	}
+	for i := range wave {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	if panicked != nil {
+		for i := range workers {
+			if logs[i].Len() > 0 {
+				infer.Logger.Writer().Write(logs[i].Bytes())
+			}
+		}
+		panic(panicked)
+	}
+
+	for i, w := range workers {
+		if w == nil {
+			continue
+		}
+		if logs[i].Len() > 0 {
+			infer.Logger.Writer().Write(logs[i].Bytes())
+		}
+		infer.Env.merge(w.Env, base)
+		infer.GQueue = append(infer.GQueue, w.GQueue...)
+	}
+}
+
+// jobs returns the number of goroutines to analyse concurrently.
+func (infer *TypeInfer) jobs() int {
+	if infer.Jobs < 1 {
+		return 1
+	}
+	return infer.Jobs
+}
+
+// fork returns a copy of the program environment which can be used
+// concurrently with prog, the changes are merged back to prog with merge.
+func (prog *Program) fork() *Program {
+	f := &Program{
+		FuncInstance: make(map[*ssa.Function]int, len(prog.FuncInstance)),
+		InitPkgs:     make(map[*ssa.Package]bool, len(prog.InitPkgs)),
+		Infer:        prog.Infer,
+		MigoProg:     &migo.Program{Funcs: append([]*migo.Function{}, prog.MigoProg.Funcs...)},
+		StmtPos:      make(map[migo.Statement]token.Pos),
+		FuncPos:      make(map[string]token.Pos, len(prog.FuncPos)),
+		nilChans:     prog.nilChans,  // Read-only.
+		closedOps:    prog.closedOps, // Read-only.
+		summaries:    make(map[string]*Function, len(prog.summaries)),
+		closures:     make(map[Instance]Captures, len(prog.closures)),
+		globals:      make(map[ssa.Value]Instance, len(prog.globals)),
+		Storage:      prog.Storage.copy(),
+	}
+	for fn, n := range prog.FuncInstance {
+		f.FuncInstance[fn] = n
+	}
+	for pkg, ok := range prog.InitPkgs {
+		f.InitPkgs[pkg] = ok
+	}
+	for name, pos := range prog.FuncPos {
+		f.FuncPos[name] = pos
+	}
+	for key, callee := range prog.summaries {
+		f.summaries[key] = callee
+	}
+	for inst, cap := range prog.closures {
+		f.closures[inst] = cap
+	}
+	for v, inst := range prog.globals {
+		f.globals[v] = inst
+	}
+	return f
+}
+
+// merge merges a fork of prog back to prog. base is a fork of prog taken at
+// the same time as fork, so only values changed in fork are merged.
+func (prog *Program) merge(fork, base *Program) {
+	// Instances of all goroutines of a wave count towards the instance limit,
+	// goroutines of a wave do not see instances of each other.
+	for fn, n := range fork.FuncInstance {
+		if m, ok := base.FuncInstance[fn]; ok {
+			n -= m
+		} else {
+			n++ // Instance IDs count from 0.
+		}
+		if m, ok := prog.FuncInstance[fn]; ok {
+			prog.FuncInstance[fn] = m + n
+		} else {
+			prog.FuncInstance[fn] = n - 1
+		}
+	}
+	for pkg, ok := range fork.InitPkgs {
+		prog.InitPkgs[pkg] = ok
+	}
+	for _, fn := range fork.MigoProg.Funcs[len(base.MigoProg.Funcs):] {
+		prog.MigoProg.AddFunction(fn)
+	}
+	for stmt, pos := range fork.StmtPos {
+		prog.StmtPos[stmt] = pos
+	}
+	for name, pos := range fork.FuncPos {
+		if _, ok := prog.FuncPos[name]; !ok {
+			prog.FuncPos[name] = pos
+		}
+	}
+	prog.NilChanOps = append(prog.NilChanOps, fork.NilChanOps...)
+	for _, d := range fork.Diagnostics {
+		prog.diagnose(d.Pos, "%s", d.Msg)
+	}
+	prog.SummaryHits += fork.SummaryHits
+	for key, callee := range fork.summaries {
+		if _, ok := prog.summaries[key]; !ok {
+			prog.summaries[key] = callee
+		}
+	}
+	for inst, cap := range fork.closures {
+		if _, ok := prog.closures[inst]; !ok {
+			prog.closures[inst] = cap
+		}
+	}
+	for v, inst := range fork.globals {
+		if base.globals[v] != inst {
+			prog.globals[v] = inst
+		}
+	}
+	prog.Storage.merge(fork.Storage, base.Storage)
+}
+
+// copy returns a deep copy of the storage.
+func (s *Storage) copy() *Storage {
+	c := NewStorage()
+	for inst, elems := range s.arrays {
+		c.arrays[inst] = make(Elems, len(elems))
+		for k, v := range elems {
+			c.arrays[inst][k] = v
+		}
+	}
+	for inst, m := range s.maps {
+		c.maps[inst] = make(map[Instance]Instance, len(m))
+		for k, v := range m {
+			c.maps[inst][k] = v
+		}
+	}
+	for inst, fields := range s.structs {
+		c.structs[inst] = append(Fields{}, fields...)
+	}
+	return c
+}
+
+// merge merges the values in fork which are changed from base to s.
+func (s *Storage) merge(fork, base *Storage) {
+	for inst, elems := range fork.arrays {
+		if _, ok := s.arrays[inst]; !ok {
+			s.arrays[inst] = elems
+			continue
+		}
+		for k, v := range elems {
+			if base.arrays[inst][k] != v {
+				s.arrays[inst][k] = v
+			}
+		}
+	}
+	for inst, m := range fork.maps {
+		if _, ok := s.maps[inst]; !ok {
+			s.maps[inst] = m
+			continue
+		}
+		for k, v := range m {
+			if base.maps[inst][k] != v {
+				s.maps[inst][k] = v
+			}
+		}
+	}
+	for inst, fields := range fork.structs {
+		if _, ok := s.structs[inst]; !ok {
+			s.structs[inst] = fields
+			continue
+		}
+		for i, field := range fields {
+			if i < len(s.structs[inst]) && (i >= len(base.structs[inst]) || base.structs[inst][i] != field) {
+				s.structs[inst][i] = field
+			}
+		}
+	}
+}
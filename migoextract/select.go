@@ -10,4 +10,18 @@ type Select struct {
 	Instr    *ssa.Select           // Select SSA instruction.
 	MigoStmt *migo.SelectStatement // Select statement in MiGo.
 	Index    Instance              // Index (extracted from Select instruction).
+	Disabled []bool                // Cases disabled by nil channel.
+}
+
+// removeDisabled removes the cases disabled by nil channel from the select
+// statement, a disabled case is never selected.
+func (s *Select) removeDisabled() {
+	var cases [][]migo.Statement
+	for i, c := range s.MigoStmt.Cases {
+		if i < len(s.Disabled) && s.Disabled[i] {
+			continue
+		}
+		cases = append(cases, c)
+	}
+	s.MigoStmt.Cases = cases
 }
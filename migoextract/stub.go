@@ -0,0 +1,231 @@
+package migoextract
+
+// Stubbed functions and extraction limits.
+//
+// Calls to stubbed functions are modelled by the stub instead of visiting the
+// body of the function, e.g. a logging package known to be communication-free
+// can be treated as no-op.
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/nickng/dingo-hunter/stubs"
+	"github.com/nickng/migo/v3"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Stub is a model of calls to a function.
+type Stub int
+
+const (
+	NoopStub     Stub = iota + 1 // Call returns without communication.
+	BlockingStub                 // Call blocks forever.
+)
+
+func (s Stub) String() string {
+	switch s {
+	case NoopStub:
+		return "noop"
+	case BlockingStub:
+		return "blocking"
+	}
+	return fmt.Sprintf("Stub(%d)", s)
+}
+
+// stub returns the stub of fn, which is looked up by name, e.g.
+// example.com/logging.Printf, or by package path, e.g. example.com/logging.*
+func (infer *TypeInfer) stub(fn *ssa.Function) (Stub, bool) {
+	if s, ok := infer.Stubs[fn.String()]; ok {
+		return s, true
+	}
+	if fn.Pkg != nil {
+		if s, ok := infer.Stubs[fn.Pkg.Pkg.Path()+".*"]; ok {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// stubCall models a call to fn with stub s instead of visiting fn.
+func (caller *Function) stubCall(common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.Value, s Stub, infer *TypeInfer) *Function {
+	callee := caller.prepareCallFn(common, fn, rcvr)
+	if s == BlockingStub {
+		ch := caller.stubChan()
+		recvStmt := &migo.RecvStatement{Chan: ch.name}
+		caller.FuncDef.AddStmts(recvStmt)
+		caller.Prog.StmtPos[recvStmt] = common.Pos()
+	}
+	return callee
+}
+
+// stubChan declares a new channel only used by a stub.
+func (caller *Function) stubChan() *nilChan {
+	ch := &nilChan{name: caller.Prog.chanName("stubchan", caller.Prog.stubChans)}
+	caller.Prog.stubChans++
+	caller.FuncDef.AddStmts(&migo.NewChanStatement{Name: ch, Chan: ch.name, Size: 0})
+	return ch
+}
+
+// stubParam is a parameter of a stub in infer.StubSet.
+type stubParam string
+
+func (p stubParam) Name() string   { return string(p) }
+func (p stubParam) String() string { return string(p) }
+
+// stubName returns the name of the stub in infer.StubSet modelling calls to
+// fn, which is a function or an interface method.
+func (infer *TypeInfer) stubName(fn interface{ String() string }) (string, bool) {
+	var name string
+	switch fn := fn.(type) {
+	case *ssa.Function:
+		if fn != nil {
+			name = stubs.Name(fn.String())
+		}
+	case *types.Func:
+		if fn != nil {
+			name = stubs.Name(fn.FullName())
+		}
+	}
+	_, ok := infer.StubSet.Params(name)
+	return name, ok
+}
+
+// stubResult returns true if v is (extracted from) the results of a call
+// modelled by a stub in infer.StubSet, which pointer analysis cannot see into.
+func (infer *TypeInfer) stubResult(v ssa.Value) bool {
+	if e, ok := v.(*ssa.Extract); ok {
+		v = e.Tuple
+	}
+	call, ok := v.(*ssa.Call)
+	if !ok {
+		return false
+	}
+	var fn interface{ String() string } = call.Call.StaticCallee()
+	if call.Call.IsInvoke() {
+		fn = call.Call.Method
+	}
+	_, ok = infer.stubName(fn)
+	return ok
+}
+
+// callStubSet models a call (or spawn if spawn is true) of common with the stub
+// name, retval is the SSA value storing the results (nil if not used). The
+// channels returned by the call are created by the caller and passed to the
+// stub after the channel arguments. Returns false if the stub does not match
+// the call.
+func (caller *Function) callStubSet(common *ssa.CallCommon, retval ssa.Value, name string, spawn bool, infer *TypeInfer) bool {
+	params, _ := infer.StubSet.Params(name)
+	var args []ssa.Value
+	for _, arg := range common.Args {
+		if _, ok := arg.Type().Underlying().(*types.Chan); ok {
+			args = append(args, arg)
+		}
+	}
+	results := common.Signature().Results()
+	nchans := len(args)
+	if !spawn {
+		for i := 0; i < results.Len(); i++ {
+			if _, ok := results.At(i).Type().Underlying().(*types.Chan); ok {
+				nchans++
+			}
+		}
+	}
+	if nchans != len(params) {
+		infer.Logger.Printf(caller.Sprintf("stub %s: expecting %d channels but got %d, ignored", name, len(params), nchans))
+		return false
+	}
+	var chans []migo.NamedVar
+	for _, arg := range args {
+		if inst, ok := caller.lookupChan(arg); !ok || isNilConst(inst) {
+			chans = append(chans, caller.stubChan()) // Nil or unknown channel, not used by others.
+		} else {
+			chans = append(chans, getChan(arg, infer))
+		}
+	}
+	if !spawn {
+		chans = append(chans, caller.stubResults(retval, results, infer)...)
+	}
+	for _, def := range infer.StubSet.Funcs(name) {
+		if _, ok := caller.Prog.MigoProg.Function(def.Name); !ok {
+			caller.Prog.addFunction(def, token.NoPos)
+		}
+	}
+	var stmt migo.Statement
+	if spawn {
+		spawnStmt := &migo.SpawnStatement{Name: name, Params: []*migo.Parameter{}}
+		for i, ch := range chans {
+			spawnStmt.AddParams(&migo.Parameter{Caller: ch, Callee: stubParam(params[i])})
+		}
+		stmt = spawnStmt
+	} else {
+		callStmt := &migo.CallStatement{Name: name, Params: []*migo.Parameter{}}
+		for i, ch := range chans {
+			callStmt.AddParams(&migo.Parameter{Caller: ch, Callee: stubParam(params[i])})
+		}
+		stmt = callStmt
+	}
+	caller.FuncDef.AddStmts(stmt)
+	caller.Prog.StmtPos[stmt] = common.Pos()
+	return true
+}
+
+// stubResults declares the channels in results of a stubbed call, which are
+// stored to retval, and returns the channels in order.
+func (caller *Function) stubResults(retval ssa.Value, results *types.Tuple, infer *TypeInfer) []migo.NamedVar {
+	var chans []migo.NamedVar
+	var tuple Tuples
+	if retval != nil && results.Len() > 1 {
+		caller.locals[retval] = &Value{retval, caller.InstanceID(), 0}
+		tuple = make(Tuples, results.Len())
+		caller.tuples[caller.locals[retval]] = tuple
+	}
+	for i := 0; i < results.Len(); i++ {
+		// The value holding the result in the caller, if any.
+		var v ssa.Value
+		if retval != nil && results.Len() == 1 {
+			v = retval
+		} else if retval != nil {
+			for _, instr := range *retval.Referrers() {
+				if e, ok := instr.(*ssa.Extract); ok && e.Index == i {
+					v = e
+					break
+				}
+			}
+		}
+		if _, ok := results.At(i).Type().Underlying().(*types.Chan); !ok {
+			if v == retval && v != nil {
+				caller.locals[retval] = &External{caller.Fn, retval.Type().Underlying(), caller.InstanceID()}
+			}
+			continue
+		}
+		if v == nil {
+			chans = append(chans, caller.stubChan())
+			continue
+		}
+		ch := &Value{v, caller.InstanceID(), 0}
+		if v == retval {
+			caller.locals[retval] = ch
+		} else {
+			tuple[i] = ch
+		}
+		caller.FuncDef.AddStmts(&migo.NewChanStatement{Name: v, Chan: ch.String(), Size: 0})
+		caller.extraargs = append(caller.extraargs, v)
+		infer.Logger.Printf(caller.Sprintf(ChanSymbol+"%s = %s (stub)", ch, fmtChan("chan")))
+		chans = append(chans, v)
+	}
+	return chans
+}
+
+// configKey returns a string identifying stubs, limits and skipped packages.
+func (infer *TypeInfer) configKey() string {
+	var names []string
+	for name, s := range infer.Stubs {
+		names = append(names, name+"="+s.String())
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%s|%s|%s|%d|%d|%d|%s", strings.Join(names, ","), infer.StubSet.Key(), infer.modelsKey(), infer.Limits.MaxUnroll, infer.Limits.MaxDepth, infer.Limits.MaxInstances, infer.SSA.SkipKey())
+}
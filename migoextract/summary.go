@@ -0,0 +1,115 @@
+package migoextract
+
+// Memoised function summaries.
+//
+// A call visits the callee body only once for each abstract shape of the
+// arguments, identical calls reuse the visited callee (and its MiGo function
+// definition) as the summary of the call.
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// summaryKey returns the memoisation key of a call to fn, which is the callee
+// and the abstract shape of its arguments. ok is false if the call cannot be
+// summarised.
+func (caller *Function) summaryKey(common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.Value) (key string, ok bool) {
+	if len(fn.FreeVars) > 0 || hasChanType(fn.Signature.Results(), make(map[types.Type]bool)) {
+		return "", false // Captures and returned channels are call-specific.
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fn.String())
+	args := make(map[Instance]int) // Arguments by instance, for aliases.
+	if rcvr != nil {
+		buf.WriteString("|" + caller.argShape(rcvr, args))
+	}
+	for _, arg := range common.Args {
+		buf.WriteString("|" + caller.argShape(arg, args))
+	}
+	if caller.mayRecover() {
+		buf.WriteString("|recover") // Panics are only modelled if recovered.
+	}
+	return buf.String(), true
+}
+
+// argShape returns the abstract shape of a call argument.
+//
+// Channels are abstracted to nil or their origin (created by make, merged by a
+// phi, or other) and the earlier argument they alias, if any. Constants are
+// kept as they may bound loops, and other references are identified by their
+// instance (and the goroutines to be joined if a receiver, see Joins).
+func (caller *Function) argShape(arg ssa.Value, args map[Instance]int) string {
+	if c, ok := arg.(*ssa.Const); ok {
+		return (&Const{c}).String()
+	}
+	inst, ok := caller.locals[arg]
+	if ch, isSync := caller.syncs[inst]; ok && isSync && caller.joins[ch] > 0 {
+		return fmt.Sprintf("%s+%d", inst, caller.joins[ch])
+	}
+	switch arg.Type().Underlying().(type) {
+	case *types.Chan:
+		if inst, ok := caller.lookupChan(arg); ok {
+			if isNilConst(inst) {
+				return "nil"
+			}
+			origin := "chan"
+			switch arg.(type) {
+			case *ssa.MakeChan:
+				origin = "make"
+			case *ssa.Phi:
+				origin = "phi"
+			}
+			if i, ok := args[inst]; ok {
+				return fmt.Sprintf("%s=%d", origin, i)
+			}
+			args[inst] = len(args)
+			return origin
+		}
+	case *types.Basic:
+		if c, ok := inst.(*Const); ok {
+			return c.String()
+		}
+		return "_"
+	}
+	if ok {
+		return inst.String()
+	}
+	return "?"
+}
+
+// hasChanType returns true if t is or may contain a channel.
+func hasChanType(t types.Type, seen map[types.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t := t.Underlying().(type) {
+	case *types.Chan:
+		return true
+	case *types.Pointer:
+		return hasChanType(t.Elem(), seen)
+	case *types.Array:
+		return hasChanType(t.Elem(), seen)
+	case *types.Slice:
+		return hasChanType(t.Elem(), seen)
+	case *types.Map:
+		return hasChanType(t.Key(), seen) || hasChanType(t.Elem(), seen)
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if hasChanType(t.Field(i).Type(), seen) {
+				return true
+			}
+		}
+	case *types.Tuple:
+		for i := 0; i < t.Len(); i++ {
+			if hasChanType(t.At(i).Type(), seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
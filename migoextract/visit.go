@@ -5,6 +5,8 @@ import (
 	"go/constant"
 	"go/token"
 	"go/types"
+	"sort"
+	"strings"
 
 	"github.com/nickng/migo/v3"
 	"golang.org/x/tools/go/ssa"
@@ -12,7 +14,10 @@ import (
 
 // visitFunc analyses function body.
 func visitFunc(fn *ssa.Function, infer *TypeInfer, f *Function) {
-	infer.Env.MigoProg.AddFunction(f.FuncDef)
+	if infer.cancelled() {
+		return
+	}
+	infer.Env.addFunction(f.FuncDef, fn.Pos())
 
 	infer.Logger.Printf(f.Sprintf(FuncEnterSymbol+"───── func %s ─────", fn.Name()))
 	defer infer.Logger.Printf(f.Sprintf(FuncExitSymbol+"───── func %s ─────", fn.Name()))
@@ -36,10 +41,16 @@ func visitFunc(fn *ssa.Function, infer *TypeInfer, f *Function) {
 	// When entering function, always visit as block 0
 	block0 := NewBlock(f, fn.Blocks[0], 0)
 	visitBasicBlock(fn.Blocks[0], infer, f, block0, &Loop{Parent: f})
+	for _, sel := range f.selects {
+		sel.removeDisabled()
+	}
 	f.hasBody = true
 }
 
 func visitBasicBlock(blk *ssa.BasicBlock, infer *TypeInfer, f *Function, bPrev *Block, l *Loop) {
+	if infer.cancelled() {
+		return
+	}
 	loopStateTransition(blk, infer, f, &l)
 	if l.Bound == Static && l.HasNext() {
 		infer.Logger.Printf(f.Sprintf(BlockSymbol+"%s %d (loop %s=%d)", fmtBlock("block"), blk.Index, l.CondVar.Name(), l.Index))
@@ -53,7 +64,7 @@ func visitBasicBlock(blk *ssa.BasicBlock, infer *TypeInfer, f *Function, bPrev *
 					if phi, ok := ea.(*ssa.Phi); ok {
 						if bPrev.Index < len(phi.Edges) {
 							for _, e := range phi.Edges {
-								if f.FuncDef.Params[i].Caller.Name() == e.Name() {
+								if f.FuncDef.Params[i].Caller.Name() == e.Name() && !hasCallee(f.FuncDef.Params, phi) {
 									f.FuncDef.Params[i].Callee = phi
 									// Remove from extra args
 									if k < len(f.extraargs) {
@@ -72,8 +83,19 @@ func visitBasicBlock(blk *ssa.BasicBlock, infer *TypeInfer, f *Function, bPrev *
 	}
 	infer.Logger.Printf(f.Sprintf(BlockSymbol+"%s %d; %s", fmtBlock("block"), blk.Index, fmtLoopHL(blk.Comment)))
 	f.Visited[blk] = 0
-	for _, instr := range blk.Instrs {
-		visitInstr(instr, infer, &Context{f, bPrev, l})
+	visitInstrs(blk.Instrs, infer, &Context{f, bPrev, l})
+}
+
+// visitInstrs visits instrs in order, a call which may panic splits the rest
+// of the instructions into normal and panic paths.
+func visitInstrs(instrs []ssa.Instruction, infer *TypeInfer, ctx *Context) {
+	for i, instr := range instrs {
+		visitInstr(instr, infer, ctx)
+		if ctx.F.panicked {
+			ctx.F.panicked = false
+			visitPanicSplit(instrs[i+1:], infer, ctx)
+			return
+		}
 	}
 }
 
@@ -125,6 +147,8 @@ func visitInstr(instr ssa.Instruction, infer *TypeInfer, ctx *Context) {
 		visitMapUpdate(instr, infer, ctx)
 	case *ssa.Next:
 		visitNext(instr, infer, ctx)
+	case *ssa.Panic:
+		visitPanic(instr, infer, ctx)
 	case *ssa.Phi:
 		visitPhi(instr, infer, ctx)
 	case *ssa.Return:
@@ -170,10 +194,10 @@ func visitAlloc(instr *ssa.Alloc, infer *TypeInfer, ctx *Context) {
 	case *types.Struct:
 		ctx.F.locals[instr] = &Value{instr, ctx.F.InstanceID(), ctx.L.Index}
 		if instr.Heap {
-			ctx.F.Prog.structs[ctx.F.locals[instr]] = make(Fields, t.NumFields())
+			ctx.F.Prog.structs[ctx.F.locals[instr]] = zeroFields(t)
 			infer.Logger.Print(ctx.F.Sprintf(NewSymbol+"%s = alloc (struct@heap) of type %s (%d fields)", ctx.F.locals[instr], instr.Type(), t.NumFields()))
 		} else {
-			ctx.F.structs[ctx.F.locals[instr]] = make(Fields, t.NumFields())
+			ctx.F.structs[ctx.F.locals[instr]] = zeroFields(t)
 			infer.Logger.Print(ctx.F.Sprintf(NewSymbol+"%s = alloc (struct@local) of type %s (%d fields)", ctx.F.locals[instr], instr.Type(), t.NumFields()))
 		}
 	case *types.Pointer:
@@ -201,10 +225,14 @@ func visitAlloc(instr *ssa.Alloc, infer *TypeInfer, ctx *Context) {
 			ctx.F.locals[instr] = &Value{instr, ctx.F.InstanceID(), ctx.L.Index}
 			infer.Logger.Print(ctx.F.Sprintf(NewSymbol+"%s = alloc/indirect of type %s", ctx.F.locals[instr], instr.Type().Underlying()))
 		}
+	case *types.Chan: // Channel variable (e.g. captured by a closure), nil until stored to.
+		ctx.F.locals[instr] = &Const{ssa.NewConst(nil, allocType)}
+		infer.Logger.Print(ctx.F.Sprintf(NewSymbol+"%s = alloc (nil chan) of type %s", instr.Name(), instr.Type().Underlying()))
 	default:
 		ctx.F.locals[instr] = &Value{instr, ctx.F.InstanceID(), ctx.L.Index}
 		infer.Logger.Print(ctx.F.Sprintf(NewSymbol+"%s = alloc of type %s", ctx.F.locals[instr], instr.Type().Underlying()))
 	}
+	ctx.F.allocSync(instr, infer)
 }
 
 func visitBinOp(instr *ssa.BinOp, infer *TypeInfer, ctx *Context) {
@@ -215,7 +243,7 @@ func visitBinOp(instr *ssa.BinOp, infer *TypeInfer, ctx *Context) {
 			case token.LSS: // i < N
 				if i, ok := instr.Y.(*ssa.Const); ok && i.Value.Kind() == constant.Int {
 					ctx.L.SetCond(instr, i.Int64()-1)
-					if _, ok := instr.X.(*ssa.Phi); ok && ctx.L.Start < ctx.L.End {
+					if _, ok := instr.X.(*ssa.Phi); ok && ctx.L.Start < ctx.L.End && infer.unrollable(ctx.L) {
 						ctx.L.Bound = Static
 						infer.Logger.Printf(ctx.F.Sprintf(LoopSymbol+"i <= %s", fmtLoopHL(ctx.L.End)))
 						return
@@ -226,7 +254,7 @@ func visitBinOp(instr *ssa.BinOp, infer *TypeInfer, ctx *Context) {
 			case token.LEQ: // i <= N
 				if i, ok := instr.Y.(*ssa.Const); ok && i.Value.Kind() == constant.Int {
 					ctx.L.SetCond(instr, i.Int64())
-					if _, ok := instr.X.(*ssa.Phi); ok && ctx.L.Start < ctx.L.End {
+					if _, ok := instr.X.(*ssa.Phi); ok && ctx.L.Start < ctx.L.End && infer.unrollable(ctx.L) {
 						ctx.L.Bound = Static
 						infer.Logger.Printf(ctx.F.Sprintf(LoopSymbol+"i <= %s", fmtLoopHL(ctx.L.End)))
 						return
@@ -237,7 +265,7 @@ func visitBinOp(instr *ssa.BinOp, infer *TypeInfer, ctx *Context) {
 			case token.GTR: // i > N
 				if i, ok := instr.Y.(*ssa.Const); ok && i.Value.Kind() == constant.Int {
 					ctx.L.SetCond(instr, i.Int64()+1)
-					if _, ok := instr.X.(*ssa.Phi); ok && ctx.L.Start > ctx.L.End {
+					if _, ok := instr.X.(*ssa.Phi); ok && ctx.L.Start > ctx.L.End && infer.unrollable(ctx.L) {
 						ctx.L.Bound = Static
 						infer.Logger.Printf(ctx.F.Sprintf(LoopSymbol+"i > %s", fmtLoopHL(ctx.L.End)))
 						return
@@ -248,7 +276,7 @@ func visitBinOp(instr *ssa.BinOp, infer *TypeInfer, ctx *Context) {
 			case token.GEQ: // i >= N
 				if i, ok := instr.Y.(*ssa.Const); ok && i.Value.Kind() == constant.Int {
 					ctx.L.SetCond(instr, i.Int64())
-					if _, ok := instr.X.(*ssa.Phi); ok && ctx.L.Start > ctx.L.End {
+					if _, ok := instr.X.(*ssa.Phi); ok && ctx.L.Start > ctx.L.End && infer.unrollable(ctx.L) {
 						ctx.L.Bound = Static
 						infer.Logger.Printf(ctx.F.Sprintf(LoopSymbol+"i >= %s", fmtLoopHL(ctx.L.End)))
 						return
@@ -271,8 +299,16 @@ func visitCall(instr *ssa.Call, infer *TypeInfer, ctx *Context) {
 func visitChangeType(instr *ssa.ChangeType, infer *TypeInfer, ctx *Context) {
 	inst, ok := ctx.F.locals[instr.X]
 	if !ok {
-		infer.Logger.Fatalf("changetype: %s: %v → %v", ErrUnknownValue, instr.X, instr)
-		return
+		switch x := instr.X.(type) {
+		case *ssa.Const:
+			inst = &Const{x}
+		case *ssa.Function: // e.g. http.HandlerFunc(f)
+			inst = &Value{x, ctx.F.InstanceID(), ctx.L.Index}
+		default:
+			infer.Logger.Panicf("changetype: %s: %v → %v", ErrUnknownValue, instr.X, instr)
+			return
+		}
+		ctx.F.locals[instr.X] = inst
 	}
 	ctx.F.locals[instr] = inst
 	if a, ok := ctx.F.arrays[ctx.F.locals[instr.X]]; ok {
@@ -295,7 +331,7 @@ func visitChangeType(instr *ssa.ChangeType, infer *TypeInfer, ctx *Context) {
 func visitChangeInterface(instr *ssa.ChangeInterface, infer *TypeInfer, ctx *Context) {
 	inst, ok := ctx.F.locals[instr.X]
 	if !ok {
-		infer.Logger.Fatalf("changeiface: %s: %v → %v", ErrUnknownValue, instr.X, instr)
+		infer.Logger.Panicf("changeiface: %s: %v → %v", ErrUnknownValue, instr.X, instr)
 	}
 	ctx.F.locals[instr] = inst
 }
@@ -308,13 +344,13 @@ func visitConvert(instr *ssa.Convert, infer *TypeInfer, ctx *Context) {
 		} else if _, ok := instr.X.(*ssa.Global); ok {
 			inst, ok := ctx.F.Prog.globals[instr.X]
 			if !ok {
-				infer.Logger.Fatalf("convert (global): %s: %+v", ErrUnknownValue, instr.X)
+				infer.Logger.Panicf("convert (global): %s: %+v", ErrUnknownValue, instr.X)
 			}
 			ctx.F.locals[instr.X] = inst
 			infer.Logger.Print(ctx.F.Sprintf(SkipSymbol+"%s convert= %s (global)", ctx.F.locals[instr], instr.X.Name()))
 			return
 		} else {
-			infer.Logger.Fatalf("convert: %s: %+v", ErrUnknownValue, instr.X)
+			infer.Logger.Panicf("convert: %s: %+v", ErrUnknownValue, instr.X)
 			return
 		}
 	}
@@ -332,7 +368,7 @@ func visitDeref(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 	if _, ok := ptr.(*ssa.Global); ok {
 		inst, ok := ctx.F.Prog.globals[ptr]
 		if !ok {
-			infer.Logger.Fatalf("deref (global): %s: %+v", ErrUnknownValue, ptr)
+			infer.Logger.Panicf("deref (global): %s: %+v", ErrUnknownValue, ptr)
 			return
 		}
 		ctx.F.locals[ptr], ctx.F.locals[val] = inst, inst
@@ -349,7 +385,7 @@ func visitDeref(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 	// Locactx.L.
 	inst, ok := ctx.F.locals[ptr]
 	if !ok {
-		infer.Logger.Fatalf("deref: %s: %+v", ErrUnknownValue, ptr)
+		infer.Logger.Panicf("deref: %s: %+v", ErrUnknownValue, ptr)
 		return
 	}
 	ctx.F.locals[ptr], ctx.F.locals[val] = inst, inst
@@ -362,7 +398,7 @@ func visitDeref(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 func visitExtract(instr *ssa.Extract, infer *TypeInfer, ctx *Context) {
 	if tupleInst, ok := ctx.F.locals[instr.Tuple]; ok {
 		if _, ok := ctx.F.tuples[tupleInst]; !ok { // Tuple uninitialised
-			infer.Logger.Fatalf("extract: %s: Unexpected tuple: %+v", ErrUnknownValue, instr)
+			infer.Logger.Panicf("extract: %s: Unexpected tuple: %+v", ErrUnknownValue, instr)
 			return
 		}
 		if inst := ctx.F.tuples[tupleInst][instr.Index]; inst == nil {
@@ -404,14 +440,14 @@ func visitField(instr *ssa.Field, infer *TypeInfer, ctx *Context) {
 	if sType, ok := struc.Type().Underlying().(*types.Struct); ok {
 		sInst, ok := ctx.F.locals[struc]
 		if !ok {
-			infer.Logger.Fatalf("field: %s :%+v", ErrUnknownValue, struc)
+			infer.Logger.Panicf("field: %s :%+v", ErrUnknownValue, struc)
 			return
 		}
 		fields, ok := ctx.F.structs[sInst]
 		if !ok {
 			fields, ok = ctx.F.Prog.structs[sInst]
 			if !ok {
-				infer.Logger.Fatalf("field: %s: struct uninitialised %+v", ErrUnknownValue, sInst)
+				infer.Logger.Panicf("field: %s: struct uninitialised %+v", ErrUnknownValue, sInst)
 				return
 			}
 		}
@@ -426,7 +462,7 @@ func visitField(instr *ssa.Field, infer *TypeInfer, ctx *Context) {
 		ctx.F.locals[field] = fields[index]
 		return
 	}
-	infer.Logger.Fatalf("field: %s: field is not struct: %+v", ErrInvalidVarRead, struc)
+	infer.Logger.Panicf("field: %s: field is not struct: %+v", ErrInvalidVarRead, struc)
 }
 
 func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
@@ -436,7 +472,7 @@ func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			sInst, ok = ctx.F.Prog.globals[struc]
 			if !ok {
-				infer.Logger.Fatalf("field-addr: %s: %+v", ErrUnknownValue, struc)
+				infer.Logger.Panicf("field-addr: %s: %+v", ErrUnknownValue, struc)
 				return
 			}
 		}
@@ -454,7 +490,7 @@ func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
 			}
 			return
 		default:
-			infer.Logger.Fatalf("field-addr: %s: not instance %+v", ErrUnknownValue, sInst)
+			infer.Logger.Panicf("field-addr: %s: not instance %+v", ErrUnknownValue, sInst)
 			return
 		}
 		// Find the struct.
@@ -462,7 +498,7 @@ func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			fields, ok = ctx.F.Prog.structs[sInst]
 			if !ok {
-				infer.Logger.Fatalf("field-addr: %s: struct uninitialised %+v", ErrUnknownValue, sInst)
+				infer.Logger.Panicf("field-addr: %s: struct uninitialised %+v", ErrUnknownValue, sInst)
 				return
 			}
 		}
@@ -477,7 +513,7 @@ func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
 		ctx.F.locals[field] = fields[index]
 		return
 	}
-	infer.Logger.Fatalf("field-addr: %s: field is not struct: %+v", ErrInvalidVarRead, struc)
+	infer.Logger.Panicf("field-addr: %s: field is not struct: %+v", ErrInvalidVarRead, struc)
 }
 
 func visitGo(instr *ssa.Go, infer *TypeInfer, ctx *Context) {
@@ -487,7 +523,7 @@ func visitGo(instr *ssa.Go, infer *TypeInfer, ctx *Context) {
 
 func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 	if len(instr.Block().Succs) != 2 {
-		infer.Logger.Fatal(ErrInvalidIfSucc)
+		infer.Logger.Panic(ErrInvalidIfSucc)
 	}
 	// Detect and unroll ctx.L.
 	if ctx.L.State != NonLoop && ctx.L.Bound == Static && instr.Cond == ctx.L.CondVar {
@@ -503,21 +539,25 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 	// Detect Select branches.
 	if bin, ok := instr.Cond.(*ssa.BinOp); ok && bin.Op == token.EQL {
 		for _, sel := range ctx.F.selects {
-			if bin.X == sel.Index.(*Value).Value {
+			if sel.Index != nil && ctx.F.locals[bin.X] == sel.Index {
 				if i, ok := bin.Y.(*ssa.Const); ok && i.Value.Kind() == constant.Int {
 					//infer.Logger.Print(fmt.Sprintf("[select-%d]", i.Int64()), ctx.F.FuncDef.String())
 					parDef := ctx.F.FuncDef
-					parDef.PutAway() // Save select
+					parDef.PutAway()     // Save select
+					vars := ctx.F.vars() // Variables stored to in a case are restored for the next.
+					joins := ctx.F.joins.copy()
 					visitBasicBlock(instr.Block().Succs[0], infer, ctx.F, NewBlock(ctx.F, instr.Block().Succs[0], ctx.B.Index), ctx.L)
+					ctx.F.setVars(vars)
+					ctx.F.joins = joins
 					ctx.F.FuncDef.PutAway() // Save case
 					selCase, err := ctx.F.FuncDef.Restore()
 					if err != nil {
-						infer.Logger.Fatal("select-case:", err)
+						infer.Logger.Panic("select-case:", err)
 					}
 					sel.MigoStmt.Cases[i.Int64()] = append(sel.MigoStmt.Cases[i.Int64()], selCase...)
 					selParent, err := parDef.Restore()
 					if err != nil {
-						infer.Logger.Fatal("select-parent:", err)
+						infer.Logger.Panic("select-parent:", err)
 					}
 					parDef.AddStmts(selParent...)
 
@@ -527,13 +567,13 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 						if instr.Block().Succs[1].Comment == "select.done" {
 							// Looks like it's empty
 							infer.Logger.Printf(SplitSymbol+"Empty default branch (%d ⇾ %d)", instr.Block().Index, instr.Block().Succs[1].Index)
-							selDefault := &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.Fn.String(), instr.Block().Succs[1].Index)}
+							selDefault := &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.defName(), instr.Block().Succs[1].Index)}
 							for i := 0; i < len(ctx.F.FuncDef.Params); i++ {
 								for k, ea := range ctx.F.extraargs {
 									if phi, ok := ea.(*ssa.Phi); ok {
 										if instr.Block().Succs[1].Index < len(phi.Edges) {
 											for _, e := range phi.Edges {
-												if ctx.F.FuncDef.Params[i].Caller.Name() == e.Name() {
+												if ctx.F.FuncDef.Params[i].Caller.Name() == e.Name() && !hasCallee(ctx.F.FuncDef.Params, phi) {
 													ctx.F.FuncDef.Params[i].Callee = phi
 													// Remove from extra args
 													if k < len(ctx.F.extraargs) {
@@ -549,14 +589,14 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 								// This loop copies args from current function to Successor.
 								if phi, ok := ctx.F.FuncDef.Params[i].Callee.(*ssa.Phi); ok {
 									// Resolve in current scope if phi
-									selDefault.AddParams(&migo.Parameter{Caller: phi.Edges[instr.Block().Succs[1].Index], Callee: ctx.F.FuncDef.Params[i].Callee})
+									selDefault.AddParams(&migo.Parameter{Caller: phiEdge(phi, instr.Block(), instr.Block().Succs[1]), Callee: ctx.F.FuncDef.Params[i].Callee})
 								} else {
 									selDefault.AddParams(&migo.Parameter{Caller: ctx.F.FuncDef.Params[i].Callee, Callee: ctx.F.FuncDef.Params[i].Callee})
 								}
 							}
 							for _, ea := range ctx.F.extraargs {
 								if phi, ok := ea.(*ssa.Phi); ok {
-									selDefault.AddParams(&migo.Parameter{Caller: phi.Edges[instr.Block().Succs[1].Index], Callee: phi})
+									selDefault.AddParams(&migo.Parameter{Caller: phiEdge(phi, instr.Block(), instr.Block().Succs[1]), Callee: phi})
 								} else {
 									selDefault.AddParams(&migo.Parameter{Caller: ea, Callee: ea})
 								}
@@ -566,7 +606,7 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 							sel.MigoStmt.Cases[len(sel.MigoStmt.Cases)-1] = append(sel.MigoStmt.Cases[len(sel.MigoStmt.Cases)-1], selDefault)
 							selParent, err := parDef.Restore()
 							if err != nil {
-								infer.Logger.Fatal("select-parent:", err)
+								infer.Logger.Panic("select-parent:", err)
 							}
 							parDef.AddStmts(selParent...)
 						} else {
@@ -576,12 +616,12 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 							ctx.F.FuncDef.PutAway() // Save case
 							selDefault, err := ctx.F.FuncDef.Restore()
 							if err != nil {
-								infer.Logger.Fatal("select-default:", err)
+								infer.Logger.Panic("select-default:", err)
 							}
 							sel.MigoStmt.Cases[len(sel.MigoStmt.Cases)-1] = append(sel.MigoStmt.Cases[len(sel.MigoStmt.Cases)-1], selDefault...)
 							selParent, err := parDef.Restore()
 							if err != nil {
-								infer.Logger.Fatal("select-parent:", err)
+								infer.Logger.Panic("select-parent:", err)
 							}
 							parDef.AddStmts(selParent...)
 						}
@@ -595,6 +635,17 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 		}
 	}
 
+	// Detect channel nil test with known outcome, e.g. disabled channel.
+	if isTrue, ok := ctx.F.chanNilCond(instr.Cond); ok {
+		succ := instr.Block().Succs[1]
+		if isTrue {
+			succ = instr.Block().Succs[0]
+		}
+		infer.Logger.Printf(ctx.F.Sprintf(IfSymbol+"if %s (%t) "+JumpSymbol+"%d", instr.Cond.Name(), isTrue, succ.Index))
+		visitIfSucc(instr, succ, infer, ctx)
+		return
+	}
+
 	var cond string
 	if inst, ok := ctx.F.locals[instr.Cond]; ok && isCommaOk(ctx.F, inst) {
 		cond = fmt.Sprintf("comma-ok %s", instr.Cond.Name())
@@ -604,40 +655,62 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 
 	// Save parent.
 	ctx.F.FuncDef.PutAway()
+	vars := ctx.F.vars() // Variables stored to in then are restored for else.
+	joins := ctx.F.joins.copy()
 	infer.Logger.Printf(ctx.F.Sprintf(IfSymbol+"if %s then"+JumpSymbol+"%d", cond, instr.Block().Succs[0].Index))
-	visitBasicBlock(instr.Block().Succs[0], infer, ctx.F, NewBlock(ctx.F, instr.Block().Succs[0], ctx.B.Index), ctx.L)
+	visitIfSucc(instr, instr.Block().Succs[0], infer, ctx)
 	// Save then.
 	ctx.F.FuncDef.PutAway()
+	ctx.F.setVars(vars)
+	ctx.F.joins = joins
 	infer.Logger.Printf(ctx.F.Sprintf(IfSymbol+"if %s else"+JumpSymbol+"%d", cond, instr.Block().Succs[1].Index))
 	if ctx.L.State == Body && ctx.L.LoopBlock == ctx.B.Index {
 		// Infinite loop.
 		infer.Logger.Printf(ctx.F.Sprintf(LoopSymbol + " infinite loop"))
-		stmt := &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.Fn.String(), ctx.B.Index)}
+		stmt := &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.defName(), ctx.B.Index)}
 		for _, p := range ctx.F.FuncDef.Params {
 			stmt.AddParams(&migo.Parameter{Caller: p.Callee, Callee: p.Callee})
 		}
 		ctx.F.FuncDef.AddStmts(stmt)
 	} else {
-		visitBasicBlock(instr.Block().Succs[1], infer, ctx.F, NewBlock(ctx.F, instr.Block().Succs[1], ctx.B.Index), ctx.L)
+		visitIfSucc(instr, instr.Block().Succs[1], infer, ctx)
 	}
 	// Save else.
 	ctx.F.FuncDef.PutAway()
 	elseStmts, err := ctx.F.FuncDef.Restore() // Else
 	if err != nil {
-		infer.Logger.Fatal("restore else:", err)
+		infer.Logger.Panic("restore else:", err)
 	}
 	thenStmts, err := ctx.F.FuncDef.Restore() // Then
 	if err != nil {
-		infer.Logger.Fatal("restore then:", err)
+		infer.Logger.Panic("restore then:", err)
 	}
 	parentStmts, err := ctx.F.FuncDef.Restore() // Parent
 	if err != nil {
-		infer.Logger.Fatal("restore if-then-else parent:", err)
+		infer.Logger.Panic("restore if-then-else parent:", err)
 	}
 	ctx.F.FuncDef.AddStmts(parentStmts...)
 	ctx.F.FuncDef.AddStmts(&migo.IfStatement{Then: thenStmts, Else: elseStmts})
 }
 
+// visitIfSucc visits succ, a successor block of If. If succ merges control flow
+// and is already visited, it is called as a MiGo function as in Jump.
+func visitIfSucc(instr *ssa.If, succ *ssa.BasicBlock, infer *TypeInfer, ctx *Context) {
+	if _, visited := ctx.F.Visited[succ]; visited && len(succ.Preds) > 1 {
+		stmt, suffix, recvs := blockCall(instr.Block(), succ, ctx)
+		if defined := ctx.F.blockDefs[blockFuncName(stmt, suffix, succ, ctx)]; defined || suffix != "" {
+			infer.Logger.Printf(ctx.F.Sprintf(SplitSymbol+"If (%d ⇾ %d) %s", instr.Block().Index, succ.Index, ctx.L.String()))
+			ctx.F.FuncDef.AddStmts(stmt)
+			ctx.F.FuncDef.AddStmts(recvs...)
+			if !defined {
+				visitBlockFunc(stmt, suffix, instr.Block(), succ, infer, ctx)
+			}
+			return
+		}
+	}
+	visitBasicBlock(succ, infer, ctx.F, NewBlock(ctx.F, succ, ctx.B.Index), ctx.L)
+}
+
 func visitIndex(instr *ssa.Index, infer *TypeInfer, ctx *Context) {
 	elem, array, index := instr, instr.X, instr.Index
 	// Array.
@@ -646,7 +719,7 @@ func visitIndex(instr *ssa.Index, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			aInst, ok = ctx.F.Prog.globals[array]
 			if !ok {
-				infer.Logger.Fatalf("index: %s: array %+v", ErrUnknownValue, array)
+				infer.Logger.Panicf("index: %s: array %+v", ErrUnknownValue, array)
 				return
 			}
 		}
@@ -654,7 +727,7 @@ func visitIndex(instr *ssa.Index, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			elems, ok = ctx.F.Prog.arrays[aInst]
 			if !ok {
-				infer.Logger.Fatalf("index: %s: not an array %+v", ErrUnknownValue, aInst)
+				infer.Logger.Panicf("index: %s: not an array %+v", ErrUnknownValue, aInst)
 				return
 			}
 		}
@@ -679,7 +752,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			aInst, ok = ctx.F.Prog.globals[array]
 			if !ok {
-				infer.Logger.Fatalf("index-addr: %s: array %+v", ErrUnknownValue, array)
+				infer.Logger.Panicf("index-addr: %s: array %+v", ErrUnknownValue, array)
 				return
 			}
 		}
@@ -696,7 +769,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 			}
 			return
 		default:
-			infer.Logger.Fatalf("index-addr: %s: array is not instance %+v", ErrUnknownValue, aInst)
+			infer.Logger.Panicf("index-addr: %s: array is not instance %+v", ErrUnknownValue, aInst)
 			return
 		}
 		// Find the array.
@@ -704,7 +777,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			elems, ok = ctx.F.Prog.arrays[aInst]
 			if !ok {
-				infer.Logger.Fatalf("index-addr: %s: array uninitialised %s", ErrUnknownValue, aInst)
+				infer.Logger.Panicf("index-addr: %s: array uninitialised %s", ErrUnknownValue, aInst)
 				return
 			}
 		}
@@ -725,7 +798,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			sInst, ok = ctx.F.Prog.globals[array]
 			if !ok {
-				infer.Logger.Fatalf("index-addr: %s: slice %+v", ErrUnknownValue, array)
+				infer.Logger.Panicf("index-addr: %s: slice %+v", ErrUnknownValue, array)
 				return
 			}
 		}
@@ -747,7 +820,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 			}
 			return
 		default:
-			infer.Logger.Fatalf("index-addr: %s: slice is not instance %+v", ErrUnknownValue, sInst)
+			infer.Logger.Panicf("index-addr: %s: slice is not instance %+v", ErrUnknownValue, sInst)
 			return
 		}
 		// Find the slice.
@@ -755,7 +828,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			elems, ok = ctx.F.Prog.arrays[sInst]
 			if !ok {
-				infer.Logger.Fatalf("index-addr: %s: slice uninitialised %+v", ErrUnknownValue, sInst)
+				infer.Logger.Panicf("index-addr: %s: slice uninitialised %+v", ErrUnknownValue, sInst)
 				return
 			}
 		}
@@ -770,12 +843,12 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		ctx.F.locals[elem] = elems[index]
 		return
 	}
-	infer.Logger.Fatalf("index-addr: %s: not array/slice %+v", ErrInvalidVarRead, array)
+	infer.Logger.Panicf("index-addr: %s: not array/slice %+v", ErrInvalidVarRead, array)
 }
 
 func visitJump(jump *ssa.Jump, infer *TypeInfer, ctx *Context) {
 	if len(jump.Block().Succs) != 1 {
-		infer.Logger.Fatal(ErrInvalidJumpSucc)
+		infer.Logger.Panic(ErrInvalidJumpSucc)
 	}
 	curr, next := jump.Block(), jump.Block().Succs[0]
 	infer.Logger.Printf(ctx.F.Sprintf(SkipSymbol+"block %d%s%d", curr.Index, fmtLoopHL(JumpSymbol), next.Index))
@@ -785,63 +858,170 @@ func visitJump(jump *ssa.Jump, infer *TypeInfer, ctx *Context) {
 	}
 	if len(next.Preds) > 1 {
 		infer.Logger.Printf(ctx.F.Sprintf(SplitSymbol+"Jump (%d ⇾ %d) %s", curr.Index, next.Index, ctx.L.String()))
-		var stmt *migo.CallStatement
-		//if ctx.L.Bound == Static && ctx.L.HasNext() {
-		//stmt = &migo.CallStatement{Name: fmt.Sprintf("%s#%d_loop%d", ctx.F.Fn.String(), next.Index, ctx.L.Index), Params: []*migo.Parameter{}}
-		//} else {
-		stmt = &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.Fn.String(), next.Index)}
-		for i := 0; i < len(ctx.F.FuncDef.Params); i++ {
-			for k, ea := range ctx.F.extraargs {
-				if phi, ok := ea.(*ssa.Phi); ok {
-					if jump.Block().Index < len(phi.Edges) {
-						for _, e := range phi.Edges {
-							if ctx.F.FuncDef.Params[i].Caller.Name() == e.Name() {
-								ctx.F.FuncDef.Params[i].Callee = phi
-								// Remove from extra args
-								if k < len(ctx.F.extraargs) {
-									ctx.F.extraargs = append(ctx.F.extraargs[:k], ctx.F.extraargs[k+1:]...)
-								} else {
-									ctx.F.extraargs = ctx.F.extraargs[:k]
-								}
+		stmt, suffix, recvs := blockCall(curr, next, ctx)
+		ctx.F.FuncDef.AddStmts(stmt)
+		ctx.F.FuncDef.AddStmts(recvs...)
+		if _, visited := ctx.F.Visited[next]; suffix != "" || !visited {
+			if !ctx.F.blockDefs[blockFuncName(stmt, suffix, next, ctx)] {
+				visitBlockFunc(stmt, suffix, curr, next, infer, ctx)
+			}
+			return
+		}
+	}
+	visitBasicBlock(next, infer, ctx.F, NewBlock(ctx.F, next, ctx.B.Index), ctx.L)
+}
+
+// blockCall returns a call to the MiGo function of block next from block curr.
+//
+// Channels which are nil at the call (e.g. set to nil to disable a select case)
+// are not passed as parameters, instead they are part of the function name,
+// so the function body is specialised for the nil channels, and so are the
+// goroutines to be joined (see joinSuffix). Returns the call, the suffix of
+// the function name and the statements following the call.
+func blockCall(curr, next *ssa.BasicBlock, ctx *Context) (*migo.CallStatement, string, []migo.Statement) {
+	var params []*migo.Parameter
+	for i := 0; i < len(ctx.F.FuncDef.Params); i++ {
+		for k, ea := range ctx.F.extraargs {
+			if phi, ok := ea.(*ssa.Phi); ok {
+				if curr.Index < len(phi.Edges) {
+					for _, e := range phi.Edges {
+						if ctx.F.FuncDef.Params[i].Caller.Name() == e.Name() && !hasCallee(ctx.F.FuncDef.Params, phi) {
+							ctx.F.FuncDef.Params[i].Callee = phi
+							// Remove from extra args
+							if k < len(ctx.F.extraargs) {
+								ctx.F.extraargs = append(ctx.F.extraargs[:k], ctx.F.extraargs[k+1:]...)
+							} else {
+								ctx.F.extraargs = ctx.F.extraargs[:k]
 							}
 						}
 					}
 				}
 			}
-			// This loop copies args from current function to Successor.
-			if phi, ok := ctx.F.FuncDef.Params[i].Callee.(*ssa.Phi); ok {
-				// Resolve in current scope if phi
-				stmt.AddParams(&migo.Parameter{Caller: phi.Edges[jump.Block().Index], Callee: ctx.F.FuncDef.Params[i].Callee})
-			} else {
-				stmt.AddParams(&migo.Parameter{Caller: ctx.F.FuncDef.Params[i].Callee, Callee: ctx.F.FuncDef.Params[i].Callee})
-			}
 		}
-		for _, ea := range ctx.F.extraargs {
-			if phi, ok := ea.(*ssa.Phi); ok {
-				stmt.AddParams(&migo.Parameter{Caller: phi.Edges[jump.Block().Index], Callee: phi})
-			} else {
-				stmt.AddParams(&migo.Parameter{Caller: ea, Callee: ea})
-			}
+		// This loop copies args from current function to Successor.
+		if phi, ok := ctx.F.FuncDef.Params[i].Callee.(*ssa.Phi); ok {
+			// Resolve in current scope if phi
+			params = append(params, &migo.Parameter{Caller: phiEdge(phi, curr, next), Callee: ctx.F.FuncDef.Params[i].Callee})
+		} else {
+			params = append(params, &migo.Parameter{Caller: ctx.F.FuncDef.Params[i].Callee, Callee: ctx.F.FuncDef.Params[i].Callee})
 		}
-		//}
-		ctx.F.FuncDef.AddStmts(stmt)
-		if _, visited := ctx.F.Visited[next]; !visited {
-			newBlock := NewBlock(ctx.F, next, ctx.B.Index)
-			oldFunc, newFunc := ctx.F.FuncDef, newBlock.MigoDef
-			if ctx.L.Bound == Static && ctx.L.HasNext() {
-				newFunc = migo.NewFunction(fmt.Sprintf("%s#%d_loop%d", ctx.F.Fn.String(), next.Index, ctx.L.Index))
+	}
+	for _, ea := range ctx.F.extraargs {
+		if phi, ok := ea.(*ssa.Phi); ok {
+			params = append(params, &migo.Parameter{Caller: phiEdge(phi, curr, next), Callee: phi})
+		} else {
+			params = append(params, &migo.Parameter{Caller: ea, Callee: ea})
+		}
+	}
+	// Channel Phi of next not yet visited.
+	for _, instr := range next.Instrs {
+		phi, ok := instr.(*ssa.Phi)
+		if !ok {
+			break
+		}
+		if _, ok := phi.Type().Underlying().(*types.Chan); ok && !hasCallee(params, phi) {
+			params = append(params, &migo.Parameter{Caller: phiEdge(phi, curr, next), Callee: phi})
+		}
+	}
+	var nils []string
+	stmt, seen := &migo.CallStatement{}, make(map[migo.NamedVar]bool)
+	for _, p := range params {
+		if seen[p.Callee] || !inScope(p.Callee, next) && !ctx.F.storedInScope(p.Callee, next) {
+			continue
+		}
+		seen[p.Callee] = true
+		if v, ok := p.Caller.(ssa.Value); ok {
+			if inst, ok := ctx.F.lookupChan(v); ok && isNilConst(inst) {
+				nils = append(nils, p.Callee.Name())
+				continue
 			}
-			for _, p := range stmt.Params {
-				newFunc.AddParams(&migo.Parameter{Caller: p.Callee, Callee: p.Callee})
+		}
+		stmt.AddParams(p)
+	}
+	// Channel variables (e.g. captured by closures) set to nil.
+	for v, inst := range ctx.F.locals {
+		if ptr, ok := v.Type().Underlying().(*types.Pointer); ok && isNilConst(inst) && inScope(v, next) {
+			if _, ok := ptr.Elem().Underlying().(*types.Chan); ok {
+				nils = append(nils, v.Name())
 			}
-			ctx.F.FuncDef = newFunc
-			infer.Env.MigoProg.AddFunction(newFunc)
-			visitBasicBlock(next, infer, ctx.F, newBlock, ctx.L)
-			ctx.F.FuncDef = oldFunc
-			return
 		}
 	}
-	visitBasicBlock(next, infer, ctx.F, NewBlock(ctx.F, next, ctx.B.Index), ctx.L)
+	var suffix string
+	if len(nils) > 0 {
+		sort.Strings(nils)
+		suffix = "_nil_" + strings.Join(nils, "_")
+	}
+	joins, recvs := ctx.F.joinSuffix(curr, next)
+	suffix += joins
+	stmt.Name = fmt.Sprintf("%s#%d%s", ctx.F.defName(), next.Index, suffix)
+	return stmt, suffix, recvs
+}
+
+// storedInScope returns true if the instance of v is stored in a variable in
+// scope of block next, e.g. a channel created in a branch and stored to a
+// variable captured by a closure, so v reaches next through the variable.
+func (caller *Function) storedInScope(v migo.NamedVar, next *ssa.BasicBlock) bool {
+	val, ok := v.(ssa.Value)
+	if !ok {
+		return false
+	}
+	inst, ok := caller.locals[val]
+	if !ok {
+		return false
+	}
+	for ptr, stored := range caller.locals {
+		if _, ok := ptr.Type().Underlying().(*types.Pointer); ok && stored == inst && ptr != val && inScope(ptr, next) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockFuncName returns the name of the MiGo function defined for block next
+// called by stmt, unrolled static loops define a function per iteration.
+func blockFuncName(stmt *migo.CallStatement, suffix string, next *ssa.BasicBlock, ctx *Context) string {
+	if ctx.L.Bound == Static && ctx.L.HasNext() {
+		return fmt.Sprintf("%s#%d_loop%d%s", ctx.F.defName(), next.Index, ctx.L.Index, suffix)
+	}
+	return stmt.Name
+}
+
+// visitBlockFunc defines and visits the MiGo function for block next called
+// from block curr by stmt.
+//
+// Block functions are defined once per name, i.e. per set of nil channels and
+// goroutines to be joined. Blocks are visited again for each of these, from
+// the state of the function visit at the call, which is restored after the
+// visit.
+func visitBlockFunc(stmt *migo.CallStatement, suffix string, curr, next *ssa.BasicBlock, infer *TypeInfer, ctx *Context) {
+	newBlock := NewBlock(ctx.F, next, curr.Index)
+	oldFunc, newFunc := ctx.F.FuncDef, newBlock.MigoDef
+	if name := blockFuncName(stmt, suffix, next, ctx); name != newFunc.Name {
+		newFunc = migo.NewFunction(name)
+	}
+	for _, p := range stmt.Params {
+		newFunc.AddParams(&migo.Parameter{Caller: p.Callee, Callee: p.Callee})
+	}
+	ctx.F.FuncDef = newFunc
+	ctx.F.blockDefs[newFunc.Name] = true
+	infer.Env.addFunction(newFunc, blockPos(next))
+	entry, visiting := ctx.F.entries[next]
+	ctx.F.entries[next] = ctx.F.joins.copy()
+	if suffix != "" {
+		fr := ctx.F.save(ctx.L)
+		ctx.F.Visited = make(map[*ssa.BasicBlock]int)
+		infer.Logger.Printf(ctx.F.Sprintf(SplitSymbol+"specialised for %s", suffix))
+		visitBasicBlock(next, infer, ctx.F, newBlock, ctx.L)
+		ctx.F.restore(fr, ctx.L)
+	} else {
+		visitBasicBlock(next, infer, ctx.F, newBlock, ctx.L)
+	}
+	if visiting {
+		ctx.F.entries[next] = entry
+	} else {
+		delete(ctx.F.entries, next)
+	}
+	ctx.F.FuncDef = oldFunc
 }
 
 func visitLookup(instr *ssa.Lookup, infer *TypeInfer, ctx *Context) {
@@ -851,7 +1031,7 @@ func visitLookup(instr *ssa.Lookup, infer *TypeInfer, ctx *Context) {
 			ctx.F.locals[instr.X] = &Const{c}
 			v = ctx.F.locals[instr.X]
 		} else {
-			infer.Logger.Fatalf("lookup: %s: %+v", ErrUnknownValue, instr.X)
+			infer.Logger.Panicf("lookup: %s: %+v", ErrUnknownValue, instr.X)
 			return
 		}
 	}
@@ -879,11 +1059,11 @@ func visitMakeChan(instr *ssa.MakeChan, infer *TypeInfer, ctx *Context) {
 	ctx.F.locals[instr] = newch
 	chType, ok := instr.Type().(*types.Chan)
 	if !ok {
-		infer.Logger.Fatal(ErrMakeChanNonChan)
+		infer.Logger.Panic(ErrMakeChanNonChan)
 	}
 	bufSz, ok := instr.Size.(*ssa.Const)
 	if !ok {
-		infer.Logger.Fatal(ErrNonConstChanBuf)
+		infer.Logger.Panic(ErrNonConstChanBuf)
 	}
 	infer.Logger.Printf(ctx.F.Sprintf(ChanSymbol+"%s = %s {t:%s, buf:%d} @ %s",
 		newch,
@@ -891,7 +1071,9 @@ func visitMakeChan(instr *ssa.MakeChan, infer *TypeInfer, ctx *Context) {
 		chType.Elem(),
 		bufSz.Int64(),
 		fmtPos(infer.SSA.FSet.Position(instr.Pos()).String())))
-	ctx.F.FuncDef.AddStmts(&migo.NewChanStatement{Name: instr, Chan: newch.String(), Size: bufSz.Int64()})
+	newchStmt := &migo.NewChanStatement{Name: instr, Chan: newch.String(), Size: bufSz.Int64()}
+	ctx.F.FuncDef.AddStmts(newchStmt)
+	ctx.F.Prog.StmtPos[newchStmt] = instr.Pos()
 	// Make sure it is not a duplicated extraargs
 	var found bool
 	for _, ea := range ctx.F.extraargs {
@@ -923,7 +1105,7 @@ func visitMakeInterface(instr *ssa.MakeInterface, infer *TypeInfer, ctx *Context
 		if c, ok := instr.X.(*ssa.Const); ok {
 			ctx.F.locals[instr.X] = &Const{c}
 		} else {
-			infer.Logger.Fatalf("make-iface: %s: %s", ErrUnknownValue, instr.X)
+			infer.Logger.Panicf("make-iface: %s: %s", ErrUnknownValue, instr.X)
 			return
 		}
 	}
@@ -946,7 +1128,7 @@ func visitMakeSlice(instr *ssa.MakeSlice, infer *TypeInfer, ctx *Context) {
 func visitMapUpdate(instr *ssa.MapUpdate, infer *TypeInfer, ctx *Context) {
 	inst, ok := ctx.F.locals[instr.Map]
 	if !ok {
-		infer.Logger.Fatalf("map-update: %s: %s", ErrUnknownValue, instr.Map)
+		infer.Logger.Panicf("map-update: %s: %s", ErrUnknownValue, instr.Map)
 		return
 	}
 	m, ok := ctx.F.maps[inst]
@@ -992,15 +1174,20 @@ func visitPhi(instr *ssa.Phi, infer *TypeInfer, ctx *Context) {
 				}
 			}
 		}
+		for _, ea := range ctx.F.extraargs {
+			if ea == instr {
+				return
+			}
+		}
 		ctx.F.extraargs = append(ctx.F.extraargs, instr)
 	}
 }
 
 func visitRecv(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 	ctx.F.locals[instr] = &Value{instr, ctx.F.InstanceID(), ctx.L.Index} // received value
-	ch, ok := ctx.F.locals[instr.X]
+	ch, ok := ctx.F.lookupChan(instr.X)
 	if !ok { // Channel does not exist
-		infer.Logger.Fatalf("recv: %s: %+v", ErrUnknownValue, instr.X)
+		infer.Logger.Panicf("recv: %s: %+v", ErrUnknownValue, instr.X)
 		return
 	}
 	// Receive test.
@@ -1009,23 +1196,30 @@ func visitRecv(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 		ctx.F.commaok[ctx.F.locals[instr]] = &CommaOk{Instr: instr, Result: ctx.F.locals[instr]}
 		ctx.F.tuples[ctx.F.locals[instr]] = make(Tuples, 2) // { recvVal, recvOk }
 	}
-	pos := infer.SSA.DecodePos(ch.(*Value).Pos())
-	infer.Logger.Print(ctx.F.Sprintf(RecvSymbol+"%s = %s @ %s", ctx.F.locals[instr], ch, fmtPos(pos)))
-	if paramName, ok := ctx.F.revlookup[ch.String()]; ok {
-		ctx.F.FuncDef.AddStmts(&migo.RecvStatement{Chan: paramName})
+	recvStmt := &migo.RecvStatement{}
+	if isNilConst(ch) {
+		recvStmt.Chan = ctx.F.nilChanOp("recv", instr.Pos(), infer)
 	} else {
-		if _, ok := instr.X.(*ssa.Phi); ok { // if it's a phi, selection is made in the parameter
-			ctx.F.FuncDef.AddStmts(&migo.RecvStatement{Chan: instr.X.Name()})
+		ctx.F.possiblyNil("recv", instr.X, ch, instr.Pos(), infer)
+		pos := infer.SSA.DecodePos(ch.(*Value).Pos())
+		infer.Logger.Print(ctx.F.Sprintf(RecvSymbol+"%s = %s @ %s", ctx.F.locals[instr], ch, fmtPos(pos)))
+		if paramName, ok := ctx.F.revlookup[ch.String()]; ok {
+			recvStmt.Chan = paramName
+		} else if _, ok := instr.X.(*ssa.Phi); ok { // if it's a phi, selection is made in the parameter
+			recvStmt.Chan = instr.X.Name()
 		} else {
-			ctx.F.FuncDef.AddStmts(&migo.RecvStatement{Chan: ch.(*Value).Name()})
+			recvStmt.Chan = ch.(*Value).Name()
 		}
 	}
+	ctx.F.FuncDef.AddStmts(recvStmt)
+	ctx.F.Prog.StmtPos[recvStmt] = instr.Pos()
 
 	// Initialise received value if needed.
 	initNestedRefVar(infer, ctx, ctx.F.locals[instr], false)
 }
 
 func visitReturn(ret *ssa.Return, infer *TypeInfer, ctx *Context) {
+	ctx.F.retjoins = ctx.F.joins.copy()
 	switch len(ret.Results) {
 	case 0:
 		infer.Logger.Printf(ctx.F.Sprintf(ReturnSymbol))
@@ -1049,22 +1243,7 @@ func visitReturn(ret *ssa.Return, infer *TypeInfer, ctx *Context) {
 }
 
 func visitRunDefers(instr *ssa.RunDefers, infer *TypeInfer, ctx *Context) {
-	for i := len(ctx.F.defers) - 1; i >= 0; i-- {
-		common := ctx.F.defers[i].Common()
-		if common.StaticCallee() != nil {
-			callee := ctx.F.prepareCallFn(common, common.StaticCallee(), nil)
-			visitFunc(callee.Fn, infer, callee)
-			if callee.HasBody() {
-				callStmt := &migo.CallStatement{Name: callee.Fn.String(), Params: []*migo.Parameter{}}
-				for _, c := range common.Args {
-					if _, ok := c.Type().(*types.Chan); ok {
-						infer.Logger.Fatalf("channel in defer: %s", ErrUnimplemented)
-					}
-				}
-				callee.FuncDef.AddStmts(callStmt)
-			}
-		}
-	}
+	runDefers(infer, ctx)
 }
 
 func visitSelect(instr *ssa.Select, infer *TypeInfer, ctx *Context) {
@@ -1074,16 +1253,43 @@ func visitSelect(instr *ssa.Select, infer *TypeInfer, ctx *Context) {
 		MigoStmt: &migo.SelectStatement{Cases: [][]migo.Statement{}},
 	}
 	selStmt := ctx.F.selects[ctx.F.locals[instr]].MigoStmt
-	for _, sel := range instr.States {
-		ch, ok := ctx.F.locals[sel.Chan]
+	// Cases on nil channel are disabled, unless all cases of a blocking select
+	// are disabled, where the select blocks forever.
+	disabled, numDisabled := make([]bool, len(instr.States)), 0
+	for i, sel := range instr.States {
+		if ch, ok := ctx.F.lookupChan(sel.Chan); ok && isNilConst(ch) {
+			disabled[i] = true
+			numDisabled++
+		}
+	}
+	if instr.Blocking && numDisabled == len(instr.States) {
+		disabled = make([]bool, len(instr.States))
+	}
+	ctx.F.selects[ctx.F.locals[instr]].Disabled = disabled
+	for i, sel := range instr.States {
+		if disabled[i] {
+			infer.Logger.Print(ctx.F.Sprintf(SelectSymbol+"case %d disabled (nil channel %s)", i, sel.Chan.Name()))
+			selStmt.Cases = append(selStmt.Cases, []migo.Statement{})
+			continue
+		}
+		ch, ok := ctx.F.lookupChan(sel.Chan)
 		if !ok {
 			infer.Logger.Print("Select found an unknown channel", sel.Chan.String())
 		}
 		var stmt migo.Statement
 		//c := getChan(ch.Var(), infer)
+		if ok {
+			op := "recv"
+			if sel.Dir == types.SendOnly {
+				op = "send"
+			}
+			ctx.F.possiblyNil(op, sel.Chan, ch, sel.Pos, infer)
+		}
 		switch sel.Dir {
 		case types.SendOnly:
-			if paramName, ok := ctx.F.revlookup[ch.String()]; ok {
+			if isNilConst(ch) { // Case never selected.
+				stmt = &migo.SendStatement{Chan: ctx.F.nilChanOp("send", sel.Pos, infer)}
+			} else if paramName, ok := ctx.F.revlookup[ch.String()]; ok {
 				stmt = &migo.SendStatement{Chan: paramName}
 			} else {
 				if _, ok := sel.Chan.(*ssa.Phi); ok { // if it's a phi, selection is made in the parameter
@@ -1093,7 +1299,9 @@ func visitSelect(instr *ssa.Select, infer *TypeInfer, ctx *Context) {
 				}
 			}
 		case types.RecvOnly:
-			if paramName, ok := ctx.F.revlookup[ch.String()]; ok {
+			if isNilConst(ch) { // Case never selected.
+				stmt = &migo.RecvStatement{Chan: ctx.F.nilChanOp("recv", sel.Pos, infer)}
+			} else if paramName, ok := ctx.F.revlookup[ch.String()]; ok {
 				stmt = &migo.RecvStatement{Chan: paramName}
 			} else {
 				if _, ok := ch.(*Value); ok {
@@ -1109,6 +1317,7 @@ func visitSelect(instr *ssa.Select, infer *TypeInfer, ctx *Context) {
 				}
 			}
 		}
+		ctx.F.Prog.StmtPos[stmt] = sel.Pos
 		selStmt.Cases = append(selStmt.Cases, []migo.Statement{stmt})
 	}
 	// Default case exists.
@@ -1117,25 +1326,32 @@ func visitSelect(instr *ssa.Select, infer *TypeInfer, ctx *Context) {
 	}
 	ctx.F.tuples[ctx.F.locals[instr]] = make(Tuples, 2+len(selStmt.Cases)) // index + recvok + cases
 	ctx.F.FuncDef.AddStmts(selStmt)
+	ctx.F.Prog.StmtPos[selStmt] = instr.Pos()
 	infer.Logger.Print(ctx.F.Sprintf(SelectSymbol+" %d cases %s = %s", 2+len(selStmt.Cases), instr.Name(), instr.String()))
 }
 
 func visitSend(instr *ssa.Send, infer *TypeInfer, ctx *Context) {
-	ch, ok := ctx.F.locals[instr.Chan]
+	ch, ok := ctx.F.lookupChan(instr.Chan)
 	if !ok {
-		infer.Logger.Fatalf("send: %s: %+v", ErrUnknownValue, instr.Chan)
+		infer.Logger.Panicf("send: %s: %+v", ErrUnknownValue, instr.Chan)
 	}
-	pos := infer.SSA.DecodePos(ch.(*Value).Pos())
-	infer.Logger.Printf(ctx.F.Sprintf(SendSymbol+"%s @ %s", ch, fmtPos(pos)))
-	if paramName, ok := ctx.F.revlookup[ch.String()]; ok {
-		ctx.F.FuncDef.AddStmts(&migo.SendStatement{Chan: paramName})
+	sendStmt := &migo.SendStatement{}
+	if isNilConst(ch) {
+		sendStmt.Chan = ctx.F.nilChanOp("send", instr.Pos(), infer)
 	} else {
-		if _, ok := instr.Chan.(*ssa.Phi); ok {
-			ctx.F.FuncDef.AddStmts(&migo.SendStatement{Chan: instr.Chan.Name()})
+		ctx.F.possiblyNil("send", instr.Chan, ch, instr.Pos(), infer)
+		pos := infer.SSA.DecodePos(ch.(*Value).Pos())
+		infer.Logger.Printf(ctx.F.Sprintf(SendSymbol+"%s @ %s", ch, fmtPos(pos)))
+		if paramName, ok := ctx.F.revlookup[ch.String()]; ok {
+			sendStmt.Chan = paramName
+		} else if _, ok := instr.Chan.(*ssa.Phi); ok {
+			sendStmt.Chan = instr.Chan.Name()
 		} else {
-			ctx.F.FuncDef.AddStmts(&migo.SendStatement{Chan: ch.(*Value).Name()})
+			sendStmt.Chan = ch.(*Value).Name()
 		}
 	}
+	ctx.F.Prog.StmtPos[sendStmt] = instr.Pos()
+	ctx.F.closedPanic("send", sendStmt, instr.Pos(), infer)
 }
 
 func visitSkip(instr ssa.Instruction, infer *TypeInfer, ctx *Context) {
@@ -1149,7 +1365,7 @@ func visitSkip(instr ssa.Instruction, infer *TypeInfer, ctx *Context) {
 func visitSlice(instr *ssa.Slice, infer *TypeInfer, ctx *Context) {
 	ctx.F.locals[instr] = &Value{instr, ctx.F.InstanceID(), ctx.L.Index}
 	if _, ok := ctx.F.locals[instr.X]; !ok {
-		infer.Logger.Fatalf("slice: %s: %+v", ErrUnknownValue, instr.X)
+		infer.Logger.Panicf("slice: %s: %+v", ErrUnknownValue, instr.X)
 		return
 	}
 	if basic, ok := instr.Type().Underlying().(*types.Basic); ok && basic.Kind() == types.String {
@@ -1168,7 +1384,7 @@ func visitSlice(instr *ssa.Slice, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			switch ctx.F.locals[instr.X].(type) {
 			case *Value: // Continue
-				infer.Logger.Fatalf("slice: %s: non-slice %+v", ErrUnknownValue, instr.X)
+				infer.Logger.Panicf("slice: %s: non-slice %+v", ErrUnknownValue, instr.X)
 				return
 			case *Const:
 				ctx.F.arrays[ctx.F.locals[instr.X]] = make(Elems)
@@ -1191,7 +1407,7 @@ func visitStore(instr *ssa.Store, infer *TypeInfer, ctx *Context) {
 	if _, ok := dstPtr.(*ssa.Global); ok {
 		dstInst, ok := ctx.F.Prog.globals[dstPtr]
 		if !ok {
-			infer.Logger.Fatalf("store (global): %s: %+v", ErrUnknownValue, dstPtr)
+			infer.Logger.Panicf("store (global): %s: %+v", ErrUnknownValue, dstPtr)
 		}
 		inst, ok := ctx.F.locals[source]
 		if !ok {
@@ -1200,7 +1416,7 @@ func visitStore(instr *ssa.Store, infer *TypeInfer, ctx *Context) {
 				if c, ok := source.(*ssa.Const); ok {
 					inst = &Const{c}
 				} else {
-					infer.Logger.Fatalf("store (global): %s: %+v", ErrUnknownValue, source)
+					infer.Logger.Panicf("store (global): %s: %+v", ErrUnknownValue, source)
 				}
 			}
 		}
@@ -1227,7 +1443,7 @@ func visitStore(instr *ssa.Store, infer *TypeInfer, ctx *Context) {
 	// Locactx.L.
 	dstInst, ok := ctx.F.locals[dstPtr]
 	if !ok {
-		infer.Logger.Fatalf("store: addr %s: %+v", ErrUnknownValue, dstPtr)
+		infer.Logger.Panicf("store: addr %s: %+v", ErrUnknownValue, dstPtr)
 	}
 	inst, ok := ctx.F.locals[source]
 	if !ok {
@@ -1247,6 +1463,11 @@ func visitStore(instr *ssa.Store, infer *TypeInfer, ctx *Context) {
 		ctx.F.updateInstances(dstInst, inst)
 	case *types.Map:
 		ctx.F.updateInstances(dstInst, inst)
+	case *types.Chan:
+		// Zero-valued (nil) channel fields are distinct constants.
+		if isNilConst(dstInst) {
+			ctx.F.updateInstances(dstInst, inst)
+		}
 	default:
 		// Nothing to update.
 	}
@@ -1259,7 +1480,7 @@ func visitTypeAssert(instr *ssa.TypeAssert, infer *TypeInfer, ctx *Context) {
 		if meth, _ := types.MissingMethod(instr.X.Type(), iface, true); meth == nil { // No missing methods
 			inst, ok := ctx.F.locals[instr.X]
 			if !ok {
-				infer.Logger.Fatalf("typeassert: %s: iface X %+v", ErrUnknownValue, instr.X.Name())
+				infer.Logger.Panicf("typeassert: %s: iface X %+v", ErrUnknownValue, instr.X.Name())
 				return
 			}
 			if instr.CommaOk {
@@ -1273,12 +1494,12 @@ func visitTypeAssert(instr *ssa.TypeAssert, infer *TypeInfer, ctx *Context) {
 			infer.Logger.Print(ctx.F.Sprintf(SkipSymbol+"%s = typeassert iface %s", ctx.F.locals[instr], inst))
 			return
 		}
-		infer.Logger.Fatalf("typeassert: %s: %+v", ErrMethodNotFound, instr)
+		infer.Logger.Panicf("typeassert: %s: %+v", ErrMethodNotFound, instr)
 		return
 	}
 	inst, ok := ctx.F.locals[instr.X]
 	if !ok {
-		infer.Logger.Fatalf("typeassert: %s: assert from %+v", ErrUnknownValue, instr.X)
+		infer.Logger.Panicf("typeassert: %s: assert from %+v", ErrUnknownValue, instr.X)
 		return
 	}
 	if instr.CommaOk {
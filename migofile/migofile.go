@@ -0,0 +1,309 @@
+// Package migofile reads MiGo programs from text, e.g. hand-edited models or
+// models written by other tools, and checks that they are well-formed.
+//
+// Programs are parsed with the parser of github.com/nickng/migo/v3, which is
+// not safe for concurrent use, so all MiGo text in dingo-hunter should be
+// parsed with Parse. Printing a parsed program with (*migo.Program).String
+// gives back the input without comments, so MiGo output can be read back in.
+package migofile // import "github.com/nickng/dingo-hunter/migofile"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/nickng/migo/v3"
+	"github.com/nickng/migo/v3/migoutil"
+	"github.com/nickng/migo/v3/parser"
+)
+
+var (
+	// parseMu serialises parsing, the parser is not safe for concurrent use.
+	parseMu sync.Mutex
+
+	comment = regexp.MustCompile(`--.*`)
+	def     = regexp.MustCompile(`(?m)^\s*def\s+([^\s(]+)`)
+)
+
+// Parse reads a MiGo program from r. An input without definitions (e.g. only
+// comments) is an empty program, and functions must be defined once.
+func Parse(r io.Reader) (*migo.Program, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// The parser keeps the first definition of a function only.
+	defined := make(map[string]bool)
+	for _, m := range def.FindAllSubmatch(comment.ReplaceAll(b, nil), -1) {
+		if defined[string(m[1])] {
+			return nil, fmt.Errorf("%s defined more than once", m[1])
+		}
+		defined[string(m[1])] = true
+	}
+	// The parser returns the previous program for empty inputs.
+	if parser.NewScanner(bytes.NewReader(b)).Scan().Tok() == 0 {
+		return migo.NewProgram(), nil
+	}
+	parseMu.Lock()
+	defer parseMu.Unlock()
+	return parser.Parse(bytes.NewReader(b))
+}
+
+// ParseFile reads a MiGo program from the file at path.
+func ParseFile(path string) (*migo.Program, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return prog, nil
+}
+
+// Simplify reduces prog by migoutil.SimplifyProgram, and removes the calls
+// and spawns of the functions it removed which it keeps, i.e. those in the
+// cases of selects and in ifFor branches.
+func Simplify(prog *migo.Program) *migo.Program {
+	migoutil.SimplifyProgram(prog)
+	funcs := make(map[string]bool)
+	for _, f := range prog.Funcs {
+		funcs[f.SimpleName()] = true
+	}
+	for _, f := range prog.Funcs {
+		f.Stmts = removeUndefined(f.Stmts, funcs)
+	}
+	return prog
+}
+
+// removeUndefined returns stmts without calls and spawns of functions not in
+// funcs, or tau if no statements are left.
+func removeUndefined(stmts []migo.Statement, funcs map[string]bool) []migo.Statement {
+	var kept []migo.Statement
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *migo.CallStatement:
+			if !funcs[s.SimpleName()] {
+				continue
+			}
+		case *migo.SpawnStatement:
+			if !funcs[s.SimpleName()] {
+				continue
+			}
+		case *migo.IfStatement:
+			s.Then, s.Else = removeUndefined(s.Then, funcs), removeUndefined(s.Else, funcs)
+		case *migo.IfForStatement:
+			s.Then, s.Else = removeUndefined(s.Then, funcs), removeUndefined(s.Else, funcs)
+		case *migo.SelectStatement:
+			for i := range s.Cases {
+				s.Cases[i] = removeUndefined(s.Cases[i], funcs)
+			}
+		}
+		kept = append(kept, stmt)
+	}
+	if len(kept) == 0 {
+		return []migo.Statement{&migo.TauStatement{}}
+	}
+	return kept
+}
+
+// Error is a problem with a definition in a MiGo program.
+type Error struct {
+	Func string         // Function containing the problem.
+	Stmt migo.Statement // Statement with the problem (nil for the definition).
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Stmt == nil {
+		return fmt.Sprintf("%s: %s", e.Func, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Func, e.Stmt, e.Msg)
+}
+
+// Check returns the problems in prog which make it meaningless to analyse,
+// i.e. functions defined more than once (e.g. by different Go functions with
+// the same name in MiGo), calls or spawns of undefined functions or with the
+// wrong number of arguments, and uses of names created in the function but
+// out of scope, e.g. a channel created in the other branch of an if, or
+// created by the caller and used by the callee without being passed to it.
+//
+// Channels used but never created or passed in a function are not problems,
+// they are external channels to the analyses.
+func Check(prog *migo.Program) []*Error {
+	var errs []*Error
+	funcs := make(map[string]*migo.Function)
+	for _, f := range prog.Funcs {
+		if funcs[f.SimpleName()] != nil {
+			errs = append(errs, &Error{Func: f.SimpleName(), Msg: "defined more than once"})
+			continue
+		}
+		funcs[f.SimpleName()] = f
+	}
+	frees := make(map[string]map[string]bool) // Names used but not created or passed, by function.
+	for name, f := range funcs {
+		bound := make(map[string]bool)
+		for _, p := range f.Params {
+			bound[p.Callee.Name()] = true
+		}
+		bindings(f.Stmts, bound)
+		frees[name] = make(map[string]bool)
+		for used := range uses(f.Stmts, make(map[string]bool)) {
+			if !bound[used] {
+				frees[name][used] = true
+			}
+		}
+	}
+	for _, f := range prog.Funcs {
+		scope, bound := make(map[string]bool), make(map[string]bool)
+		for _, p := range f.Params {
+			scope[p.Callee.Name()] = true
+		}
+		bindings(f.Stmts, bound)
+		errs = append(errs, checkStmts(f.SimpleName(), f.Stmts, funcs, frees, scope, bound)...)
+	}
+	return errs
+}
+
+// bindings adds the names created by stmts to bound.
+func bindings(stmts []migo.Statement, bound map[string]bool) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *migo.NewChanStatement:
+			bound[s.Name.Name()] = true
+		case *migo.NewMem:
+			bound[s.Name] = true
+		case *migo.IfStatement:
+			bindings(s.Then, bound)
+			bindings(s.Else, bound)
+		case *migo.IfForStatement:
+			bindings(s.Then, bound)
+			bindings(s.Else, bound)
+		case *migo.SelectStatement:
+			for _, cas := range s.Cases {
+				bindings(cas, bound)
+			}
+		}
+	}
+}
+
+// uses adds the names used by stmts to used.
+func uses(stmts []migo.Statement, used map[string]bool) map[string]bool {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *migo.CallStatement:
+			for _, p := range s.Params {
+				used[p.Caller.Name()] = true
+			}
+		case *migo.SpawnStatement:
+			for _, p := range s.Params {
+				used[p.Caller.Name()] = true
+			}
+		case *migo.SendStatement:
+			used[s.Chan] = true
+		case *migo.RecvStatement:
+			used[s.Chan] = true
+		case *migo.CloseStatement:
+			used[s.Chan] = true
+		case *migo.MemRead:
+			used[s.Name] = true
+		case *migo.MemWrite:
+			used[s.Name] = true
+		case *migo.IfStatement:
+			uses(s.Then, used)
+			uses(s.Else, used)
+		case *migo.IfForStatement:
+			uses(s.Then, used)
+			uses(s.Else, used)
+		case *migo.SelectStatement:
+			for _, cas := range s.Cases {
+				uses(cas, used)
+			}
+		}
+	}
+	return used
+}
+
+// checkStmts returns the problems of calls, spawns and uses of names in
+// stmts, where scope are the names in scope and bound are all the names
+// created in the function. Names created in branches are in scope of the
+// branch only. Names free in a callee (see frees) must not be created by the
+// caller, they are meant to be passed.
+func checkStmts(fn string, stmts []migo.Statement, funcs map[string]*migo.Function, frees map[string]map[string]bool, scope, bound map[string]bool) []*Error {
+	var errs []*Error
+	use := func(stmt migo.Statement, name string) {
+		if bound[name] && !scope[name] {
+			errs = append(errs, &Error{Func: fn, Stmt: stmt, Msg: fmt.Sprintf("%s is not in scope", name)})
+		}
+	}
+	branch := func(stmts []migo.Statement) {
+		inner := make(map[string]bool, len(scope))
+		for name := range scope {
+			inner[name] = true
+		}
+		errs = append(errs, checkStmts(fn, stmts, funcs, frees, inner, bound)...)
+	}
+	for _, stmt := range stmts {
+		var callee string
+		var params []*migo.Parameter
+		switch s := stmt.(type) {
+		case *migo.CallStatement:
+			callee, params = s.SimpleName(), s.Params
+		case *migo.SpawnStatement:
+			callee, params = s.SimpleName(), s.Params
+		case *migo.NewChanStatement:
+			scope[s.Name.Name()] = true
+		case *migo.NewMem:
+			scope[s.Name] = true
+		case *migo.SendStatement:
+			use(stmt, s.Chan)
+		case *migo.RecvStatement:
+			use(stmt, s.Chan)
+		case *migo.CloseStatement:
+			use(stmt, s.Chan)
+		case *migo.MemRead:
+			use(stmt, s.Name)
+		case *migo.MemWrite:
+			use(stmt, s.Name)
+		case *migo.IfStatement:
+			branch(s.Then)
+			branch(s.Else)
+		case *migo.IfForStatement:
+			branch(s.Then)
+			branch(s.Else)
+		case *migo.SelectStatement:
+			for _, cas := range s.Cases {
+				branch(cas)
+			}
+		}
+		if callee == "" {
+			continue
+		}
+		for _, p := range params {
+			use(stmt, p.Caller.Name())
+		}
+		f := funcs[callee]
+		switch {
+		case f == nil:
+			errs = append(errs, &Error{Func: fn, Stmt: stmt, Msg: fmt.Sprintf("%s is not defined", callee)})
+		case len(params) != len(f.Params):
+			errs = append(errs, &Error{Func: fn, Stmt: stmt, Msg: fmt.Sprintf("%s has %d parameters but is given %d arguments", callee, len(f.Params), len(params))})
+		}
+		var free []string
+		for name := range frees[callee] {
+			if bound[name] {
+				free = append(free, name)
+			}
+		}
+		sort.Strings(free)
+		for _, name := range free {
+			errs = append(errs, &Error{Func: fn, Stmt: stmt, Msg: fmt.Sprintf("%s is used by %s but not passed to it", name, callee)})
+		}
+	}
+	return errs
+}
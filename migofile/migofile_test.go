@@ -0,0 +1,164 @@
+package migofile
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests parsed programs print as the input without comments.
+func TestParse(t *testing.T) {
+	s := `def main.main():
+    let t0 = newchan main.main.t0_0_0, 1;
+    spawn main.main$1(t0);
+    select
+      case recv t0;
+      case tau; send t0;
+    endselect;
+    call main.main#1(t0);
+def main.main#1(t1):
+    if close t1; else tau; endif;
+def main.main$1(ch):
+    send ch;
+`
+	prog, err := Parse(strings.NewReader("-- Example.\n" + s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.Funcs) != 3 {
+		t.Errorf("Expecting 3 functions but got %d\n", len(prog.Funcs))
+	}
+	again, err := Parse(strings.NewReader(prog.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != prog.String() {
+		t.Errorf("Expecting parsed MiGo to be\n%s\nbut got\n%s\n", prog.String(), again.String())
+	}
+	if errs := Check(prog); len(errs) != 0 {
+		t.Errorf("Expecting no problems but got %v\n", errs)
+	}
+}
+
+// Tests inputs without definitions are empty programs.
+func TestParseEmpty(t *testing.T) {
+	if _, err := Parse(strings.NewReader("def main.main():\n    tau;\n")); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Parse(strings.NewReader("-- Nothing here.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.Funcs) != 0 {
+		t.Errorf("Expecting empty program but got\n%s\n", prog.String())
+	}
+}
+
+// Tests functions defined more than once are rejected.
+func TestParseDuplicate(t *testing.T) {
+	_, err := Parse(strings.NewReader(`def main.f():
+    tau;
+-- def main.g(): is not a definition.
+def main.f():
+    tau;
+`))
+	if err == nil || !strings.Contains(err.Error(), "main.f defined more than once") {
+		t.Errorf("Expecting main.f defined more than once but got %v\n", err)
+	}
+}
+
+// Tests calls and spawns of undefined functions are reported.
+func TestCheck(t *testing.T) {
+	prog, err := Parse(strings.NewReader(`def main.main():
+    let t0 = newchan main.main.t0_0_0, 0;
+    spawn main.worker(t0);
+    select
+      case recv t0; call main.missing(t0);
+    endselect;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := Check(prog)
+	if len(errs) != 2 {
+		t.Fatalf("Expecting 2 problems but got %v\n", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "main.worker is not defined") {
+		t.Errorf("Expecting main.worker is not defined but got %v\n", errs[0])
+	}
+	if errs[1].Func != "main.main" || !strings.Contains(errs[1].Error(), "main.missing is not defined") {
+		t.Errorf("Expecting main.missing is not defined in main.main but got %v\n", errs[1])
+	}
+}
+
+// Tests calls with the wrong number of arguments and uses of channels out of
+// scope are reported, e.g. in the loop of a sync.WaitGroup model.
+func TestCheckScope(t *testing.T) {
+	prog, err := Parse(strings.NewReader(`def main.main():
+    call main.work(ext);
+def main.work(ch):
+    if let c = newchan c, 1; spawn main.work(c); call main.work(); else recv c; endif;
+    send ext;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := Check(prog)
+	if len(errs) != 2 {
+		t.Fatalf("Expecting 2 problems but got %v\n", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "main.work has 1 parameters but is given 0 arguments") {
+		t.Errorf("Expecting wrong number of arguments but got %v\n", errs[0])
+	}
+	if !strings.Contains(errs[1].Error(), "recv c: c is not in scope") {
+		t.Errorf("Expecting c is not in scope but got %v\n", errs[1])
+	}
+}
+
+// Tests calls of functions removed by simplification are removed in selects.
+func TestSimplify(t *testing.T) {
+	prog, err := Parse(strings.NewReader(`def main.main():
+    let t0 = newchan main.main.t0_0_0, 0;
+    select
+      case recv t0; if call main.flip(); else tau; endif;
+      case send t0; call main.flip();
+    endselect;
+def main.flip():
+    tau;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Simplify(prog)
+	if errs := Check(prog); len(errs) != 0 {
+		t.Errorf("Expecting no problems but got %v\n%s\n", errs, prog.String())
+	}
+	if strings.Contains(prog.String(), "main.flip") {
+		t.Errorf("Expecting main.flip removed but got\n%s\n", prog.String())
+	}
+}
+
+// Tests channels created by the caller and used by the callee without being
+// passed are reported, e.g. a block function of a channel created in a branch.
+func TestCheckFree(t *testing.T) {
+	prog, err := Parse(strings.NewReader(`def main.main():
+    if let t2 = newchan t2, 0; call main.main#2(); else call main.main#2(); endif;
+def main.main#2():
+    spawn main.main$1(t2);
+    recv t2;
+    send ext;
+def main.main$1(ch):
+    send ch;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := Check(prog)
+	if len(errs) != 2 {
+		t.Fatalf("Expecting 2 problems but got %v\n", errs)
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "t2 is used by main.main#2 but not passed to it") {
+			t.Errorf("Expecting t2 is used by main.main#2 but not passed to it but got %v\n", err)
+		}
+	}
+}
@@ -11,20 +11,23 @@ type Node struct {
 	Children []*Node
 }
 
-var (
+// builder holds the functions and blocks visited while building a callgraph.
+type builder struct {
 	visitedFunc  map[*ssa.Function]bool
 	visitedBlock map[*ssa.BasicBlock]bool
-)
+}
 
 func Build(main *ssa.Function) *Node {
 	root := &Node{
 		Func:     main,
 		Children: []*Node{},
 	}
-	visitedFunc = make(map[*ssa.Function]bool)
-	visitedBlock = make(map[*ssa.BasicBlock]bool)
-	visitedFunc[root.Func] = true
-	visitBlock(root.Func.Blocks[0], root)
+	cg := &builder{
+		visitedFunc:  make(map[*ssa.Function]bool),
+		visitedBlock: make(map[*ssa.BasicBlock]bool),
+	}
+	cg.visitedFunc[root.Func] = true
+	cg.visitBlock(root.Func.Blocks[0], root)
 	return root
 }
 
@@ -41,17 +44,17 @@ func (node *Node) Traverse(v FuncVisitor) {
 	}
 }
 
-func visitBlock(b *ssa.BasicBlock, node *Node) {
-	if _, ok := visitedBlock[b]; ok {
+func (cg *builder) visitBlock(b *ssa.BasicBlock, node *Node) {
+	if _, ok := cg.visitedBlock[b]; ok {
 		return
 	}
-	visitedBlock[b] = true
+	cg.visitedBlock[b] = true
 	for _, instr := range b.Instrs {
 		switch instr := instr.(type) {
 		case *ssa.Call:
 			if f := instr.Common().StaticCallee(); f != nil {
-				if _, ok := visitedFunc[f]; !ok {
-					visitedFunc[f] = true
+				if _, ok := cg.visitedFunc[f]; !ok {
+					cg.visitedFunc[f] = true
 					node.Children = append(node.Children, &Node{
 						Func:     f,
 						Children: []*Node{},
@@ -60,8 +63,8 @@ func visitBlock(b *ssa.BasicBlock, node *Node) {
 			}
 		case *ssa.Go:
 			if f := instr.Common().StaticCallee(); f != nil {
-				if _, ok := visitedFunc[f]; !ok {
-					visitedFunc[f] = true
+				if _, ok := cg.visitedFunc[f]; !ok {
+					cg.visitedFunc[f] = true
 					node.Children = append(node.Children, &Node{
 						Func:     f,
 						Children: []*Node{},
@@ -69,15 +72,15 @@ func visitBlock(b *ssa.BasicBlock, node *Node) {
 				}
 			}
 		case *ssa.If:
-			visitBlock(instr.Block().Succs[0], node)
-			visitBlock(instr.Block().Succs[1], node)
+			cg.visitBlock(instr.Block().Succs[0], node)
+			cg.visitBlock(instr.Block().Succs[1], node)
 		case *ssa.Jump: // End of a block
-			visitBlock(instr.Block().Succs[0], node)
+			cg.visitBlock(instr.Block().Succs[0], node)
 		case *ssa.Return: // End of a function
 			for _, child := range node.Children {
-				if _, ok := visitedFunc[child.Func]; !ok {
-					visitedFunc[child.Func] = true
-					visitBlock(child.Func.Blocks[0], child)
+				if _, ok := cg.visitedFunc[child.Func]; !ok {
+					cg.visitedFunc[child.Func] = true
+					cg.visitBlock(child.Func.Blocks[0], child)
 				}
 			}
 		}
@@ -7,6 +7,7 @@ import (
 	"go/token"
 	"go/types"
 
+	"golang.org/x/tools/go/pointer"
 	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/ssa/ssautil"
 )
@@ -25,6 +26,7 @@ type ChanOp struct {
 	Value ssa.Value
 	Type  ChanOpType
 	Pos   token.Pos
+	Instr ssa.Instruction // Instruction of the operation, nil for ChanMake.
 }
 
 // chanOps extract all channel operations from an instruction.
@@ -32,24 +34,24 @@ func chanOps(instr ssa.Instruction) []ChanOp {
 	var ops []ChanOp
 	switch instr := instr.(type) {
 	case *ssa.Send:
-		ops = append(ops, ChanOp{instr.Chan, ChanSend, instr.Pos()})
+		ops = append(ops, ChanOp{instr.Chan, ChanSend, instr.Pos(), instr})
 	case *ssa.UnOp:
 		if instr.Op == token.ARROW {
-			ops = append(ops, ChanOp{instr.X, ChanRecv, instr.Pos()})
+			ops = append(ops, ChanOp{instr.X, ChanRecv, instr.Pos(), instr})
 		}
 	case *ssa.Select:
 		for _, st := range instr.States {
 			switch st.Dir {
 			case types.SendOnly:
-				ops = append(ops, ChanOp{st.Chan, ChanSend, st.Pos})
+				ops = append(ops, ChanOp{st.Chan, ChanSend, st.Pos, instr})
 			case types.RecvOnly:
-				ops = append(ops, ChanOp{st.Chan, ChanRecv, st.Pos})
+				ops = append(ops, ChanOp{st.Chan, ChanRecv, st.Pos, instr})
 			}
 		}
 	case ssa.CallInstruction:
 		common := instr.Common()
 		if b, ok := common.Value.(*ssa.Builtin); ok && b.Name() == "close" {
-			ops = append(ops, ChanOp{common.Args[0], ChanClose, common.Pos()})
+			ops = append(ops, ChanOp{common.Args[0], ChanClose, common.Pos(), instr})
 		}
 	}
 	return ops
@@ -86,3 +88,108 @@ func purgeChanOps(ops []ChanOp, ch ssa.Value) []ChanOp {
 	ops = ops[:i]
 	return ops
 }
+
+// nilChanOps returns the channel operations in ops where the channel does not
+// point to any channel created in the program, i.e. the channel is nil.
+func nilChanOps(ops []ChanOp, result *pointer.Result) []ChanOp {
+	var nilOps []ChanOp
+	for _, op := range ops {
+		if ptr, ok := result.Queries[op.Value]; ok && len(ptr.PointsTo().Labels()) == 0 {
+			nilOps = append(nilOps, op)
+		}
+	}
+	return nilOps
+}
+
+// closedChanOps returns the sends and closes in ops where the channel may be
+// closed by a close in ops at another position which may run before it, i.e.
+// which may panic. A close repeated at the same position (e.g. in a loop) is
+// not found.
+func closedChanOps(ops []ChanOp, result *pointer.Result) []ChanOp {
+	var closes []ChanOp
+	for _, op := range ops {
+		if op.Type == ChanClose {
+			closes = append(closes, op)
+		}
+	}
+	var closedOps []ChanOp
+	for _, op := range ops {
+		ptr, ok := result.Queries[op.Value]
+		if !ok || op.Type != ChanSend && op.Type != ChanClose {
+			continue
+		}
+		for _, c := range closes {
+			if cptr, ok := result.Queries[c.Value]; ok && c.Pos != op.Pos && ptr.MayAlias(cptr) && closesBefore(c, op) {
+				closedOps = append(closedOps, op)
+				break
+			}
+		}
+	}
+	return closedOps
+}
+
+// closesBefore returns true if close c may run before op. A close in another
+// function may run before op in another goroutine, unless it is a closure
+// deferred by the function of op. In the same function, a deferred close runs
+// after op, otherwise c must reach op in the control flow graph.
+func closesBefore(c, op ChanOp) bool {
+	if c.Instr == nil || op.Instr == nil {
+		return true
+	}
+	cb, ob := c.Instr.Block(), op.Instr.Block()
+	if cb.Parent() != ob.Parent() {
+		return !deferredBy(cb.Parent(), ob.Parent())
+	}
+	if _, ok := c.Instr.(*ssa.Defer); ok {
+		return false
+	}
+	if cb == ob && instrIndex(c.Instr) < instrIndex(op.Instr) {
+		return true
+	}
+	seen := make(map[*ssa.BasicBlock]bool)
+	queue := append([]*ssa.BasicBlock(nil), cb.Succs...)
+	for len(queue) > 0 {
+		b := queue[0]
+		queue = queue[1:]
+		if b == ob {
+			return true
+		}
+		if !seen[b] {
+			seen[b] = true
+			queue = append(queue, b.Succs...)
+		}
+	}
+	return false
+}
+
+// deferredBy returns true if the anonymous function fn is only called by
+// deferred calls of its enclosing function parent.
+func deferredBy(fn, parent *ssa.Function) bool {
+	if fn.Parent() != parent {
+		return false
+	}
+	deferred := false
+	for _, b := range parent.Blocks {
+		for _, instr := range b.Instrs {
+			call, ok := instr.(ssa.CallInstruction)
+			if !ok || call.Common().StaticCallee() != fn {
+				continue
+			}
+			if _, ok := call.(*ssa.Defer); !ok {
+				return false
+			}
+			deferred = true
+		}
+	}
+	return deferred
+}
+
+// instrIndex returns the index of instr in its block.
+func instrIndex(instr ssa.Instruction) int {
+	for i, in := range instr.Block().Instrs {
+		if in == instr {
+			return i
+		}
+	}
+	return -1
+}
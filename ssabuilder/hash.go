@@ -0,0 +1,153 @@
+package ssabuilder
+
+// Content hashes of packages and source positions independent of the
+// FileSet, for caching analysis results across runs.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Position is a source position which is stable across runs (unlike
+// token.Pos, which depends on the order files are loaded).
+type Position struct {
+	File   string `json:",omitempty"`
+	Offset int    `json:",omitempty"`
+}
+
+type hashes struct {
+	sync.Mutex
+	pkgs  map[*types.Package]string
+	files map[string]*token.File
+}
+
+func newHashes() *hashes {
+	return &hashes{pkgs: make(map[*types.Package]string)}
+}
+
+// PkgHash returns the content hash of pkg, which covers the source of pkg and
+// (transitively) the packages it imports.
+func (info *SSAInfo) PkgHash(pkg *types.Package) string {
+	info.hashes.Lock()
+	defer info.hashes.Unlock()
+	return info.pkgHash(pkg)
+}
+
+func (info *SSAInfo) pkgHash(pkg *types.Package) string {
+	if h, ok := info.hashes.pkgs[pkg]; ok {
+		return h
+	}
+	info.hashes.pkgs[pkg] = "" // Import cycles are not possible, but be safe.
+	h := sha256.New()
+	h.Write([]byte(pkg.Path() + "\x00"))
+	if pkgInfo := info.lprog.Package(pkg.Path()); pkgInfo != nil {
+		var srcs []string
+		for _, f := range pkgInfo.Files {
+			srcs = append(srcs, info.source(info.FSet.File(f.Pos()).Name()))
+		}
+		sort.Strings(srcs)
+		for _, src := range srcs {
+			h.Write([]byte(src + "\x00"))
+		}
+	}
+	var deps []string
+	for _, imp := range pkg.Imports() {
+		deps = append(deps, info.pkgHash(imp))
+	}
+	sort.Strings(deps)
+	for _, dep := range deps {
+		h.Write([]byte(dep + "\x00"))
+	}
+	info.hashes.pkgs[pkg] = hex.EncodeToString(h.Sum(nil))
+	return info.hashes.pkgs[pkg]
+}
+
+// source returns the content of a source file.
+func (info *SSAInfo) source(filename string) string {
+	if info.BuildConf.BuildMode == FromString && filename == "" {
+		return info.BuildConf.Source
+	}
+	if src, ok := info.BuildConf.Sources[filename]; ok && (info.BuildConf.BuildMode == FromSources || info.BuildConf.BuildMode == FromModule) {
+		return src
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		// Not (or no longer) readable, use name so the hash never matches
+		// a readable version.
+		return "\x00unreadable:" + filename
+	}
+	return string(b)
+}
+
+// SkipKey returns a string identifying the packages not loaded (see
+// Config.BadPkgs), which are left out of SSA and pointer analysis.
+func (info *SSAInfo) SkipKey() string {
+	pkgs := append([]string(nil), info.IgnoredPkgs...)
+	sort.Strings(pkgs)
+	return strings.Join(pkgs, ",")
+}
+
+// ProgHash returns the content hash of the whole program.
+func (info *SSAInfo) ProgHash() string {
+	h := sha256.New()
+	for _, pkgInfo := range info.lprog.InitialPackages() {
+		h.Write([]byte(info.PkgHash(pkgInfo.Pkg) + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Position returns the stable position of pos.
+func (info *SSAInfo) Position(pos token.Pos) Position {
+	if !pos.IsValid() {
+		return Position{}
+	}
+	p := info.FSet.Position(pos)
+	return Position{File: p.Filename, Offset: p.Offset}
+}
+
+// Pos returns the token.Pos of a stable position p, or token.NoPos if p is not
+// in the program.
+func (info *SSAInfo) Pos(p Position) token.Pos {
+	if p == (Position{}) {
+		return token.NoPos
+	}
+	info.hashes.Lock()
+	defer info.hashes.Unlock()
+	if info.hashes.files == nil {
+		info.hashes.files = make(map[string]*token.File)
+		info.FSet.Iterate(func(f *token.File) bool {
+			info.hashes.files[f.Name()] = f
+			return true
+		})
+	}
+	f, ok := info.hashes.files[p.File]
+	if !ok || p.Offset > f.Size() {
+		return token.NoPos
+	}
+	return f.Pos(p.Offset)
+}
+
+// chanOpsAt returns the channel operations in the program at positions.
+func (info *SSAInfo) chanOpsAt(positions []Position) []ChanOp {
+	at := make(map[token.Pos]bool)
+	for _, p := range positions {
+		if pos := info.Pos(p); pos.IsValid() {
+			at[pos] = true
+		}
+	}
+	var ops []ChanOp
+	for _, op := range progChanOps(info.Prog) {
+		if _, isConst := op.Value.(*ssa.Const); !isConst && at[op.Pos] {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
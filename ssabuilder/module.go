@@ -0,0 +1,236 @@
+package ssabuilder
+
+// Loading of modules by the go command (go/packages), for programs given as
+// files of a module, e.g. in a txtar archive.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/txtar"
+)
+
+// ArchiveFiles returns the files of a txtar archive by filename, e.g.
+//
+//	-- go.mod --
+//	module example.com/hello
+//	-- main.go --
+//	package main
+//
+// The comment before the first file is ignored. It returns nil if data has no
+// files, e.g. data is the source code of a single file.
+func ArchiveFiles(data []byte) map[string]string {
+	ar := txtar.Parse(data)
+	if len(ar.Files) == 0 {
+		return nil
+	}
+	files := make(map[string]string, len(ar.Files))
+	for _, f := range ar.Files {
+		files[f.Name] = string(f.Data)
+	}
+	return files
+}
+
+// NewConfigFromArchive creates a new default build configuration from the
+// files of a txtar archive (see ArchiveFiles and NewConfigFromSources). If
+// data has no files, it is the source code of a single file.
+func NewConfigFromArchive(data []byte) (*Config, error) {
+	files := ArchiveFiles(data)
+	if files == nil {
+		return NewConfigFromString(string(data))
+	}
+	return NewConfigFromSources(files)
+}
+
+// loadModule writes the sources to a temporary directory and loads all the
+// packages of the module with their dependencies. Files of the module are
+// named as in Sources, so positions and content hashes do not depend on the
+// directory. Syntax and type errors are returned as scanner.ErrorList.
+func (conf *Config) loadModule() (*loader.Program, error) {
+	dir, err := ioutil.TempDir("", "dingo-hunter-module-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range conf.Sources {
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("invalid filename %q", name)
+		}
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(file, []byte(src), 0640); err != nil {
+			return nil, err
+		}
+	}
+
+	// The go command lists the packages, which are type checked here in the
+	// order of imports, as go/packages does not know the sizes of types of
+	// recent Go releases. Cgo is disabled so the files listed do not import
+	// "C", which cannot be type checked without running cgo.
+	env := conf.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps,
+		Dir:  dir,
+		Env:  append(env[:len(env):len(env)], "CGO_ENABLED=0"),
+	}, "./...")
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages in module")
+	}
+	var errs scanner.ErrorList
+	var other []string
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, e := range pkg.Errors {
+			if pos, ok := parseErrorPos(e.Pos, dir); ok {
+				errs.Add(pos, e.Msg)
+			} else {
+				other = append(other, e.Error())
+			}
+		}
+	})
+	if len(other) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(other, "\n"))
+	}
+	if len(errs) > 0 {
+		errs.Sort()
+		return nil, errs
+	}
+
+	// The packages are given as a loader.Program, as if loaded by the
+	// go/loader package, with the packages of the module as initial.
+	lprog := &loader.Program{
+		Fset:        token.NewFileSet(),
+		Imported:    make(map[string]*loader.PackageInfo),
+		AllPackages: make(map[*types.Package]*loader.PackageInfo),
+	}
+	sizes := types.SizesFor("gc", build.Default.GOARCH)
+	checked := make(map[*packages.Package]*loader.PackageInfo)
+	var check func(pkg *packages.Package) *loader.PackageInfo
+	check = func(pkg *packages.Package) *loader.PackageInfo {
+		if info, ok := checked[pkg]; ok {
+			return info
+		}
+		info := &loader.PackageInfo{
+			Importable:            true,
+			TransitivelyErrorFree: true,
+			Info: types.Info{
+				Types:      make(map[ast.Expr]types.TypeAndValue),
+				Instances:  make(map[*ast.Ident]types.Instance),
+				Defs:       make(map[*ast.Ident]types.Object),
+				Uses:       make(map[*ast.Ident]types.Object),
+				Implicits:  make(map[ast.Node]types.Object),
+				Selections: make(map[*ast.SelectorExpr]*types.Selection),
+				Scopes:     make(map[ast.Node]*types.Scope),
+			},
+		}
+		checked[pkg] = info
+		if pkg.PkgPath == "unsafe" {
+			info.Pkg = types.Unsafe
+			lprog.AllPackages[info.Pkg] = info
+			return info
+		}
+		imports := make(map[string]*types.Package)
+		for path, imp := range pkg.Imports {
+			imports[path] = check(imp).Pkg
+		}
+		for _, filename := range pkg.GoFiles {
+			src, err := ioutil.ReadFile(filename)
+			if err != nil {
+				other = append(other, err.Error())
+				continue
+			}
+			if rel, err := filepath.Rel(dir, filename); err == nil && !strings.HasPrefix(rel, "..") {
+				filename = filepath.ToSlash(rel)
+			}
+			f, err := parser.ParseFile(lprog.Fset, filename, src, parser.AllErrors|parser.ParseComments)
+			if err != nil {
+				if list, ok := err.(scanner.ErrorList); ok {
+					errs = append(errs, list...)
+				} else {
+					other = append(other, err.Error())
+				}
+			}
+			if f != nil {
+				info.Files = append(info.Files, f)
+			}
+		}
+		tconf := &types.Config{
+			Importer: importerFunc(func(path string) (*types.Package, error) {
+				if pkg, ok := imports[path]; ok && pkg != nil {
+					return pkg, nil
+				}
+				return nil, fmt.Errorf("package %q not found", path)
+			}),
+			Sizes: sizes,
+			Error: func(err error) {
+				if err, ok := err.(types.Error); ok {
+					errs.Add(err.Fset.Position(err.Pos), err.Msg)
+				}
+			},
+		}
+		info.Pkg, _ = tconf.Check(pkg.PkgPath, lprog.Fset, info.Files, &info.Info)
+		lprog.AllPackages[info.Pkg] = info
+		return info
+	}
+	for _, pkg := range pkgs {
+		lprog.Imported[pkg.PkgPath] = check(pkg)
+	}
+	if len(other) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(other, "\n"))
+	}
+	if len(errs) > 0 {
+		errs.Sort()
+		return nil, errs
+	}
+	return lprog, nil
+}
+
+// importerFunc is a types.Importer of a function.
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// parseErrorPos parses the position "file:line:col" of a packages.Error, with
+// files in dir named relative to dir.
+func parseErrorPos(s, dir string) (token.Position, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 {
+		return token.Position{}, false
+	}
+	pos := token.Position{Column: 1}
+	if n, err := strconv.Atoi(parts[len(parts)-1]); err == nil && len(parts) >= 3 {
+		if line, err := strconv.Atoi(parts[len(parts)-2]); err == nil {
+			pos.Filename, pos.Line, pos.Column = strings.Join(parts[:len(parts)-2], ":"), line, n
+		}
+	}
+	if pos.Line == 0 {
+		line, err := strconv.Atoi(parts[len(parts)-1])
+		if err != nil {
+			return token.Position{}, false
+		}
+		pos.Filename, pos.Line = strings.Join(parts[:len(parts)-1], ":"), line
+	}
+	if rel, err := filepath.Rel(dir, pos.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+		pos.Filename = filepath.ToSlash(rel)
+	}
+	return pos, true
+}
@@ -4,17 +4,23 @@ package ssabuilder // import "github.com/nickng/dingo-hunter/ssabuilder"
 
 import (
 	"fmt"
+	"go/ast"
 	"go/build"
+	"go/scanner"
 	"go/token"
+	"go/types"
 	"io"
 	"io/ioutil"
 	"log"
+	"sort"
+	"sync"
 
 	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/pointer"
 	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/ssa/ssautil"
 
+	"github.com/nickng/dingo-hunter/cache"
 	"github.com/nickng/dingo-hunter/ssabuilder/callgraph"
 )
 
@@ -27,6 +33,13 @@ const (
 
 	// FromString is option to use a string as body of initial package.
 	FromString
+
+	// FromSources is option to use named strings as files of initial package.
+	FromSources
+
+	// FromModule is option to use named strings as files of a module (with a
+	// go.mod file), loaded with its dependencies by the go command.
+	FromModule
 )
 
 // Config holds the configuration for building SSA IR.
@@ -34,6 +47,8 @@ type Config struct {
 	BuildMode Mode
 	Files     []string          // (Initial) files to load.
 	Source    string            // Source code.
+	Sources   map[string]string // Source code by filename.
+	Env       []string          // Environment of the go command (FromModule), nil for the current environment.
 	BuildLog  io.Writer         // Build log.
 	PtaLog    io.Writer         // Pointer analysis log.
 	LogFlags  int               // Flags for build/pta log.
@@ -50,6 +65,15 @@ type SSAInfo struct {
 	PtaConf *pointer.Config // Pointer analysis config.
 
 	Logger *log.Logger // Build logger.
+	Cache  *cache.Dir  // Cache for analysis results (nil if disabled).
+
+	lprog  *loader.Program // Loaded program.
+	hashes *hashes         // Content hashes of packages.
+
+	chanPta    sync.Once       // Ptr analysis of channel operations (see findChanOps).
+	chanOps    []ChanOp        // Channel operations queried.
+	chanResult *pointer.Result // Result of the ptr analysis.
+	chanErr    error           // Error of the ptr analysis.
 }
 
 var (
@@ -80,6 +104,27 @@ func NewConfig(files []string) (*Config, error) {
 	}, nil
 }
 
+// NewConfigFromSources creates a new default build configuration from source
+// code of files by filename. If there is a go.mod file, the files are of a
+// module, e.g. with packages in subdirectories, loaded by the go command.
+func NewConfigFromSources(sources map[string]string) (*Config, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no files specified for analysis")
+	}
+	mode := FromSources
+	if _, ok := sources["go.mod"]; ok {
+		mode = FromModule
+	}
+	return &Config{
+		BuildMode: mode,
+		Sources:   sources,
+		BuildLog:  ioutil.Discard,
+		PtaLog:    ioutil.Discard,
+		LogFlags:  log.LstdFlags,
+		BadPkgs:   badPkgs,
+	}, nil
+}
+
 // NewConfigFromString creates a new default build configuration.
 func NewConfigFromString(s string) (*Config, error) {
 	return &Config{
@@ -93,31 +138,16 @@ func NewConfigFromString(s string) (*Config, error) {
 }
 
 // Build constructs the SSA IR using given config, and sets up pointer analysis.
+// Syntax and type errors in the source code are returned as scanner.ErrorList.
 func (conf *Config) Build() (*SSAInfo, error) {
-	var lconf = loader.Config{Build: &build.Default}
 	buildLog := log.New(conf.BuildLog, "ssabuild: ", conf.LogFlags)
-
-	if conf.BuildMode == FromFiles {
-		args, err := lconf.FromArgs(conf.Files, false /* No tests */)
-		if err != nil {
-			return nil, err
-		}
-		if len(args) > 0 {
-			return nil, fmt.Errorf("surplus arguments: %q", args)
-		}
-	} else if conf.BuildMode == FromString {
-		f, err := lconf.ParseFile("", conf.Source)
-		if err != nil {
-			return nil, err
-		}
-		lconf.CreateFromFiles("", f)
+	var lprog *loader.Program
+	var err error
+	if conf.BuildMode == FromModule {
+		lprog, err = conf.loadModule()
 	} else {
-		buildLog.Fatal("Unknown build mode")
-
+		lprog, err = conf.load(buildLog)
 	}
-
-	// Load, parse and type-check program
-	lprog, err := lconf.Load()
 	if err != nil {
 		return nil, err
 	}
@@ -149,9 +179,73 @@ func (conf *Config) Build() (*SSAInfo, error) {
 		Prog:        prog,
 		PtaConf:     ptaConf,
 		Logger:      buildLog,
+
+		lprog:  lprog,
+		hashes: newHashes(),
 	}, nil
 }
 
+// load loads the program with the go/loader package, from GOPATH.
+func (conf *Config) load(buildLog *log.Logger) (*loader.Program, error) {
+	var lconf = loader.Config{Build: &build.Default}
+	if conf.BuildMode == FromFiles {
+		args, err := lconf.FromArgs(conf.Files, false /* No tests */)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 0 {
+			return nil, fmt.Errorf("surplus arguments: %q", args)
+		}
+	} else if conf.BuildMode == FromString {
+		f, err := lconf.ParseFile("", conf.Source)
+		if err != nil {
+			return nil, err
+		}
+		lconf.CreateFromFiles("", f)
+	} else if conf.BuildMode == FromSources {
+		var filenames []string
+		for filename := range conf.Sources {
+			filenames = append(filenames, filename)
+		}
+		sort.Strings(filenames)
+		var files []*ast.File
+		for _, filename := range filenames {
+			f, err := lconf.ParseFile(filename, conf.Sources[filename])
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+		}
+		lconf.CreateFromFiles("", files...)
+	} else {
+		buildLog.Fatal("Unknown build mode")
+
+	}
+
+	// Load, parse and type-check program
+	var typeErrs scanner.ErrorList
+	lconf.TypeChecker.Error = func(err error) {
+		buildLog.Print(err)
+		switch err := err.(type) {
+		case types.Error:
+			typeErrs.Add(err.Fset.Position(err.Pos), err.Msg)
+		case scanner.ErrorList:
+			typeErrs = append(typeErrs, err...)
+		case *scanner.Error:
+			typeErrs = append(typeErrs, err)
+		}
+	}
+	lprog, err := lconf.Load()
+	if err != nil {
+		if len(typeErrs) > 0 {
+			typeErrs.Sort()
+			return nil, typeErrs
+		}
+		return nil, err
+	}
+	return lprog, nil
+}
+
 // CallGraph builds the call graph from the 'main.main' function.
 //
 // The call graph is rooted at 'main.main', all nodes appear only once in the
@@ -201,7 +295,7 @@ func (info *SSAInfo) FindChan(ch ssa.Value) []ChanOp {
 	var ops []ChanOp
 	for _, label := range queryCh.PointsTo().Labels() {
 		// Add MakeChan to result
-		ops = append(ops, ChanOp{label.Value(), ChanMake, label.Pos()})
+		ops = append(ops, ChanOp{label.Value(), ChanMake, label.Pos(), nil})
 	}
 	for _, op := range chanOps {
 		if ptr, ok := result.Queries[op.Value]; ok && ptr.MayAlias(queryCh) {
@@ -210,3 +304,66 @@ func (info *SSAInfo) FindChan(ch ssa.Value) []ChanOp {
 	}
 	return ops
 }
+
+// FindNilChans performs a ptr analysis on all channel operations in the
+// program, returns a list of ChanOp on channels which are always nil.
+//
+// Channels which are not made in the analysed code (e.g. made in packages not
+// loaded) are also found to be nil, so the results are possibly nil channels.
+func (info *SSAInfo) FindNilChans() ([]ChanOp, error) {
+	return info.findChanOps("nilchans", nilChanOps)
+}
+
+// FindClosedChanOps performs a ptr analysis on all channel operations in the
+// program, returns a list of sends and closes which may panic, as the channel
+// may be closed by another close which may run before them (see
+// closedChanOps).
+func (info *SSAInfo) FindClosedChanOps() ([]ChanOp, error) {
+	return info.findChanOps("closedchans", closedChanOps)
+}
+
+// findChanOps returns the channel operations selected by sel from the ptr
+// analysis of all channel operations in the program, which is run once for
+// all kinds of selection. The results are cached by kind.
+func (info *SSAInfo) findChanOps(kind string, sel func([]ChanOp, *pointer.Result) []ChanOp) ([]ChanOp, error) {
+	if info.PtaConf == nil {
+		return nil, nil // No main or pointer analysis not set up.
+	}
+	var key string
+	if info.Cache != nil {
+		key = kind + "|" + info.SkipKey() + "|" + info.ProgHash()
+		var positions []Position
+		if info.Cache.Get(key, &positions) {
+			return info.chanOpsAt(positions), nil
+		}
+	}
+	info.chanPta.Do(func() {
+		// Queries are added to a copy so they are not added to other analyses.
+		conf := *info.PtaConf
+		conf.Queries, conf.IndirectQueries = nil, nil
+		if conf.Log == ioutil.Discard {
+			conf.Log = nil
+		}
+		for _, op := range progChanOps(info.Prog) {
+			if _, isConst := op.Value.(*ssa.Const); !isConst {
+				conf.AddQuery(op.Value)
+				info.chanOps = append(info.chanOps, op)
+			}
+		}
+		info.chanResult, info.chanErr = pointer.Analyze(&conf)
+	})
+	if info.chanErr != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPtaInternal, info.chanErr)
+	}
+	ops := sel(info.chanOps, info.chanResult)
+	if info.Cache != nil {
+		positions := make([]Position, len(ops))
+		for i, op := range ops {
+			positions[i] = info.Position(op.Pos)
+		}
+		if err := info.Cache.Put(key, positions); err != nil {
+			info.Logger.Printf("%s: cannot cache result: %v", kind, err)
+		}
+	}
+	return ops, nil
+}
@@ -1,6 +1,9 @@
 package ssabuilder
 
 import (
+	"go/constant"
+	"go/types"
+
 	"golang.org/x/tools/go/ssa"
 )
 
@@ -14,3 +17,49 @@ func MainPkg(prog *ssa.Program) *ssa.Package {
 	}
 	return nil // Not found
 }
+
+// CallsRecover returns true if fn calls the recover builtin.
+func CallsRecover(fn *ssa.Function) bool {
+	for _, blk := range fn.Blocks {
+		for _, instr := range blk.Instrs {
+			if call, ok := instr.(*ssa.Call); ok {
+				if b, ok := call.Common().Value.(*ssa.Builtin); ok && b.Name() == "recover" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// IsSelectPanic returns true if instr is the unreachable panic of a blocking
+// select with no matching cases.
+func IsSelectPanic(instr *ssa.Panic) bool {
+	if iface, ok := instr.X.(*ssa.MakeInterface); ok {
+		if c, ok := iface.X.(*ssa.Const); ok && c.Value != nil && c.Value.Kind() == constant.String {
+			return constant.StringVal(c.Value) == "blocking select matched no case"
+		}
+	}
+	return false
+}
+
+// DynamicMethod returns the function value of method of the concrete value
+// converted to interface v, and the receiver (nil if none). A function
+// converted to a named function type, e.g. http.HandlerFunc(f), is returned
+// as the function f itself. Returns false if the value is not known.
+func DynamicMethod(prog *ssa.Program, v ssa.Value, method string) (fn, recv ssa.Value, ok bool) {
+	mi, ok := v.(*ssa.MakeInterface)
+	if !ok {
+		return nil, nil, false
+	}
+	if ct, ok := mi.X.(*ssa.ChangeType); ok {
+		if _, ok := ct.X.Type().Underlying().(*types.Signature); ok {
+			return ct.X, nil, true
+		}
+	}
+	sel := prog.MethodSets.MethodSet(mi.X.Type()).Lookup(nil, method)
+	if sel == nil {
+		return nil, nil, false
+	}
+	return prog.MethodValue(sel), mi.X, true
+}
@@ -0,0 +1,239 @@
+// Interactive graphs of models (see /graph), rendered as SVG without external
+// tools. Clicking a node or edge with a source position highlights the line in
+// the Go code, and the trace of CFSMs to a stuck configuration can be stepped
+// through, highlighting the current state of each machine.
+var graph = (function() {
+  var svgNS = 'http://www.w3.org/2000/svg';
+  var nodeHeight = 24, layerGap = 40, nodeGap = 16, groupGap = 40, margin = 20;
+
+  function svgElem(name, attrs) {
+    var el = document.createElementNS(svgNS, name);
+    for (var k in attrs) {
+      el.setAttribute(k, attrs[k]);
+    }
+    return el;
+  }
+
+  // highlightLine highlights the source line of pos in the #go div.
+  function highlightLine(pos) {
+    $('#go pre').removeClass('highlight');
+    if (pos==null || pos.line<1) {
+      return;
+    }
+    var line = $('#go pre').eq(sourceLine(pos)-1);
+    line.addClass('highlight');
+    if (line.length>0) {
+      line[0].scrollIntoView({block: 'center'});
+    }
+  }
+
+  // layout places the nodes of g in layers by distance from the first node of
+  // each group, with groups side by side. Returns positions of nodes by ID.
+  function layout(g) {
+    var pos = {}, succs = {}, x = margin, height = 0;
+    $.each(g.edges, function(i, e) {
+      (succs[e.from] = succs[e.from] || []).push(e.to);
+    });
+    $.each(g.groups, function(i, group) {
+      var nodes = $.grep(g.nodes, function(n) { return n.group==group.id; });
+      var depth = {}, layers = [];
+      // Breadth-first from each node not yet placed, in order.
+      $.each(nodes, function(j, n) {
+        if (depth[n.id]!==undefined) {
+          return;
+        }
+        depth[n.id] = 0;
+        var queue = [n.id];
+        while (queue.length>0) {
+          var id = queue.shift();
+          (layers[depth[id]] = layers[depth[id]] || []).push(id);
+          $.each(succs[id] || [], function(k, to) {
+            if (depth[to]===undefined && $.grep(nodes, function(m) { return m.id==to; }).length>0) {
+              depth[to] = depth[id]+1;
+              queue.push(to);
+            }
+          });
+        }
+      });
+      var width = textWidth(group.label);
+      $.each(layers, function(d, layer) {
+        var lx = 0;
+        $.each(layer || [], function(k, id) {
+          var n = $.grep(nodes, function(m) { return m.id==id; })[0];
+          var w = textWidth(n.label);
+          pos[id] = {x: x+lx, y: margin+nodeHeight+layerGap+d*(nodeHeight+layerGap), w: w};
+          lx += w+nodeGap;
+        });
+        width = Math.max(width, lx);
+        height = Math.max(height, margin+nodeHeight+layerGap+(d+1)*(nodeHeight+layerGap));
+      });
+      pos['group:'+group.id] = {x: x, y: margin, w: width};
+      x += width+groupGap;
+    });
+    pos.width = x;
+    pos.height = height+margin;
+    return pos;
+  }
+
+  function textWidth(s) {
+    return 7*s.length+16;
+  }
+
+  // render draws graph g into the element el.
+  function render(g, el) {
+    var pos = layout(g);
+    var svg = svgElem('svg', {width: pos.width, height: pos.height, 'class': 'graph'});
+    var defs = svgElem('defs', {});
+    var marker = svgElem('marker', {id: 'arrow', viewBox: '0 0 10 10', refX: 10, refY: 5, markerWidth: 6, markerHeight: 6, orient: 'auto'});
+    marker.appendChild(svgElem('path', {d: 'M 0 0 L 10 5 L 0 10 z'}));
+    defs.appendChild(marker);
+    svg.appendChild(defs);
+    $.each(g.groups, function(i, group) {
+      var p = pos['group:'+group.id];
+      var text = svgElem('text', {x: p.x, y: p.y+nodeHeight/2, 'class': 'group '+group.kind});
+      text.textContent = group.label;
+      clickable(text, group.pos);
+      svg.appendChild(text);
+    });
+    $.each(g.edges, function(i, e) {
+      var from = pos[e.from], to = pos[e.to];
+      if (from==null || to==null) {
+        return;
+      }
+      var x1 = from.x+from.w/2, y1 = from.y+nodeHeight, x2 = to.x+to.w/2, y2 = to.y;
+      var d = 'M '+x1+' '+y1+' L '+x2+' '+y2;
+      if (to.y<=from.y) { // Back edge, curve around the side.
+        var side = Math.max(from.x+from.w, to.x+to.w)+layerGap;
+        y1 = from.y+nodeHeight/2;
+        y2 = to.y+nodeHeight/2;
+        x1 = from.x+from.w;
+        x2 = to.x+to.w;
+        d = 'M '+x1+' '+y1+' C '+side+' '+y1+' '+side+' '+y2+' '+x2+' '+y2;
+      }
+      var path = svgElem('path', {d: d, 'class': 'edge', 'marker-end': 'url(#arrow)', 'data-from': e.from, 'data-to': e.to});
+      clickable(path, e.pos);
+      svg.appendChild(path);
+      if (e.label) {
+        var label = svgElem('text', {x: (x1+x2)/2+4, y: (y1+y2)/2, 'class': 'edge-label'});
+        label.textContent = e.label;
+        clickable(label, e.pos);
+        svg.appendChild(label);
+      }
+    });
+    $.each(g.nodes, function(i, n) {
+      var p = pos[n.id];
+      var node = svgElem('g', {'class': 'node '+n.kind, 'data-id': n.id});
+      node.appendChild(svgElem('rect', {x: p.x, y: p.y, width: p.w, height: nodeHeight, rx: 5}));
+      var text = svgElem('text', {x: p.x+8, y: p.y+nodeHeight/2+4});
+      text.textContent = n.label;
+      node.appendChild(text);
+      clickable(node, n.pos);
+      svg.appendChild(node);
+    });
+    $(el).empty().append(svg);
+  }
+
+  function clickable(el, pos) {
+    if (pos==null) {
+      return;
+    }
+    el.setAttribute('class', (el.getAttribute('class') || '')+' positioned');
+    $(el).on('click', function() { highlightLine(pos); });
+  }
+
+  // showStep highlights the states of step i of trace t in the element el.
+  function showStep(t, i, el) {
+    $(el).find('g.node').each(function() {
+      this.classList.remove('current', 'stuck');
+    });
+    var step = t.steps[i];
+    $.each(step.states, function(k, id) {
+      $(el).find('g.node[data-id="'+id+'"]').each(function() { this.classList.add('current'); });
+    });
+    if (i==t.steps.length-1) {
+      $.each(t.stuck, function(k, id) {
+        $(el).find('g.node[data-id="'+id+'"]').each(function() { this.classList.add('stuck'); });
+      });
+    }
+    var desc = i==0 ? 'Start' : 'Step '+i+': '+step.label;
+    if (i==t.steps.length-1) {
+      desc += ' (stuck)';
+    }
+    $('#graph-step').text(desc);
+  }
+
+  return {render: render, showStep: showStep, highlightLine: highlightLine};
+})();
+
+(function(){
+var reply = null, step = 0;
+
+// showGraph renders the graph of model in the reply.
+function showGraph(model) {
+  $('#graph-tabs button').removeClass('active');
+  $('#graph-'+model).addClass('active');
+  $('#graph-trace').toggle(model=='cfsms' && reply.trace!=null);
+  if (reply[model]==null) {
+    $('#graph-canvas').text('No graph.');
+    return;
+  }
+  graph.render(reply[model], '#graph-canvas');
+  if (model=='cfsms' && reply.trace!=null) {
+    step = 0;
+    graph.showStep(reply.trace, step, '#graph-canvas');
+  }
+}
+
+function requestGraph(model) {
+  reportTime('');
+  $.ajax({
+    url: model=='migo' ? '/graph?model=migo' : '/graph',
+    type: 'POST',
+    data: goCode(),
+    dataType: 'json',
+    async: true,
+    success: function(obj) {
+      if (reply==null || model=='migo') {
+        reply = $.extend(reply || {}, obj);
+      } else {
+        reply = $.extend(obj, {migo: reply.migo});
+      }
+      reportTime(obj.time);
+      $('#graph-wrap').addClass('visible');
+      showGraph(model);
+    },
+    error: function(xhr) {
+      reportError(xhr, '#graph-canvas');
+      $('#graph-wrap').addClass('visible');
+    }
+  });
+}
+
+$('#graph').on('click', function() {
+  reply = null;
+  requestGraph('session');
+});
+$('#graph-session').on('click', function() { showGraph('session'); });
+$('#graph-cfsms').on('click', function() { showGraph('cfsms'); });
+$('#graph-migo').on('click', function() {
+  if (reply!=null && reply.migo!=null) {
+    showGraph('migo');
+  } else {
+    requestGraph('migo');
+  }
+});
+$('#graph-prev').on('click', function() {
+  if (reply!=null && reply.trace!=null && step>0) {
+    graph.showStep(reply.trace, --step, '#graph-canvas');
+  }
+});
+$('#graph-next').on('click', function() {
+  if (reply!=null && reply.trace!=null && step<reply.trace.steps.length-1) {
+    graph.showStep(reply.trace, ++step, '#graph-canvas');
+  }
+});
+$('#graph-output-close').on('click', function() {
+  $('#graph-wrap').removeClass('visible');
+  graph.highlightLine(null);
+});
+})()
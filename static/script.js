@@ -29,6 +29,32 @@ function reportTime(t) {
     $('#time').html('');
   }
 }
+// sourceLine returns the line in the #go div of pos, which is of a file in the
+// txtar archive if the code is an archive of files.
+function sourceLine(pos) {
+  var line = pos.line;
+  if (pos.filename) {
+    $.each($('#go pre'), function(i, val) {
+      if (val.innerText.trim() == '-- '+pos.filename+' --') {
+        line = i+1+pos.line;
+        return false;
+      }
+    });
+  }
+  return line;
+}
+// reportError puts the error reported by the server into the selector div.
+function reportError(xhr, selector) {
+  var err = xhr.responseJSON;
+  var msg = 'Error: '+xhr.statusText;
+  if (err!=null && err.message!=null) {
+    msg = 'Error: '+err.message;
+    if (err.pos!=null) {
+      msg += ' (line '+sourceLine(err.pos)+', column '+err.pos.column+')';
+    }
+  }
+  writeTo($('<div/>').text(msg).html(), selector);
+}
 (function(){
 $('#ssa').on('click', function() {
   reportTime('');
@@ -40,6 +66,9 @@ $('#ssa').on('click', function() {
     success: function(msg) {
       writeTo(msg, '#out');
       $('#out').attr('lang', 'Go SSA')
+    },
+    error: function(xhr) {
+      reportError(xhr, '#out');
     }
   });
 });
@@ -59,6 +88,9 @@ $('#cfsm').on('click', function() {
       } else {
         writeTo("JSON error", '#out');
       }
+    },
+    error: function(xhr) {
+      reportError(xhr, '#out');
     }
   });
 });
@@ -78,6 +110,9 @@ $('#migo').on('click', function() {
       } else {
         writeTo("JSON error", '#out');
       }
+    },
+    error: function(xhr) {
+      reportError(xhr, '#out');
     }
   });
 });
@@ -92,6 +127,9 @@ $('#example').on('click', function() {
       writeTo(msg, '#go');
       writeTo('No output.', '#out');
       $('#out').removeAttr('lang');
+    },
+    error: function(xhr) {
+      reportError(xhr, '#out');
     }
   });
 });
@@ -114,6 +152,10 @@ $('#gong').on('click', function() {
       } else {
         writeTo("JSON error", '#gong-output');
       }
+    },
+    error: function(xhr) {
+      reportError(xhr, '#gong-output');
+      $('#gong-wrap').addClass('visible');
     }
   });
 });
@@ -141,12 +183,54 @@ $('#synthesis').on('click', function() {
       } else {
         writeTo("JSON error", '#synthesis-output');
       }
+    },
+    error: function(xhr) {
+      reportError(xhr, '#synthesis-output');
+      $('#synthesis-wrap').addClass('visible');
     }
   });
 });
 $('#synthesis-output-close').on('click', function() {
   $('#synthesis-wrap').removeClass('visible');
 })
+// currentView returns the analysis shown, to be restored from a permalink.
+function currentView() {
+  if ($('#graph-wrap').hasClass('visible')) {
+    return 'graph';
+  }
+  return {'Go SSA': 'ssa', 'CFSM': 'cfsm', 'MiGo': 'migo'}[$('#out').attr('lang')] || '';
+}
+$('#share').on('click', function() {
+  $.ajax({
+    url: '/share',
+    type: 'POST',
+    data: JSON.stringify({code: goCode(), view: currentView(), chan: parseInt($('#chan-cfsm').val()) || 0}),
+    contentType: 'application/json',
+    dataType: 'json',
+    async: true,
+    success: function(obj) {
+      var url = window.location.protocol+'//'+window.location.host+obj.path;
+      $('#share-link').empty().append($('<a/>').attr('href', url).text(url));
+      window.history.replaceState(null, '', obj.path);
+    },
+    error: function(xhr) {
+      reportError(xhr, '#out');
+    }
+  });
+});
+if (shared!=null) {
+  // Restore the shared snippet and its analysis when all scripts are loaded.
+  writeTo($('<div/>').text(shared.code.replace(/\n$/, '')).html(), '#go');
+  if (shared.chan) {
+    $('#chan-cfsm').val(shared.chan);
+  }
+  $(function() {
+    if (shared.view) {
+      $('#'+shared.view).click();
+    }
+  });
+  return;
+}
 writeTo('// Write Go code here\n'
   + 'package main\n\n'
   + 'import "fmt"\n\n'
@@ -0,0 +1,138 @@
+// Package stdlib provides built-in models of concurrency APIs in the standard
+// library and golang.org/x/sync, which are not built to SSA by default or
+// synchronise without channels.
+//
+// Functions with channel arguments are modelled by MiGo stubs (see package
+// stubs), e.g. signal.Notify is an external sender on its channel argument.
+// Functions synchronising through function arguments or receivers are
+// modelled by a Model, e.g. sync.Once.Do calls its argument and
+// errgroup.Group.Wait joins the goroutines spawned by errgroup.Group.Go, or
+// sync.WaitGroup.Wait joins the goroutines added by sync.WaitGroup.Add.
+package stdlib // import "github.com/nickng/dingo-hunter/stubs/stdlib"
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	"github.com/nickng/dingo-hunter/stubs"
+)
+
+// Kind is the behaviour of calls to a modelled function.
+type Kind int
+
+const (
+	Call   Kind = iota + 1 // Calls the function argument.
+	Spawn                  // Spawns the function argument as a goroutine.
+	Join                   // Waits for goroutines spawned with the receiver.
+	Wait                   // Waits for a notification of the receiver.
+	Notify                 // Notifies a waiter of the receiver, never blocks.
+	Add                    // Adds goroutines (constant argument) joined by the receiver.
+	Done                   // Signals a goroutine joined by the receiver is done.
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Call:
+		return "call"
+	case Spawn:
+		return "spawn"
+	case Join:
+		return "join"
+	case Wait:
+		return "wait"
+	case Notify:
+		return "notify"
+	case Add:
+		return "add"
+	case Done:
+		return "done"
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// Model is a model of calls to a function.
+type Model struct {
+	Kind   Kind
+	Arg    int    // Index of the function argument of Call, Spawn or Add (receiver is 0).
+	Method string // Method of the argument called if it is an interface, e.g. ServeHTTP.
+	Join   bool   // Spawned goroutine is joined by the receiver.
+	Repeat bool   // Spawn is repeated forever, e.g. a handler for each request.
+}
+
+// Sync returns true if the model synchronises through the receiver.
+func (m Model) Sync() bool {
+	return m.Join || m.Kind == Join || m.Kind == Wait || m.Kind == Notify || m.Kind == Add || m.Kind == Done
+}
+
+// Receiver returns true if values of type t (or pointers to t) are receivers
+// synchronised by models, and join is true if they join goroutines.
+func Receiver(models map[string]Model, t types.Type) (join, ok bool) {
+	for p, isPtr := t.Underlying().(*types.Pointer); isPtr; p, isPtr = t.Underlying().(*types.Pointer) {
+		t = p.Elem()
+	}
+	prefix := stubs.Name(types.TypeString(t, nil)) + "."
+	for name, m := range models {
+		if !m.Sync() || !strings.HasPrefix(name, prefix) || strings.Contains(name[len(prefix):], ".") {
+			continue
+		}
+		ok = true
+		join = join || m.Join || m.Kind == Join
+	}
+	return join, ok
+}
+
+// models are the models of functions by stub name.
+var models = map[string]Model{
+	"sync.Once.Do":        {Kind: Call, Arg: 1},
+	"sync.Cond.Wait":      {Kind: Wait},
+	"sync.Cond.Signal":    {Kind: Notify},
+	"sync.Cond.Broadcast": {Kind: Notify},
+	"sync.WaitGroup.Add":  {Kind: Add, Arg: 1},
+	"sync.WaitGroup.Done": {Kind: Done},
+	"sync.WaitGroup.Go":   {Kind: Spawn, Arg: 1, Join: true},
+	"sync.WaitGroup.Wait": {Kind: Join},
+
+	"golang.org_x_sync_errgroup.Group.Go":    {Kind: Spawn, Arg: 1, Join: true},
+	"golang.org_x_sync_errgroup.Group.TryGo": {Kind: Spawn, Arg: 1, Join: true},
+	"golang.org_x_sync_errgroup.Group.Wait":  {Kind: Join},
+
+	// Handlers are spawned for each request, by a loop spawned when the
+	// handler is registered.
+	"net_http.HandleFunc":          {Kind: Spawn, Arg: 1, Repeat: true},
+	"net_http.ServeMux.HandleFunc": {Kind: Spawn, Arg: 2, Repeat: true},
+	"net_http.Handle":              {Kind: Spawn, Arg: 1, Method: "ServeHTTP", Repeat: true},
+	"net_http.ServeMux.Handle":     {Kind: Spawn, Arg: 2, Method: "ServeHTTP", Repeat: true},
+}
+
+// Models returns the built-in models of functions by stub name (see
+// stubs.Name), e.g. sync.Once.Do for (*sync.Once).Do.
+func Models() map[string]Model {
+	m := make(map[string]Model, len(models))
+	for name, model := range models {
+		m[name] = model
+	}
+	return m
+}
+
+// migoStubs are the built-in MiGo stubs.
+const migoStubs = `
+-- signal.Notify delivers signals to c until stopped, without blocking.
+def os_signal.Notify(c):
+    spawn os_signal.Notify#deliver(c);
+def os_signal.Notify#deliver(c):
+    select
+      case send c;
+      case tau;
+    endselect;
+    call os_signal.Notify#deliver(c);
+`
+
+// Stubs returns the built-in MiGo stubs.
+func Stubs() *stubs.Set {
+	set, err := stubs.Parse(strings.NewReader(migoStubs))
+	if err != nil {
+		panic(fmt.Sprintf("stdlib: %v", err))
+	}
+	return set
+}
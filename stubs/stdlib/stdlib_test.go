@@ -0,0 +1,52 @@
+package stdlib
+
+import (
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+// Tests the built-in MiGo stubs are well-formed.
+func TestStubs(t *testing.T) {
+	params, ok := Stubs().Params("os_signal.Notify")
+	if !ok || len(params) != 1 {
+		t.Errorf("Expecting stub os_signal.Notify(c) but got %v\n", params)
+	}
+}
+
+// Tests receivers are identified by the models of their methods.
+func TestReceiver(t *testing.T) {
+	named := func(path, name string) types.Type {
+		pkg := types.NewPackage(path, "")
+		return types.NewPointer(types.NewNamed(types.NewTypeName(token.NoPos, pkg, name, nil), types.NewStruct(nil, nil), nil))
+	}
+	for _, tc := range []struct {
+		t        types.Type
+		join, ok bool
+	}{
+		{named("sync", "Cond"), false, true},
+		{named("golang.org/x/sync/errgroup", "Group"), true, true},
+		{named("sync", "WaitGroup"), true, true},
+		{named("sync", "Once"), false, false},
+	} {
+		if join, ok := Receiver(Models(), tc.t); join != tc.join || ok != tc.ok {
+			t.Errorf("Expecting %s to be (join=%t, ok=%t) but got (%t, %t)\n", tc.t, tc.join, tc.ok, join, ok)
+		}
+	}
+}
+
+// Tests HTTP handlers are spawned for each request, of functions and of
+// Handler values.
+func TestHandlerModels(t *testing.T) {
+	m := Models()
+	for name, method := range map[string]string{
+		"net_http.HandleFunc":          "",
+		"net_http.ServeMux.HandleFunc": "",
+		"net_http.Handle":              "ServeHTTP",
+		"net_http.ServeMux.Handle":     "ServeHTTP",
+	} {
+		if model, ok := m[name]; !ok || model.Kind != Spawn || !model.Repeat || model.Method != method {
+			t.Errorf("Expecting %s to spawn its handler %s repeatedly but got %+v\n", name, method, model)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package stubs reads hand-written models of the channel behaviour of
+// functions without SSA bodies, e.g. clients of third-party packages which are
+// not built or skipped.
+//
+// Stubs are written in MiGo. A stub is a MiGo function named after the Go
+// function as printed in MiGo output, e.g. github.com_natsio_nats.go.Conn.Subscribe
+// for (*github.com/nats-io/nats.go.Conn).Subscribe, or after the interface
+// method for calls through an interface. The parameters of a stub are the
+// channel arguments of the call followed by the channel results, which are
+// created by the caller, for example
+//
+//	-- pubsub.Subscribe returns a channel that is sent to forever.
+//	def example.com_pubsub.Subscribe(ch):
+//	    spawn example.com_pubsub.Subscribe#publish(ch);
+//	def example.com_pubsub.Subscribe#publish(ch):
+//	    send ch;
+//	    call example.com_pubsub.Subscribe#publish(ch);
+//
+// Other functions in the stub files, e.g. the publisher above, can be called or
+// spawned by the stubs.
+package stubs // import "github.com/nickng/dingo-hunter/stubs"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/migo/v3"
+)
+
+// Ext is the file extension of stub files.
+const Ext = ".migo"
+
+// nameFilter is the filter applied to names when printing MiGo.
+var nameFilter = strings.NewReplacer("(", "", ")", "", "*", "", "/", "_", "\"", "", "-", "")
+
+// Name returns the stub name of a function name, e.g. (*example.com/pkg.T).M
+// is example.com_pkg.T.M
+func Name(fn string) string {
+	return nameFilter.Replace(fn)
+}
+
+// Set is a set of stubs. A nil Set has no stubs.
+type Set struct {
+	defs map[string]*migo.Function // Parsed definitions, never handed out.
+	text map[string]string         // MiGo text of definitions.
+}
+
+// Parse reads stubs from r.
+func Parse(r io.Reader) (*Set, error) {
+	prog, err := migofile.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{defs: make(map[string]*migo.Function), text: make(map[string]string)}
+	if err := s.add(prog); err != nil {
+		return nil, err
+	}
+	return s, s.check()
+}
+
+// Load reads stubs from the files at paths, directories are read for files
+// with the Ext extension.
+func Load(paths ...string) (*Set, error) {
+	s := &Set{defs: make(map[string]*migo.Function), text: make(map[string]string)}
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*"+Ext)); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			prog, err := migofile.ParseFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.add(prog); err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+		}
+	}
+	return s, s.check()
+}
+
+// Merge returns a set with stubs of s and other, stubs of other replace stubs
+// of s with the same name.
+func (s *Set) Merge(other *Set) *Set {
+	m := &Set{defs: make(map[string]*migo.Function), text: make(map[string]string)}
+	for _, set := range []*Set{s, other} {
+		if set == nil {
+			continue
+		}
+		for name, def := range set.defs {
+			m.defs[name], m.text[name] = def, set.text[name]
+		}
+	}
+	return m
+}
+
+// add adds the definitions of prog to s with normalised names.
+func (s *Set) add(prog *migo.Program) error {
+	for _, def := range prog.Funcs {
+		def.Name = Name(def.Name)
+		walkStmts(def.Stmts, func(stmt migo.Statement) {
+			switch stmt := stmt.(type) {
+			case *migo.CallStatement:
+				stmt.Name = Name(stmt.Name)
+			case *migo.SpawnStatement:
+				stmt.Name = Name(stmt.Name)
+			}
+		})
+		if _, ok := s.defs[def.Name]; ok {
+			return fmt.Errorf("stub %s redefined", def.Name)
+		}
+		s.defs[def.Name], s.text[def.Name] = def, def.String()
+	}
+	return nil
+}
+
+// check returns an error if a stub calls or spawns an undefined stub, or with
+// the wrong number of parameters.
+func (s *Set) check() error {
+	var err error
+	for _, name := range s.Names() {
+		walkStmts(s.defs[name].Stmts, func(stmt migo.Statement) {
+			var callee string
+			var params []*migo.Parameter
+			switch stmt := stmt.(type) {
+			case *migo.CallStatement:
+				callee, params = stmt.Name, stmt.Params
+			case *migo.SpawnStatement:
+				callee, params = stmt.Name, stmt.Params
+			default:
+				return
+			}
+			if def, ok := s.defs[callee]; !ok {
+				if err == nil {
+					err = fmt.Errorf("stub %s: %s undefined", name, callee)
+				}
+			} else if len(def.Params) != len(params) {
+				if err == nil {
+					err = fmt.Errorf("stub %s: %s takes %d parameters but %d given", name, callee, len(def.Params), len(params))
+				}
+			}
+		})
+	}
+	return err
+}
+
+// Names returns the names of stubs in s (sorted).
+func (s *Set) Names() []string {
+	if s == nil {
+		return nil
+	}
+	var names []string
+	for name := range s.defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Params returns the parameter names of stub name.
+func (s *Set) Params(name string) ([]string, bool) {
+	if s == nil {
+		return nil, false
+	}
+	def, ok := s.defs[name]
+	if !ok {
+		return nil, false
+	}
+	params := make([]string, len(def.Params))
+	for i, p := range def.Params {
+		params[i] = p.Callee.Name()
+	}
+	return params, true
+}
+
+// Funcs returns copies of the definitions of stub name and the stubs it calls
+// or spawns (transitively), name first.
+func (s *Set) Funcs(name string) []*migo.Function {
+	if _, ok := s.Params(name); !ok {
+		return nil
+	}
+	names, seen := []string{name}, map[string]bool{name: true}
+	var buf strings.Builder
+	for i := 0; i < len(names); i++ {
+		buf.WriteString(s.text[names[i]])
+		walkStmts(s.defs[names[i]].Stmts, func(stmt migo.Statement) {
+			var callee string
+			switch stmt := stmt.(type) {
+			case *migo.CallStatement:
+				callee = stmt.Name
+			case *migo.SpawnStatement:
+				callee = stmt.Name
+			}
+			if callee != "" && !seen[callee] {
+				seen[callee] = true
+				names = append(names, callee)
+			}
+		})
+	}
+	prog, err := migofile.Parse(strings.NewReader(buf.String()))
+	if err != nil {
+		return nil
+	}
+	return prog.Funcs
+}
+
+// Key returns a string identifying the stubs in s, e.g. for cache keys.
+func (s *Set) Key() string {
+	if s == nil || len(s.defs) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, name := range s.Names() {
+		io.WriteString(h, s.text[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// walkStmts calls f on stmts and the statements nested in them.
+func walkStmts(stmts []migo.Statement, f func(migo.Statement)) {
+	for _, stmt := range stmts {
+		f(stmt)
+		switch stmt := stmt.(type) {
+		case *migo.IfStatement:
+			walkStmts(stmt.Then, f)
+			walkStmts(stmt.Else, f)
+		case *migo.IfForStatement:
+			walkStmts(stmt.Then, f)
+			walkStmts(stmt.Else, f)
+		case *migo.SelectStatement:
+			for _, c := range stmt.Cases {
+				walkStmts(c, f)
+			}
+		}
+	}
+}
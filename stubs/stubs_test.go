@@ -0,0 +1,54 @@
+package stubs
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests stubs are read with names as printed in MiGo.
+func TestParse(t *testing.T) {
+	s, err := Parse(strings.NewReader(`
+def example.com/pubsub.Subscribe(ch):
+    spawn example.com/pubsub.Subscribe#publish(ch);
+def example.com/pubsub.Subscribe#publish(ch):
+    send ch;
+    call example.com/pubsub.Subscribe#publish(ch);
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := Name("(*example.com/pubsub.PubSub).Subscribe")
+	if name != "example.com_pubsub.PubSub.Subscribe" {
+		t.Errorf("Expecting stub name example.com_pubsub.PubSub.Subscribe but got %s\n", name)
+	}
+	params, ok := s.Params(Name("example.com/pubsub.Subscribe"))
+	if !ok || len(params) != 1 || params[0] != "ch" {
+		t.Errorf("Expecting stub of Subscribe with parameter ch but got %v\n", params)
+	}
+	funcs := s.Funcs(Name("example.com/pubsub.Subscribe"))
+	if len(funcs) != 2 || funcs[1].Name != "example.com_pubsub.Subscribe#publish" {
+		t.Fatalf("Expecting Subscribe and its publisher but got %v\n", funcs)
+	}
+	funcs[0].Stmts = nil
+	if again := s.Funcs(Name("example.com/pubsub.Subscribe")); len(again[0].Stmts) != 1 {
+		t.Errorf("Expecting stubs to be copied but got\n%s\n", again[0])
+	}
+}
+
+// Tests stubs calling undefined stubs are rejected.
+func TestUndefined(t *testing.T) {
+	if _, err := Parse(strings.NewReader(`
+def main.f(ch):
+    call main.g(ch);
+`)); err == nil {
+		t.Errorf("Expecting error for undefined main.g\n")
+	}
+	if _, err := Parse(strings.NewReader(`
+def main.f(ch):
+    call main.g(ch);
+def main.g(a, b):
+    send a;
+`)); err == nil {
+		t.Errorf("Expecting error for main.g called with 1 parameter\n")
+	}
+}
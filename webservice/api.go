@@ -0,0 +1,316 @@
+package webservice
+
+// Versioned JSON API for scripts, e.g. CI jobs, under /api/v1/.
+//
+// Requests and responses are JSON documents with snake_case keys, errors are
+// reported as Error with a HTTP status code. The schemas are documented by
+// the types below and in the README.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nickng/dingo-hunter/cfsmextract"
+	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
+	"github.com/nickng/dingo-hunter/leakcheck"
+	"github.com/nickng/dingo-hunter/migoextract"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+)
+
+// APIVersion is the version of the JSON API.
+const APIVersion = "v1"
+
+const maxRequest = 1 << 22 // Bytes of a request body.
+
+// Extractors of the JSON API.
+const (
+	ExtractMiGo = "migo" // MiGo types, checked for goroutine leaks.
+	ExtractCFSM = "cfsm" // Communicating finite state machines.
+)
+
+// AnalyseRequest is the request body of POST /api/v1/analyse.
+type AnalyseRequest struct {
+	Files     map[string]string `json:"files"`              // Source code by filename, of package main or a module with go.mod.
+	Extractor string            `json:"extractor"`          // ExtractMiGo (default) or ExtractCFSM.
+	Simplify  *bool             `json:"simplify,omitempty"` // Simplify MiGo types (default true).
+	Bounds    Bounds            `json:"bounds"`             // Bounds of extraction and verification.
+}
+
+// Bounds are the bounds of extraction, zero means no bound. Parts of the code
+// over the bounds are left out of the model, see Diagnostic. Checking MiGo
+// types for leaks is not bounded, the search of CFSMs for deadlocks is bounded
+// by MaxConfigs.
+type Bounds struct {
+	MaxUnroll    int64 `json:"max_unroll"`    // Maximum iterations of a static loop to unroll (MiGo only).
+	MaxDepth     int   `json:"max_depth"`     // Maximum depth of calls to visit.
+	MaxInstances int   `json:"max_instances"` // Maximum calls or spawns of a function to visit.
+	MaxConfigs   int   `json:"max_configs"`   // Maximum configurations of CFSMs to search for deadlocks (CFSM only, zero for 10000).
+}
+
+// AnalyseResponse is the response body of POST /api/v1/analyse.
+type AnalyseResponse struct {
+	Version     string       `json:"version"`
+	Extractor   string       `json:"extractor"`
+	Model       Model        `json:"model"`
+	Findings    []Finding    `json:"findings"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Timing      Timing       `json:"timing"`
+}
+
+// Model is the extracted model, fields of other extractors are omitted.
+type Model struct {
+	MiGo string `json:"migo,omitempty"` // MiGo types.
+	CFSM string `json:"cfsm,omitempty"` // CFSMs.
+	Dot  string `json:"dot,omitempty"`  // Graphviz of the CFSM session.
+}
+
+// Kinds of findings.
+const (
+	FindingLeak     = "leak"     // Goroutine blocked forever.
+	FindingDeadlock = "deadlock" // Main goroutine (MiGo) or CFSMs blocked forever.
+	FindingNilChan  = "nil_chan" // Operation on nil channel.
+)
+
+// Finding is a problem found in the analysed code.
+type Finding struct {
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+	Pos     *Position `json:"pos,omitempty"`
+}
+
+// Diagnostic is a part of the code left out of the model by the bounds.
+type Diagnostic struct {
+	Message string    `json:"message"`
+	Pos     *Position `json:"pos,omitempty"`
+}
+
+// Timing is the time taken by each step of an analysis in milliseconds.
+type Timing struct {
+	Build   float64 `json:"build_ms"`
+	Extract float64 `json:"extract_ms"`
+	Check   float64 `json:"check_ms"`
+	Total   float64 `json:"total_ms"`
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func apiNotFoundHandler(w http.ResponseWriter, req *http.Request) error {
+	return NewErrNotFound(nil, fmt.Sprintf("No API endpoint %s", req.URL.Path))
+}
+
+func apiAnalyseHandler(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodPost {
+		return newError(http.StatusMethodNotAllowed, nil, fmt.Sprintf("Method %s not allowed", req.Method))
+	}
+	var areq AnalyseRequest
+	if err := json.NewDecoder(req.Body).Decode(&areq); err != nil {
+		return NewErrBadInput(err, "Cannot decode request")
+	}
+	ctx, cancel := withLimits(req.Context(), AnalysisLimits)
+	defer cancel()
+	resp, err := analyse(ctx, &areq)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// validate checks areq can be analysed and sets the default extractor.
+func (areq *AnalyseRequest) validate() error {
+	if areq.Extractor == "" {
+		areq.Extractor = ExtractMiGo
+	}
+	if areq.Extractor != ExtractMiGo && areq.Extractor != ExtractCFSM {
+		return NewErrBadInput(nil, fmt.Sprintf("Unknown extractor %q", areq.Extractor))
+	}
+	if len(areq.Files) == 0 {
+		return NewErrBadInput(nil, "No files")
+	}
+	return nil
+}
+
+// analyse runs the analysis of areq until ctx is cancelled. Building SSA
+// cannot be cancelled, so ctx is checked after the build.
+func analyse(ctx context.Context, areq *AnalyseRequest) (*AnalyseResponse, error) {
+	if err := areq.validate(); err != nil {
+		return nil, err
+	}
+	resp := &AnalyseResponse{Version: APIVersion, Extractor: areq.Extractor, Findings: []Finding{}, Diagnostics: []Diagnostic{}}
+	start := time.Now()
+	conf, err := ssabuilder.NewConfigFromSources(areq.Files)
+	if err != nil {
+		return nil, NewErrBadInput(err, "Cannot initialise SSA")
+	}
+	conf.Env = GoEnv
+	info, err := conf.Build()
+	if err != nil {
+		return nil, NewErrBadInput(err, "Cannot build SSA")
+	}
+	if ctx.Err() != nil {
+		return nil, newErrStopped(ctx, "Cannot build SSA")
+	}
+	resp.Timing.Build = millis(time.Since(start))
+	switch areq.Extractor {
+	case ExtractMiGo:
+		err = analyseMiGo(ctx, areq, info, resp)
+	case ExtractCFSM:
+		err = analyseCFSM(ctx, areq, info, resp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Timing.Total = millis(time.Since(start))
+	return resp, nil
+}
+
+func analyseMiGo(ctx context.Context, areq *AnalyseRequest, info *ssabuilder.SSAInfo, resp *AnalyseResponse) error {
+	extract, err := migoextract.New(info, ioutil.Discard)
+	if err != nil {
+		return NewErrInternal(err, "Cannot initialise MiGo type inference")
+	}
+	extract.Limits = migoextract.Limits{
+		MaxUnroll:    areq.Bounds.MaxUnroll,
+		MaxDepth:     areq.Bounds.MaxDepth,
+		MaxInstances: areq.Bounds.MaxInstances,
+	}
+	if err := runExtract(ctx, extract.RunContext, extract.Error, extract.Done, "MiGo type inference failed"); err != nil {
+		return err
+	}
+	resp.Timing.Extract = millis(extract.Time)
+
+	start := time.Now()
+	pos := positioner(info)
+	for _, d := range extract.Env.Diagnostics {
+		resp.Diagnostics = append(resp.Diagnostics, Diagnostic{Message: d.Msg, Pos: pos(d.Pos)})
+	}
+	for _, op := range extract.Env.NilChanOps {
+		resp.Findings = append(resp.Findings, Finding{
+			Kind:    FindingNilChan,
+			Message: op.String(),
+			Pos:     pos(op.Pos),
+		})
+	}
+	if areq.Simplify == nil || *areq.Simplify {
+		migofile.Simplify(extract.Env.MigoProg)
+	}
+	for _, f := range leakcheck.Check(extract.Env.MigoProg) {
+		kind := FindingLeak
+		if f.Kind == leakcheck.Deadlock {
+			kind = FindingDeadlock
+		}
+		resp.Findings = append(resp.Findings, Finding{
+			Kind:    kind,
+			Message: fmt.Sprintf("%s blocks forever on %s in %s", f.Proc, f.Op, f.Func),
+			Pos:     pos(extract.Env.StmtPos[f.Op]),
+		})
+	}
+	resp.Timing.Check = millis(time.Since(start))
+	resp.Model.MiGo = extract.Env.MigoProg.String()
+	return nil
+}
+
+func analyseCFSM(ctx context.Context, areq *AnalyseRequest, info *ssabuilder.SSAInfo, resp *AnalyseResponse) error {
+	ws, cleanup, err := newWorkspace()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	extract := cfsmextract.New(info, "extract", ws)
+	extract.Limits = cfsmextract.Limits{MaxDepth: areq.Bounds.MaxDepth, MaxInstances: areq.Bounds.MaxInstances}
+	if err := runExtract(ctx, extract.RunContext, extract.Error, extract.Done, "CFSM extraction failed"); err != nil {
+		return err
+	}
+	resp.Timing.Extract = millis(extract.Time)
+	pos := positioner(info)
+	for _, d := range extract.Diagnostics {
+		resp.Diagnostics = append(resp.Diagnostics, Diagnostic{Message: d.Msg, Pos: pos(d.Pos)})
+	}
+
+	start := time.Now()
+	sys := sesstype.NewCFSMs(extract.Session())
+	limit := areq.Bounds.MaxConfigs
+	if limit == 0 {
+		limit = traceLimit
+	}
+	resp.Findings = append(resp.Findings, stuckFindings(sys, limit, pos)...)
+	resp.Timing.Check = millis(time.Since(start))
+	var cfsms, dot bytes.Buffer
+	sys.WriteTo(&cfsms)
+	sesstype.NewGraphvizDot(extract.Session()).WriteTo(&dot)
+	resp.Model.CFSM = cfsms.String()
+	resp.Model.Dot = dot.String()
+	return nil
+}
+
+// stuckFindings returns a deadlock finding of each machine stuck at the end of
+// the trace to a stuck configuration of sys within limit configurations, at
+// the position of the communications the machine waits for.
+func stuckFindings(sys *sesstype.CFSMs, limit int, pos func(token.Pos) *Position) []Finding {
+	trace := sys.StuckTrace(limit)
+	if trace == nil {
+		return nil
+	}
+	g := sys.Graph()
+	groups := make(map[string]*sesstype.GraphGroup)
+	for _, gr := range g.Groups {
+		groups[gr.ID] = gr
+	}
+	nodes := make(map[string]*sesstype.GraphNode)
+	for _, n := range g.Nodes {
+		nodes[n.ID] = n
+	}
+	waits := make(map[string][]*sesstype.GraphEdge)
+	for _, e := range g.Edges {
+		waits[e.From] = append(waits[e.From], e)
+	}
+	var findings []Finding
+	for _, id := range trace.Stuck {
+		n, ok := nodes[id]
+		if !ok {
+			continue
+		}
+		f := Finding{Kind: FindingDeadlock}
+		var labels []string
+		for _, e := range waits[id] {
+			labels = append(labels, e.Label)
+			if f.Pos == nil {
+				f.Pos = pos(e.Pos)
+			}
+		}
+		if f.Pos == nil {
+			f.Pos = pos(groups[n.Group].Pos)
+		}
+		machine := "goroutine"
+		if groups[n.Group].Kind == "chan" {
+			machine = "channel"
+		}
+		f.Message = fmt.Sprintf("%s %s stuck in state %s after %d communications", machine, groups[n.Group].Label, n.Label, len(trace.Steps)-1)
+		if len(labels) > 0 {
+			f.Message += fmt.Sprintf(", waiting for %s", strings.Join(labels, " or "))
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+// positioner returns a function converting positions in info to Position, or
+// nil if not in the source code.
+func positioner(info *ssabuilder.SSAInfo) func(token.Pos) *Position {
+	return func(p token.Pos) *Position {
+		if !p.IsValid() {
+			return nil
+		}
+		return newPosition(info.FSet.Position(p))
+	}
+}
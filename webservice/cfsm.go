@@ -3,38 +3,30 @@ package webservice
 import (
 	"bytes"
 	"encoding/json"
-	"io/ioutil"
 	"log"
 	"net/http"
 
 	"github.com/nickng/dingo-hunter/cfsmextract"
 	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
-	"github.com/nickng/dingo-hunter/ssabuilder"
 )
 
-func cfsmHandler(w http.ResponseWriter, req *http.Request) {
-	b, err := ioutil.ReadAll(req.Body)
+func cfsmHandler(w http.ResponseWriter, req *http.Request) error {
+	ssainfo, err := buildSSA(req)
 	if err != nil {
-		NewErrInternal(err, "Cannot read input Go source code").Report(w)
+		return err
 	}
-	req.Body.Close()
-	conf, err := ssabuilder.NewConfigFromString(string(b))
+	ctx, cancel := withLimits(req.Context(), AnalysisLimits)
+	defer cancel()
+	ws, cleanup, err := newWorkspace()
 	if err != nil {
-		NewErrInternal(err, "Cannot initialise SSA").Report(w)
+		return err
 	}
-	ssainfo, err := conf.Build()
-	if err != nil {
-		NewErrInternal(err, "Cannot build SSA").Report(w)
-	}
-	extract := cfsmextract.New(ssainfo, "extract", "/tmp")
-	go extract.Run()
-
-	select {
-	case <-extract.Error:
-		NewErrInternal(err, "CFSM extraction failed").Report(w)
-	case <-extract.Done:
-		log.Println("CFSMs: analysis completed in", extract.Time)
+	defer cleanup()
+	extract := cfsmextract.New(ssainfo, "extract", ws)
+	if err := runExtract(ctx, extract.RunContext, extract.Error, extract.Done, "CFSM extraction failed"); err != nil {
+		return err
 	}
+	log.Println("CFSMs: analysis completed in", extract.Time)
 	cfsms := sesstype.NewCFSMs(extract.Session())
 	bufCfsm := new(bytes.Buffer)
 	cfsms.WriteTo(bufCfsm)
@@ -50,5 +42,5 @@ func cfsmHandler(w http.ResponseWriter, req *http.Request) {
 		Dot:  bufDot.String(),
 		Time: extract.Time.String(),
 	}
-	json.NewEncoder(w).Encode(&reply)
+	return json.NewEncoder(w).Encode(&reply)
 }
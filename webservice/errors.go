@@ -1,26 +1,86 @@
 package webservice
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"go/scanner"
+	"go/token"
 	"log"
 	"net/http"
 )
 
-type ErrInternal struct {
-	cause error
-	msg   string
+// Error is an error reported to web clients as JSON, for example
+//
+//	{"code":400,"message":"Cannot build SSA: 4:2: undefined: x","pos":{"line":4,"column":2}}
+type Error struct {
+	Code    int       `json:"code"` // HTTP status code.
+	Message string    `json:"message"`
+	Pos     *Position `json:"pos,omitempty"` // Position in the input, if known.
 }
 
-func NewErrInternal(cause error, message string) *ErrInternal {
-	return &ErrInternal{cause: cause, msg: message}
+// Position is a position in the input source code.
+type Position struct {
+	Filename string `json:"filename,omitempty"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
 }
 
-func (e *ErrInternal) Error() string {
-	return fmt.Sprintf("%s: %v", e.msg, e.cause)
+func newError(code int, cause error, message string) *Error {
+	e := &Error{Code: code, Message: message}
+	if cause != nil {
+		e.Message = fmt.Sprintf("%s: %v", message, cause)
+	}
+	return e
 }
 
-// Report sends internal server error to web client also logs to console.
-func (e *ErrInternal) Report(w http.ResponseWriter) {
-	http.Error(w, e.Error(), http.StatusInternalServerError)
-	log.Fatal(e)
+// NewErrInternal returns an error of the server, e.g. missing executables.
+func NewErrInternal(cause error, message string) *Error {
+	return newError(http.StatusInternalServerError, cause, message)
+}
+
+// NewErrBadInput returns an error caused by the input, e.g. invalid Go source
+// code. The position of the error is taken from syntax or type errors.
+func NewErrBadInput(cause error, message string) *Error {
+	e := newError(http.StatusBadRequest, cause, message)
+	switch err := cause.(type) {
+	case scanner.ErrorList:
+		if len(err) > 0 {
+			e.Pos = newPosition(err[0].Pos)
+		}
+	case *scanner.Error:
+		e.Pos = newPosition(err.Pos)
+	}
+	return e
+}
+
+// NewErrNotFound returns an error for requests of missing resources.
+func NewErrNotFound(cause error, message string) *Error {
+	return newError(http.StatusNotFound, cause, message)
+}
+
+// newErrStopped returns an error of an analysis stopped by ctx, e.g. on
+// exceeding its limits.
+func newErrStopped(ctx context.Context, message string) *Error {
+	cause := context.Cause(ctx)
+	if cause == ErrTimeLimit || cause == ErrMemoryLimit {
+		return newError(http.StatusUnprocessableEntity, cause, message)
+	}
+	return newError(http.StatusServiceUnavailable, cause, message)
+}
+
+func newPosition(pos token.Position) *Position {
+	return &Position{Filename: pos.Filename, Line: pos.Line, Column: pos.Column}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Report sends the error to web client as JSON also logs to console.
+func (e *Error) Report(w http.ResponseWriter) {
+	log.Printf("Error %d: %s", e.Code, e.Message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(e)
 }
@@ -0,0 +1,258 @@
+package webservice
+
+// Programs of the playground are run by an Executor, Exec. Running programs is
+// disabled by default (Exec is nil) and enabled by setting Exec, e.g. to a
+// Sandbox, which builds and runs each program in its own workspace with
+// resource limits.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickng/dingo-hunter/ssabuilder"
+)
+
+// Executor runs Go programs, e.g. of the playground.
+type Executor interface {
+	// Run builds the program of the main package src, the source code of a
+	// file or a txtar archive of files, and runs it until it exits or ctx is
+	// cancelled. The output of the build and of the program is
+	// written to stdout and stderr.
+	Run(ctx context.Context, src string, stdout, stderr io.Writer) error
+}
+
+var (
+	// Exec runs the programs of the playground, nil (the default) disables
+	// running programs (static analysis is still available).
+	Exec Executor
+
+	ErrExecDisabled    = errors.New("running programs is disabled")
+	ErrExecTimeLimit   = errors.New("program time limit exceeded")
+	ErrExecOutputLimit = errors.New("program output limit exceeded")
+)
+
+// Sandbox is an Executor which builds each program in a temporary workspace,
+// with its own GOPATH and module cache, and runs it within limits. Programs
+// wait for their turn to run beyond the number of programs run at the same
+// time.
+//
+// The environment of the go tool and of the programs disables the module
+// proxy, cgo and HTTP proxies, so neither fetches from the network. This is
+// not isolation from the host: programs can still open connections and files
+// directly, so servers open to untrusted users should run the sandbox in a
+// container without network, or disable execution.
+//
+// Memory and CPU are resource limits of the program (not of the build), set
+// as soon as it starts, and only on Linux: elsewhere they are not enforced.
+type Sandbox struct {
+	Timeout time.Duration // Time to build and run a program, zero for no limit.
+	Output  int64         // Bytes of output of a program, zero for no limit.
+	Memory  uint64        // Bytes of address space of a program, zero for no limit.
+	CPU     time.Duration // CPU time of a program, zero for no limit.
+
+	runs      chan struct{} // Semaphore of programs run at the same time.
+	cacheOnce sync.Once
+	cache     string // Build cache shared by runs, so packages are built once.
+	cacheErr  error
+}
+
+// NewSandbox returns a Sandbox with the given limits, which runs up to runs
+// programs at the same time (zero for no limit).
+func NewSandbox(timeout time.Duration, output int64, runs int) *Sandbox {
+	s := &Sandbox{Timeout: timeout, Output: output}
+	if runs > 0 {
+		s.runs = make(chan struct{}, runs)
+	}
+	return s
+}
+
+// Run builds and runs src, and returns ErrExecTimeLimit or ErrExecOutputLimit
+// if the program was stopped by the limits. The time limit starts when the
+// program gets its turn to run.
+func (s *Sandbox) Run(ctx context.Context, src string, stdout, stderr io.Writer) error {
+	if s.runs != nil {
+		select {
+		case s.runs <- struct{}{}:
+			defer func() { <-s.runs }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		return err
+	}
+	s.cacheOnce.Do(func() {
+		s.cache, s.cacheErr = ioutil.TempDir("", "dingo-hunter-gocache-")
+	})
+	if s.cacheErr != nil {
+		return s.cacheErr
+	}
+	ws, cleanup, err := newWorkspace()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	// Not in ws, the temporary directory of the go tool, where go.mod is
+	// ignored.
+	dir := filepath.Join(ws, "prog.src")
+	pkg, err := writeProgram(dir, src)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, s.Timeout, ErrExecTimeLimit)
+		defer cancel()
+	}
+	out := &outputLimit{remain: s.Output, stop: stop}
+	env := s.environ(ws, gotool)
+
+	bin := filepath.Join(ws, "prog")
+	build := exec.CommandContext(ctx, gotool, append([]string{"build", "-o", bin}, pkg...)...)
+	build.Dir, build.Env = dir, append(env, "GOCACHE="+s.cache)
+	w := out.writer(stderr) // Output of the build is reported as errors.
+	build.Stdout, build.Stderr = w, w
+	if err := build.Run(); err != nil {
+		return stopped(ctx, err)
+	}
+	run := exec.CommandContext(ctx, bin)
+	run.Dir, run.Env = ws, env
+	run.Stdout, run.Stderr = out.writer(stdout), out.writer(stderr)
+	run.WaitDelay = time.Second // Do not wait for output of leftover children.
+	if err := run.Start(); err != nil {
+		return stopped(ctx, err)
+	}
+	if err := s.limit(run.Process.Pid); err != nil {
+		run.Process.Kill()
+		run.Wait()
+		return err
+	}
+	return stopped(ctx, run.Wait())
+}
+
+// writeProgram writes src to dir, the source code of a single file or a txtar
+// archive of files, and returns the arguments of go build of the program: the
+// module in dir if there is a go.mod file, or else the Go files.
+func writeProgram(dir, src string) ([]string, error) {
+	files := ssabuilder.ArchiveFiles([]byte(src))
+	if files == nil {
+		files = map[string]string{"prog.go": src}
+	}
+	var gofiles []string
+	for name, data := range files {
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("invalid filename %q", name)
+		}
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			return nil, err
+		}
+		if filepath.Ext(name) == ".go" && !strings.Contains(name, "/") {
+			gofiles = append(gofiles, name)
+		}
+	}
+	if _, ok := files["go.mod"]; ok {
+		return []string{"."}, nil
+	}
+	sort.Strings(gofiles)
+	return gofiles, nil
+}
+
+// environ returns the environment of the go tool and programs run in ws.
+func (s *Sandbox) environ(ws, gotool string) []string {
+	gopath := filepath.Join(ws, "gopath")
+	noProxy := "http://127.0.0.1:0"
+	return []string{
+		"PATH=" + filepath.Dir(gotool),
+		"HOME=" + ws,
+		"TMPDIR=" + ws,
+		"GOPATH=" + gopath,
+		"GOMODCACHE=" + filepath.Join(gopath, "pkg", "mod"),
+		"GOENV=off",
+		"GOFLAGS=-mod=mod",
+		"GOPROXY=off",
+		"GOSUMDB=off",
+		"GOTOOLCHAIN=local",
+		"CGO_ENABLED=0",
+		"HTTP_PROXY=" + noProxy, "HTTPS_PROXY=" + noProxy, "ALL_PROXY=" + noProxy,
+		"http_proxy=" + noProxy, "https_proxy=" + noProxy, "all_proxy=" + noProxy,
+		"NO_PROXY=", "no_proxy=",
+	}
+}
+
+// stopped returns the cause of stopping a command by ctx, or err of the
+// command.
+func stopped(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// outputLimit limits the output of writers to remain bytes in total, and stops
+// the program when the output exceeds the limit.
+type outputLimit struct {
+	mu     sync.Mutex
+	remain int64 // Bytes left to write, zero for no limit.
+	over   bool
+	stop   context.CancelCauseFunc
+}
+
+func (o *outputLimit) writer(w io.Writer) io.Writer {
+	if o.remain == 0 {
+		return w
+	}
+	return &limitedWriter{w: w, limit: o}
+}
+
+type limitedWriter struct {
+	w     io.Writer
+	limit *outputLimit
+}
+
+func (lw *limitedWriter) Write(b []byte) (int, error) {
+	o := lw.limit
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.over {
+		return 0, ErrExecOutputLimit
+	}
+	n := len(b)
+	if int64(len(b)) > o.remain {
+		b = b[:o.remain]
+		o.over = true
+		o.stop(ErrExecOutputLimit)
+	}
+	o.remain -= int64(len(b))
+	if _, err := lw.w.Write(b); err != nil {
+		return 0, err
+	}
+	if o.over {
+		return len(b), ErrExecOutputLimit
+	}
+	return n, nil
+}
+
+// noExec is the Executor when running programs is disabled.
+type noExec struct{}
+
+func (noExec) Run(ctx context.Context, src string, stdout, stderr io.Writer) error {
+	return ErrExecDisabled
+}
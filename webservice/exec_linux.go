@@ -0,0 +1,25 @@
+package webservice
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// limit sets the resource limits of the sandbox to the program run as process
+// pid. The program is sent SIGXCPU when it exceeds its CPU time, and killed a
+// second later.
+func (s *Sandbox) limit(pid int) error {
+	if s.Memory > 0 {
+		if err := unix.Prlimit(pid, unix.RLIMIT_AS, &unix.Rlimit{Cur: s.Memory, Max: s.Memory}, nil); err != nil {
+			return err
+		}
+	}
+	if s.CPU > 0 {
+		sec := uint64((s.CPU + time.Second - 1) / time.Second)
+		if err := unix.Prlimit(pid, unix.RLIMIT_CPU, &unix.Rlimit{Cur: sec, Max: sec + 1}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
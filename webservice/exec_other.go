@@ -0,0 +1,8 @@
+//go:build !linux
+
+package webservice
+
+// limit does not set resource limits outside Linux.
+func (s *Sandbox) limit(pid int) error {
+	return nil
+}
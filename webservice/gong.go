@@ -5,37 +5,42 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"time"
 )
 
-func gongHandler(w http.ResponseWriter, req *http.Request) {
+func gongHandler(w http.ResponseWriter, req *http.Request) error {
 	log.Println("Running Gong on snippet")
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		NewErrInternal(err, "Cannot read input MiGo types").Report(w)
+		return NewErrBadInput(err, "Cannot read input MiGo types")
 	}
 	req.Body.Close()
-	file, err := ioutil.TempFile(os.TempDir(), "gong")
+	Gong, err := exec.LookPath("Gong")
 	if err != nil {
-		NewErrInternal(err, "Cannot create temp file for MiGo input").Report(w)
-	}
-	defer os.Remove(file.Name())
-
-	if _, err := file.Write(b); err != nil {
-		NewErrInternal(err, "Cannot write to temp file for MiGo input").Report(w)
-	}
-	if err := file.Close(); err != nil {
-		NewErrInternal(err, "Cannot close temp file for MiGo input").Report(w)
+		return NewErrInternal(err, "Cannot find Gong executable (Check $PATH?)")
 	}
-	Gong, err := exec.LookPath("Gong")
+	ws, cleanup, err := newWorkspace()
 	if err != nil {
-		NewErrInternal(err, "Cannot find Gong executable (Check $PATH?)").Report(w)
+		return err
+	}
+	defer cleanup()
+	file := path.Join(ws, "input.migo")
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		return NewErrInternal(err, "Cannot write MiGo input")
 	}
+	ctx, cancel := withLimits(req.Context(), AnalysisLimits)
+	defer cancel()
 	startTime := time.Now()
-	out, err := exec.Command(Gong, file.Name()).CombinedOutput()
+	cmd := exec.CommandContext(ctx, Gong, file)
+	cmd.Dir = ws
+	cmd.WaitDelay = time.Second // Do not wait for output of leftover children.
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return newErrStopped(ctx, "Gong stopped")
+	}
 	if err != nil {
 		log.Printf("Gong execution failed: %v\n", err)
 	}
@@ -49,5 +54,5 @@ func gongHandler(w http.ResponseWriter, req *http.Request) {
 		Time: execTime.String(),
 	}
 	log.Println("Gong completed in", execTime.String())
-	json.NewEncoder(w).Encode(&reply)
+	return json.NewEncoder(w).Encode(&reply)
 }
@@ -0,0 +1,170 @@
+package webservice
+
+// Graphs of the models for rendering in the browser (see static/graph.js),
+// with positions in the source code of the nodes and edges.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nickng/dingo-hunter/cfsmextract"
+	"github.com/nickng/dingo-hunter/cfsmextract/sesstype"
+	"github.com/nickng/dingo-hunter/leakcheck"
+	"github.com/nickng/dingo-hunter/migoextract"
+	"github.com/nickng/dingo-hunter/migofile"
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"github.com/nickng/migo/v3"
+)
+
+// traceLimit is the number of configurations of CFSMs to search for a stuck
+// trace.
+const traceLimit = 10000
+
+// Graph is a graph of a model, see sesstype.Graph.
+type Graph struct {
+	Groups []GraphGroup `json:"groups"`
+	Nodes  []GraphNode  `json:"nodes"`
+	Edges  []GraphEdge  `json:"edges"`
+}
+
+// GraphGroup is a group of nodes of a Graph.
+type GraphGroup struct {
+	ID    string    `json:"id"`
+	Label string    `json:"label"`
+	Kind  string    `json:"kind"`
+	Pos   *Position `json:"pos,omitempty"`
+}
+
+// GraphNode is a node of a Graph.
+type GraphNode struct {
+	ID    string    `json:"id"`
+	Group string    `json:"group"`
+	Kind  string    `json:"kind"`
+	Label string    `json:"label"`
+	Pos   *Position `json:"pos,omitempty"`
+}
+
+// GraphEdge is an edge between nodes of a Graph.
+type GraphEdge struct {
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Label string    `json:"label,omitempty"`
+	Pos   *Position `json:"pos,omitempty"`
+}
+
+// newGraph converts g with positions in info.
+func newGraph(g *sesstype.Graph, info *ssabuilder.SSAInfo) *Graph {
+	pos := positioner(info)
+	graph := &Graph{Groups: []GraphGroup{}, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, gr := range g.Groups {
+		graph.Groups = append(graph.Groups, GraphGroup{ID: gr.ID, Label: gr.Label, Kind: gr.Kind, Pos: pos(gr.Pos)})
+	}
+	for _, n := range g.Nodes {
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: n.ID, Group: n.Group, Kind: n.Kind, Label: n.Label, Pos: pos(n.Pos)})
+	}
+	for _, e := range g.Edges {
+		graph.Edges = append(graph.Edges, GraphEdge{From: e.From, To: e.To, Label: e.Label, Pos: pos(e.Pos)})
+	}
+	return graph
+}
+
+// graphHandler replies the graphs of the session and CFSMs with the trace to
+// a stuck configuration of the CFSMs if any, or with ?model=migo the graph of
+// calls and spawns of MiGo functions.
+func graphHandler(w http.ResponseWriter, req *http.Request) error {
+	info, err := buildSSA(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withLimits(req.Context(), AnalysisLimits)
+	defer cancel()
+	reply := struct {
+		Session *Graph          `json:"session,omitempty"`
+		CFSMs   *Graph          `json:"cfsms,omitempty"`
+		Trace   *sesstype.Trace `json:"trace,omitempty"`
+		MiGo    *Graph          `json:"migo,omitempty"`
+		Time    string          `json:"time"`
+	}{}
+	start := time.Now()
+	if req.URL.Query().Get("model") == "migo" {
+		extract, err := migoextract.New(info, ioutil.Discard)
+		if err != nil {
+			return NewErrInternal(err, "Cannot initialise MiGo type inference")
+		}
+		if err := runExtract(ctx, extract.RunContext, extract.Error, extract.Done, "MiGo type inference failed"); err != nil {
+			return err
+		}
+		migofile.Simplify(extract.Env.MigoProg)
+		reply.MiGo = migoGraph(extract.Env, info)
+	} else {
+		ws, cleanup, err := newWorkspace()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		extract := cfsmextract.New(info, "extract", ws)
+		if err := runExtract(ctx, extract.RunContext, extract.Error, extract.Done, "CFSM extraction failed"); err != nil {
+			return err
+		}
+		cfsms := sesstype.NewCFSMs(extract.Session())
+		reply.Session = newGraph(sesstype.NewSessionGraph(extract.Session()), info)
+		reply.CFSMs = newGraph(cfsms.Graph(), info)
+		reply.Trace = cfsms.StuckTrace(traceLimit)
+	}
+	reply.Time = time.Since(start).String()
+	log.Println("Graph: analysis completed in", reply.Time)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(&reply)
+}
+
+// migoGraph returns the graph of MiGo functions in prog, with edges of calls
+// and spawns. Functions with operations blocked forever are of kind "blocked".
+func migoGraph(prog *migoextract.Program, info *ssabuilder.SSAInfo) *Graph {
+	pos := positioner(info)
+	blocked := make(map[string]bool)
+	for _, f := range leakcheck.Check(prog.MigoProg) {
+		blocked[f.Func] = true
+	}
+	graph := &Graph{Groups: []GraphGroup{{ID: "migo", Label: "MiGo", Kind: "migo"}}, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	defined := make(map[string]bool)
+	for _, f := range prog.MigoProg.Funcs {
+		kind := "func"
+		if blocked[f.Name] || blocked[f.SimpleName()] {
+			kind = "blocked"
+		}
+		defined[f.SimpleName()] = true
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: f.SimpleName(), Group: "migo", Kind: kind, Label: f.SimpleName(), Pos: pos(prog.FuncPos[f.Name])})
+	}
+	var visit func(fn string, stmts []migo.Statement)
+	visit = func(fn string, stmts []migo.Statement) {
+		for _, stmt := range stmts {
+			switch s := stmt.(type) {
+			case *migo.CallStatement:
+				if defined[s.SimpleName()] {
+					graph.Edges = append(graph.Edges, GraphEdge{From: fn, To: s.SimpleName(), Label: "call", Pos: pos(prog.StmtPos[s])})
+				}
+			case *migo.SpawnStatement:
+				if defined[s.SimpleName()] {
+					graph.Edges = append(graph.Edges, GraphEdge{From: fn, To: s.SimpleName(), Label: "spawn", Pos: pos(prog.StmtPos[s])})
+				}
+			case *migo.IfStatement:
+				visit(fn, s.Then)
+				visit(fn, s.Else)
+			case *migo.IfForStatement:
+				visit(fn, s.Then)
+				visit(fn, s.Else)
+			case *migo.SelectStatement:
+				for _, cas := range s.Cases {
+					visit(fn, cas)
+				}
+			}
+		}
+	}
+	for _, f := range prog.MigoProg.Funcs {
+		visit(f.SimpleName(), f.Stmts)
+	}
+	return graph
+}
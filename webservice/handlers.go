@@ -1,30 +1,97 @@
 package webservice
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"html/template"
-	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"path"
+	"runtime/debug"
+
+	"golang.org/x/tools/txtar"
 )
 
+// Files of the web interface, e.g. embedded in the binary or directories on
+// disk for development.
 var (
-	ExamplesDir string
-	TemplateDir string
-	StaticDir   string
+	Examples  fs.FS // Examples, a main package in each directory.
+	Templates fs.FS // HTML templates.
+	Static    fs.FS // Static files, e.g. scripts and style sheets.
 )
 
-func indexHandler(w http.ResponseWriter, req *http.Request) {
+// handler is a HTTP handler which returns its error instead of writing it.
+// Errors and panics of the handler are reported to web client (see Error).
+// Request bodies are limited to maxRequest bytes.
+type handler func(w http.ResponseWriter, req *http.Request) error
+
+func (h handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in %s: %v\n%s", req.URL.Path, r, debug.Stack())
+			NewErrInternal(fmt.Errorf("%v", r), "Analysis failed").Report(w)
+		}
+	}()
+	req.Body = http.MaxBytesReader(w, req.Body, maxRequest)
+	if err := h(w, req); err != nil {
+		e, ok := err.(*Error)
+		if !ok {
+			e = NewErrInternal(err, "Request failed")
+		}
+		e.Report(w)
+	}
+}
+
+// runExtract runs an extractor in a new goroutine and waits until it reports
+// an error to errc or completion to done, or until ctx is done. Errors of the
+// extractor are caused by the input (e.g. no main package) or by cancelling
+// ctx, panics are internal errors. Only panics of the goroutine calling run
+// are recovered, so run must raise panics of goroutines it starts again (as
+// the extractors do for goroutines analysed concurrently).
+func runExtract(ctx context.Context, run func(context.Context), errc <-chan error, done <-chan struct{}, message string) error {
+	panicked := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic in extractor: %v\n%s", r, debug.Stack())
+				panicked <- fmt.Errorf("%v", r)
+			}
+		}()
+		run(ctx)
+	}()
+	select {
+	case err := <-errc:
+		if ctx.Err() != nil {
+			return newErrStopped(ctx, message)
+		}
+		return NewErrBadInput(err, message)
+	case err := <-panicked:
+		return NewErrInternal(err, message)
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return newErrStopped(ctx, message)
+	}
+}
+
+func indexHandler(w http.ResponseWriter, req *http.Request) error {
+	return writeIndex(w, nil)
+}
+
+// writeIndex writes the web interface, with the shared snippet restored if it
+// is not nil.
+func writeIndex(w http.ResponseWriter, shared *Snippet) error {
 	var examples []string
-	t, err := template.ParseFiles(path.Join(TemplateDir, "index.tmpl"))
+	t, err := template.ParseFS(Templates, "index.tmpl")
 	if err != nil {
-		NewErrInternal(err, "Cannot load template").Report(w)
+		return NewErrInternal(err, "Cannot load template")
 	}
-	d, err := ioutil.ReadDir(ExamplesDir)
+	d, err := fs.ReadDir(Examples, ".")
 	if err != nil {
-		NewErrInternal(err, "Cannot read examples").Report(w)
+		return NewErrInternal(err, "Cannot read examples")
 	}
 	for _, f := range d {
 		if f.IsDir() {
@@ -35,28 +102,64 @@ func indexHandler(w http.ResponseWriter, req *http.Request) {
 	data := struct {
 		Title    string
 		Examples []string
+		Sharing  bool     // Snippets can be shared.
+		Shared   *Snippet // Shared snippet to restore.
 	}{
 		Title:    "GoInfer/Gong demo",
 		Examples: examples,
+		Sharing:  Shares != nil,
+		Shared:   shared,
 	}
-	err = t.Execute(w, data)
-	if err != nil {
-		NewErrInternal(err, "Template execute failed").Report(w)
+	if err := t.Execute(w, data); err != nil {
+		return NewErrInternal(err, "Template execute failed")
 	}
+	return nil
 }
 
-func loadHandler(w http.ResponseWriter, req *http.Request) {
+func loadHandler(w http.ResponseWriter, req *http.Request) error {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		NewErrInternal(err, "Cannot read input").Report(w)
+		return NewErrBadInput(err, "Cannot read input")
 	}
 	if err := req.Body.Close(); err != nil {
-		NewErrInternal(err, "Cannot close request").Report(w)
+		return NewErrInternal(err, "Cannot close request")
 	}
 	log.Println("Load example:", string(b))
-	file, err := os.Open(path.Join(ExamplesDir, string(b), "main.go"))
+	// Example names are directory names, not paths.
+	dir := path.Base(path.Clean("/" + string(b)))
+	src, err := loadExample(dir)
 	if err != nil {
-		NewErrInternal(err, "Cannot open file").Report(w)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+			return NewErrNotFound(nil, fmt.Sprintf("Example %q not found", string(b)))
+		}
+		return NewErrInternal(err, "Cannot open file")
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// loadExample returns the source code of the example in dir, main.go if it is
+// the only file, or else a txtar archive of the Go files and go.mod.
+func loadExample(dir string) ([]byte, error) {
+	entries, err := fs.ReadDir(Examples, dir)
+	if err != nil {
+		return nil, err
+	}
+	var ar txtar.Archive
+	for _, e := range entries {
+		if !e.IsDir() && (path.Ext(e.Name()) == ".go" || e.Name() == "go.mod") {
+			b, err := fs.ReadFile(Examples, path.Join(dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			ar.Files = append(ar.Files, txtar.File{Name: e.Name(), Data: b})
+		}
+	}
+	if len(ar.Files) == 1 && ar.Files[0].Name == "main.go" {
+		return ar.Files[0].Data, nil
+	}
+	if len(ar.Files) == 0 {
+		return nil, fs.ErrNotExist
 	}
-	io.Copy(w, file)
+	return txtar.Format(&ar), nil
 }
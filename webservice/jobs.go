@@ -0,0 +1,299 @@
+package webservice
+
+// Analyses run in the background as jobs, under /api/v1/jobs.
+//
+// POST /api/v1/jobs queues an AnalyseRequest and returns the queued Job with
+// its ID, GET /api/v1/jobs/{id} returns the Job with its result once done,
+// and DELETE /api/v1/jobs/{id} cancels it. Jobs are run by a fixed number of
+// workers, each within AnalysisLimits and ServerMemory.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits are the limits of an analysis, zero means no limit.
+type Limits struct {
+	Timeout time.Duration // Time to run an analysis.
+
+	// Memory is the bytes the heap may grow by while an analysis runs, not
+	// counting external tools (e.g. Gong) run by the analysis. Analyses
+	// share the heap of the server, so the growth includes allocations of
+	// analyses running at the same time, and an analysis may be stopped by
+	// the others. JobWorkers analyses of Memory bytes each fit in
+	// ServerMemory if Memory is at most ServerMemory/JobWorkers.
+	Memory uint64
+}
+
+var (
+	// AnalysisLimits are the limits of each analysis, of requests and jobs.
+	AnalysisLimits = Limits{Timeout: time.Minute, Memory: 512 << 20}
+
+	// ServerMemory is the limit of bytes of heap of the whole server, zero
+	// means no limit. All analyses running while the heap is over the limit
+	// are stopped with ErrMemoryLimit.
+	ServerMemory uint64 = 2 << 30
+
+	JobWorkers = runtime.NumCPU() // Jobs run at the same time.
+	JobQueue   = 64               // Jobs waiting for a worker.
+)
+
+var (
+	ErrTimeLimit   = errors.New("time limit exceeded")
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+)
+
+const (
+	jobsPath     = "/api/" + APIVersion + "/jobs"
+	jobKeep      = time.Hour              // Time to keep finished jobs.
+	memoryPeriod = 100 * time.Millisecond // Time between checks of memory limit.
+)
+
+// withLimits returns a copy of parent which is cancelled with ErrTimeLimit
+// (see context.Cause) when the analysis exceeds limits, or ErrMemoryLimit
+// when the heap grows by more than limits.Memory or exceeds ServerMemory.
+func withLimits(parent context.Context, limits Limits) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	stop := context.CancelFunc(func() {})
+	if limits.Timeout > 0 {
+		ctx, stop = context.WithTimeoutCause(ctx, limits.Timeout, ErrTimeLimit)
+	}
+	if ServerMemory > 0 || limits.Memory > 0 {
+		go watchMemory(ctx, cancel, heapBytes(), limits.Memory)
+	}
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// watchMemory cancels ctx when the heap grows by more than growth bytes from
+// start (zero for no limit), or over ServerMemory.
+func watchMemory(ctx context.Context, cancel context.CancelCauseFunc, start, growth uint64) {
+	ticker := time.NewTicker(memoryPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			heap := heapBytes()
+			if ServerMemory > 0 && heap > ServerMemory || growth > 0 && heap > start+growth {
+				cancel(ErrMemoryLimit)
+				return
+			}
+		}
+	}
+}
+
+// heapBytes returns the bytes of heap used by objects.
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// Statuses of jobs.
+const (
+	JobQueued    = "queued"    // Waiting for a worker.
+	JobRunning   = "running"   // Being analysed.
+	JobDone      = "done"      // Analysed, with Result.
+	JobFailed    = "failed"    // Not analysed, with Error.
+	JobCancelled = "cancelled" // Cancelled by DELETE.
+)
+
+// Job is an analysis run in the background, the response body of the jobs
+// API.
+type Job struct {
+	ID     string           `json:"id"`
+	Status string           `json:"status"`
+	Result *AnalyseResponse `json:"result,omitempty"` // Result of a done job.
+	Error  *Error           `json:"error,omitempty"`  // Error of a failed job.
+
+	req      *AnalyseRequest
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished time.Time
+}
+
+// Jobs is a queue of jobs run by a fixed number of workers.
+type Jobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+}
+
+// NewJobs returns a queue of up to queued jobs run by workers workers.
+func NewJobs(workers, queued int) *Jobs {
+	jobs := &Jobs{
+		jobs:  make(map[string]*Job),
+		queue: make(chan *Job, queued),
+	}
+	for i := 0; i < workers; i++ {
+		go jobs.work()
+	}
+	return jobs
+}
+
+// Submit queues an analysis of areq, or returns an error if areq is invalid
+// or the queue is full.
+func (jobs *Jobs) Submit(areq *AnalyseRequest) (*Job, error) {
+	if err := areq.validate(); err != nil {
+		return nil, err
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, NewErrInternal(err, "Cannot create job ID")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{ID: id, Status: JobQueued, req: areq, ctx: ctx, cancel: cancel}
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.prune()
+	select {
+	case jobs.queue <- job:
+	default:
+		cancel()
+		return nil, newError(http.StatusServiceUnavailable, nil, "Job queue full")
+	}
+	jobs.jobs[id] = job
+	j := *job
+	return &j, nil
+}
+
+// Get returns the job with id, or nil if there is no such job.
+func (jobs *Jobs) Get(id string) *Job {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	job, ok := jobs.jobs[id]
+	if !ok {
+		return nil
+	}
+	j := *job
+	return &j
+}
+
+// Cancel cancels the job with id if it is not finished, and returns the job
+// or nil if there is no such job. A running job is cancelled when its
+// extractor stops.
+func (jobs *Jobs) Cancel(id string) *Job {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	job, ok := jobs.jobs[id]
+	if !ok {
+		return nil
+	}
+	if job.Status == JobQueued {
+		job.Status = JobCancelled
+		job.finished = time.Now()
+	}
+	job.cancel()
+	j := *job
+	return &j
+}
+
+// prune removes jobs finished longer than jobKeep ago.
+func (jobs *Jobs) prune() {
+	for id, job := range jobs.jobs {
+		if !job.finished.IsZero() && time.Since(job.finished) > jobKeep {
+			delete(jobs.jobs, id)
+		}
+	}
+}
+
+// work runs queued jobs until the queue is closed.
+func (jobs *Jobs) work() {
+	for job := range jobs.queue {
+		jobs.mu.Lock()
+		if job.Status != JobQueued { // Cancelled while queued.
+			jobs.mu.Unlock()
+			continue
+		}
+		job.Status = JobRunning
+		jobs.mu.Unlock()
+
+		resp, err := runJob(job)
+
+		jobs.mu.Lock()
+		job.finished = time.Now()
+		switch {
+		case err == nil:
+			job.Status, job.Result = JobDone, resp
+		case job.ctx.Err() != nil:
+			job.Status = JobCancelled
+		default:
+			e, ok := err.(*Error)
+			if !ok {
+				e = NewErrInternal(err, "Analysis failed")
+			}
+			job.Status, job.Error = JobFailed, e
+		}
+		jobs.mu.Unlock()
+		job.cancel()
+	}
+}
+
+// runJob runs the analysis of job within AnalysisLimits and ServerMemory.
+func runJob(job *Job) (resp *AnalyseResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in job %s: %v\n%s", job.ID, r, debug.Stack())
+			err = NewErrInternal(fmt.Errorf("%v", r), "Analysis failed")
+		}
+	}()
+	ctx, cancel := withLimits(job.ctx, AnalysisLimits)
+	defer cancel()
+	return analyse(ctx, job.req)
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// serve handles requests to jobsPath and jobsPath/{id}.
+func (jobs *Jobs) serve(w http.ResponseWriter, req *http.Request) error {
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, jobsPath), "/")
+	var job *Job
+	switch {
+	case id == "" && req.Method == http.MethodPost:
+		var areq AnalyseRequest
+		if err := json.NewDecoder(req.Body).Decode(&areq); err != nil {
+			return NewErrBadInput(err, "Cannot decode request")
+		}
+		j, err := jobs.Submit(&areq)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		return json.NewEncoder(w).Encode(j)
+	case id != "" && req.Method == http.MethodGet:
+		job = jobs.Get(id)
+	case id != "" && req.Method == http.MethodDelete:
+		job = jobs.Cancel(id)
+	default:
+		return newError(http.StatusMethodNotAllowed, nil, fmt.Sprintf("Method %s not allowed", req.Method))
+	}
+	if job == nil {
+		return NewErrNotFound(nil, fmt.Sprintf("Job %q not found", id))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(job)
+}
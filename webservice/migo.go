@@ -7,34 +7,25 @@ import (
 	"net/http"
 
 	"github.com/nickng/dingo-hunter/migoextract"
-	"github.com/nickng/dingo-hunter/ssabuilder"
-	"github.com/nickng/migo/v3/migoutil"
+	"github.com/nickng/dingo-hunter/migofile"
 )
 
-func migoHandler(w http.ResponseWriter, req *http.Request) {
-	b, err := ioutil.ReadAll(req.Body)
+func migoHandler(w http.ResponseWriter, req *http.Request) error {
+	info, err := buildSSA(req)
 	if err != nil {
-		NewErrInternal(err, "Cannot read input Go source code").Report(w)
+		return err
 	}
-	req.Body.Close()
-	conf, err := ssabuilder.NewConfigFromString(string(b))
-	if err != nil {
-		NewErrInternal(err, "Cannot initialise SSA").Report(w)
-	}
-	info, err := conf.Build()
+	extract, err := migoextract.New(info, ioutil.Discard)
 	if err != nil {
-		NewErrInternal(err, "Cannot build SSA").Report(w)
+		return NewErrInternal(err, "Cannot initialise MiGo type inference")
 	}
-	extract, err := migoextract.New(info, ioutil.Discard)
-	go extract.Run()
-
-	select {
-	case <-extract.Error:
-		NewErrInternal(err, "MiGo type inference failed").Report(w)
-	case <-extract.Done:
-		log.Println("MiGo: analysis completed in", extract.Time)
-		migoutil.SimplifyProgram(extract.Env.MigoProg)
+	ctx, cancel := withLimits(req.Context(), AnalysisLimits)
+	defer cancel()
+	if err := runExtract(ctx, extract.RunContext, extract.Error, extract.Done, "MiGo type inference failed"); err != nil {
+		return err
 	}
+	log.Println("MiGo: analysis completed in", extract.Time)
+	migofile.Simplify(extract.Env.MigoProg)
 
 	reply := struct {
 		MiGo string `json:"MiGo"`
@@ -43,5 +34,5 @@ func migoHandler(w http.ResponseWriter, req *http.Request) {
 		MiGo: extract.Env.MigoProg.String(),
 		Time: extract.Time.String(),
 	}
-	json.NewEncoder(w).Encode(&reply)
+	return json.NewEncoder(w).Encode(&reply)
 }
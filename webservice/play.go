@@ -2,35 +2,35 @@ package webservice
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"go/build"
-	"io/ioutil"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
-	"path/filepath"
+	"sync"
 	"time"
 
+	"golang.org/x/net/websocket"
 	"golang.org/x/tools/godoc/static"
-	"golang.org/x/tools/playground/socket"
 )
 
-const basePkg = "golang.org/x/tools/cmd/present"
-
 var scripts = []string{"jquery.js", "jquery-ui.js", "playground.js", "play.js"}
 
-func initPlayground(origin *url.URL) {
-	p, err := build.Default.Import(basePkg, "", build.FindOnly)
-	if err != nil {
-		log.Fatalf("Could not find gopresent files: %v", err)
+// initPlayground adds the handlers of the Go playground to mux.
+func initPlayground(mux *http.ServeMux, origin *url.URL) error {
+	if err := playScript(mux, "SocketTransport"); err != nil {
+		return err
 	}
-	basePath := p.Dir
-
-	playScript(basePath, "SocketTransport")
-	http.Handle("/socket", socket.NewHandler(origin))
+	mux.Handle("/socket", playSocket(origin, Exec))
+	return nil
 }
 
-func playScript(root, transport string) {
+// playScript adds the script of the playground to mux, of scripts from
+// golang.org/x/tools/godoc/static or else from Static.
+func playScript(mux *http.ServeMux, transport string) error {
 	modTime := time.Now()
 	var buf bytes.Buffer
 	for _, p := range scripts {
@@ -38,16 +38,147 @@ func playScript(root, transport string) {
 			buf.WriteString(s)
 			continue
 		}
-		b, err := ioutil.ReadFile(filepath.Join(root, "static", p))
+		if Static == nil {
+			return fmt.Errorf("no static files for %s", p)
+		}
+		b, err := fs.ReadFile(Static, p)
 		if err != nil {
-			panic(err)
+			return err
 		}
 		buf.Write(b)
 	}
 	fmt.Fprintf(&buf, "\ninitPlayground(new %v());\n", transport)
 	b := buf.Bytes()
-	http.HandleFunc("/play.js", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/play.js", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-type", "application/javascript")
 		http.ServeContent(w, r, "", modTime, bytes.NewReader(b))
 	})
+	return nil
+}
+
+// playMessage is a message of the playground websocket, see
+// golang.org/x/tools/playground/socket.
+type playMessage struct {
+	Id   string // Id of the program given by the client.
+	Kind string // "run" or "kill" from the client, "stdout", "stderr" or "end" to the client.
+	Body string
+}
+
+// playSocket returns the websocket handler of the playground, which runs the
+// programs by exec, or reports running is disabled if exec is nil.
+func playSocket(origin *url.URL, exec Executor) websocket.Server {
+	if exec == nil {
+		exec = noExec{}
+	}
+	return websocket.Server{
+		Config:    websocket.Config{Origin: origin},
+		Handshake: playHandshake,
+		Handler: websocket.Handler(func(ws *websocket.Conn) {
+			c := &playConn{enc: json.NewEncoder(ws), exec: exec, kill: make(map[string]context.CancelFunc)}
+			c.serve(ws)
+		}),
+	}
+}
+
+// playHandshake accepts websocket connections from origin of the server only.
+func playHandshake(c *websocket.Config, req *http.Request) error {
+	o, err := websocket.Origin(c, req)
+	if err != nil || o == nil {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	_, port, err := net.SplitHostPort(c.Origin.Host)
+	if err != nil {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	if c.Origin.Scheme != o.Scheme || (c.Origin.Host != o.Host && c.Origin.Host != net.JoinHostPort(o.Host, port)) {
+		log.Printf("Bad websocket origin: %v", o)
+		return websocket.ErrBadWebSocketOrigin
+	}
+	return nil
+}
+
+// playConn is a websocket connection of the playground.
+type playConn struct {
+	mu     sync.Mutex // Serialises messages to the client.
+	enc    *json.Encoder
+	exec   Executor
+	killMu sync.Mutex
+	kill   map[string]context.CancelFunc // Running programs by Id.
+}
+
+// serve runs and kills programs by messages from ws until it is closed.
+func (c *playConn) serve(ws *websocket.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // Kill all programs of the connection.
+	dec := json.NewDecoder(ws)
+	for {
+		var m playMessage
+		if err := dec.Decode(&m); err != nil {
+			return
+		}
+		c.stop(m.Id)
+		if m.Kind == "run" {
+			log.Printf("Running program from %s", ws.Request().RemoteAddr)
+			go c.run(c.start(ctx, m.Id), m.Id, m.Body)
+		}
+	}
+}
+
+// start returns the context of running program id, which is killed by stop.
+func (c *playConn) start(ctx context.Context, id string) context.Context {
+	ctx, kill := context.WithCancel(ctx)
+	c.killMu.Lock()
+	defer c.killMu.Unlock()
+	c.kill[id] = kill
+	return ctx
+}
+
+// stop kills the running program id, if any.
+func (c *playConn) stop(id string) {
+	c.killMu.Lock()
+	defer c.killMu.Unlock()
+	if kill, ok := c.kill[id]; ok {
+		kill()
+		delete(c.kill, id)
+	}
+}
+
+// run runs the program src and sends its output and exit status to the client.
+func (c *playConn) run(ctx context.Context, id, src string) {
+	err := c.exec.Run(ctx, src, &playWriter{c, id, "stdout"}, &playWriter{c, id, "stderr"})
+	var status string
+	switch {
+	case err == nil:
+	case ctx.Err() == context.Canceled:
+		status = "killed"
+	default:
+		status = err.Error()
+	}
+	c.killMu.Lock()
+	if ctx.Err() == nil { // Not stopped, so id is still this program.
+		c.kill[id]()
+		delete(c.kill, id)
+	}
+	c.killMu.Unlock()
+	c.send(&playMessage{Id: id, Kind: "end", Body: status})
+}
+
+func (c *playConn) send(m *playMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(m)
+}
+
+// playWriter sends writes to the client as messages of kind.
+type playWriter struct {
+	c    *playConn
+	id   string
+	kind string
+}
+
+func (w *playWriter) Write(b []byte) (int, error) {
+	if err := w.c.send(&playMessage{Id: w.id, Kind: w.kind, Body: string(b)}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
 }
@@ -13,8 +13,10 @@ type Server struct {
 	listener net.Listener
 	iface    string
 	port     string
+	handler  http.Handler
 
 	listenerMtx sync.Mutex
+	handlerOnce sync.Once
 }
 
 func NewServer(iface string, port string) *Server {
@@ -25,20 +27,39 @@ func NewServer(iface string, port string) *Server {
 }
 
 func (s *Server) Start() {
-	origin := &url.URL{Scheme: "http", Host: net.JoinHostPort(s.iface, s.port)}
-	initPlayground(origin)
-	http.HandleFunc("/", indexHandler)
-	fs := http.FileServer(http.Dir(StaticDir))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
-	http.HandleFunc("/ssa", ssaHandler)
-	http.HandleFunc("/load", loadHandler)
-	http.HandleFunc("/cfsm", cfsmHandler)
-	http.HandleFunc("/migo", migoHandler)
-	http.HandleFunc("/gong", gongHandler)
-	http.HandleFunc("/synthesis", synthesisHandler)
-
+	h := s.Handler()
 	log.Printf("Listening at %s", s.URL())
-	(&http.Server{}).Serve(s.Listener())
+	(&http.Server{Handler: h}).Serve(s.Listener())
+}
+
+// Handler returns the handler of all requests to the server. Each request
+// is analysed with its own state, so requests can be handled concurrently.
+func (s *Server) Handler() http.Handler {
+	s.handlerOnce.Do(func() {
+		mux := http.NewServeMux()
+		origin := &url.URL{Scheme: "http", Host: s.Listener().Addr().String()}
+		if err := initPlayground(mux, origin); err != nil {
+			log.Printf("Playground disabled: %v", err)
+		}
+		mux.Handle("/", handler(indexHandler))
+		mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(Static))))
+		mux.Handle("/ssa", handler(ssaHandler))
+		mux.Handle("/load", handler(loadHandler))
+		mux.Handle("/cfsm", handler(cfsmHandler))
+		mux.Handle("/migo", handler(migoHandler))
+		mux.Handle("/gong", handler(gongHandler))
+		mux.Handle("/synthesis", handler(synthesisHandler))
+		mux.Handle("/graph", handler(graphHandler))
+		mux.Handle("/share", handler(shareHandler))
+		mux.Handle(sharePath, handler(sharedHandler))
+		mux.Handle("/api/"+APIVersion+"/", handler(apiNotFoundHandler))
+		mux.Handle("/api/"+APIVersion+"/analyse", handler(apiAnalyseHandler))
+		jobs := NewJobs(JobWorkers, JobQueue)
+		mux.Handle(jobsPath, handler(jobs.serve))
+		mux.Handle(jobsPath+"/", handler(jobs.serve))
+		s.handler = mux
+	})
+	return s.handler
 }
 
 func (s *Server) Close() {
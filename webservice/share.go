@@ -0,0 +1,163 @@
+package webservice
+
+// Permalinks of snippets: POST /share stores a snippet with its analysis
+// options in Shares, and GET /s/{id} opens the web interface with the snippet
+// restored and analysed again.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sharePath  = "/s/"
+	shareIDLen = 16      // Hex digits of the hash in IDs of snippets.
+	maxSnippet = 1 << 16 // Bytes of a shared snippet.
+)
+
+// Shares stores the shared snippets, nil disables sharing.
+var Shares *ShareStore
+
+// Views of the web interface a snippet can be shared with.
+var shareViews = map[string]bool{"": true, "ssa": true, "cfsm": true, "migo": true, "graph": true}
+
+// Snippet is a shared snippet, the request body of POST /share.
+type Snippet struct {
+	Code string `json:"code"`           // Go source code.
+	View string `json:"view,omitempty"` // Analysis shown, i.e. "ssa", "cfsm", "migo" or "graph".
+	Chan int    `json:"chan,omitempty"` // Channel CFSMs of synthesis.
+}
+
+// ShareStore is a content-addressed store of snippets in a directory, where
+// the ID of a snippet is the hash of its content, so sharing a snippet again
+// returns the same ID. It is safe for concurrent use.
+type ShareStore struct {
+	Dir string
+}
+
+// OpenShareStore opens (and creates if needed) the store in directory dir.
+func OpenShareStore(dir string) (*ShareStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &ShareStore{Dir: dir}, nil
+}
+
+// file returns the path of the snippet with id.
+func (s *ShareStore) file(id string) string {
+	return filepath.Join(s.Dir, id[:2], id[2:]+".json")
+}
+
+// Put stores snip and returns its ID.
+func (s *ShareStore) Put(snip *Snippet) (string, error) {
+	b, err := json.Marshal(snip)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	id := hex.EncodeToString(sum[:])[:shareIDLen]
+	file := s.file(id)
+	if _, err := os.Stat(file); err == nil {
+		return id, nil // Shared before.
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return "", err
+	}
+	// Write to a temporary file first so readers never see partial snippets.
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return id, os.Rename(tmp.Name(), file)
+}
+
+// Get returns the snippet with id, or an error wrapping fs.ErrNotExist if
+// there is no such snippet.
+func (s *ShareStore) Get(id string) (*Snippet, error) {
+	if !validShareID(id) {
+		return nil, fmt.Errorf("invalid snippet ID %q: %w", id, fs.ErrNotExist)
+	}
+	b, err := ioutil.ReadFile(s.file(id))
+	if err != nil {
+		return nil, err
+	}
+	var snip Snippet
+	if err := json.Unmarshal(b, &snip); err != nil {
+		return nil, err
+	}
+	return &snip, nil
+}
+
+// validShareID returns true if id is a hash of a snippet, so it cannot name
+// files outside the store.
+func validShareID(id string) bool {
+	if len(id) != shareIDLen {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
+
+// shareHandler stores the snippet of the request and replies its ID and path.
+func shareHandler(w http.ResponseWriter, req *http.Request) error {
+	if Shares == nil {
+		return NewErrNotFound(nil, "Sharing is disabled")
+	}
+	if req.Method != http.MethodPost {
+		return newError(http.StatusMethodNotAllowed, nil, fmt.Sprintf("Method %s not allowed", req.Method))
+	}
+	var snip Snippet
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxSnippet)).Decode(&snip); err != nil {
+		return NewErrBadInput(err, "Cannot decode snippet")
+	}
+	if snip.Code == "" {
+		return NewErrBadInput(nil, "No code")
+	}
+	if !shareViews[snip.View] {
+		return NewErrBadInput(nil, fmt.Sprintf("Unknown view %q", snip.View))
+	}
+	id, err := Shares.Put(&snip)
+	if err != nil {
+		return NewErrInternal(err, "Cannot store snippet")
+	}
+	reply := struct {
+		ID   string `json:"id"`
+		Path string `json:"path"`
+	}{ID: id, Path: sharePath + id}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(&reply)
+}
+
+// sharedHandler replies the web interface with the snippet of sharePath/{id}.
+func sharedHandler(w http.ResponseWriter, req *http.Request) error {
+	id := strings.TrimPrefix(req.URL.Path, sharePath)
+	if Shares == nil {
+		return NewErrNotFound(nil, "Sharing is disabled")
+	}
+	snip, err := Shares.Get(id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return NewErrNotFound(nil, fmt.Sprintf("Snippet %q not found", id))
+		}
+		return NewErrInternal(err, "Cannot read snippet")
+	}
+	return writeIndex(w, snip)
+}
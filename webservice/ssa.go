@@ -3,23 +3,43 @@ package webservice
 import (
 	"io/ioutil"
 	"net/http"
+	"os"
 
 	"github.com/nickng/dingo-hunter/ssabuilder"
 )
 
-func ssaHandler(w http.ResponseWriter, req *http.Request) {
+// GoEnv is the environment of the go command loading modules of requests. By
+// default the module proxy and checksum database are disabled, so modules are
+// not fetched from the network and only modules in the module cache can be
+// required, and cgo is disabled.
+var GoEnv = append(os.Environ(), "GOPROXY=off", "GOSUMDB=off", "GOFLAGS=-mod=mod", "GOWORK=off", "GOTOOLCHAIN=local", "CGO_ENABLED=0")
+
+// buildSSA builds SSA of the Go source code in the request body, the source
+// code of a single file or a txtar archive of files, e.g. of a module with a
+// go.mod file (see ssabuilder.NewConfigFromArchive).
+func buildSSA(req *http.Request) (*ssabuilder.SSAInfo, error) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		NewErrInternal(err, "Cannot read input Go source code").Report(w)
+		return nil, NewErrBadInput(err, "Cannot read input Go source code")
 	}
 	req.Body.Close()
-	conf, err := ssabuilder.NewConfigFromString(string(b))
+	conf, err := ssabuilder.NewConfigFromArchive(b)
 	if err != nil {
-		NewErrInternal(err, "Cannot initialise SSA").Report(w)
+		return nil, NewErrBadInput(err, "Cannot initialise SSA")
 	}
+	conf.Env = GoEnv
 	info, err := conf.Build()
 	if err != nil {
-		NewErrInternal(err, "Cannot build SSA").Report(w)
+		return nil, NewErrBadInput(err, "Cannot build SSA")
+	}
+	return info, nil
+}
+
+func ssaHandler(w http.ResponseWriter, req *http.Request) error {
+	info, err := buildSSA(req)
+	if err != nil {
+		return err
 	}
-	info.WriteTo(w)
+	_, err = info.WriteTo(w)
+	return err
 }
@@ -2,7 +2,6 @@ package webservice
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -13,11 +12,11 @@ import (
 	"time"
 )
 
-func synthesisHandler(w http.ResponseWriter, req *http.Request) {
+func synthesisHandler(w http.ResponseWriter, req *http.Request) error {
 	log.Println("Running SMC check on snippet")
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		NewErrInternal(err, "Cannot read input CFSM").Report(w)
+		return NewErrBadInput(err, "Cannot read input CFSM")
 	}
 	req.Body.Close()
 	chanCFSMs := req.FormValue("chan")
@@ -26,50 +25,47 @@ func synthesisHandler(w http.ResponseWriter, req *http.Request) {
 	log.Println("Finding required executables")
 	gmc, err := exec.LookPath("GMC")
 	if err != nil {
-		NewErrInternal(err, "Cannot find GMC executable (Check $PATH?)").Report(w)
+		return NewErrInternal(err, "Cannot find GMC executable (Check $PATH?)")
 	}
 	bg, err := exec.LookPath("BuildGlobal")
 	if err != nil {
-		NewErrInternal(err, "Cannot find BuildGobal executable (Check $PATH?)").Report(w)
+		return NewErrInternal(err, "Cannot find BuildGobal executable (Check $PATH?)")
 	}
 	petrify, err := exec.LookPath("petrify")
 	if err != nil {
-		NewErrInternal(err, "Cannot find petrify executable (Check $PATH?)").Report(w)
+		return NewErrInternal(err, "Cannot find petrify executable (Check $PATH?)")
 	}
 	dot, err := exec.LookPath("dot")
 	if err != nil {
-		NewErrInternal(err, "Cannot find dot executable (Check $PATH?)").Report(w)
+		return NewErrInternal(err, "Cannot find dot executable (Check $PATH?)")
 	}
 
 	// ---- Output dirs/files ----
-	baseDir := path.Join(os.TempDir(), "syn")
-	err = os.MkdirAll(baseDir, 0777)
+	// The tools write to outputs/ in their working directory, the workspace.
+	ws, cleanup, err := newWorkspace()
 	if err != nil {
-		NewErrInternal(err, "Cannot create temp dir").Report(w)
+		return err
 	}
-	err = os.MkdirAll(path.Join(baseDir, "outputs"), 0777)
-	if err != nil {
-		NewErrInternal(err, "Cannot create final output dir").Report(w)
-	}
-	err = os.Chdir(baseDir)
-	if err != nil {
-		NewErrInternal(err, "Cannot chdir to temp dir").Report(w)
+	defer cleanup()
+	if err := os.Mkdir(path.Join(ws, "outputs"), 0777); err != nil {
+		return NewErrInternal(err, "Cannot create final output dir")
 	}
-	file, err := ioutil.TempFile(baseDir, "cfsm")
-	if err != nil {
-		NewErrInternal(err, "Cannot create temp file for CFSM input").Report(w)
-	}
-	defer os.Remove(file.Name())
-	toPetrifyPath := path.Join(baseDir, "outputs", fmt.Sprintf("%s_toPetrify", path.Base(file.Name())))
-	petriPath := path.Join(baseDir, "default")
-	machinesDotPath := path.Join(baseDir, "outputs", fmt.Sprintf("%s_machines.dot", path.Base(file.Name())))
-	globalDotPath := path.Join(baseDir, "outputs", "default_global.dot")
+	file := path.Join(ws, "cfsm")
+	toPetrifyPath := path.Join(ws, "outputs", "cfsm_toPetrify")
+	petriPath := path.Join(ws, "default")
+	machinesDotPath := path.Join(ws, "outputs", "cfsm_machines.dot")
+	globalDotPath := path.Join(ws, "outputs", "default_global.dot")
 
-	if _, err := file.Write(b); err != nil {
-		NewErrInternal(err, "Cannot write to temp file for CFSM input").Report(w)
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		return NewErrInternal(err, "Cannot write CFSM input")
 	}
-	if err := file.Close(); err != nil {
-		NewErrInternal(err, "Cannot close temp file for CFSM input").Report(w)
+	ctx, cancel := withLimits(req.Context(), AnalysisLimits)
+	defer cancel()
+	command := func(name string, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Dir = ws
+		cmd.WaitDelay = time.Second // Do not wait for output of leftover children.
+		return cmd
 	}
 
 	// Replace symbols
@@ -77,31 +73,37 @@ func synthesisHandler(w http.ResponseWriter, req *http.Request) {
 	outReplacer := strings.NewReplacer("True", "<span style='color: #87ff87; font-weight: bold'>True</span>", "False", "<span style='color: #ff005f; font-weight: bold'>False</span>")
 
 	startTime := time.Now()
-	gmcOut, err := exec.Command(gmc, file.Name(), chanCFSMs, "+RTS", "-N").CombinedOutput()
+	gmcOut, err := command(gmc, file, chanCFSMs, "+RTS", "-N").CombinedOutput()
 	if err != nil {
 		log.Printf("GMC execution failed: %v\n", err)
 	}
-	petriOut, err := exec.Command(petrify, "-dead", "-ip", toPetrifyPath).CombinedOutput()
+	petriOut, err := command(petrify, "-dead", "-ip", toPetrifyPath).CombinedOutput()
 	if err != nil {
 		log.Printf("petrify execution failed: %v\n", err)
 	}
 	ioutil.WriteFile(petriPath, []byte(re.Replace(string(petriOut))), 0664)
-	bgOut, err := exec.Command(bg, petriPath).CombinedOutput()
+	bgOut, err := command(bg, petriPath).CombinedOutput()
 	if err != nil {
 		log.Printf("BuildGlobal execution failed: %v\n", err)
 	}
 	log.Println("BuildGlobal:", string(bgOut))
+	if ctx.Err() != nil {
+		return newErrStopped(ctx, "Synthesis stopped")
+	}
 
 	execTime := time.Now().Sub(startTime)
 
-	machinesSVG, err := exec.Command(dot, "-Tsvg", machinesDotPath).CombinedOutput()
+	machinesSVG, err := command(dot, "-Tsvg", machinesDotPath).CombinedOutput()
 	if err != nil {
 		log.Printf("dot execution failed for : %v\n", err)
 	}
-	globalSVG, err := exec.Command(dot, "-Tsvg", globalDotPath).CombinedOutput()
+	globalSVG, err := command(dot, "-Tsvg", globalDotPath).CombinedOutput()
 	if err != nil {
 		log.Printf("dot execution failed for : %v\n", err)
 	}
+	if ctx.Err() != nil {
+		return newErrStopped(ctx, "Synthesis stopped")
+	}
 
 	reply := struct {
 		SMC      string `json:"SMC"`
@@ -115,5 +117,5 @@ func synthesisHandler(w http.ResponseWriter, req *http.Request) {
 		Time:     execTime.String(),
 	}
 	log.Println("Synthesis completed in", execTime.String())
-	json.NewEncoder(w).Encode(&reply)
+	return json.NewEncoder(w).Encode(&reply)
 }
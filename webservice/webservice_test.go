@@ -0,0 +1,829 @@
+package webservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/nickng/dingo-hunter/ssabuilder"
+	"golang.org/x/net/websocket"
+)
+
+// post sends body to h and returns the reported error, or nil if the request
+// succeeded.
+func post(t *testing.T, h handler, body string) *Error {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if rec.Code == http.StatusOK {
+		return nil
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expecting JSON error but got Content-Type %s\n", ct)
+	}
+	var e Error
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+		t.Fatalf("Expecting JSON error but got %v\n", err)
+	}
+	if e.Code != rec.Code {
+		t.Errorf("Expecting code %d but got %d\n", rec.Code, e.Code)
+	}
+	return &e
+}
+
+var goHandlers = map[string]handler{
+	"/ssa":  ssaHandler,
+	"/cfsm": cfsmHandler,
+	"/migo": migoHandler,
+}
+
+// Tests invalid Go source code is reported with its position.
+func TestInvalidGo(t *testing.T) {
+	for _, src := range []struct {
+		name string
+		code string
+		line int
+	}{
+		{"syntax", "package main\n\nfunc main() {\n\tx :=\n}\n", 5},
+		{"type", "package main\n\nfunc main() {\n\tch := make(chan int)\n\tch <- y\n}\n", 5},
+		{"empty", "", 1},
+	} {
+		for path, h := range goHandlers {
+			e := post(t, h, src.code)
+			if e == nil {
+				t.Errorf("%s %s: Expecting error but request succeeded\n", path, src.name)
+				continue
+			}
+			if e.Code != http.StatusBadRequest {
+				t.Errorf("%s %s: Expecting code %d but got %d\n", path, src.name, http.StatusBadRequest, e.Code)
+			}
+			if e.Pos == nil || e.Pos.Line != src.line {
+				t.Errorf("%s %s: Expecting error at line %d but got %+v\n", path, src.name, src.line, e.Pos)
+			}
+		}
+	}
+}
+
+// Tests programs without main package are reported by the extractors.
+func TestNoMainPkg(t *testing.T) {
+	for _, path := range []string{"/cfsm", "/migo"} {
+		e := post(t, goHandlers[path], "package notmain\n\nfunc F() {}\n")
+		if e == nil || e.Code != http.StatusBadRequest || !strings.Contains(e.Message, "no main package") {
+			t.Errorf("%s: Expecting no main package error but got %+v\n", path, e)
+		}
+	}
+}
+
+// Tests panics and errors of handlers are reported as internal errors.
+func TestHandlerPanic(t *testing.T) {
+	e := post(t, func(w http.ResponseWriter, req *http.Request) error { panic("boom") }, "")
+	if e == nil || e.Code != http.StatusInternalServerError || !strings.Contains(e.Message, "boom") {
+		t.Errorf("Expecting internal error with panic value but got %+v\n", e)
+	}
+	e = post(t, func(w http.ResponseWriter, req *http.Request) error { return errors.New("oops") }, "")
+	if e == nil || e.Code != http.StatusInternalServerError || !strings.Contains(e.Message, "oops") {
+		t.Errorf("Expecting internal error but got %+v\n", e)
+	}
+}
+
+// Tests panics of extractors are reported as internal errors.
+func TestExtractPanic(t *testing.T) {
+	errc, done := make(chan error), make(chan struct{})
+	err := runExtract(context.Background(), func(context.Context) { panic("boom") }, errc, done, "Extraction failed")
+	e, ok := err.(*Error)
+	if !ok || e.Code != http.StatusInternalServerError || !strings.Contains(e.Message, "boom") {
+		t.Errorf("Expecting internal error with panic value but got %v\n", err)
+	}
+}
+
+// Tests panics in goroutines started by an extractor are reported as
+// internal errors, and do not stop the server.
+func TestExtractWorkerPanic(t *testing.T) {
+	b, err := ioutil.ReadFile("../examples/dining-philosophers/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := post(t, cfsmHandler, string(b))
+	if e == nil || e.Code != http.StatusInternalServerError {
+		t.Errorf("/cfsm: Expecting internal error but got %+v\n", e)
+	}
+	_, e = postAPI(t, AnalyseRequest{Files: map[string]string{"main.go": string(b)}, Extractor: ExtractCFSM})
+	if e == nil || e.Code != http.StatusInternalServerError {
+		t.Errorf("/api/v1/analyse: Expecting internal error but got %+v\n", e)
+	}
+	if e := post(t, cfsmHandler, "package main\n\nfunc main() {\n\tch := make(chan int, 1)\n\tch <- 1\n}\n"); e != nil {
+		t.Errorf("Expecting success after panic but got %+v\n", e)
+	}
+}
+
+// Tests programs the MiGo type inference cannot handle are reported as
+// errors, and do not stop the server.
+func TestMigoFailed(t *testing.T) {
+	e := post(t, migoHandler, `package main
+
+type T struct{ ch chan int }
+
+func main() {
+	var ts []T
+	ts = append(ts, T{})
+	close(ts[0].ch)
+}`)
+	if e == nil || e.Code < 400 || !strings.Contains(e.Message, "MiGo type inference failed") {
+		t.Errorf("Expecting MiGo type inference failed but got %+v\n", e)
+	}
+}
+
+// Tests extractors which do not stop when cancelled are not waited for.
+func TestExtractCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Millisecond, ErrTimeLimit)
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	errc, done := make(chan error), make(chan struct{})
+	err := runExtract(ctx, func(context.Context) { <-block }, errc, done, "Extraction failed")
+	e, ok := err.(*Error)
+	if !ok || e.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expecting time limit error but got %v\n", err)
+	}
+}
+
+// Tests the index and examples are served from files not on disk, e.g.
+// embedded in the binary.
+func TestAssets(t *testing.T) {
+	Templates = fstest.MapFS{"index.tmpl": {Data: []byte("{{range .Examples}}{{.}} {{end}}")}}
+	Examples = fstest.MapFS{"fib/main.go": {Data: []byte("package main\n")}}
+	rec := httptest.NewRecorder()
+	handler(indexHandler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "fib " {
+		t.Errorf("Expecting index of example fib but got %d %q\n", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	handler(loadHandler).ServeHTTP(rec, httptest.NewRequest("POST", "/load", strings.NewReader("fib")))
+	if rec.Code != http.StatusOK || rec.Body.String() != "package main\n" {
+		t.Errorf("Expecting example fib but got %d %q\n", rec.Code, rec.Body.String())
+	}
+}
+
+// Tests examples of multiple files are loaded as txtar archives.
+func TestLoadArchive(t *testing.T) {
+	Examples = fstest.MapFS{
+		"multi/main.go":   {Data: []byte("package main\n")},
+		"multi/worker.go": {Data: []byte("package main\n\nfunc worker() {}\n")},
+		"multi/README":    {Data: []byte("Not Go\n")},
+	}
+	rec := httptest.NewRecorder()
+	handler(loadHandler).ServeHTTP(rec, httptest.NewRequest("POST", "/load", strings.NewReader("multi")))
+	files := ssabuilder.ArchiveFiles(rec.Body.Bytes())
+	if rec.Code != http.StatusOK || len(files) != 2 || files["worker.go"] != "package main\n\nfunc worker() {}\n" {
+		t.Errorf("Expecting archive of main.go and worker.go but got %d %q\n", rec.Code, rec.Body.String())
+	}
+}
+
+// Tests examples outside the examples directory are not found.
+func TestLoadNotFound(t *testing.T) {
+	Examples = os.DirFS(t.TempDir())
+	e := post(t, loadHandler, "../../etc")
+	if e == nil || e.Code != http.StatusNotFound {
+		t.Errorf("Expecting not found error but got %+v\n", e)
+	}
+}
+
+// postAPI sends areq to the analyse endpoint and decodes the response.
+func postAPI(t *testing.T, areq AnalyseRequest) (*AnalyseResponse, *Error) {
+	b, err := json.Marshal(areq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler(apiAnalyseHandler).ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/analyse", bytes.NewReader(b)))
+	if rec.Code != http.StatusOK {
+		var e Error
+		if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		return nil, &e
+	}
+	var resp AnalyseResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp, nil
+}
+
+var leakFiles = map[string]string{
+	"main.go": `package main
+
+func main() {
+	ch := make(chan int)
+	go worker(ch)
+}
+`,
+	"worker.go": `package main
+
+func worker(ch chan int) {
+	ch <- 1
+}
+`,
+}
+
+// Tests findings of MiGo extraction of multiple files are reported with
+// positions.
+func TestAPIAnalyse(t *testing.T) {
+	resp, e := postAPI(t, AnalyseRequest{Files: leakFiles})
+	if e != nil {
+		t.Fatalf("Expecting success but got %+v\n", e)
+	}
+	if resp.Version != APIVersion || resp.Extractor != ExtractMiGo {
+		t.Errorf("Expecting %s %s response but got %s %s\n", APIVersion, ExtractMiGo, resp.Version, resp.Extractor)
+	}
+	if !strings.Contains(resp.Model.MiGo, "spawn main.worker(") {
+		t.Errorf("Expecting spawn of main.worker in MiGo but got\n%s\n", resp.Model.MiGo)
+	}
+	if len(resp.Findings) != 1 || resp.Findings[0].Kind != FindingLeak {
+		t.Fatalf("Expecting 1 leak but got %+v\n", resp.Findings)
+	}
+	if pos := resp.Findings[0].Pos; pos == nil || pos.Filename != "worker.go" || pos.Line != 4 {
+		t.Errorf("Expecting leak at worker.go:4 but got %+v\n", pos)
+	}
+}
+
+// Tests CFSM extraction and the simplify option.
+func TestAPIOptions(t *testing.T) {
+	resp, e := postAPI(t, AnalyseRequest{Files: leakFiles, Extractor: ExtractCFSM})
+	if e != nil {
+		t.Fatalf("Expecting success but got %+v\n", e)
+	}
+	if resp.Model.CFSM == "" || resp.Model.Dot == "" || resp.Model.MiGo != "" {
+		t.Errorf("Expecting CFSM model only but got %+v\n", resp.Model)
+	}
+	simplify := false
+	resp, e = postAPI(t, AnalyseRequest{Files: leakFiles, Simplify: &simplify})
+	if e != nil {
+		t.Fatalf("Expecting success but got %+v\n", e)
+	}
+	if !strings.Contains(resp.Model.MiGo, "spawn main.worker(") || len(resp.Findings) != 1 {
+		t.Errorf("Expecting unsimplified MiGo with 1 finding but got %+v\n", resp)
+	}
+}
+
+// Tests parts of the code over the bounds are reported as diagnostics.
+func TestAPIBounds(t *testing.T) {
+	files := map[string]string{"main.go": `package main
+
+func main() {
+	ch := make(chan int, 10)
+	for i := 0; i < 5; i++ {
+		ch <- i
+	}
+}
+`}
+	resp, e := postAPI(t, AnalyseRequest{Files: files, Bounds: Bounds{MaxUnroll: 2}})
+	if e != nil {
+		t.Fatalf("Expecting success but got %+v\n", e)
+	}
+	if len(resp.Diagnostics) != 1 || resp.Diagnostics[0].Pos == nil || resp.Diagnostics[0].Pos.Line != 5 {
+		t.Errorf("Expecting diagnostic of loop at line 5 but got %+v\n", resp.Diagnostics)
+	}
+	resp, e = postAPI(t, AnalyseRequest{Files: files})
+	if e != nil || len(resp.Diagnostics) != 0 {
+		t.Errorf("Expecting no diagnostics without bounds but got %+v %+v\n", e, resp)
+	}
+}
+
+// Tests invalid API requests are rejected.
+func TestAPIInvalid(t *testing.T) {
+	if _, e := postAPI(t, AnalyseRequest{Files: leakFiles, Extractor: "gong"}); e == nil || e.Code != http.StatusBadRequest {
+		t.Errorf("Expecting unknown extractor error but got %+v\n", e)
+	}
+	if _, e := postAPI(t, AnalyseRequest{}); e == nil || e.Code != http.StatusBadRequest {
+		t.Errorf("Expecting no files error but got %+v\n", e)
+	}
+	e := post(t, apiAnalyseHandler, "{")
+	if e == nil || e.Code != http.StatusBadRequest {
+		t.Errorf("Expecting decode error but got %+v\n", e)
+	}
+	rec := httptest.NewRecorder()
+	handler(apiAnalyseHandler).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/analyse", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expecting code %d but got %d\n", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+// serveJob sends a request to the jobs API and decodes the returned job.
+func serveJob(t *testing.T, jobs *Jobs, method, path string, body interface{}) (*Job, int) {
+	var b bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&b).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler(jobs.serve).ServeHTTP(rec, httptest.NewRequest(method, path, &b))
+	if rec.Code != http.StatusOK && rec.Code != http.StatusAccepted {
+		return nil, rec.Code
+	}
+	var job Job
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	return &job, rec.Code
+}
+
+// Tests a submitted job is run in the background and its result returned.
+func TestJobs(t *testing.T) {
+	jobs := NewJobs(1, 1)
+	job, code := serveJob(t, jobs, "POST", jobsPath, AnalyseRequest{Files: leakFiles})
+	if code != http.StatusAccepted || job.ID == "" || job.Status != JobQueued {
+		t.Fatalf("Expecting queued job but got %d %+v\n", code, job)
+	}
+	for deadline := time.Now().Add(time.Minute); job.Status == JobQueued || job.Status == JobRunning; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expecting job to finish but got %s\n", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		job, _ = serveJob(t, jobs, "GET", jobsPath+"/"+job.ID, nil)
+	}
+	if job.Status != JobDone || job.Result == nil || len(job.Result.Findings) != 1 {
+		t.Errorf("Expecting done job with 1 finding but got %+v\n", job)
+	}
+	if _, code := serveJob(t, jobs, "GET", jobsPath+"/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("Expecting code %d but got %d\n", http.StatusNotFound, code)
+	}
+	if _, code := serveJob(t, jobs, "POST", jobsPath, AnalyseRequest{}); code != http.StatusBadRequest {
+		t.Errorf("Expecting code %d but got %d\n", http.StatusBadRequest, code)
+	}
+}
+
+// Tests queued jobs can be cancelled and the queue is bounded.
+func TestJobCancel(t *testing.T) {
+	jobs := NewJobs(0, 1) // No workers, jobs stay queued.
+	job, _ := serveJob(t, jobs, "POST", jobsPath, AnalyseRequest{Files: leakFiles})
+	if job == nil {
+		t.Fatalf("Expecting job to be queued\n")
+	}
+	if _, code := serveJob(t, jobs, "POST", jobsPath, AnalyseRequest{Files: leakFiles}); code != http.StatusServiceUnavailable {
+		t.Errorf("Expecting code %d for full queue but got %d\n", http.StatusServiceUnavailable, code)
+	}
+	job, _ = serveJob(t, jobs, "DELETE", jobsPath+"/"+job.ID, nil)
+	if job == nil || job.Status != JobCancelled {
+		t.Errorf("Expecting cancelled job but got %+v\n", job)
+	}
+}
+
+// Tests analyses exceeding their limits are stopped.
+func TestLimits(t *testing.T) {
+	defer func(limits Limits) { AnalysisLimits = limits }(AnalysisLimits)
+	AnalysisLimits = Limits{Timeout: time.Nanosecond}
+	_, e := postAPI(t, AnalyseRequest{Files: leakFiles})
+	if e == nil || e.Code != http.StatusUnprocessableEntity || !strings.Contains(e.Message, ErrTimeLimit.Error()) {
+		t.Errorf("Expecting time limit error but got %+v\n", e)
+	}
+
+	defer func(limit uint64) { ServerMemory = limit }(ServerMemory)
+	ServerMemory = 1
+	ctx, cancel := withLimits(context.Background(), Limits{})
+	defer cancel()
+	buf := make([]byte, 1<<20)
+	select {
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); cause != ErrMemoryLimit {
+			t.Errorf("Expecting %v but got %v\n", ErrMemoryLimit, cause)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("Expecting memory limit to cancel context\n")
+	}
+	runtime.KeepAlive(buf)
+
+	ServerMemory = 0
+	runtime.GC() // Heap does not shrink below the start of the analysis.
+	ctx, cancel = withLimits(context.Background(), Limits{Memory: 1})
+	defer cancel()
+	buf = make([]byte, 16<<20)
+	select {
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); cause != ErrMemoryLimit {
+			t.Errorf("Expecting %v but got %v\n", ErrMemoryLimit, cause)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("Expecting memory limit of analysis to cancel context\n")
+	}
+	runtime.KeepAlive(buf)
+}
+
+// Tests external tools run by handlers are stopped by the limits.
+func TestToolLimits(t *testing.T) {
+	dir := t.TempDir()
+	for _, tool := range []string{"Gong", "GMC", "BuildGlobal", "petrify", "dot"} {
+		if err := ioutil.WriteFile(dir+"/"+tool, []byte("#!/bin/sh\nsleep 10\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func(limits Limits) { AnalysisLimits = limits }(AnalysisLimits)
+	AnalysisLimits = Limits{Timeout: 100 * time.Millisecond}
+	for name, h := range map[string]handler{"gong": gongHandler, "synthesis": synthesisHandler} {
+		start := time.Now()
+		e := post(t, h, "def main.main(): 0;")
+		if e == nil || e.Code != http.StatusUnprocessableEntity || !strings.Contains(e.Message, ErrTimeLimit.Error()) {
+			t.Errorf("Expecting %s to stop with time limit error but got %+v\n", name, e)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("Expecting %s to stop within time limit but took %s\n", name, d)
+		}
+	}
+}
+
+// Tests concurrent requests to a server are analysed independently, each
+// result is of its own program only.
+func TestServerConcurrent(t *testing.T) {
+	s := NewServer("127.0.0.1", "0")
+	go s.Start()
+	defer s.Close()
+	s.Handler()
+
+	paths := []string{"/ssa", "/cfsm", "/migo", "/api/v1/analyse"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8*len(paths); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("worker%d", i)
+			code := fmt.Sprintf("package main\n\nfunc %s(ch chan int) { ch <- %d }\n\nfunc main() {\n\tch := make(chan int)\n\tgo %s(ch)\n\t<-ch\n}\n", name, i, name)
+			body := code
+			path := paths[i%len(paths)]
+			if path == "/api/v1/analyse" {
+				b, _ := json.Marshal(AnalyseRequest{Files: map[string]string{"main.go": code}})
+				body = string(b)
+			}
+			resp, err := http.Post(strings.TrimSuffix(s.URL(), "/")+path, "text/plain", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("%s %s: %v\n", path, name, err)
+				return
+			}
+			defer resp.Body.Close()
+			b, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("%s %s: %v\n", path, name, err)
+				return
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s %s: Expecting success but got %d %s\n", path, name, resp.StatusCode, b)
+				return
+			}
+			if !strings.Contains(string(b), name) {
+				t.Errorf("%s %s: Expecting result of %s but got\n%s\n", path, name, name, b)
+			}
+			for j := 0; j < 8*len(paths); j++ {
+				if other := fmt.Sprintf("worker%d(", j); j != i && strings.Contains(string(b), other) {
+					t.Errorf("%s %s: Expecting result of %s only but got %s\n", path, name, name, other)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Tests the playground reports running programs is disabled without Exec.
+func TestPlayDisabled(t *testing.T) {
+	srv := httptest.NewUnstartedServer(nil)
+	origin := &url.URL{Scheme: "http", Host: srv.Listener.Addr().String()}
+	srv.Config.Handler = playSocket(origin, nil)
+	srv.Start()
+	defer srv.Close()
+	ws, err := websocket.Dial("ws://"+origin.Host+"/socket", "", origin.String())
+	if err != nil {
+		t.Fatalf("Cannot connect to playground: %v\n", err)
+	}
+	defer ws.Close()
+	if err := json.NewEncoder(ws).Encode(playMessage{Id: "0", Kind: "run", Body: "package main\n\nfunc main() {}\n"}); err != nil {
+		t.Fatalf("Cannot send to playground: %v\n", err)
+	}
+	var m playMessage
+	if err := json.NewDecoder(ws).Decode(&m); err != nil {
+		t.Fatalf("Cannot receive from playground: %v\n", err)
+	}
+	if m.Id != "0" || m.Kind != "end" || m.Body != ErrExecDisabled.Error() {
+		t.Errorf("Expecting end of program with %q but got %+v\n", ErrExecDisabled, m)
+	}
+}
+
+// Tests programs run by the sandbox are stopped by its limits.
+func TestSandbox(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping building programs in short mode")
+	}
+	s := NewSandbox(time.Minute, 100, 1)
+	var out bytes.Buffer
+	if err := s.Run(context.Background(), "package main\n\nfunc main() { println(\"hello\") }\n", &out, &out); err != nil {
+		t.Fatalf("Expecting program to run but got %v\n%s", err, out.String())
+	}
+	if out.String() != "hello\n" {
+		t.Errorf("Expecting output hello but got %q\n", out.String())
+	}
+
+	out.Reset()
+	err := s.Run(context.Background(), "package main\n\nfunc main() {\n\tfor {\n\t\tprintln(\"spam\")\n\t}\n}\n", &out, &out)
+	if err != ErrExecOutputLimit || out.Len() != 100 {
+		t.Errorf("Expecting %v after 100 bytes but got %v after %d bytes\n", ErrExecOutputLimit, err, out.Len())
+	}
+
+	out.Reset()
+	archive := "-- go.mod --\nmodule example.com/hello\n-- main.go --\npackage main\n\nimport \"example.com/hello/greet\"\n\nfunc main() { greet.Hello() }\n" +
+		"-- greet/greet.go --\npackage greet\n\nfunc Hello() { println(\"hello\") }\n"
+	if err := s.Run(context.Background(), archive, &out, &out); err != nil || out.String() != "hello\n" {
+		t.Errorf("Expecting module to run with output hello but got %v %q\n", err, out.String())
+	}
+
+	s.Timeout = 5 * time.Second
+	if err := s.Run(context.Background(), "package main\n\nfunc main() {\n\tfor {\n\t}\n}\n", &out, &out); err != ErrExecTimeLimit {
+		t.Errorf("Expecting %v but got %v\n", ErrExecTimeLimit, err)
+	}
+	if runtime.GOOS != "linux" {
+		return // Resource limits are only set on Linux.
+	}
+
+	s.Timeout, s.CPU = time.Minute, time.Second
+	start := time.Now()
+	if err := s.Run(context.Background(), "package main\n\nfunc main() {\n\tfor {\n\t}\n}\n", &out, &out); err == nil || err == ErrExecTimeLimit {
+		t.Errorf("Expecting program to be killed by CPU limit but got %v\n", err)
+	}
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("Expecting program to be killed after 1s of CPU but took %s\n", d)
+	}
+
+	s.Memory = 256 << 20
+	out.Reset()
+	if err := s.Run(context.Background(), "package main\n\nfunc main() { println(\"hello\") }\n", &out, &out); err != nil || out.String() != "hello\n" {
+		t.Errorf("Expecting program to run within memory limit but got %v %q\n", err, out.String())
+	}
+	if err := s.Run(context.Background(), "package main\n\nvar b []byte\n\nfunc main() {\n\tb = make([]byte, 1<<30)\n\tb[len(b)-1] = 1\n}\n", ioutil.Discard, ioutil.Discard); err == nil {
+		t.Errorf("Expecting program to fail over memory limit\n")
+	}
+}
+
+// Tests programs wait for their turn to run in the sandbox.
+func TestSandboxRuns(t *testing.T) {
+	s := NewSandbox(time.Minute, 100, 1)
+	s.runs <- struct{}{} // A program is running.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	if err := s.Run(ctx, "package main\n\nfunc main() {}\n", &out, &out); err != context.DeadlineExceeded {
+		t.Errorf("Expecting program to wait until %v but got %v\n", context.DeadlineExceeded, err)
+	}
+}
+
+// execFunc is an Executor of a function.
+type execFunc func(ctx context.Context, src string, stdout, stderr io.Writer) error
+
+func (f execFunc) Run(ctx context.Context, src string, stdout, stderr io.Writer) error {
+	return f(ctx, src, stdout, stderr)
+}
+
+// Tests programs of the playground are forgotten when they exit or are killed.
+func TestPlayKill(t *testing.T) {
+	c := &playConn{enc: json.NewEncoder(ioutil.Discard), kill: make(map[string]context.CancelFunc)}
+	c.exec = execFunc(func(ctx context.Context, src string, stdout, stderr io.Writer) error { return nil })
+	c.run(c.start(context.Background(), "0"), "0", "")
+	if len(c.kill) != 0 {
+		t.Errorf("Expecting exited program to be forgotten but got %v\n", c.kill)
+	}
+	ctx := c.start(context.Background(), "1")
+	c.stop("1")
+	next := c.start(context.Background(), "1") // Run again.
+	c.run(ctx, "1", "")
+	if len(c.kill) != 1 || next.Err() != nil {
+		t.Errorf("Expecting killed program not to forget the next run of its Id but got %v\n", c.kill)
+	}
+}
+
+// Tests graphs of a deadlocking program have positions of nodes in the source
+// and a trace to the deadlock.
+func TestGraph(t *testing.T) {
+	src := "package main\n\nfunc main() {\n\tch := make(chan int)\n\tgo worker(ch)\n\t<-ch\n}\n\nfunc worker(ch chan int) {\n\tdone := make(chan int)\n\tclose(done)\n}\n"
+	var reply struct {
+		Session, CFSMs, MiGo *Graph
+		Trace                *struct{ Stuck []string }
+	}
+	for _, path := range []string{"/graph", "/graph?model=migo"} {
+		rec := httptest.NewRecorder()
+		handler(graphHandler).ServeHTTP(rec, httptest.NewRequest("POST", path, strings.NewReader(src)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expecting %s to succeed but got %d: %s\n", path, rec.Code, rec.Body.String())
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply.Session == nil || reply.CFSMs == nil || reply.MiGo == nil {
+		t.Fatalf("Expecting session, CFSMs and MiGo graphs but got %+v\n", reply)
+	}
+	recv := false
+	for _, n := range reply.Session.Nodes {
+		if n.Kind == "recv" && n.Pos != nil && n.Pos.Line == 6 {
+			recv = true
+		}
+	}
+	if !recv {
+		t.Errorf("Expecting receive at line 6 in session graph but got %+v\n", reply.Session.Nodes)
+	}
+	if reply.Trace == nil || len(reply.Trace.Stuck) == 0 {
+		t.Errorf("Expecting trace to deadlock but got %+v\n", reply.Trace)
+	}
+	funcs := make(map[string]string)
+	for _, n := range reply.MiGo.Nodes {
+		funcs[n.Label] = n.Kind
+	}
+	if funcs["main.main"] != "blocked" || funcs["main.worker"] != "func" {
+		t.Errorf("Expecting blocked main.main and main.worker in MiGo graph but got %v\n", funcs)
+	}
+	if len(reply.MiGo.Edges) != 1 || reply.MiGo.Edges[0].Label != "spawn" || reply.MiGo.Edges[0].Pos == nil {
+		t.Errorf("Expecting spawn of main.worker with position but got %+v\n", reply.MiGo.Edges)
+	}
+}
+
+// Tests shared snippets are stored by content and restored in the index.
+func TestShare(t *testing.T) {
+	shares, err := OpenShareStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	Shares = shares
+	defer func() { Shares = nil }()
+	Templates = fstest.MapFS{"index.tmpl": {Data: []byte("{{if .Shared}}{{.Shared.View}} {{.Shared.Code}}{{end}}")}}
+	Examples = fstest.MapFS{}
+
+	share := func(snip Snippet) (int, string) {
+		b, _ := json.Marshal(snip)
+		rec := httptest.NewRecorder()
+		handler(shareHandler).ServeHTTP(rec, httptest.NewRequest("POST", "/share", bytes.NewReader(b)))
+		var reply struct{ ID, Path string }
+		json.NewDecoder(rec.Body).Decode(&reply)
+		return rec.Code, reply.Path
+	}
+	code, path := share(Snippet{Code: "package main\n", View: "migo"})
+	if code != http.StatusOK || !strings.HasPrefix(path, sharePath) {
+		t.Fatalf("Expecting snippet to be shared but got %d %q\n", code, path)
+	}
+	if _, again := share(Snippet{Code: "package main\n", View: "migo"}); again != path {
+		t.Errorf("Expecting same snippet at %s but got %s\n", path, again)
+	}
+	if _, other := share(Snippet{Code: "package main\n", View: "cfsm"}); other == path {
+		t.Errorf("Expecting snippet with other view at other path than %s\n", path)
+	}
+	if code, _ := share(Snippet{Code: "package main\n", View: "exec"}); code != http.StatusBadRequest {
+		t.Errorf("Expecting code %d of unknown view but got %d\n", http.StatusBadRequest, code)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(sharedHandler).ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "migo package main\n" {
+		t.Errorf("Expecting index with shared snippet but got %d %q\n", rec.Code, rec.Body.String())
+	}
+	for _, p := range []string{sharePath + "0123456789abcdef", sharePath + "../../etc/passwd"} {
+		rec := httptest.NewRecorder()
+		handler(sharedHandler).ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: Expecting code %d but got %d\n", p, http.StatusNotFound, rec.Code)
+		}
+	}
+}
+
+// moduleArchive is a module with a package imported by main, as a txtar
+// archive.
+const moduleArchive = `A module with two packages.
+-- go.mod --
+module example.com/hello
+-- main.go --
+package main
+
+import "example.com/hello/worker"
+
+func main() {
+	ch := make(chan int)
+	go worker.Work(ch)
+}
+-- worker/worker.go --
+package worker
+
+func Work(ch chan int) {
+	ch <- 1
+}
+`
+
+// Tests archives of modules are built with packages in subdirectories, and
+// errors are reported at positions in their files.
+func TestModuleArchive(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler(migoHandler).ServeHTTP(rec, httptest.NewRequest("POST", "/migo", strings.NewReader(moduleArchive)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "spawn example.com_hello_worker.Work(") {
+		t.Errorf("Expecting MiGo of module with spawn of worker.Work but got %d %s\n", rec.Code, rec.Body.String())
+	}
+	e := post(t, migoHandler, strings.Replace(moduleArchive, "ch <- 1", "ch <- x", 1))
+	if e == nil || e.Pos == nil || e.Pos.Filename != "worker/worker.go" || e.Pos.Line != 4 {
+		t.Errorf("Expecting error at worker/worker.go:4 but got %+v\n", e)
+	}
+
+	// Files of package main without go.mod.
+	rec = httptest.NewRecorder()
+	archive := "-- main.go --\n" + leakFiles["main.go"] + "-- worker.go --\n" + leakFiles["worker.go"]
+	handler(migoHandler).ServeHTTP(rec, httptest.NewRequest("POST", "/migo", strings.NewReader(archive)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "spawn main.worker(") {
+		t.Errorf("Expecting MiGo with spawn of main.worker but got %d %s\n", rec.Code, rec.Body.String())
+	}
+
+	resp, err := postAPI(t, AnalyseRequest{Files: ssabuilder.ArchiveFiles([]byte(moduleArchive))})
+	if err != nil {
+		t.Fatalf("Expecting success but got %+v\n", err)
+	}
+	if len(resp.Findings) != 1 || resp.Findings[0].Pos == nil || resp.Findings[0].Pos.Filename != "worker/worker.go" {
+		t.Errorf("Expecting 1 leak in worker/worker.go but got %+v\n", resp.Findings)
+	}
+}
+
+// Tests archives of modules importing packages with cgo files are built with
+// the files without cgo.
+func TestModuleArchiveCgo(t *testing.T) {
+	archive := `-- go.mod --
+module example.com/hello
+
+go 1.16
+-- main.go --
+package main
+
+import "os/user"
+
+func main() {
+	ch := make(chan error, 1)
+	ch <- user.UnknownUserIdError(0)
+}
+`
+	rec := httptest.NewRecorder()
+	handler(ssaHandler).ServeHTTP(rec, httptest.NewRequest("POST", "/ssa", strings.NewReader(archive)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "os/user.UnknownUserIdError") {
+		t.Errorf("Expecting SSA of module importing os/user but got %d %s\n", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	handler(migoHandler).ServeHTTP(rec, httptest.NewRequest("POST", "/migo", strings.NewReader(archive)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "def main.main()") {
+		t.Errorf("Expecting MiGo of module importing os/user but got %d %s\n", rec.Code, rec.Body.String())
+	}
+}
+
+// Tests stuck configurations of CFSMs are reported as deadlocks.
+func TestAPICFSMDeadlock(t *testing.T) {
+	files := map[string]string{"main.go": `package main
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		ch <- 1
+	}()
+	ch <- 2
+}
+`}
+	resp, e := postAPI(t, AnalyseRequest{Files: files, Extractor: ExtractCFSM})
+	if e != nil {
+		t.Fatalf("Expecting success but got %+v\n", e)
+	}
+	if len(resp.Findings) == 0 {
+		t.Fatalf("Expecting deadlock but got no findings\n")
+	}
+	for _, f := range resp.Findings {
+		if f.Kind != FindingDeadlock || f.Pos == nil || f.Pos.Filename != "main.go" {
+			t.Errorf("Expecting deadlock in main.go but got %+v\n", f)
+		}
+	}
+	resp, e = postAPI(t, AnalyseRequest{Files: files, Extractor: ExtractCFSM, Bounds: Bounds{MaxConfigs: 1}})
+	if e != nil || len(resp.Findings) != 0 {
+		t.Errorf("Expecting no findings within 1 configuration but got %+v %+v\n", e, resp)
+	}
+}
+
+// Tests request bodies over maxRequest bytes are rejected.
+func TestAPITooLarge(t *testing.T) {
+	e := post(t, apiAnalyseHandler, `{"files": {"main.go": "`+strings.Repeat(" ", maxRequest)+`"}}`)
+	if e == nil || e.Code != http.StatusBadRequest {
+		t.Errorf("Expecting decode error but got %+v\n", e)
+	}
+	large := "package main\n\nfunc main() {}\n" + strings.Repeat("\n", maxRequest)
+	for name, h := range map[string]handler{"ssa": ssaHandler, "migo": migoHandler, "cfsm": cfsmHandler, "load": loadHandler, "gong": gongHandler, "synthesis": synthesisHandler} {
+		e := post(t, h, large)
+		if e == nil || e.Code != http.StatusBadRequest {
+			t.Errorf("Expecting %s to reject large body but got %+v\n", name, e)
+		}
+	}
+}
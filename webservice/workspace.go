@@ -0,0 +1,17 @@
+package webservice
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// newWorkspace creates a directory for the files of a request, e.g. inputs
+// and outputs of external tools, so requests running at the same time do not
+// share files. The directory is removed by cleanup.
+func newWorkspace() (dir string, cleanup func(), err error) {
+	dir, err = ioutil.TempDir("", "dingo-hunter-")
+	if err != nil {
+		return "", nil, NewErrInternal(err, "Cannot create workspace")
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}